@@ -0,0 +1,15 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+)
+
+// handleAdminDashboard serves the board's admin dashboard, GET /admin.
+func handleAdminDashboard(s *Store) http.HandlerFunc {
+	tmpl := template.Must(template.New("admin").Parse(adminHTML))
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		tmpl.Execute(w, struct{ Base, Title string }{requestBase(r), s.GetBoard().Board.Title})
+	}
+}
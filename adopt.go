@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/brunoga/deep/v5/crdt"
+)
+
+// A node whose local data is known to be corrupt can be recovered without
+// wiping the cluster: an admin has it adopt the state of a healthy peer with
+// POST /api/admin/adopt?peer=host:8081. The node's CRDT is replaced by the
+// peer's, not merged with it, so whatever only this node had is gone. Its
+// clock keeps running from where it was, so its next edits still win over
+// its earlier ones on other nodes. The history is kept, and an entry marks
+// the adoption. Each adoption starts a new epoch of the node, a counter kept
+// in its settings, so that entries from before it can be told apart.
+
+var (
+	// ErrUnknownPeer is returned for a peer this node does not sync with.
+	ErrUnknownPeer = errors.New("not a peer of this node")
+	// ErrPeerUnreachable is returned when a peer's state cannot be fetched.
+	ErrPeerUnreachable = errors.New("peer state could not be fetched")
+)
+
+// epochSetting is the setting the node's epoch is kept in.
+const epochSetting = "epoch"
+
+// Epoch returns how many times this node adopted a peer's state.
+func (s *Store) Epoch() int {
+	var epoch int
+	if _, err := s.GetSetting(epochSetting, &epoch); err != nil {
+		log.Printf("Failed to load the epoch: %v", err)
+	}
+	return epoch
+}
+
+// Adoption is the result of AdoptFrom.
+type Adoption struct {
+	Peer  string `json:"peer"`
+	Epoch int    `json:"epoch"`
+	Cards int    `json:"cards"`
+}
+
+// AdoptFrom replaces the board with the state of peer.
+func (s *Store) AdoptFrom(peer string) (Adoption, error) {
+	if !slices.Contains(s.GetPeers(), peer) {
+		return Adoption{}, ErrUnknownPeer
+	}
+	if err := s.checkPeer(peer); err != nil {
+		return Adoption{}, err
+	}
+	data, err := s.transport.FetchState(peer, s.basePath)
+	if err != nil {
+		return Adoption{}, fmt.Errorf("%w: %v", ErrPeerUnreachable, err)
+	}
+	s.recordTraffic(peer, PeerTraffic{BytesReceived: int64(len(data))})
+	if data, err = upgradePayload(payloadState, data); err != nil {
+		return Adoption{}, err
+	}
+	adopted, err := s.adoptable(data)
+	if err != nil {
+		return Adoption{}, fmt.Errorf("%w: %v", ErrPeerUnreachable, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	epoch := s.Epoch() + 1
+	adopted.Clock().Update(s.crdt.Clock().Now())
+	before := s.crdt.View().Board.Cards
+	prev, lastModified := s.crdt, s.lastModified
+	s.crdt = adopted
+	s.lastModified = max(s.lastModified, adopted.Clock().Latest.WallTime)
+	changes := remoteChanges(before, s.crdt.View().Board.Cards)
+	if err := s.commitAdoption(peer, epoch, changes); err != nil {
+		s.crdt, s.lastModified = prev, lastModified
+		return Adoption{}, err
+	}
+	s.trash.clear()
+	s.observeRemote(changes)
+	// The peer's state lists its connections, not ours.
+	s.updateConnectionsLocked(s.hub.Len())
+	s.Broadcast(WSMessage{Type: "refresh"})
+	log.Printf("Adopted the state of %s, epoch %d", peer, epoch)
+	return Adoption{Peer: peer, Epoch: epoch, Cards: len(s.snap.Load().state.Board.Cards)}, nil
+}
+
+// adoptable decodes a peer's CRDT as this node's own: the encoding carries
+// the peer's node ID, under which this node's edits would be made.
+func (s *Store) adoptable(data []byte) (*crdt.CRDT[BoardState], error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	fields["nodeID"], _ = json.Marshal(s.nodeID)
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	c := crdt.NewCRDT(BoardState{}, s.nodeID)
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// commitAdoption saves the adopted state along with its history entry, the
+// new epoch, the journal position, so no patch from before is replayed onto
+// it, and the changes it makes to the feed. It must be called with s.mu held.
+func (s *Store) commitAdoption(peer string, epoch int, changes []Event) error {
+	data, err := json.Marshal(s.crdt)
+	if err != nil {
+		return err
+	}
+	data = stampModel(data)
+
+	s.histMu.Lock()
+	defer s.histMu.Unlock()
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	summary := fmt.Sprintf("Adopted the state of %s (epoch %d)", peer, epoch)
+	res, err := tx.Exec("INSERT INTO patches (timestamp, patch, summary, actor, kind) VALUES (?, ?, ?, ?, ?)",
+		s.crdt.Clock().Now().String(), "{}", summary, s.actor, PatchAdmin)
+	if err != nil {
+		return err
+	}
+	id, _ := res.LastInsertId()
+	epochData, _ := json.Marshal(epoch)
+	for _, q := range []struct {
+		query string
+		args  []any
+	}{
+		{"INSERT OR REPLACE INTO state (id, data) VALUES ('latest', ?)", []any{data}},
+		{"INSERT OR REPLACE INTO state (id, data) VALUES ('journal', ?)", []any{strconv.FormatInt(id, 10)}},
+		// The patches before are not to be replayed onto it either, see
+		// integrity.go.
+		{"INSERT OR REPLACE INTO state (id, data) VALUES ('good', ?)", []any{data}},
+		{"INSERT OR REPLACE INTO state (id, data) VALUES ('goodJournal', ?)", []any{strconv.FormatInt(id, 10)}},
+		{"INSERT OR REPLACE INTO settings (key, value) VALUES (?, ?)", []any{epochSetting, epochData}},
+	} {
+		if _, err := tx.Exec(q.query, q.args...); err != nil {
+			return err
+		}
+	}
+	if err := recordChanges(tx, changes); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	s.publish(data)
+	return nil
+}
+
+// handleAdopt serves POST /api/admin/adopt?peer=host:8081, replacing the
+// board with the state of the peer, and answers the Adoption.
+func handleAdopt(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		peer := r.FormValue("peer")
+		log.Printf("ADMIN: Adopting the state of %s", peer)
+		adoption, err := s.AdoptFrom(peer)
+		if err != nil {
+			writeMutationError(w, err)
+			return
+		}
+		s.Audit(r, AuditBoardAdopt, fmt.Sprintf("peer=%s epoch=%d", peer, adoption.Epoch))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(adoption)
+	}
+}
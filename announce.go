@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Peer discovery by service name guesses that every node listens on :8080
+// and is named after its host, which containers and port mappings break.
+// Nodes started with -announce-addr say instead where they are reached, in
+// BoardState.Announcements, renewed every announceInterval, and every node
+// syncs with the nodes announced on the board along with its -peers. A new
+// node needs a single peer to join: the others learn its address from its
+// next renewal. Announcements not renewed for announceTTL are ignored, then
+// dropped, along with the node's connections, so nodes that are gone stop
+// being synced with.
+//
+// An announcement is one string, "host:port@unix", rather than fields of
+// the node's entry in NodeConnections: a patch to a field of an entry is
+// lost on nodes that never saw the entry, while a map value is set whole,
+// so every renewal carries the address.
+
+const (
+	// announceInterval is how often nodes renew their announcement and look
+	// for those of others.
+	announceInterval = time.Minute
+	// announceTTL is how long an announcement holds without being renewed.
+	announceTTL = 10 * time.Minute
+)
+
+// SetAnnounceAddr sets the address other nodes reach this one at, such as
+// "deepboard-2:8080", to announce on the board. It must be called before
+// the board is started.
+func (s *Store) SetAnnounceAddr(addr string) {
+	s.announceAddr = addr
+}
+
+// parseAnnouncement returns the address of announcement a and when it was
+// renewed.
+func parseAnnouncement(a string) (addr string, at time.Time, ok bool) {
+	i := strings.LastIndexByte(a, '@')
+	if i < 0 {
+		return "", time.Time{}, false
+	}
+	unix, err := strconv.ParseInt(a[i+1:], 10, 64)
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	return a[:i], time.Unix(unix, 0), true
+}
+
+// announcedAddr returns the address node announced in state, if its
+// announcement holds at now.
+func announcedAddr(state BoardState, node string, now time.Time) (string, bool) {
+	addr, at, ok := parseAnnouncement(state.Announcements[node])
+	if !ok || now.Sub(at) >= announceTTL {
+		return "", false
+	}
+	return addr, true
+}
+
+// announcedAddrs returns the addresses the nodes of state other than self
+// announced, as of now, in order.
+func announcedAddrs(state BoardState, self string, now time.Time) []string {
+	var addrs []string
+	for node := range state.Announcements {
+		if addr, ok := announcedAddr(state, node, now); ok && node != self && !slices.Contains(addrs, addr) {
+			addrs = append(addrs, addr)
+		}
+	}
+	slices.Sort(addrs)
+	return addrs
+}
+
+// joinPeers returns the configured peers followed by the announced ones they
+// do not already name.
+func joinPeers(configured, announced []string) []string {
+	peers := slices.Clone(configured)
+	for _, p := range announced {
+		if !slices.Contains(peers, p) {
+			peers = append(peers, p)
+		}
+	}
+	return peers
+}
+
+// announce renews this node's announcement on the board.
+func (s *Store) announce() {
+	announcement := fmt.Sprintf("%s@%d", s.announceAddr, time.Now().Unix())
+	s.SilentEdit(func(bs *BoardState) {
+		if bs.Announcements == nil {
+			bs.Announcements = map[string]string{}
+		}
+		bs.Announcements[s.nodeID] = announcement
+	})
+}
+
+// dropExpiredAnnouncements drops the announcements of bs that no longer
+// hold at now.
+func dropExpiredAnnouncements(bs *BoardState, now time.Time) {
+	for _, node := range slices.Collect(maps.Keys(bs.Announcements)) {
+		if _, ok := announcedAddr(*bs, node, now); !ok {
+			delete(bs.Announcements, node)
+		}
+	}
+}
+
+// announcedPeers returns the peers announced on the board, as last followed.
+func (s *Store) announcedPeers() []string {
+	s.peerMu.RLock()
+	defer s.peerMu.RUnlock()
+	return slices.Clone(s.announced)
+}
+
+// configured returns the peers of -peers or discovery.
+func (s *Store) configured() []string {
+	s.peerMu.RLock()
+	defer s.peerMu.RUnlock()
+	return slices.Clone(s.configuredPeers)
+}
+
+// followAnnouncements makes the nodes announced on the board this node's
+// peers, besides the configured ones, and syncs with those that are new.
+func (s *Store) followAnnouncements() {
+	addrs := slices.DeleteFunc(announcedAddrs(s.GetBoard(), s.nodeID, time.Now()), func(addr string) bool {
+		return addr == s.announceAddr
+	})
+	s.peerMu.Lock()
+	if slices.Equal(addrs, s.announced) {
+		s.peerMu.Unlock()
+		return
+	}
+	var added []string
+	for _, p := range addrs {
+		if !slices.Contains(s.peers, p) {
+			added = append(added, p)
+		}
+	}
+	s.announced = addrs
+	s.peers = joinPeers(s.configuredPeers, addrs)
+	s.peerMu.Unlock()
+
+	log.Printf("Announced peers: %v", addrs)
+	if s.IsArchived() {
+		return
+	}
+	for _, p := range added {
+		go syncWithPeer(s, p)
+	}
+}
+
+// startAnnouncements announces this node, if it has an address, and follows
+// the announcements of others, now and then every announceInterval until
+// the store is closed.
+func startAnnouncements(s *Store) {
+	ticker := time.NewTicker(announceInterval)
+	defer ticker.Stop()
+	for {
+		if s.announceAddr != "" && !s.readOnly {
+			s.announce()
+		}
+		s.followAnnouncements()
+		select {
+		case <-ticker.C:
+		case <-s.done:
+			return
+		}
+	}
+}
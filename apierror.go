@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Every error response, from the REST API and over WebSocket, carries the
+// same envelope:
+//
+//	{"error": {"code": "card_not_found", "message": "card not found", "requestId": "..."}}
+//
+// Codes are stable identifiers clients can switch on; messages are for
+// people. The request ID is also sent in the X-Request-ID header of every
+// response, and server errors are logged with it.
+
+// requestIDHeader carries the ID of a request. Clients may set it to tie
+// their own logs to the server's; otherwise the server makes one up.
+const requestIDHeader = "X-Request-ID"
+
+// validRequestID bounds the request IDs accepted from clients.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// APIError is the body of an error response.
+type APIError struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	Details   any    `json:"details,omitempty"`
+	RequestID string `json:"requestId,omitempty"`
+}
+
+// errorStatus maps the errors handlers pass to writeMutationError to a status
+// and code. Errors not listed are internal errors.
+var errorStatus = []struct {
+	err    error
+	status int
+	code   string
+}{
+	{ErrBoardFrozen, http.StatusLocked, "board_frozen"},
+	{ErrBoardArchived, http.StatusLocked, "board_archived"},
+	{ErrForbidden, http.StatusForbidden, "forbidden"},
+	{ErrPeerSecretRequired, http.StatusForbidden, "peer_secret_required"},
+	{ErrBadPeerSecret, http.StatusUnauthorized, "bad_peer_secret"},
+	{ErrUnfurlDenied, http.StatusForbidden, "unfurl_denied"},
+	{ErrReadOnly, http.StatusForbidden, "read_only"},
+	{ErrQuotaExceeded, http.StatusInsufficientStorage, "quota_exceeded"},
+	{ErrUploadTooLarge, http.StatusRequestEntityTooLarge, "upload_too_large"},
+	{ErrPlaintext, http.StatusBadRequest, "plaintext"},
+	{ErrBadTextOp, http.StatusConflict, "bad_text_op"},
+	{ErrBadKeyCheck, http.StatusBadRequest, "bad_key_check"},
+	{ErrCloneSelf, http.StatusBadRequest, "clone_self"},
+	{ErrBadSprint, http.StatusBadRequest, "bad_sprint"},
+	{ErrBadEstimate, http.StatusBadRequest, "bad_estimate"},
+	{ErrBadSort, http.StatusBadRequest, "bad_sort"},
+	{ErrBadDue, http.StatusBadRequest, "bad_due"},
+	{ErrBadQuery, http.StatusBadRequest, "bad_query"},
+	{ErrBadBot, http.StatusBadRequest, "bad_bot"},
+	{ErrBadBoardName, http.StatusBadRequest, "bad_board_name"},
+	{ErrBadColumn, http.StatusBadRequest, "bad_column"},
+	{ErrBadEmbed, http.StatusBadRequest, "bad_embed"},
+	{ErrBadLabel, http.StatusBadRequest, "bad_label"},
+	{ErrBadUser, http.StatusBadRequest, "bad_user"},
+	{ErrBadChecklistOp, http.StatusBadRequest, "bad_checklist_op"},
+	{ErrNoVoter, http.StatusBadRequest, "no_voter"},
+	{ErrInvalidMessage, http.StatusBadRequest, "invalid_message"},
+	{ErrBoardNotEmpty, http.StatusConflict, "board_not_empty"},
+	{ErrAlreadyEncrypted, http.StatusConflict, "already_encrypted"},
+	{ErrSprintEnded, http.StatusConflict, "sprint_ended"},
+	{ErrBotExists, http.StatusConflict, "bot_exists"},
+	{ErrBoardExists, http.StatusConflict, "board_exists"},
+	{ErrStaticBoard, http.StatusConflict, "static_board"},
+	{ErrColumnNotEmpty, http.StatusConflict, "column_not_empty"},
+	{ErrLastColumn, http.StatusConflict, "last_column"},
+	{ErrColumnNotFound, http.StatusNotFound, "column_not_found"},
+	{ErrCardNotFound, http.StatusNotFound, "card_not_found"},
+	{ErrNoArchive, http.StatusNotFound, "no_archive"},
+	{ErrTemplateNotFound, http.StatusNotFound, "template_not_found"},
+	{ErrSprintNotFound, http.StatusNotFound, "sprint_not_found"},
+	{ErrViewNotFound, http.StatusNotFound, "view_not_found"},
+	{ErrBotNotFound, http.StatusNotFound, "bot_not_found"},
+	{ErrBoardNotFound, http.StatusNotFound, "board_not_found"},
+	{ErrEmbedNotFound, http.StatusNotFound, "embed_not_found"},
+	{ErrLabelNotFound, http.StatusNotFound, "label_not_found"},
+	{ErrUserNotFound, http.StatusNotFound, "user_not_found"},
+	{ErrChecklistItemNotFound, http.StatusNotFound, "checklist_item_not_found"},
+	{ErrNoRound, http.StatusNotFound, "no_round"},
+	{ErrNotRevealed, http.StatusConflict, "not_revealed"},
+	{ErrUndoExpired, http.StatusGone, "undo_expired"},
+	{ErrRateLimited, http.StatusTooManyRequests, "rate_limited"},
+	{ErrUnknownPeer, http.StatusNotFound, "unknown_peer"},
+	{ErrPeerBlocked, http.StatusServiceUnavailable, "peer_blocked"},
+	{ErrPeerUnreachable, http.StatusBadGateway, "peer_unreachable"},
+	{ErrRelayDown, http.StatusServiceUnavailable, "relay_down"},
+	{ErrPartitioned, http.StatusServiceUnavailable, "partitioned"},
+	{ErrNotSaved, http.StatusServiceUnavailable, "not_saved"},
+}
+
+// classify returns the status and envelope err is reported with.
+func classify(err error) (int, APIError) {
+	var missing MissingVariablesError
+	if errors.As(err, &missing) {
+		return http.StatusBadRequest, APIError{Code: "missing_variables", Message: err.Error(), Details: missing}
+	}
+	var quota *QuotaError
+	if errors.As(err, &quota) {
+		return http.StatusInsufficientStorage, APIError{Code: "quota_exceeded", Message: err.Error(), Details: quota}
+	}
+	var version *ModelVersionError
+	if errors.As(err, &version) {
+		return http.StatusConflict, APIError{Code: "model_version", Message: err.Error(), Details: version}
+	}
+	var invalid *SchemaError
+	if errors.As(err, &invalid) {
+		return http.StatusBadRequest, APIError{Code: "invalid_message", Message: err.Error(), Details: invalid}
+	}
+	for _, e := range errorStatus {
+		if errors.Is(err, e.err) {
+			return e.status, APIError{Code: e.code, Message: err.Error()}
+		}
+	}
+	return http.StatusInternalServerError, APIError{Code: statusCode(http.StatusInternalServerError), Message: err.Error()}
+}
+
+// statusCode is the code of errors that have nothing more specific than
+// their status, e.g. "method_not_allowed".
+func statusCode(status int) string {
+	return strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+}
+
+// writeError replies with message and status in an error envelope. It is
+// the envelope's drop-in for http.Error.
+func writeError(w http.ResponseWriter, message string, status int) {
+	writeAPIError(w, status, APIError{Code: statusCode(status), Message: message})
+}
+
+// writeAPIError replies with e and status, stamped with the request's ID.
+func writeAPIError(w http.ResponseWriter, status int, e APIError) {
+	h := w.Header()
+	e.RequestID = h.Get(requestIDHeader)
+	if status >= http.StatusInternalServerError {
+		log.Printf("Request %s failed: %s", e.RequestID, e.Message)
+	}
+	h.Del("Content-Length")
+	h.Set("Content-Type", "application/json; charset=utf-8")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct {
+		Error APIError `json:"error"`
+	}{e})
+}
+
+// writeMutationError replies with the status and code of a failed edit.
+func writeMutationError(w http.ResponseWriter, err error) {
+	status, e := classify(err)
+	writeAPIError(w, status, e)
+}
+
+// wsError is err as sent in WebSocket error messages of the connection
+// opened by request requestID.
+func wsError(err error, requestID string) *APIError {
+	_, e := classify(err)
+	e.RequestID = requestID
+	return &e
+}
+
+// withRequestID gives every request an ID, the client's if it sent a valid
+// one, and returns it in the X-Request-ID header.
+func withRequestID(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = uuid.New().String()
+		}
+		w.Header().Set(requestIDHeader, id)
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/brunoga/deep/v5/crdt"
+)
+
+// ErrBoardArchived is returned when unfreezing an archived board.
+var ErrBoardArchived = errors.New("board is archived; unarchive it to make changes")
+
+// ErrNoArchive is returned when a board has no archived snapshot.
+var ErrNoArchive = errors.New("board has no archived snapshot")
+
+// An archived board is frozen and leaves the active sync set: nodes stop
+// pulling its state and pushing its changes, so a cluster with many old
+// boards only spends traffic on the live ones. Like the freeze, the archive
+// flag lives in the CRDT. Archived boards still serve /api/state, so a peer
+// that missed the change learns it the next time it pulls, and unarchiving
+// pushes the change that brings every node back.
+
+// IsArchived reports whether the board is archived.
+func (s *Store) IsArchived() bool {
+	return s.snap.Load().state.Board.Archived
+}
+
+// SetArchived archives or unarchives the board. Archiving freezes it and
+// keeps a compressed snapshot of its CRDT in the database; unarchiving
+// lifts the freeze again.
+func (s *Store) SetArchived(archived bool) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	if _, err := s.Edit(func(bs *BoardState) {
+		bs.Board.Archived = archived
+		bs.Board.Frozen = archived
+	}); err != nil {
+		return err
+	}
+	if !archived {
+		return nil
+	}
+	return s.saveArchive()
+}
+
+// saveArchive stores the current CRDT, gzipped, as the archived snapshot.
+func (s *Store) saveArchive() error {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(s.snap.Load().crdtJSON); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	_, err := s.db.Exec("INSERT OR REPLACE INTO state (id, data) VALUES ('archive', ?)", buf.Bytes())
+	return err
+}
+
+// Archive returns the gzipped snapshot taken when the board was last
+// archived.
+func (s *Store) Archive() ([]byte, error) {
+	var data []byte
+	err := s.db.QueryRow("SELECT data FROM state WHERE id = 'archive'").Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, ErrNoArchive
+	}
+	return data, err
+}
+
+// ArchivedBoard loads the board from its archived snapshot.
+func (s *Store) ArchivedBoard() (BoardState, error) {
+	data, err := s.Archive()
+	if err != nil {
+		return BoardState{}, err
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return BoardState{}, err
+	}
+	crdtJSON, err := io.ReadAll(zr)
+	if err != nil {
+		return BoardState{}, err
+	}
+	if crdtJSON, err = upgradePayload(payloadState, crdtJSON); err != nil {
+		return BoardState{}, err
+	}
+	c := crdt.NewCRDT(BoardState{}, s.nodeID)
+	if err := json.Unmarshal(crdtJSON, c); err != nil {
+		return BoardState{}, err
+	}
+	return c.View(), nil
+}
+
+// handleArchive archives or unarchives the board, POST
+// /api/admin/archive?archived=true|false.
+func handleArchive(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		archived, err := strconv.ParseBool(r.FormValue("archived"))
+		if err != nil {
+			writeError(w, "invalid archived value", http.StatusBadRequest)
+			return
+		}
+		log.Printf("ADMIN: Setting board archived=%v", archived)
+		if err := s.SetArchived(archived); err != nil {
+			writeMutationError(w, err)
+			return
+		}
+		s.Audit(r, AuditBoardArchive, fmt.Sprintf("archived=%v", archived))
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// handleArchiveDownload serves the archived snapshot, GET /api/archive. It
+// is the CRDT as served on /api/state, gzipped.
+func handleArchiveDownload(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data, err := s.Archive()
+		if err != nil {
+			writeMutationError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/gzip")
+		w.Header().Set("Content-Disposition", `attachment; filename="board.json.gz"`)
+		w.Write(data)
+	}
+}
+
+// handleArchiveView renders the archived snapshot as a read-only Markdown
+// report, GET /archive. It is loaded from the database on every request.
+func handleArchiveView(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		state, err := s.ArchivedBoard()
+		if err != nil {
+			writeMutationError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+		fmt.Fprint(w, renderMarkdown(state))
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Audit actions.
+const (
+	AuditLogin             = "login"
+	AuditLoginFailed       = "login.failed"
+	AuditLogout            = "logout"
+	AuditBoardReset        = "board.reset"
+	AuditBoardFreeze       = "board.freeze"
+	AuditBoardArchive      = "board.archive"
+	AuditBoardEncrypt      = "board.encrypt"
+	AuditHistoryClear      = "history.clear"
+	AuditImport            = "import"
+	AuditColumnPermissions = "column.permissions"
+	AuditColumnDwell       = "column.dwell"
+	AuditColumnSort        = "column.sort"
+	AuditColumnChange      = "column.change"
+	AuditRulesChange       = "rules.change"
+	AuditTemplatesChange   = "templates.change"
+	AuditBoardClone        = "board.clone"
+	AuditBoardCreate       = "board.create"
+	AuditBoardDelete       = "board.delete"
+	AuditSprintEnd         = "sprint.end"
+	AuditIntegrationChange = "integration.change"
+	AuditBotCreate         = "bot.create"
+	AuditBotRevoke         = "bot.revoke"
+	AuditEmbedCreate       = "embed.create"
+	AuditEmbedRevoke       = "embed.revoke"
+	AuditPartitionChange   = "partition.change"
+	AuditBoardAdopt        = "board.adopt"
+	AuditBoardRepair       = "board.repair"
+	AuditFeatureChange     = "feature.change"
+)
+
+// AuditEntry is one row of the append-only audit log. Unlike the board
+// history it records who did something and from where, and it is never
+// cleared from the UI.
+type AuditEntry struct {
+	ID     int64  `json:"id"`
+	Time   int64  `json:"time"`
+	Actor  string `json:"actor"`
+	IP     string `json:"ip"`
+	Action string `json:"action"`
+	Detail string `json:"detail"`
+}
+
+// requestIP returns the client address, preferring the first hop recorded by
+// a reverse proxy.
+func requestIP(r *http.Request) string {
+	if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+		ip, _, _ := strings.Cut(fwd, ",")
+		return strings.TrimSpace(ip)
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func requestActor(r *http.Request) string {
+	if u := currentUser(r); u != nil {
+		if u.Name != "" {
+			return u.Name + " <" + u.ID + ">"
+		}
+		return u.ID
+	}
+	return "anonymous"
+}
+
+// Audit records a security-relevant action performed by the request's user.
+func (s *Store) Audit(r *http.Request, action, detail string) {
+	s.AuditAs(r, requestActor(r), action, detail)
+}
+
+// AuditAs records an action for an explicit actor, for flows (like login)
+// where the user is not on the request yet.
+func (s *Store) AuditAs(r *http.Request, actor, action, detail string) {
+	_, err := s.db.Exec("INSERT INTO audit (time, actor, ip, action, detail) VALUES (?, ?, ?, ?, ?)",
+		time.Now().Unix(), actor, requestIP(r), action, detail)
+	if err != nil {
+		log.Printf("Failed to write audit entry %s: %v", action, err)
+	}
+}
+
+// GetAudit returns the newest audit entries, optionally filtered by action.
+func (s *Store) GetAudit(action string, limit int) ([]AuditEntry, error) {
+	query := "SELECT id, time, actor, ip, action, detail FROM audit"
+	args := []any{}
+	if action != "" {
+		query += " WHERE action = ?"
+		args = append(args, action)
+	}
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var e AuditEntry
+		if err := rows.Scan(&e.ID, &e.Time, &e.Actor, &e.IP, &e.Action, &e.Detail); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// handleAudit serves GET /api/admin/audit?action=&limit=.
+func handleAudit(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit := 100
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				writeError(w, "invalid limit", http.StatusBadRequest)
+				return
+			}
+			limit = min(n, 1000)
+		}
+		entries, err := s.GetAudit(r.URL.Query().Get("action"), limit)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(entries)
+	}
+}
@@ -0,0 +1,638 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-jose/go-jose/v3"
+	"github.com/go-jose/go-jose/v3/jwt"
+)
+
+// Roles, from least to most privileged.
+const (
+	RoleViewer = "viewer"
+	RoleEditor = "editor"
+	RoleAdmin  = "admin"
+)
+
+var roleRank = map[string]int{RoleViewer: 1, RoleEditor: 2, RoleAdmin: 3}
+
+// ErrForbidden is returned when the user's role does not allow an operation.
+var ErrForbidden = errors.New("insufficient permissions")
+
+const (
+	sessionCookieName = "deepboard_session"
+	sessionTTL        = 12 * time.Hour
+	loginStateTTL     = 10 * time.Minute
+	// loginCookieName ties an OIDC login to the browser that started it:
+	// the callback is only accepted with the state this cookie holds.
+	loginCookieName = "deepboard_login"
+	// idTokenLeeway is the clock skew tolerated on ID token times.
+	idTokenLeeway = time.Minute
+	// jwksRefetch is the least time between fetches of the provider's keys,
+	// done when an ID token is signed by a key not fetched yet.
+	jwksRefetch = time.Minute
+)
+
+// idTokenAlgs are the signature algorithms accepted on ID tokens: the
+// asymmetric ones, whose keys the provider publishes.
+var idTokenAlgs = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+// User is an authenticated person.
+type User struct {
+	ID     string   `json:"id"`
+	Name   string   `json:"name"`
+	Email  string   `json:"email"`
+	Groups []string `json:"groups"`
+	Role   string   `json:"role"`
+	Bot    bool     `json:"bot,omitempty"`
+	Scopes []string `json:"scopes,omitempty"` // of a bot
+}
+
+// HasRole reports whether u has at least the given role.
+func (u *User) HasRole(role string) bool {
+	return roleRank[u.Role] >= roleRank[role]
+}
+
+// InGroup reports whether u is a member of group.
+func (u *User) InGroup(group string) bool {
+	return slices.Contains(u.Groups, group)
+}
+
+type userContextKey struct{}
+
+// currentUser returns the authenticated user for r, or nil when
+// authentication is disabled.
+func currentUser(r *http.Request) *User {
+	u, _ := r.Context().Value(userContextKey{}).(*User)
+	return u
+}
+
+// OIDCConfig configures login through an OpenID Connect provider (Google,
+// Keycloak, Azure AD, ...).
+type OIDCConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	GroupsClaim  string
+	// RoleMap maps provider groups to roles. Users get the highest role of
+	// all their groups, or DefaultRole if none match.
+	RoleMap     map[string]string
+	DefaultRole string
+}
+
+// parseRoleMap parses "group=role,group2=role2".
+func parseRoleMap(s string) (map[string]string, error) {
+	m := make(map[string]string)
+	if s == "" {
+		return m, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		group, role, ok := strings.Cut(pair, "=")
+		if !ok || roleRank[role] == 0 {
+			return nil, fmt.Errorf("invalid role mapping %q", pair)
+		}
+		m[strings.TrimSpace(group)] = role
+	}
+	return m, nil
+}
+
+type session struct {
+	user    *User
+	expires time.Time
+}
+
+// loginState is an OIDC login in progress, by its state.
+type loginState struct {
+	nonce   string // the ID token must carry
+	expires time.Time
+}
+
+// Authenticator owns the login flows (OIDC and/or LDAP bind) and the
+// node-local session table. Sessions are not replicated; the proxy's sticky
+// routing keeps a browser on one node.
+type Authenticator struct {
+	cfg         OIDCConfig
+	issuer      string // as the provider names itself in ID tokens
+	authURL     string // empty when OIDC is not configured
+	tokenURL    string
+	userinfoURL string
+	jwksURL     string
+	client      *http.Client
+	ldap        *LDAPDirectory // nil unless LDAP bind login is enabled
+	// audit, if set, records logins and logouts.
+	audit func(r *http.Request, actor, action, detail string)
+
+	mu       sync.Mutex
+	states   map[string]loginState
+	sessions map[string]session
+
+	keysMu      sync.Mutex // held while fetching keys; nothing else is locked under it
+	keys        jose.JSONWebKeySet
+	keysFetched time.Time
+}
+
+// authn is nil when authentication is disabled.
+var authn *Authenticator
+
+// newLDAPAuthenticator returns an Authenticator that only offers LDAP bind
+// login.
+func newLDAPAuthenticator(dir *LDAPDirectory) *Authenticator {
+	return &Authenticator{
+		ldap:     dir,
+		states:   make(map[string]loginState),
+		sessions: make(map[string]session),
+	}
+}
+
+// newAuthenticator loads the provider's discovery document.
+func newAuthenticator(cfg OIDCConfig) (*Authenticator, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(strings.TrimSuffix(cfg.Issuer, "/") + "/.well-known/openid-configuration")
+	if err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oidc discovery: %s", resp.Status)
+	}
+
+	var doc struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		UserinfoEndpoint      string `json:"userinfo_endpoint"`
+		JWKSURI               string `json:"jwks_uri"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.UserinfoEndpoint == "" || doc.JWKSURI == "" {
+		return nil, fmt.Errorf("oidc discovery: provider is missing required endpoints")
+	}
+	if strings.TrimSuffix(doc.Issuer, "/") != strings.TrimSuffix(cfg.Issuer, "/") {
+		return nil, fmt.Errorf("oidc discovery: provider calls itself %q, not %q", doc.Issuer, cfg.Issuer)
+	}
+
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "profile", "email"}
+	}
+	if cfg.GroupsClaim == "" {
+		cfg.GroupsClaim = "groups"
+	}
+	if cfg.DefaultRole == "" {
+		cfg.DefaultRole = RoleViewer
+	}
+
+	a := &Authenticator{
+		cfg:         cfg,
+		issuer:      doc.Issuer,
+		authURL:     doc.AuthorizationEndpoint,
+		tokenURL:    doc.TokenEndpoint,
+		userinfoURL: doc.UserinfoEndpoint,
+		jwksURL:     doc.JWKSURI,
+		client:      client,
+		states:      make(map[string]loginState),
+		sessions:    make(map[string]session),
+	}
+	if a.keys, err = a.fetchKeys(context.Background()); err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	a.keysFetched = time.Now()
+	return a, nil
+}
+
+func randomToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// roleFor maps groups to the highest configured role.
+func (a *Authenticator) roleFor(groups []string) string {
+	role := a.cfg.DefaultRole
+	for _, g := range groups {
+		if r, ok := a.cfg.RoleMap[g]; ok && roleRank[r] > roleRank[role] {
+			role = r
+		}
+	}
+	return role
+}
+
+func (a *Authenticator) userFromRequest(r *http.Request) *User {
+	c, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	sess, ok := a.sessions[c.Value]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(sess.expires) {
+		delete(a.sessions, c.Value)
+		return nil
+	}
+	return sess.user
+}
+
+// handleLogin starts the OIDC flow, or serves and processes the LDAP login
+// form when LDAP bind is the only (or the submitted) method.
+func (a *Authenticator) handleLogin(w http.ResponseWriter, r *http.Request) {
+	if a.ldap != nil && r.Method == http.MethodPost {
+		a.handleLDAPLogin(w, r)
+		return
+	}
+	if a.authURL == "" {
+		fmt.Fprint(w, loginHTML)
+		return
+	}
+
+	state, nonce := randomToken(), randomToken()
+	a.mu.Lock()
+	now := time.Now()
+	for s, ls := range a.states {
+		if now.After(ls.expires) {
+			delete(a.states, s)
+		}
+	}
+	a.states[state] = loginState{nonce: nonce, expires: now.Add(loginStateTTL)}
+	a.mu.Unlock()
+
+	// The state is also kept in the browser, so a callback carrying someone
+	// else's login is refused.
+	http.SetCookie(w, &http.Cookie{
+		Name:     loginCookieName,
+		Value:    state,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int(loginStateTTL.Seconds()),
+	})
+	q := url.Values{
+		"response_type": {"code"},
+		"client_id":     {a.cfg.ClientID},
+		"redirect_uri":  {a.cfg.RedirectURL},
+		"scope":         {strings.Join(a.cfg.Scopes, " ")},
+		"state":         {state},
+		"nonce":         {nonce},
+	}
+	http.Redirect(w, r, a.authURL+"?"+q.Encode(), http.StatusFound)
+}
+
+func (a *Authenticator) handleCallback(w http.ResponseWriter, r *http.Request) {
+	state := r.URL.Query().Get("state")
+	c, err := r.Cookie(loginCookieName)
+	http.SetCookie(w, &http.Cookie{Name: loginCookieName, Path: "/", HttpOnly: true, Secure: r.TLS != nil, SameSite: http.SameSiteLaxMode, MaxAge: -1})
+	if err != nil || subtle.ConstantTimeCompare([]byte(c.Value), []byte(state)) != 1 {
+		writeError(w, "login was not started in this browser", http.StatusBadRequest)
+		return
+	}
+	a.mu.Lock()
+	ls, ok := a.states[state]
+	delete(a.states, state)
+	a.mu.Unlock()
+	if !ok || time.Now().After(ls.expires) {
+		writeError(w, "invalid or expired login state", http.StatusBadRequest)
+		return
+	}
+	if e := r.URL.Query().Get("error"); e != "" {
+		writeError(w, "login failed: "+e, http.StatusUnauthorized)
+		return
+	}
+
+	user, err := a.exchange(r.Context(), r.URL.Query().Get("code"), ls.nonce)
+	if err != nil {
+		log.Printf("OIDC login failed: %v", err)
+		a.record(r, "unknown", AuditLoginFailed, "oidc: "+err.Error())
+		writeError(w, "login failed", http.StatusUnauthorized)
+		return
+	}
+
+	a.startSession(w, r, user)
+}
+
+// startSession creates a session for user, sets the cookie and redirects to
+// the board.
+func (a *Authenticator) startSession(w http.ResponseWriter, r *http.Request, user *User) {
+	id := randomToken()
+	a.mu.Lock()
+	a.sessions[id] = session{user: user, expires: time.Now().Add(sessionTTL)}
+	a.mu.Unlock()
+	log.Printf("User %s (%s) logged in with role %s", user.Name, user.ID, user.Role)
+	a.record(r, user.Name+" <"+user.ID+">", AuditLogin, "role="+user.Role)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    id,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int(sessionTTL.Seconds()),
+	})
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// exchange trades an authorization code for an ID token and an access token.
+// The ID token must be signed by the provider, for this client, and carry
+// the login's nonce; the user is then resolved through the userinfo
+// endpoint, which must answer for the same subject.
+func (a *Authenticator) exchange(ctx context.Context, code, nonce string) (*User, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {a.cfg.RedirectURL},
+		"client_id":     {a.cfg.ClientID},
+		"client_secret": {a.cfg.ClientSecret},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token endpoint returned %s", resp.Status)
+	}
+	var tok struct {
+		AccessToken string `json:"access_token"`
+		IDToken     string `json:"id_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil {
+		return nil, err
+	}
+	if tok.IDToken == "" {
+		return nil, fmt.Errorf("token response has no id_token")
+	}
+	subject, err := a.verifyIDToken(ctx, tok.IDToken, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err = http.NewRequestWithContext(ctx, http.MethodGet, a.userinfoURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+tok.AccessToken)
+	resp, err = a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("userinfo endpoint returned %s", resp.Status)
+	}
+	var claims map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&claims); err != nil {
+		return nil, err
+	}
+
+	user := &User{
+		ID:     claimString(claims, "sub"),
+		Name:   claimString(claims, "name"),
+		Email:  claimString(claims, "email"),
+		Groups: claimStrings(claims, a.cfg.GroupsClaim),
+	}
+	if user.ID != subject {
+		return nil, fmt.Errorf("userinfo is for subject %q, not the ID token's %q", user.ID, subject)
+	}
+	if user.Name == "" {
+		user.Name = user.Email
+	}
+	user.Role = a.roleFor(user.Groups)
+	return user, nil
+}
+
+// verifyIDToken checks the ID token of a login, raw: that a key of the
+// provider signed it, that the provider issued it to this client and it has
+// not expired, and that it carries nonce. It returns the token's subject.
+func (a *Authenticator) verifyIDToken(ctx context.Context, raw, nonce string) (string, error) {
+	tok, err := jwt.ParseSigned(raw)
+	if err != nil {
+		return "", fmt.Errorf("id token: %w", err)
+	}
+	if len(tok.Headers) != 1 || !slices.Contains(idTokenAlgs, tok.Headers[0].Algorithm) {
+		return "", fmt.Errorf("id token: unsupported signature")
+	}
+	key, err := a.signingKey(ctx, tok.Headers[0].KeyID)
+	if err != nil {
+		return "", fmt.Errorf("id token: %w", err)
+	}
+	var claims jwt.Claims
+	var extra struct {
+		Nonce string `json:"nonce"`
+		AZP   string `json:"azp"`
+	}
+	if err := tok.Claims(key.Key, &claims, &extra); err != nil {
+		return "", fmt.Errorf("id token: %w", err)
+	}
+	if claims.Expiry == nil {
+		return "", fmt.Errorf("id token: no expiry")
+	}
+	want := jwt.Expected{Issuer: a.issuer, Audience: jwt.Audience{a.cfg.ClientID}, Time: time.Now()}
+	if err := claims.ValidateWithLeeway(want, idTokenLeeway); err != nil {
+		return "", fmt.Errorf("id token: %w", err)
+	}
+	if len(claims.Audience) > 1 && extra.AZP != a.cfg.ClientID {
+		return "", fmt.Errorf("id token: authorized party is %q", extra.AZP)
+	}
+	if subtle.ConstantTimeCompare([]byte(extra.Nonce), []byte(nonce)) != 1 {
+		return "", fmt.Errorf("id token: nonce mismatch")
+	}
+	if claims.Subject == "" {
+		return "", fmt.Errorf("id token: no subject")
+	}
+	return claims.Subject, nil
+}
+
+// signingKey returns the provider's signing key kid, fetching the keys again
+// if it is new, as after the provider rotated them. A token without a kid
+// needs the provider to have a single signing key.
+func (a *Authenticator) signingKey(ctx context.Context, kid string) (jose.JSONWebKey, error) {
+	a.keysMu.Lock()
+	defer a.keysMu.Unlock()
+	if k, ok := findSigningKey(a.keys, kid); ok {
+		return k, nil
+	}
+	if time.Since(a.keysFetched) >= jwksRefetch {
+		keys, err := a.fetchKeys(ctx)
+		if err != nil {
+			return jose.JSONWebKey{}, err
+		}
+		a.keys, a.keysFetched = keys, time.Now()
+		if k, ok := findSigningKey(a.keys, kid); ok {
+			return k, nil
+		}
+	}
+	return jose.JSONWebKey{}, fmt.Errorf("no provider key %q", kid)
+}
+
+// findSigningKey returns the signing key kid of keys, or their only signing
+// key if kid is "".
+func findSigningKey(keys jose.JSONWebKeySet, kid string) (jose.JSONWebKey, bool) {
+	var found []jose.JSONWebKey
+	for _, k := range keys.Keys {
+		if (k.Use == "" || k.Use == "sig") && k.IsPublic() && (kid == "" || k.KeyID == kid) {
+			found = append(found, k)
+		}
+	}
+	if len(found) != 1 {
+		return jose.JSONWebKey{}, false
+	}
+	return found[0], true
+}
+
+// fetchKeys fetches the provider's public keys.
+func (a *Authenticator) fetchKeys(ctx context.Context) (jose.JSONWebKeySet, error) {
+	var keys jose.JSONWebKeySet
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.jwksURL, nil)
+	if err != nil {
+		return keys, err
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return keys, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return keys, fmt.Errorf("jwks endpoint returned %s", resp.Status)
+	}
+	err = json.NewDecoder(resp.Body).Decode(&keys)
+	return keys, err
+}
+
+func claimString(claims map[string]any, key string) string {
+	s, _ := claims[key].(string)
+	return s
+}
+
+// claimStrings accepts a claim that is either a list of strings or a single
+// space/comma separated string.
+func claimStrings(claims map[string]any, key string) []string {
+	switch v := claims[key].(type) {
+	case []any:
+		out := make([]string, 0, len(v))
+		for _, x := range v {
+			if s, ok := x.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	case string:
+		return strings.FieldsFunc(v, func(r rune) bool { return r == ',' || r == ' ' })
+	}
+	return nil
+}
+
+func (a *Authenticator) handleLDAPLogin(w http.ResponseWriter, r *http.Request) {
+	user, err := a.ldap.Authenticate(r.FormValue("username"), r.FormValue("password"))
+	if err != nil {
+		log.Printf("LDAP login failed for %q: %v", r.FormValue("username"), err)
+		a.record(r, r.FormValue("username"), AuditLoginFailed, "ldap: "+err.Error())
+		writeError(w, "invalid username or password", http.StatusUnauthorized)
+		return
+	}
+	a.startSession(w, r, user)
+}
+
+const loginHTML = `<!DOCTYPE html>
+<html>
+<head><title>DeepBoard - Sign in</title></head>
+<body style="font-family: 'Segoe UI', Tahoma, Geneva, Verdana, sans-serif; background: #f0f2f5; display: flex; justify-content: center; align-items: center; height: 100vh; margin: 0;">
+    <form method="POST" action="/login" style="background: white; padding: 24px; border-radius: 10px; box-shadow: 0 1px 3px rgba(0,0,0,0.1); display: flex; flex-direction: column; gap: 10px; width: 280px;">
+        <h2 style="margin: 0 0 8px 0; color: #2c3e50;">DeepBoard</h2>
+        <input name="username" placeholder="Username" required autofocus style="padding: 8px 12px; border: 1px solid #ddd; border-radius: 6px;">
+        <input name="password" type="password" placeholder="Password" required style="padding: 8px 12px; border: 1px solid #ddd; border-radius: 6px;">
+        <button type="submit" style="padding: 8px 16px; background: #2ecc71; color: white; border: none; border-radius: 6px; cursor: pointer; font-weight: 600;">Sign in</button>
+    </form>
+</body>
+</html>
+`
+
+func (a *Authenticator) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if c, err := r.Cookie(sessionCookieName); err == nil {
+		a.mu.Lock()
+		sess, ok := a.sessions[c.Value]
+		delete(a.sessions, c.Value)
+		a.mu.Unlock()
+		if ok {
+			a.record(r, sess.user.Name+" <"+sess.user.ID+">", AuditLogout, "")
+		}
+	}
+	http.SetCookie(w, &http.Cookie{Name: sessionCookieName, Value: "", Path: "/", MaxAge: -1})
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func (a *Authenticator) record(r *http.Request, actor, action, detail string) {
+	if a.audit != nil {
+		a.audit(r, actor, action, detail)
+	}
+}
+
+// withAuth requires an authenticated user with at least role before calling
+// h. Browsers are sent to the login page; API clients get a status code.
+// It is a pass-through when authentication is disabled, except for bots,
+// which withBots has already identified and which need the route's scope.
+func withAuth(role string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if bot := currentUser(r); bot != nil && bot.Bot {
+			if !bot.Allows(routeScope(role, r.URL.Path)) {
+				writeError(w, ErrForbidden.Error(), http.StatusForbidden)
+				return
+			}
+			h(w, r)
+			return
+		}
+		if authn == nil {
+			h(w, r)
+			return
+		}
+		user := authn.userFromRequest(r)
+		if user == nil {
+			if r.Method == http.MethodGet && strings.Contains(r.Header.Get("Accept"), "text/html") {
+				http.Redirect(w, r, "/login", http.StatusFound)
+				return
+			}
+			writeError(w, "authentication required", http.StatusUnauthorized)
+			return
+		}
+		if !user.HasRole(role) {
+			writeError(w, ErrForbidden.Error(), http.StatusForbidden)
+			return
+		}
+		h(w, r.WithContext(context.WithValue(r.Context(), userContextKey{}, user)))
+	}
+}
+
+// handleMe returns the current user, or 204 when authentication is disabled.
+func handleMe(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	if user == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
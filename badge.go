@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Badges are shields.io-style SVGs of a board metric, for READMEs and
+// dashboards. Image proxies fetch them without credentials, so when login is
+// enabled a badge URL carries the token of an embed of the whole board
+// (?embed=) instead.
+
+// Badge metrics.
+const (
+	BadgeDoneCount = "done-count" // cards moved to done this week
+	BadgeOpenBugs  = "open-bugs"  // cards labeled "bug" not yet done
+	BadgeOpenCount = "open-count" // cards not yet done
+)
+
+// Badge colors, as shields.io uses them.
+const (
+	badgeGreen = "#4c1"
+	badgeRed   = "#e05d44"
+	badgeBlue  = "#007ec6"
+	badgeGray  = "#555"
+)
+
+// maxBadgeLabel caps the length of a custom label.
+const maxBadgeLabel = 64
+
+// weekStart returns the start of the week (Monday, 00:00) of t.
+func weekStart(t time.Time) time.Time {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+}
+
+// badgeMetric returns the value, default label and color of metric on
+// board at now, or false for an unknown metric.
+func badgeMetric(board Board, metric string, now time.Time) (int, string, string, bool) {
+	n := 0
+	switch metric {
+	case BadgeDoneCount:
+		since := weekStart(now).Unix()
+		for _, c := range board.Cards {
+			if c.ColumnID == doneColumn && c.EnteredAt >= since {
+				n++
+			}
+		}
+		return n, "done this week", badgeGreen, true
+	case BadgeOpenBugs:
+		for _, c := range board.Cards {
+			if c.ColumnID != doneColumn && slices.ContainsFunc(c.Labels.Names(), func(l string) bool { return strings.EqualFold(l, "bug") }) {
+				n++
+			}
+		}
+		color := badgeGreen
+		if n > 0 {
+			color = badgeRed
+		}
+		return n, "open bugs", color, true
+	case BadgeOpenCount:
+		for _, c := range board.Cards {
+			if c.ColumnID != doneColumn {
+				n++
+			}
+		}
+		return n, "open cards", badgeBlue, true
+	}
+	return 0, "", "", false
+}
+
+// badgeTextWidth estimates the width of s in 11px Verdana, which shields.io
+// badges are set in.
+func badgeTextWidth(s string) int {
+	return len([]rune(s))*7 + 10
+}
+
+// renderBadge returns a flat badge of label and value, the value on color.
+func renderBadge(label, value, color string) string {
+	lw, vw := badgeTextWidth(label), badgeTextWidth(value)
+	label, value = html.EscapeString(label), html.EscapeString(value)
+	return fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%[1]d" height="20" role="img" aria-label="%[3]s: %[4]s">
+<title>%[3]s: %[4]s</title>
+<linearGradient id="s" x2="0" y2="100%%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>
+<clipPath id="r"><rect width="%[1]d" height="20" rx="3" fill="#fff"/></clipPath>
+<g clip-path="url(#r)"><rect width="%[2]d" height="20" fill="%[6]s"/><rect x="%[2]d" width="%[5]d" height="20" fill="%[7]s"/><rect width="%[1]d" height="20" fill="url(#s)"/></g>
+<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">
+<text x="%[8]d" y="15" fill="#010101" fill-opacity=".3">%[3]s</text><text x="%[8]d" y="14">%[3]s</text>
+<text x="%[9]d" y="15" fill="#010101" fill-opacity=".3">%[4]s</text><text x="%[9]d" y="14">%[4]s</text>
+</g>
+</svg>
+`, lw+vw, lw, label, value, vw, badgeGray, color, lw/2, lw+vw/2)
+}
+
+// handleBadge serves GET /badge.svg?metric=done-count, with an optional
+// ?label= replacing the metric's own. Requests with the token of an embed
+// of the whole board need no login.
+func handleBadge(s *Store) http.HandlerFunc {
+	serve := func(w http.ResponseWriter, r *http.Request) {
+		n, label, color, ok := badgeMetric(s.GetBoard().Board, r.URL.Query().Get("metric"), time.Now())
+		if !ok {
+			writeError(w, "unknown metric; use done-count, open-bugs or open-count", http.StatusBadRequest)
+			return
+		}
+		if l := r.URL.Query().Get("label"); l != "" {
+			label = string([]rune(l)[:min(len([]rune(l)), maxBadgeLabel)])
+		}
+		w.Header().Set("Cache-Control", "no-cache, max-age=0")
+		w.Header().Set("Content-Type", "image/svg+xml")
+		fmt.Fprint(w, renderBadge(label, strconv.Itoa(n), color))
+	}
+	viewer := withAuth(RoleViewer, serve)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if token := r.URL.Query().Get("embed"); token != "" {
+			embed, ok := s.EmbedByToken(token)
+			if !ok {
+				writeError(w, ErrEmbedNotFound.Error(), http.StatusNotFound)
+				return
+			}
+			if embed.Column != "" {
+				writeError(w, ErrForbidden.Error(), http.StatusForbidden)
+				return
+			}
+			serve(w, r)
+			return
+		}
+		viewer(w, r)
+	}
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A node serves the default board, the tenants of -tenants and the boards
+// admins create at runtime with /api/boards. Created boards are listed in
+// the default board, so the list replicates like any edit: every node opens
+// a board as the list reaches it, and closes it once it is deleted. They
+// are served like tenants, at /t/<name>/ with a database next to the main
+// one, and sync with the same board on other nodes. /b/<name> leads to any
+// board.
+
+// boardsCheckInterval is how often a node looks for boards created or
+// deleted on other nodes.
+const boardsCheckInterval = 5 * time.Second
+
+var (
+	// ErrBoardNotFound is returned for an unknown board.
+	ErrBoardNotFound = errors.New("board not found")
+	// ErrBoardExists is returned when creating a board under a name already
+	// used, even by a deleted board, whose database is still on disk.
+	ErrBoardExists = errors.New("a board with this name already exists")
+	// ErrBadBoardName is returned for a board name that cannot be a path.
+	ErrBadBoardName = errors.New("board names are up to 63 lowercase letters, digits and dashes")
+	// ErrStaticBoard is returned when deleting a board not created with
+	// /api/boards.
+	ErrStaticBoard = errors.New("only boards created with /api/boards can be deleted")
+)
+
+// BoardRef is a board created at runtime, as listed in the default board.
+type BoardRef struct {
+	Name      string `deep:"key" json:"name"`
+	CreatedBy string `json:"createdBy"`
+	CreatedAt int64  `json:"createdAt"`
+	DeletedAt int64  `json:"deletedAt,omitempty"` // 0 until deleted
+}
+
+// CreateBoard lists a new board called name, created by creator. It must be
+// called on the default board.
+func (s *Store) CreateBoard(name, creator string) error {
+	if !tenantNameRe.MatchString(name) || name == defaultBoardKey {
+		return ErrBadBoardName
+	}
+	return s.tryMutate(func(bs *BoardState) error {
+		if slices.ContainsFunc(bs.Board.Boards, func(b BoardRef) bool { return b.Name == name }) {
+			return ErrBoardExists
+		}
+		bs.Board.Boards = append(bs.Board.Boards, BoardRef{Name: name, CreatedBy: creator, CreatedAt: time.Now().Unix()})
+		return nil
+	})
+}
+
+// DeleteBoard delists board name. Its database is kept, and its name is not
+// given to another board.
+func (s *Store) DeleteBoard(name string) error {
+	return s.tryMutate(func(bs *BoardState) error {
+		i := slices.IndexFunc(bs.Board.Boards, func(b BoardRef) bool { return b.Name == name })
+		if i < 0 || bs.Board.Boards[i].DeletedAt != 0 {
+			return ErrBoardNotFound
+		}
+		bs.Board.Boards[i].DeletedAt = time.Now().Unix()
+		return nil
+	})
+}
+
+// SetTitle renames the board.
+func (s *Store) SetTitle(title string) error {
+	return s.mutate(func(bs *BoardState) { bs.Board.Title = title })
+}
+
+// Boards are the boards a node serves, the default one first.
+type Boards struct {
+	mu     sync.RWMutex
+	stores []*Store
+
+	// follow serializes opening and closing created boards.
+	follow sync.Mutex
+	open   func(name string) (*Store, error)
+	close  func(s *Store)
+}
+
+func newBoards(stores ...*Store) *Boards {
+	return &Boards{stores: stores}
+}
+
+// All returns the boards.
+func (b *Boards) All() []*Store {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return slices.Clone(b.stores)
+}
+
+// Get returns the board called key in per-board routes, or nil.
+func (b *Boards) Get(key string) *Store {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	i := slices.IndexFunc(b.stores, func(s *Store) bool { return s.BoardKey() == key })
+	if i < 0 {
+		return nil
+	}
+	return b.stores[i]
+}
+
+// Add adds a board.
+func (b *Boards) Add(s *Store) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.stores = append(b.stores, s)
+}
+
+// remove removes board key and returns it, or nil.
+func (b *Boards) remove(key string) *Store {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	i := slices.IndexFunc(b.stores, func(s *Store) bool { return s.BoardKey() == key })
+	if i < 0 {
+		return nil
+	}
+	s := b.stores[i]
+	b.stores = slices.Delete(b.stores, i, i+1)
+	return s
+}
+
+// root returns the default board, which lists the created ones.
+func (b *Boards) root() *Store {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.stores[0]
+}
+
+// Serve serves the boards listed in the default board: open opens and
+// mounts one, and close unmounts and closes one that was deleted. It must be
+// called before serving requests.
+func (b *Boards) Serve(open func(name string) (*Store, error), close func(s *Store)) {
+	b.follow.Lock()
+	b.open, b.close = open, close
+	b.follow.Unlock()
+	b.sync()
+}
+
+// Follow serves the boards created and deleted on other nodes as the list
+// reaches this one, checking it every boardsCheckInterval until the default
+// board is closed.
+func (b *Boards) Follow() {
+	root := b.root()
+	ticker := time.NewTicker(boardsCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			b.sync()
+		case <-root.done:
+			return
+		}
+	}
+}
+
+// sync opens the listed boards that are not open yet and closes the
+// deleted ones that still are.
+func (b *Boards) sync() {
+	b.follow.Lock()
+	defer b.follow.Unlock()
+	if b.open == nil {
+		return
+	}
+	for _, ref := range b.root().snap.Load().state.Board.Boards {
+		s := b.Get(ref.Name)
+		switch {
+		case ref.DeletedAt == 0 && s == nil:
+			opened, err := b.open(ref.Name)
+			if err != nil {
+				log.Printf("Failed to open board %s: %v", ref.Name, err)
+				continue
+			}
+			b.Add(opened)
+			log.Printf("Board %s mounted at %s/", ref.Name, opened.basePath)
+		case ref.DeletedAt != 0 && s != nil:
+			b.remove(ref.Name)
+			b.close(s)
+			log.Printf("Board %s deleted", ref.Name)
+		}
+	}
+}
+
+// BoardInfo is a board as /api/boards lists it.
+type BoardInfo struct {
+	Key       string `json:"key"`
+	Path      string `json:"path"`
+	Title     string `json:"title"`
+	Cards     int    `json:"cards"`
+	CreatedBy string `json:"createdBy,omitempty"` // for boards created with /api/boards
+	CreatedAt int64  `json:"createdAt,omitempty"`
+}
+
+// handleBoards manages the node's boards: GET /api/boards lists them, POST
+// {"name": "sprint-13", "title": "Sprint 13"} creates one and DELETE
+// ?name=sprint-13 deletes one. Creating and deleting take an admin.
+func handleBoards(boards *Boards) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			if u := currentUser(r); u != nil && !u.HasRole(RoleAdmin) {
+				writeMutationError(w, ErrForbidden)
+				return
+			}
+		}
+		root := boards.root()
+		switch r.Method {
+		case http.MethodGet:
+			created := root.snap.Load().state.Board.Boards
+			list := []BoardInfo{}
+			for _, s := range boards.All() {
+				state := s.snap.Load().state
+				info := BoardInfo{
+					Key:   s.BoardKey(),
+					Path:  s.basePath + "/",
+					Title: state.Board.Title,
+					Cards: len(state.Board.Cards),
+				}
+				if i := slices.IndexFunc(created, func(b BoardRef) bool { return b.Name == info.Key }); i >= 0 {
+					info.CreatedBy, info.CreatedAt = created[i].CreatedBy, created[i].CreatedAt
+				}
+				list = append(list, info)
+			}
+			w.Header().Set("Cache-Control", "no-store")
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(list)
+		case http.MethodPost:
+			var req struct {
+				Name  string `json:"name"`
+				Title string `json:"title"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeError(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if boards.Get(req.Name) != nil {
+				writeMutationError(w, ErrBoardExists)
+				return
+			}
+			if err := root.CreateBoard(req.Name, requestActor(r)); err != nil {
+				writeMutationError(w, err)
+				return
+			}
+			boards.sync()
+			s := boards.Get(req.Name)
+			if s == nil {
+				writeError(w, "the board could not be opened", http.StatusInternalServerError)
+				return
+			}
+			if title := strings.TrimSpace(req.Title); title != "" {
+				if err := s.SetTitle(title); err != nil {
+					writeMutationError(w, err)
+					return
+				}
+			}
+			root.Audit(r, AuditBoardCreate, req.Name)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(BoardInfo{
+				Key:       req.Name,
+				Path:      s.basePath + "/",
+				Title:     s.snap.Load().state.Board.Title,
+				Cards:     len(s.snap.Load().state.Board.Cards),
+				CreatedBy: requestActor(r),
+				CreatedAt: time.Now().Unix(),
+			})
+		case http.MethodDelete:
+			name := r.URL.Query().Get("name")
+			err := root.DeleteBoard(name)
+			if errors.Is(err, ErrBoardNotFound) && boards.Get(name) != nil {
+				err = ErrStaticBoard
+			}
+			if err != nil {
+				writeMutationError(w, err)
+				return
+			}
+			boards.sync()
+			root.Audit(r, AuditBoardDelete, name)
+			w.WriteHeader(http.StatusOK)
+		default:
+			writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// handleBoardLink serves /b/{board}/..., redirecting to the same path on
+// the board, wherever it is mounted.
+func handleBoardLink(boards *Boards) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s := boards.Get(r.PathValue("board"))
+		if s == nil {
+			writeMutationError(w, ErrBoardNotFound)
+			return
+		}
+		target := s.basePath + "/" + r.PathValue("path")
+		if r.URL.RawQuery != "" {
+			target += "?" + r.URL.RawQuery
+		}
+		http.Redirect(w, r, target, http.StatusFound)
+	}
+}
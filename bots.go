@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A bot is an account for automations. It cannot log in: it calls the board
+// API with "Authorization: Bearer <token>", limited to its scopes, and its
+// edits are attributed to it in the history. Bots live in the CRDT, so a
+// revocation reaches every node; only a hash of each token is kept.
+
+// Bot scopes. Each board route needs one: admin routes need ScopeAdmin,
+// editor routes ScopeCardsWrite, the history ScopeHistoryRead and everything
+// else ScopeCardsRead.
+const (
+	ScopeCardsRead   = "cards:read"
+	ScopeCardsWrite  = "cards:write" // implies cards:read
+	ScopeHistoryRead = "history:read"
+	ScopeAdmin       = "admin" // implies every scope
+)
+
+var botScopes = []string{ScopeCardsRead, ScopeCardsWrite, ScopeHistoryRead, ScopeAdmin}
+
+// historyRoutes are the viewer routes that need ScopeHistoryRead.
+var historyRoutes = []string{"/history", "/api/history", "/api/changes", "/api/cards/versions"}
+
+var (
+	// ErrBotNotFound is returned for an unknown bot.
+	ErrBotNotFound = errors.New("bot not found")
+	// ErrBotExists is returned when creating a bot under a name already
+	// used, even by a revoked bot, so history entries stay unambiguous.
+	ErrBotExists = errors.New("a bot with this name already exists")
+	// ErrBadBot is returned for a bot without a valid name or scopes.
+	ErrBadBot = errors.New("bot needs a name of letters, digits, '.', '_' or '-' and known scopes")
+)
+
+var botName = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// Bot is an automation account.
+type Bot struct {
+	ID        string   `deep:"key" json:"id"` // "bot:<name>", the actor of its edits
+	Name      string   `json:"name"`
+	TokenHash string   `json:"tokenHash,omitempty"` // hex SHA-256 of the token
+	Scopes    []string `json:"scopes"`
+	CreatedBy string   `json:"createdBy"`
+	CreatedAt int64    `json:"createdAt"`
+	RevokedAt int64    `json:"revokedAt,omitempty"` // 0 while active
+}
+
+// user returns the identity requests with the bot's token run as. Its role
+// is the highest its scopes allow, for handlers that check roles themselves.
+func (b Bot) user() *User {
+	role := RoleViewer
+	switch {
+	case slices.Contains(b.Scopes, ScopeAdmin):
+		role = RoleAdmin
+	case slices.Contains(b.Scopes, ScopeCardsWrite):
+		role = RoleEditor
+	}
+	return &User{ID: b.ID, Name: b.Name, Role: role, Bot: true, Scopes: b.Scopes}
+}
+
+// Allows reports whether u may use scope. People are limited by their role
+// only, so it is true for them.
+func (u *User) Allows(scope string) bool {
+	if !u.Bot || slices.Contains(u.Scopes, ScopeAdmin) || slices.Contains(u.Scopes, scope) {
+		return true
+	}
+	return scope == ScopeCardsRead && slices.Contains(u.Scopes, ScopeCardsWrite)
+}
+
+// routeScope returns the scope a bot needs for a route of path that people
+// need role for.
+func routeScope(role, path string) string {
+	switch {
+	case role == RoleAdmin:
+		return ScopeAdmin
+	case role == RoleEditor:
+		return ScopeCardsWrite
+	case slices.Contains(historyRoutes, path):
+		return ScopeHistoryRead
+	}
+	return ScopeCardsRead
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateBot adds a bot called name with scopes, created by creator, and
+// returns it with the token it authenticates with. Only the token's hash is
+// stored, so it cannot be shown again.
+func (s *Store) CreateBot(name string, scopes []string, creator string) (Bot, string, error) {
+	if !botName.MatchString(name) || len(scopes) == 0 ||
+		slices.ContainsFunc(scopes, func(sc string) bool { return !slices.Contains(botScopes, sc) }) {
+		return Bot{}, "", ErrBadBot
+	}
+	token := "dbb_" + randomToken()
+	bot := Bot{
+		ID:        "bot:" + name,
+		Name:      name,
+		TokenHash: hashToken(token),
+		Scopes:    slices.Compact(slices.Sorted(slices.Values(scopes))),
+		CreatedBy: creator,
+		CreatedAt: time.Now().Unix(),
+	}
+	err := s.tryMutate(func(bs *BoardState) error {
+		if slices.ContainsFunc(bs.Board.Bots, func(b Bot) bool { return b.ID == bot.ID }) {
+			return ErrBotExists
+		}
+		bs.Board.Bots = append(bs.Board.Bots, bot)
+		return nil
+	})
+	if err != nil {
+		return Bot{}, "", err
+	}
+	return bot, token, nil
+}
+
+// RevokeBot disables bot id's token for good. The bot stays listed so its
+// history entries can still be traced to it.
+func (s *Store) RevokeBot(id string) error {
+	return s.tryMutate(func(bs *BoardState) error {
+		i := slices.IndexFunc(bs.Board.Bots, func(b Bot) bool { return b.ID == id })
+		if i < 0 {
+			return ErrBotNotFound
+		}
+		if bs.Board.Bots[i].RevokedAt == 0 {
+			bs.Board.Bots[i].RevokedAt = time.Now().Unix()
+		}
+		return nil
+	})
+}
+
+// BotByToken returns the active bot that token belongs to.
+func (s *Store) BotByToken(token string) (Bot, bool) {
+	hash := hashToken(token)
+	for _, b := range s.GetBoard().Board.Bots {
+		if b.TokenHash == hash && b.RevokedAt == 0 {
+			return b, true
+		}
+	}
+	return Bot{}, false
+}
+
+// bearerToken returns the token of an "Authorization: Bearer" header.
+func bearerToken(r *http.Request) (string, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return strings.TrimSpace(token), ok && strings.TrimSpace(token) != ""
+}
+
+// withBots serves the board's routes to people and bots. Requests with a
+// bot token run as the bot, on a mux over a handle of the store that
+// attributes edits to it; those with an unknown or revoked token are
+// rejected rather than served anonymously.
+func withBots(s *Store, directory *LDAPDirectory) http.Handler {
+	people := newBoardMux(s, directory)
+	var mu sync.Mutex
+	bots := map[string]*http.ServeMux{}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := bearerToken(r)
+		if !ok {
+			people.ServeHTTP(w, r)
+			return
+		}
+		bot, ok := s.BotByToken(token)
+		if !ok {
+			writeError(w, "invalid or revoked bot token", http.StatusUnauthorized)
+			return
+		}
+		mu.Lock()
+		mux, ok := bots[bot.ID]
+		if !ok {
+			mux = newBoardMux(s.As(bot.ID), directory)
+			bots[bot.ID] = mux
+		}
+		mu.Unlock()
+		mux.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userContextKey{}, bot.user())))
+	})
+}
+
+// handleBots manages the board's bots: GET lists them, POST {"name":
+// "ci-sync", "scopes": ["cards:write"]} creates one and returns its token,
+// which is not shown again, and DELETE ?id= revokes one.
+func handleBots(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			bots := slices.Clone(s.GetBoard().Board.Bots)
+			for i := range bots {
+				bots[i].TokenHash = ""
+			}
+			slices.SortFunc(bots, func(a, b Bot) int { return strings.Compare(a.Name, b.Name) })
+			if bots == nil {
+				bots = []Bot{}
+			}
+			w.Header().Set("Cache-Control", "no-store")
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(bots)
+		case http.MethodPost:
+			var req struct {
+				Name   string   `json:"name"`
+				Scopes []string `json:"scopes"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeError(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			bot, token, err := s.CreateBot(req.Name, req.Scopes, requestActor(r))
+			if err != nil {
+				writeMutationError(w, err)
+				return
+			}
+			s.Audit(r, AuditBotCreate, bot.ID+" "+strings.Join(bot.Scopes, ","))
+			bot.TokenHash = ""
+			w.Header().Set("Cache-Control", "no-store")
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(struct {
+				Bot   Bot    `json:"bot"`
+				Token string `json:"token"`
+			}{bot, token})
+		case http.MethodDelete:
+			id := r.URL.Query().Get("id")
+			if err := s.RevokeBot(id); err != nil {
+				writeMutationError(w, err)
+				return
+			}
+			s.Audit(r, AuditBotRevoke, id)
+			w.WriteHeader(http.StatusOK)
+		default:
+			writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Scripts drive the board through /api/cards without the page: GET lists
+// the cards and POST creates one, and /api/cards/{card} reads (GET),
+// updates (PUT) or deletes (DELETE) one, named by its ID or its key. Every
+// answer is the card as CardInfo, with its column and its order in it. The
+// edits go through the same store methods as the page's, so they are
+// checked, attributed and replicated the same way.
+
+// CardInput is a card as scripts create or update it. Fields left out of an
+// update are kept.
+type CardInput struct {
+	Title       *string   `json:"title"`
+	Description *string   `json:"description"`
+	Column      *string   `json:"column"`
+	Index       *int      `json:"index"` // position in the column, its end by default
+	Assignee    *string   `json:"assignee"`
+	Labels      *[]string `json:"labels"`
+	Priority    *string   `json:"priority"`
+	Estimate    *string   `json:"estimate"`
+	Sprint      *string   `json:"sprint"`
+	Due         *string   `json:"due"`
+}
+
+// check rejects input that is malformed or names a column or sprint state
+// does not have.
+func (in CardInput) check(state BoardState) error {
+	if in.Column != nil && !slices.ContainsFunc(state.Board.Columns, func(c Column) bool { return c.ID == *in.Column }) {
+		return ErrColumnNotFound
+	}
+	if in.Estimate != nil && utf8.RuneCountInString(*in.Estimate) > maxEstimateLen {
+		return ErrBadEstimate
+	}
+	if in.Due != nil && *in.Due != "" {
+		if _, err := time.Parse(dueLayout, *in.Due); err != nil {
+			return ErrBadDue
+		}
+	}
+	if in.Sprint != nil && *in.Sprint != "" {
+		i := findSprint(&state, *in.Sprint)
+		if i < 0 {
+			return ErrSprintNotFound
+		}
+		if state.Board.Sprints[i].EndedAt != 0 {
+			return ErrSprintEnded
+		}
+	}
+	return nil
+}
+
+// validate rejects what set would fail to apply to a card of column
+// fromCol of bs, edited by user.
+func (in CardInput) validate(s *Store, bs *BoardState, user *User, fromCol string) error {
+	if err := in.check(*bs); err != nil {
+		return err
+	}
+	growth := 0
+	// Encrypted titles and descriptions are only ever replaced whole.
+	if in.Title != nil {
+		if bs.Board.Encryption.Enabled() && !isSealed(*in.Title) {
+			return ErrPlaintext
+		}
+		growth += len(*in.Title)
+	}
+	if in.Description != nil {
+		if bs.Board.Encryption.Enabled() && !isSealed(*in.Description) {
+			return ErrPlaintext
+		}
+		if err := s.quota.checkDescription(len(*in.Description)); err != nil {
+			return err
+		}
+		growth += len(*in.Description)
+	}
+	if err := s.checkGrowth(growth); err != nil {
+		return err
+	}
+	if in.Column != nil {
+		return checkColumnAccess(*bs, user, *in.Column)
+	}
+	if in.Index != nil {
+		return checkColumnAccess(*bs, user, fromCol)
+	}
+	return nil
+}
+
+// set sets the fields of in on card cardID of bs, moving it last, and
+// returns the events of the edit. in must have been validated.
+func (in CardInput) set(s *Store, bs *BoardState, cardID string) []Event {
+	card := bs.Board.Cards[cardID]
+	var events []Event
+	if in.Title != nil && *in.Title != card.Title {
+		card.Title = *in.Title
+		events = append(events, Event{Type: EventCardUpdated, CardID: cardID, Title: card.Title})
+	}
+	if in.Description != nil {
+		card.Description = textDelete(card.Description, 0, textLen(card.Description))
+		card.Description = textInsert(card.Description, 0, *in.Description, s.crdt.Clock())
+		events = append(events, Event{Type: EventCardUpdated, CardID: cardID})
+	}
+	if in.Assignee != nil {
+		if assignee := strings.TrimSpace(*in.Assignee); assignee != card.Assignee {
+			card.Assignee = assignee
+			events = append(events, Event{Type: EventCardAssigned, CardID: cardID, Title: card.Title, Assignee: assignee})
+		}
+	}
+	if in.Labels != nil {
+		card.Labels = labelSetOf(*in.Labels...)
+	}
+	if in.Priority != nil {
+		card.Priority = *in.Priority
+	}
+	if in.Estimate != nil {
+		card.Estimate = *in.Estimate
+	}
+	if in.Sprint != nil {
+		card.Sprint = *in.Sprint
+	}
+	if in.Due != nil {
+		card.Due = *in.Due
+	}
+	if in.Column != nil || in.Index != nil {
+		from, to, index := card.ColumnID, card.ColumnID, len(bs.Board.Cards)
+		if in.Column != nil {
+			to = *in.Column
+		}
+		if in.Index != nil {
+			index = *in.Index
+		}
+		card, _ = placeCard(bs, card, to, index)
+		events = append(events, Event{Type: EventCardMoved, CardID: cardID, Title: card.Title, From: from, Column: card.ColumnID})
+	}
+	bs.Board.Cards[cardID] = card
+	return events
+}
+
+// UpdateCard sets the fields of in on card cardID in a single edit made by
+// user, so an update that fails changes nothing.
+func (s *Store) UpdateCard(user *User, cardID string, in CardInput) error {
+	return s.tryMutate(func(bs *BoardState) error {
+		card, ok := bs.Board.Cards[cardID]
+		if !ok {
+			return ErrCardNotFound
+		}
+		if err := in.validate(s, bs, user, card.ColumnID); err != nil {
+			return err
+		}
+		s.stage(in.set(s, bs, cardID)...)
+		return nil
+	})
+}
+
+// lookupCard returns the card of board with ID or key ref.
+func lookupCard(board Board, ref string) (Card, bool) {
+	if c, ok := board.Cards[ref]; ok {
+		return c, true
+	}
+	if !strings.HasPrefix(ref, cardKeyPrefix+"-") {
+		return Card{}, false
+	}
+	for _, c := range board.Cards {
+		if cardKey(c.Number) == ref {
+			return c, true
+		}
+	}
+	return Card{}, false
+}
+
+// canEditCards reports whether the user of r may edit cards, writing the
+// error if not. The card routes are open to viewers, who may only read.
+func canEditCards(w http.ResponseWriter, r *http.Request) bool {
+	if u := currentUser(r); u != nil && !u.HasRole(RoleEditor) {
+		writeMutationError(w, ErrForbidden)
+		return false
+	}
+	return true
+}
+
+// writeCard answers card cardID of s with status.
+func writeCard(w http.ResponseWriter, s *Store, cardID string, status int) {
+	card, ok := s.snap.Load().state.Board.Cards[cardID]
+	if !ok {
+		writeMutationError(w, ErrCardNotFound)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(newCardInfo(card))
+}
+
+// createCard serves POST /api/cards, {"title": "Fix login", "column":
+// "todo"} with any other CardInput field, and answers the new card. Cards go
+// to the end of the default column, "todo", unless told otherwise.
+func createCard(s *Store, w http.ResponseWriter, r *http.Request) {
+	if !canEditCards(w, r) {
+		return
+	}
+	var in CardInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if in.Title == nil || strings.TrimSpace(*in.Title) == "" {
+		writeError(w, "a card needs a title", http.StatusBadRequest)
+		return
+	}
+	state := s.GetBoard()
+	if err := in.check(state); err != nil {
+		writeMutationError(w, err)
+		return
+	}
+	draft := CardDraft{Title: *in.Title, ColumnID: defaultColumn(state.Board)}
+	if in.Column != nil {
+		draft.ColumnID = *in.Column
+	}
+	if err := checkColumnAccess(state, currentUser(r), draft.ColumnID); err != nil {
+		writeMutationError(w, err)
+		return
+	}
+	if in.Description != nil {
+		draft.Description = *in.Description
+	}
+	if in.Assignee != nil {
+		draft.Assignee = *in.Assignee
+	}
+	if in.Labels != nil {
+		draft.Labels = *in.Labels
+	}
+	if in.Priority != nil {
+		draft.Priority = *in.Priority
+	}
+	// What the draft cannot hold is set on the new card in the same edit, so
+	// a card is only created whole.
+	rest := CardInput{Index: in.Index, Estimate: in.Estimate, Sprint: in.Sprint, Due: in.Due}
+	ids, cols := make([]string, 1), make([]string, 1)
+	err := s.tryMutate(func(bs *BoardState) error {
+		if err := rest.validate(s, bs, currentUser(r), draft.ColumnID); err != nil {
+			return err
+		}
+		if err := s.addDrafts(bs, []CardDraft{draft}, ids, cols); err != nil {
+			return err
+		}
+		s.stage(Event{Type: EventCardCreated, CardID: ids[0], Title: draft.Title, Column: cols[0]})
+		s.stage(rest.set(s, bs, ids[0])...)
+		return nil
+	})
+	if err != nil {
+		writeMutationError(w, err)
+		return
+	}
+	id := ids[0]
+	go githubOnCardCreated(s, id, draft.Title)
+	writeCard(w, s, id, http.StatusCreated)
+}
+
+// handleCard serves /api/cards/{card}: GET answers the card, PUT updates it
+// with a CardInput and answers it, and DELETE deletes it, for as long as
+// deletions can be undone.
+func handleCard(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		card, ok := lookupCard(s.snap.Load().state.Board, r.PathValue("card"))
+		if !ok {
+			writeMutationError(w, ErrCardNotFound)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			writeCard(w, s, card.ID, http.StatusOK)
+		case http.MethodPut:
+			if !canEditCards(w, r) {
+				return
+			}
+			var in CardInput
+			if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+				writeError(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if in.Title != nil && strings.TrimSpace(*in.Title) == "" {
+				writeError(w, "a card needs a title", http.StatusBadRequest)
+				return
+			}
+			if err := s.UpdateCard(currentUser(r), card.ID, in); err != nil {
+				writeMutationError(w, err)
+				return
+			}
+			writeCard(w, s, card.ID, http.StatusOK)
+		case http.MethodDelete:
+			if !canEditCards(w, r) {
+				return
+			}
+			if err := s.DeleteCard(card.ID); err != nil {
+				writeMutationError(w, err)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		default:
+			writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
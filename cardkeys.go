@@ -0,0 +1,192 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"log"
+	"maps"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cards have short keys, "DB-" and a number, that descriptions and comments
+// can mention as #DB-42. A new card takes the next number, so cards created
+// on two nodes at once can end up sharing one. Every node periodically
+// repairs that, deterministically: of the cards sharing a number, the one
+// created first keeps it, and the others, like cards from before keys
+// existed, get the next free numbers, in creation order. Nodes working from
+// the same state make the same repair, so the keys converge.
+
+// cardKeyPrefix starts every card key.
+const cardKeyPrefix = "DB"
+
+// keyRepairInterval is how often each node looks for cards to renumber.
+const keyRepairInterval = time.Minute
+
+// cardMentionPattern matches a card mention; the number is the first group.
+var cardMentionPattern = regexp.MustCompile(`#` + cardKeyPrefix + `-(\d+)\b`)
+
+// cardKey returns the key of the card numbered n, "" for an unnumbered card.
+func cardKey(n int) string {
+	if n <= 0 {
+		return ""
+	}
+	return cardKeyPrefix + "-" + strconv.Itoa(n)
+}
+
+// nextCardNumber returns the number after the highest one in use.
+func nextCardNumber(bs *BoardState) int {
+	n := 0
+	for _, c := range bs.Board.Cards {
+		n = max(n, c.Number)
+	}
+	return n + 1
+}
+
+// byCreation orders cards by when they were created, then by ID.
+func byCreation(a, b Card) int {
+	return cmp.Or(cmp.Compare(a.EnteredAt, b.EnteredAt), strings.Compare(a.ID, b.ID))
+}
+
+// keyRepairs returns the new numbers of the cards that need one.
+func keyRepairs(bs *BoardState) map[string]int {
+	cards := slices.SortedFunc(maps.Values(bs.Board.Cards), byCreation)
+	taken := make(map[int]bool, len(cards))
+	var renumber []string
+	for _, c := range cards {
+		if c.Number <= 0 || taken[c.Number] {
+			renumber = append(renumber, c.ID)
+			continue
+		}
+		taken[c.Number] = true
+	}
+	if len(renumber) == 0 {
+		return nil
+	}
+	next := nextCardNumber(bs)
+	repairs := make(map[string]int, len(renumber))
+	for i, id := range renumber {
+		repairs[id] = next + i
+	}
+	return repairs
+}
+
+// RepairCardKeys numbers the cards without a number and renumbers those
+// sharing one.
+func (s *Store) RepairCardKeys() error {
+	if s.readOnly {
+		return nil
+	}
+	state := s.GetBoard()
+	if keyRepairs(&state) == nil {
+		return nil
+	}
+	return s.tryMutate(func(bs *BoardState) error {
+		for id, n := range keyRepairs(bs) {
+			c := bs.Board.Cards[id]
+			c.Number = n
+			bs.Board.Cards[id] = c
+		}
+		return nil
+	})
+}
+
+// startKeyRepair repairs card keys now and then every keyRepairInterval
+// until the store is closed.
+func startKeyRepair(s *Store) {
+	ticker := time.NewTicker(keyRepairInterval)
+	defer ticker.Stop()
+	for {
+		if err := s.RepairCardKeys(); err != nil && err != ErrBoardFrozen {
+			log.Printf("Failed to repair card keys: %v", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// CardRef names a card in links.
+type CardRef struct {
+	ID    string `json:"id"`
+	Key   string `json:"key"`
+	Title string `json:"title"`
+}
+
+// cardMentions returns the numbers of the cards c mentions, in its
+// description or comments.
+func cardMentions(c Card) []int {
+	var nums []int
+	texts := []string{textString(c.Description)}
+	for _, cm := range c.Comments {
+		texts = append(texts, cm.Body)
+	}
+	for _, t := range texts {
+		for _, m := range cardMentionPattern.FindAllStringSubmatch(t, -1) {
+			if n, err := strconv.Atoi(m[1]); err == nil {
+				nums = append(nums, n)
+			}
+		}
+	}
+	slices.Sort(nums)
+	return slices.Compact(nums)
+}
+
+// cardLinks returns the cards that the card id mentions and those that
+// mention it, each ordered by number.
+func cardLinks(board Board, id string) (links, backlinks []CardRef) {
+	links, backlinks = []CardRef{}, []CardRef{}
+	card, ok := board.Cards[id]
+	if !ok {
+		return links, backlinks
+	}
+	byNumber := make(map[int]Card, len(board.Cards))
+	for _, c := range slices.SortedFunc(maps.Values(board.Cards), byCreation) {
+		if _, dup := byNumber[c.Number]; !dup {
+			byNumber[c.Number] = c
+		}
+	}
+	ref := func(c Card) CardRef { return CardRef{ID: c.ID, Key: cardKey(c.Number), Title: c.Title} }
+	for _, n := range cardMentions(card) {
+		if c, ok := byNumber[n]; ok && c.ID != id {
+			links = append(links, ref(c))
+		}
+	}
+	if card.Number > 0 {
+		byNum := func(a, b Card) int { return cmp.Or(cmp.Compare(a.Number, b.Number), byCreation(a, b)) }
+		for _, c := range slices.SortedFunc(maps.Values(board.Cards), byNum) {
+			if c.ID != id && slices.Contains(cardMentions(c), card.Number) {
+				backlinks = append(backlinks, ref(c))
+			}
+		}
+	}
+	return links, backlinks
+}
+
+// handleCardLinks lists a card's mentions and backlinks, GET
+// /api/cards/links?card=<id>.
+func handleCardLinks(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		board := s.GetBoard().Board
+		id := r.URL.Query().Get("card")
+		card, ok := board.Cards[id]
+		if !ok {
+			writeError(w, ErrCardNotFound.Error(), http.StatusNotFound)
+			return
+		}
+		links, backlinks := cardLinks(board, id)
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Key       string    `json:"key"`
+			Links     []CardRef `json:"links"`
+			Backlinks []CardRef `json:"backlinks"`
+		}{cardKey(card.Number), links, backlinks})
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"cmp"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CardInfo is a card as the card API returns it, with its description as
+// plain text.
+type CardInfo struct {
+	ID          string          `json:"id"`
+	Key         string          `json:"key"`
+	Title       string          `json:"title"`
+	Description string          `json:"description"`
+	Column      string          `json:"column"`
+	Order       float64         `json:"order"` // position in the column, see sortCards
+	Assignee    string          `json:"assignee"`
+	Labels      []string        `json:"labels"`
+	Priority    string          `json:"priority"`
+	Votes       int             `json:"votes"`
+	Estimate    string          `json:"estimate"`
+	Sprint      string          `json:"sprint"`
+	Due         string          `json:"due"`
+	PastDue     bool            `json:"pastDue"`   // due before today
+	Checklist   []ChecklistItem `json:"checklist"` // in order
+	EnteredAt   int64           `json:"enteredAt"`
+	number      int
+	columnIndex int
+}
+
+// cardList is how cards can be paged and sorted.
+var cardList = listSpec[CardInfo]{
+	DefaultLimit: 50,
+	MaxLimit:     500,
+	DefaultSort:  "key",
+	Orders: map[string]func(a, b CardInfo) int{
+		"key":       func(a, b CardInfo) int { return cmp.Compare(a.number, b.number) },
+		"title":     func(a, b CardInfo) int { return strings.Compare(strings.ToLower(a.Title), strings.ToLower(b.Title)) },
+		"column":    func(a, b CardInfo) int { return cmp.Compare(a.columnIndex, b.columnIndex) },
+		"priority":  func(a, b CardInfo) int { return cmp.Compare(priorityRank(a.Priority), priorityRank(b.Priority)) },
+		"votes":     func(a, b CardInfo) int { return cmp.Compare(a.Votes, b.Votes) },
+		"due":       func(a, b CardInfo) int { return compareDue(a.Due, b.Due) },
+		"enteredAt": func(a, b CardInfo) int { return cmp.Compare(a.EnteredAt, b.EnteredAt) },
+	},
+}
+
+// cardInfos returns the cards of a board in board order.
+func cardInfos(state BoardState) []CardInfo {
+	var infos []CardInfo
+	for i, col := range buildUIColumns(state) {
+		for _, c := range col.Cards {
+			info := newCardInfo(c)
+			info.columnIndex = i
+			infos = append(infos, info)
+		}
+	}
+	return infos
+}
+
+// newCardInfo returns c as the card API returns it.
+func newCardInfo(c Card) CardInfo {
+	return CardInfo{
+		ID:          c.ID,
+		Key:         cardKey(c.Number),
+		Title:       c.Title,
+		Description: textString(c.Description),
+		Column:      c.ColumnID,
+		Order:       c.Order,
+		Assignee:    c.Assignee,
+		Labels:      c.Labels.Names(),
+		Priority:    c.Priority,
+		Votes:       len(c.Votes),
+		Estimate:    c.Estimate,
+		Sprint:      c.Sprint,
+		Due:         c.Due,
+		PastDue:     pastDue(c.Due, time.Now()),
+		Checklist:   sortChecklist(c.Checklist),
+		EnteredAt:   c.EnteredAt,
+		number:      c.Number,
+	}
+}
+
+// handleCards lists the board's cards: GET /api/cards, with the list
+// parameters (sort by key, title, column, priority, votes, due or
+// enteredAt; by key by default), or only those with a label with
+// ?label=bug, or those of one person with ?assignee=ada ("me" for the
+// viewer, "none" for unassigned cards). POST creates one, see createCard.
+func handleCards(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			createCard(s, w, r)
+			return
+		}
+		p, err := cardList.parse(r)
+		if err != nil {
+			writeMutationError(w, err)
+			return
+		}
+		infos := cardInfos(s.GetBoard())
+		if label := r.URL.Query().Get("label"); label != "" {
+			infos = slices.DeleteFunc(infos, func(c CardInfo) bool {
+				return !slices.ContainsFunc(c.Labels, func(l string) bool { return strings.EqualFold(l, label) })
+			})
+		}
+		if assignee := r.URL.Query().Get("assignee"); assignee != "" {
+			handles := viewerHandles(r)
+			infos = slices.DeleteFunc(infos, func(c CardInfo) bool { return !assignedTo(c, assignee, handles) })
+		}
+		page, err := cardList.page(infos, p)
+		if err != nil {
+			writeMutationError(w, err)
+			return
+		}
+		writePage(w, page, p)
+	}
+}
+
+// HistoryEntry is one edit of the board's history.
+type HistoryEntry struct {
+	ID        int64  `json:"id"`
+	Timestamp string `json:"timestamp"` // HLC timestamp of the edit
+	Summary   string `json:"summary"`
+	Actor     string `json:"actor,omitempty"` // the bot that made the edit, e.g. "bot:ci-sync"
+	Color     string `json:"color,omitempty"` // the actor's, see colors.go
+	Kind      string `json:"kind"`            // content, presence or admin, see patchkind.go
+}
+
+// historyList is how the history can be paged: by time only, newest first
+// by default.
+var historyList = listSpec[HistoryEntry]{
+	DefaultLimit: 15,
+	MaxLimit:     200,
+	DefaultSort:  "-time",
+	Orders: map[string]func(a, b HistoryEntry) int{
+		"time": func(a, b HistoryEntry) int { return cmp.Compare(a.ID, b.ID) },
+	},
+}
+
+// HistoryPage returns up to limit entries of the history after the entry
+// with ID after (0 for the start), oldest first when asc and newest first
+// otherwise, and whether more follow. Only content edits are listed unless
+// all is set.
+func (s *Store) HistoryPage(after int64, limit int, asc, all bool) ([]HistoryEntry, bool, error) {
+	s.histMu.RLock()
+	defer s.histMu.RUnlock()
+
+	query := "SELECT id, timestamp, summary, actor, kind FROM patches WHERE id > ? AND (? OR kind = ?) ORDER BY id LIMIT ?"
+	if !asc {
+		query = "SELECT id, timestamp, summary, actor, kind FROM patches WHERE id < ? AND (? OR kind = ?) ORDER BY id DESC LIMIT ?"
+		if after == 0 {
+			after = math.MaxInt64
+		}
+	}
+	rows, err := s.db.Query(query, after, all, PatchContent, limit+1)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	board := s.snap.Load().state.Board
+	var entries []HistoryEntry
+	for rows.Next() {
+		var e HistoryEntry
+		if err := rows.Scan(&e.ID, &e.Timestamp, &e.Summary, &e.Actor, &e.Kind); err != nil {
+			return nil, false, err
+		}
+		if e.Actor != "" {
+			e.Color = userColor(board, e.Actor)
+		}
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+	if len(entries) > limit {
+		return entries[:limit], true, nil
+	}
+	return entries, false, nil
+}
+
+// recentHistory returns the latest entries of the history, newest first, as
+// the Activity sidebar lists them: content edits only, unless all is set.
+func (s *Store) recentHistory(all bool) []HistoryEntry {
+	entries, _, _ := s.HistoryPage(0, historyList.DefaultLimit, false, all)
+	return entries
+}
+
+// handleHistoryAPI lists the board's history: GET /api/history, with the
+// list parameters (sort by time, newest first by default). Its cursors
+// stay valid while new edits are made. Presence and admin entries are left
+// out unless ?all=true.
+func handleHistoryAPI(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, err := historyList.parse(r)
+		if err != nil {
+			writeMutationError(w, err)
+			return
+		}
+		var after int64
+		if p.Cursor != "" {
+			if after, err = strconv.ParseInt(p.Cursor, 10, 64); err != nil || after <= 0 {
+				writeMutationError(w, ErrBadQuery)
+				return
+			}
+		}
+		all, _ := strconv.ParseBool(r.URL.Query().Get("all"))
+		entries, more, err := s.HistoryPage(after, p.Limit, !p.Desc, all)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		page := Page[HistoryEntry]{Items: entries}
+		if more {
+			page.NextCursor = strconv.FormatInt(entries[len(entries)-1].ID, 10)
+		}
+		writePage(w, page, p)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultChangesLimit = 100
+	maxChangesLimit     = 1000
+)
+
+// Change is an Event as exposed by the polling feed. Cursor is the stable,
+// monotonically increasing position of the change in this node's feed.
+type Change struct {
+	Cursor string `json:"cursor"`
+	Event
+}
+
+// recordChanges appends events to the change feed in tx, the transaction
+// that saves the change they describe, so the feed has every saved change
+// and nothing else.
+func recordChanges(tx *sql.Tx, events []Event) error {
+	for _, ev := range events {
+		data, err := json.Marshal(ev)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec("INSERT INTO changes (data) VALUES (?)", data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// remoteChanges normalizes a change merged from a peer into feed events by
+// comparing the cards before and after. These events are only recorded and
+// observed (see observeRemote), never emitted, so local automation does not
+// re-run on replicated edits.
+func remoteChanges(before, after map[string]Card) []Event {
+	events := diffCards(before, after)
+	now := time.Now().UnixMilli()
+	for i := range events {
+		events[i].Remote = true
+		events[i].Time = now
+	}
+	return events
+}
+
+// observeRemote passes the events of a change merged from a peer, once it
+// is saved, to the notifications and column streams.
+func (s *Store) observeRemote(events []Event) {
+	for _, ev := range events {
+		s.notify(ev)
+		s.feedColumns(ev)
+	}
+}
+
+// GetChanges returns up to limit changes after the given cursor, oldest
+// first. A zero cursor returns the most recent changes.
+func (s *Store) GetChanges(since int64, limit int) ([]Change, error) {
+	var query string
+	if since > 0 {
+		query = "SELECT id, data FROM changes WHERE id > ? ORDER BY id ASC LIMIT ?"
+	} else {
+		query = "SELECT id, data FROM (SELECT id, data FROM changes WHERE id > ? ORDER BY id DESC LIMIT ?) ORDER BY id ASC"
+	}
+	rows, err := s.db.Query(query, since, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := []Change{}
+	for rows.Next() {
+		var id int64
+		var data []byte
+		if err := rows.Scan(&id, &data); err != nil {
+			return nil, err
+		}
+		c := Change{Cursor: strconv.FormatInt(id, 10)}
+		if err := json.Unmarshal(data, &c.Event); err != nil {
+			continue
+		}
+		changes = append(changes, c)
+	}
+	return changes, rows.Err()
+}
+
+// diffCards derives card events from two snapshots of the card map.
+func diffCards(before, after map[string]Card) []Event {
+	var events []Event
+	for id, a := range after {
+		b, ok := before[id]
+		if !ok {
+			events = append(events, Event{Type: EventCardCreated, CardID: id, Title: a.Title, Column: a.ColumnID})
+			continue
+		}
+		if a.ColumnID != b.ColumnID {
+			events = append(events, Event{Type: EventCardMoved, CardID: id, Title: a.Title, From: b.ColumnID, Column: a.ColumnID})
+		}
+		if a.Assignee != b.Assignee {
+			events = append(events, Event{Type: EventCardAssigned, CardID: id, Title: a.Title, Assignee: a.Assignee})
+		}
+		for _, l := range a.Labels.Names() {
+			if !b.Labels[l] {
+				events = append(events, Event{Type: EventCardLabeled, CardID: id, Label: l})
+			}
+		}
+		for _, c := range a.Comments {
+			if !slices.ContainsFunc(b.Comments, func(o Comment) bool { return o.ID == c.ID }) {
+				events = append(events, Event{Type: EventCardCommented, CardID: id, Title: a.Title, Author: c.Author, Comment: c.Body})
+			}
+		}
+		if a.Title != b.Title || textString(a.Description) != textString(b.Description) {
+			events = append(events, Event{Type: EventCardUpdated, CardID: id})
+		}
+	}
+	for id, b := range before {
+		if _, ok := after[id]; !ok {
+			events = append(events, Event{Type: EventCardDeleted, CardID: id, Title: b.Title, Column: b.ColumnID})
+		}
+	}
+	return events
+}
+
+// handleChanges serves the polling feed: GET /api/changes?since=<cursor>&limit=N.
+// The response cursor is passed as "since" on the next poll.
+func handleChanges(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var since int64
+		if v := r.URL.Query().Get("since"); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n < 0 {
+				writeError(w, "invalid cursor", http.StatusBadRequest)
+				return
+			}
+			since = n
+		}
+		limit := defaultChangesLimit
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				writeError(w, "invalid limit", http.StatusBadRequest)
+				return
+			}
+			limit = min(n, maxChangesLimit)
+		}
+
+		changes, err := s.GetChanges(since, limit)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		cursor := strconv.FormatInt(since, 10)
+		if len(changes) > 0 {
+			cursor = changes[len(changes)-1].Cursor
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Changes []Change `json:"changes"`
+			Cursor  string   `json:"cursor"`
+		}{changes, cursor})
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Cards can have a checklist of things to do. Items are keyed by ID, so
+// items added, checked or removed on different nodes at once all take
+// effect; like cards, they are ordered by a fractional position, so moving
+// one only changes that item. Checklists are edited with a "checklist"
+// WebSocket message or POST /api/cards/checklist, and cards show how much of
+// theirs is done, e.g. 3/5.
+
+var (
+	// ErrBadChecklistOp is returned for a checklist edit that is not add,
+	// toggle, remove or move, or that adds an item without text.
+	ErrBadChecklistOp = errors.New("a checklist op is add (with text), toggle, remove or move")
+	// ErrChecklistItemNotFound is returned for an item the card's checklist
+	// does not have.
+	ErrChecklistItemNotFound = errors.New("checklist item not found")
+)
+
+// ChecklistItem is an item of a card's checklist. Cards start with an empty
+// checklist, not nil: items added at once on two nodes to a nil one would
+// each replace it, and only one would stay.
+type ChecklistItem struct {
+	ID    string  `deep:"key" json:"id"`
+	Text  string  `json:"text"`
+	Done  bool    `json:"done"`
+	Order float64 `json:"order"` // position in the checklist, see sortChecklist
+}
+
+// ChecklistOp edits a card's checklist in a "checklist" request: "add"
+// appends an item with Text, "toggle" sets whether ItemID is Done, "remove"
+// removes it and "move" moves it to ToIndex.
+type ChecklistOp struct {
+	CardID  string `json:"cardId"`
+	Op      string `json:"op"`
+	ItemID  string `json:"itemId,omitempty"`
+	Text    string `json:"text,omitempty"`
+	Done    bool   `json:"done,omitempty"`
+	ToIndex int    `json:"toIndex,omitempty"`
+}
+
+// cloneChecklist returns a copy of items under new IDs, in the same order,
+// for a copy of their card.
+func cloneChecklist(items []ChecklistItem) []ChecklistItem {
+	clone := []ChecklistItem{}
+	for _, it := range sortChecklist(items) {
+		it.ID = uuid.New().String()
+		clone = append(clone, it)
+	}
+	return clone
+}
+
+// sortChecklist returns items in checklist order. Items of equal order, as
+// left by concurrent adds, are ordered by ID so every node agrees.
+func sortChecklist(items []ChecklistItem) []ChecklistItem {
+	return slices.SortedFunc(slices.Values(items), func(a, b ChecklistItem) int {
+		return cmp.Or(cmp.Compare(a.Order, b.Order), cmp.Compare(a.ID, b.ID))
+	})
+}
+
+// checklistProgress returns how many of items are done, as "3/5", or "" for
+// no items.
+func checklistProgress(items []ChecklistItem) string {
+	if len(items) == 0 {
+		return ""
+	}
+	done := 0
+	for _, it := range items {
+		if it.Done {
+			done++
+		}
+	}
+	return fmt.Sprintf("%d/%d", done, len(items))
+}
+
+// EditChecklist applies op to the checklist of its card and returns the ID
+// of the item it edited, new for "add".
+func (s *Store) EditChecklist(op ChecklistOp) (string, error) {
+	op.Text = strings.TrimSpace(op.Text)
+	switch op.Op {
+	case "add":
+		if op.Text == "" {
+			return "", ErrBadChecklistOp
+		}
+		op.ItemID = uuid.New().String()
+	case "toggle", "remove", "move":
+	default:
+		return "", ErrBadChecklistOp
+	}
+	err := s.tryMutate(func(bs *BoardState) error {
+		card, ok := bs.Board.Cards[op.CardID]
+		if !ok {
+			return ErrCardNotFound
+		}
+		items := sortChecklist(card.Checklist)
+		i := slices.IndexFunc(items, func(it ChecklistItem) bool { return it.ID == op.ItemID })
+		if op.Op != "add" && i < 0 {
+			return ErrChecklistItemNotFound
+		}
+		switch op.Op {
+		case "add":
+			if err := s.checkGrowth(len(op.Text)); err != nil {
+				return err
+			}
+			order := 1000.0
+			if len(items) > 0 {
+				order = items[len(items)-1].Order + 1000
+			}
+			card.Checklist = append(card.Checklist, ChecklistItem{ID: op.ItemID, Text: op.Text, Order: order})
+		case "toggle":
+			if items[i].Done == op.Done {
+				return nil
+			}
+			j := slices.IndexFunc(card.Checklist, func(it ChecklistItem) bool { return it.ID == op.ItemID })
+			card.Checklist[j].Done = op.Done
+		case "remove":
+			card.Checklist = slices.DeleteFunc(card.Checklist, func(it ChecklistItem) bool { return it.ID == op.ItemID })
+		case "move":
+			others := slices.Delete(items, i, i+1)
+			var order float64
+			switch to := op.ToIndex; {
+			case len(others) == 0:
+				return nil
+			case to <= 0:
+				order = others[0].Order - 1000
+			case to >= len(others):
+				order = others[len(others)-1].Order + 1000
+			default:
+				order = (others[to-1].Order + others[to].Order) / 2
+			}
+			j := slices.IndexFunc(card.Checklist, func(it ChecklistItem) bool { return it.ID == op.ItemID })
+			card.Checklist[j].Order = order
+		}
+		bs.Board.Cards[op.CardID] = card
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return op.ItemID, nil
+}
+
+// handleCardChecklist edits a card's checklist, POST /api/cards/checklist
+// with a ChecklistOp, e.g. {"cardId": "...", "op": "add", "text": "Write
+// tests"} or {"cardId": "...", "op": "toggle", "itemId": "...", "done":
+// true}, and answers the card's checklist in order with the ID of the item
+// edited: {"itemId": "...", "checklist": [...]}.
+func handleCardChecklist(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var op ChecklistOp
+		if err := json.NewDecoder(r.Body).Decode(&op); err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		id, err := s.EditChecklist(op)
+		if err != nil {
+			writeMutationError(w, err)
+			return
+		}
+		items := sortChecklist(s.snap.Load().state.Board.Cards[op.CardID].Checklist)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			ItemID    string          `json:"itemId"`
+			Checklist []ChecklistItem `json:"checklist"`
+		}{id, items})
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/brunoga/deep/v5/crdt"
+	"github.com/brunoga/deep/v5/crdt/hlc"
+	"github.com/google/uuid"
+)
+
+// A board can be cloned into another board on the same node, to start, say,
+// a new sprint from a known layout. The clone replaces the target's content
+// in one edit, like a reset, so it replicates to the target's peers as any
+// other change: boards are configured per node with -tenants, and replacing
+// a board's CRDT document outright would cut it off from its peers. The
+// copy gets a fresh board ID and fresh card IDs, and, for cards, keeps the
+// content but none of the history: comments, votes and issue links stay
+// behind. Column IDs are kept, since cards are placed by them.
+
+// ErrCloneSelf is returned when a board is cloned into itself.
+var ErrCloneSelf = errors.New("cannot clone a board into itself")
+
+// cloneBoard copies src with fresh IDs. Without cards, only the structure is
+// copied: title, columns, templates, variables and the key check. Card
+// descriptions are rebuilt as new text stamped with clock.
+func cloneBoard(src Board, withCards bool, clock *hlc.Clock, now time.Time) Board {
+	b := Board{
+		ID:         uuid.New().String(),
+		Title:      src.Title,
+		Cards:      make(map[string]Card),
+		Encryption: src.Encryption,
+		Templates:  slices.Clone(src.Templates),
+		Variables:  maps.Clone(src.Variables),
+	}
+	for _, col := range src.Columns {
+		col.MoveGroups = slices.Clone(col.MoveGroups)
+		b.Columns = append(b.Columns, col)
+	}
+	for i, t := range b.Templates {
+		b.Templates[i].Labels = slices.Clone(t.Labels)
+	}
+	if !withCards {
+		return b
+	}
+	for _, c := range src.Cards {
+		id := uuid.New().String()
+		b.Cards[id] = Card{
+			ID:          id,
+			Title:       c.Title,
+			Description: textInsert(crdt.Text{}, 0, c.Description.String(), clock),
+			ColumnID:    c.ColumnID,
+			Order:       c.Order,
+			Assignee:    c.Assignee,
+			Labels:      labelSetOf(c.Labels.Names()...),
+			Checklist:   cloneChecklist(c.Checklist),
+			Priority:    c.Priority,
+			Due:         c.Due,
+			EnteredAt:   now.Unix(),
+		}
+	}
+	return b
+}
+
+// CloneFrom replaces this board's content with a copy of src. It returns
+// the number of cards copied.
+func (s *Store) CloneFrom(src *Store, withCards bool) (int, error) {
+	if src == s {
+		return 0, ErrCloneSelf
+	}
+	board := src.GetBoard().Board
+	var n int
+	err := s.tryMutate(func(bs *BoardState) error {
+		if withCards {
+			if err := s.quota.checkCards(len(board.Cards)); err != nil {
+				return err
+			}
+			if err := s.checkGrowth(len(src.snap.Load().crdtJSON)); err != nil {
+				return err
+			}
+		}
+		clone := cloneBoard(board, withCards, s.crdt.Clock(), time.Now())
+		clone.Archived = bs.Board.Archived
+		clone.Boards = bs.Board.Boards
+		bs.Board = clone
+		n = len(clone.Cards)
+		return nil
+	})
+	return n, err
+}
+
+// handleCloneBoard clones a board into another, POST
+// /api/boards/{id}/clone with {"into": "sprint-13", "withoutCards": true}.
+// The target's content is replaced.
+func handleCloneBoard(boards *Boards) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req struct {
+			Into         string `json:"into"`
+			WithoutCards bool   `json:"withoutCards"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		src, dst := boards.Get(r.PathValue("id")), boards.Get(req.Into)
+		if src == nil || dst == nil {
+			writeError(w, "board not found", http.StatusNotFound)
+			return
+		}
+		n, err := dst.CloneFrom(src, !req.WithoutCards)
+		if err != nil {
+			writeMutationError(w, err)
+			return
+		}
+		log.Printf("ADMIN: Cloned board %s into %s (%d cards)", src.BoardKey(), dst.BoardKey(), n)
+		dst.Audit(r, AuditBoardClone, fmt.Sprintf("from=%s cards=%d", src.BoardKey(), n))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Board string `json:"board"`
+			Cards int    `json:"cards"`
+		}{dst.BoardKey(), n})
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"hash/fnv"
+	"slices"
+)
+
+// Every user gets a color, used for them wherever they appear: presence
+// tags, the who's online panel and the history. A user is given one the
+// first time they connect to any node, and it is kept in the board, so every
+// node shows them the same way. A new user gets a color nobody on the board
+// has yet while there are any left.
+
+// UserColor is the color of the user with the given presence name. A keyed
+// list rather than a map, so nodes giving colors at the same time merge them.
+type UserColor struct {
+	Name  string `deep:"key" json:"name"`
+	Color string `json:"color"`
+}
+
+// userColors are the colors users are given.
+var userColors = []string{
+	"#e74c3c", "#3498db", "#2ecc71", "#9b59b6", "#e67e22", "#1abc9c",
+	"#f39c12", "#34495e", "#e84393", "#16a085", "#8e44ad", "#c0392b",
+}
+
+// hashedColor is the color name gets when it has none of its own, or when
+// all are taken.
+func hashedColor(name string) int {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return int(h.Sum32() % uint32(len(userColors)))
+}
+
+// assignColor returns the color of name, giving it one if it has none yet.
+// The search for a free color starts at its hashed one, so a user tends to
+// get the same color on every board.
+func (b *Board) assignColor(name string) string {
+	if c, ok := b.colorOf(name); ok {
+		return c
+	}
+	used := make(map[string]bool, len(b.Colors))
+	for _, c := range b.Colors {
+		used[c.Color] = true
+	}
+	start := hashedColor(name)
+	color := userColors[start]
+	for i := range userColors {
+		if c := userColors[(start+i)%len(userColors)]; !used[c] {
+			color = c
+			break
+		}
+	}
+	b.Colors = append(b.Colors, UserColor{Name: name, Color: color})
+	return color
+}
+
+// colorOf returns the color given to name, if any.
+func (b *Board) colorOf(name string) (string, bool) {
+	i := slices.IndexFunc(b.Colors, func(c UserColor) bool { return c.Name == name })
+	if i < 0 {
+		return "", false
+	}
+	return b.Colors[i].Color, true
+}
+
+// userColor returns the color of name on board b: its own, or its hashed one
+// if it never connected, as with bots.
+func userColor(b Board, name string) string {
+	if c, ok := b.colorOf(name); ok {
+		return c
+	}
+	return userColors[hashedColor(name)]
+}
+
+// userColorMap returns the colors of names on b.
+func userColorMap(b Board, names ...string) map[string]string {
+	colors := make(map[string]string, len(names))
+	for _, name := range names {
+		if name != "" {
+			colors[name] = userColor(b, name)
+		}
+	}
+	return colors
+}
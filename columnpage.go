@@ -0,0 +1,84 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"slices"
+)
+
+// Large columns are rendered a page at a time: /board and the board page
+// show the first columnPageSize cards of each column and a "more" marker,
+// and the page loads the rest from /board/column/{id} as the marker scrolls
+// into view. A refresh asks for as many cards per column as the page has
+// loaded, so scrolling down is not undone by the next change.
+
+// Column paging bounds.
+const (
+	columnPageSize = 50
+	maxColumnPage  = 100000
+)
+
+// pageColumn returns col with only the cards from offset, up to limit of
+// them.
+func pageColumn(col UIColumn, offset, limit int) UIColumn {
+	total := len(col.Cards)
+	offset = min(offset, total)
+	end := min(offset+limit, total)
+	col.Cards = col.Cards[offset:end]
+	col.Next, col.More = 0, 0
+	if end < total {
+		col.Next, col.More = end, total-end
+	}
+	return col
+}
+
+// pageColumns returns the first page of up to limit cards of every column.
+func pageColumns(cols []UIColumn, limit int) []UIColumn {
+	paged := make([]UIColumn, len(cols))
+	for i, col := range cols {
+		paged[i] = pageColumn(col, 0, limit)
+	}
+	return paged
+}
+
+// truncated reports whether any of cols leaves cards out.
+func truncated(cols []UIColumn) bool {
+	return slices.ContainsFunc(cols, func(c UIColumn) bool { return c.Next > 0 })
+}
+
+// requestColumns returns the columns of state, or of the view named by
+// ?view=, as the viewer of r sees them.
+func requestColumns(state BoardState, r *http.Request) ([]UIColumn, error) {
+	id := r.URL.Query().Get("view")
+	if id == "" {
+		return buildUIColumns(state), nil
+	}
+	view, ok := userView(state.Board, homeUser(r), id)
+	if !ok {
+		return nil, ErrViewNotFound
+	}
+	return applyView(buildUIColumns(state), view.Filter, viewerHandles(r)), nil
+}
+
+// handleBoardColumn serves GET /board/column/{id}?offset=50, the cards of a
+// column from offset on, up to ?limit= of them (columnPageSize by default),
+// followed by a "more" marker if cards remain. ?view= pages through a view.
+func handleBoardColumn(s *Store) http.HandlerFunc {
+	tmpl := template.Must(template.New("column").Funcs(uiFuncs).Parse(columnPageHTML))
+	return func(w http.ResponseWriter, r *http.Request) {
+		cols, err := requestColumns(s.snap.Load().state, r)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		i := slices.IndexFunc(cols, func(c UIColumn) bool { return c.ID == r.PathValue("id") })
+		if i < 0 {
+			writeMutationError(w, ErrColumnNotFound)
+			return
+		}
+		offset := queryInt(r, "offset", 0, 0, maxColumnPage)
+		limit := queryInt(r, "limit", columnPageSize, 1, maxColumnPage)
+		w.Header().Set("Cache-Control", "no-store")
+		render(w, tmpl, pageColumn(cols[i], offset, limit))
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Admins shape the board's columns: add, rename, reorder and delete them,
+// with /api/admin/columns or "column" WebSocket messages. Columns are edited
+// like everything else, so the changes replicate. They are shown by Order,
+// which, as with cards, lets concurrent moves of different columns merge;
+// columns of equal Order, as on boards from before columns could be moved,
+// keep the order they are stored in. A card moved into a column that is
+// being deleted on another node is shown in the first column until the
+// board is repaired (see repair.go).
+
+var (
+	// ErrColumnNotEmpty is returned when deleting a column with cards
+	// without naming a column to move them into.
+	ErrColumnNotEmpty = errors.New("column has cards; name a column to move them into")
+	// ErrLastColumn is returned when deleting the only column.
+	ErrLastColumn = errors.New("a board needs a column")
+	// ErrBadColumn is returned for a column change that cannot be made, such
+	// as a column without a title.
+	ErrBadColumn = errors.New("invalid column change")
+)
+
+// ColumnOp is a change to the columns requested by a client: "add" a column
+// titled Title, "rename" ColumnID to Title, "move" it to Index, or "delete"
+// it, moving its cards Into another column.
+type ColumnOp struct {
+	Op       string `json:"op"`
+	ColumnID string `json:"columnId"`
+	Title    string `json:"title"`
+	Index    int    `json:"index"`
+	Into     string `json:"into"`
+}
+
+// nonSlug matches what column IDs made from titles leave out.
+var nonSlug = regexp.MustCompile(`[^a-z0-9]+`)
+
+// orderedColumns returns the columns of b in the order they are shown.
+func orderedColumns(b Board) []Column {
+	return slices.SortedStableFunc(slices.Values(b.Columns), func(x, y Column) int {
+		if x.Order == y.Order && x.Order != 0 {
+			return strings.Compare(x.ID, y.ID) // added at once on two nodes
+		}
+		return cmp.Compare(x.Order, y.Order)
+	})
+}
+
+// defaultColumn returns the column new cards go to: "todo", or the first
+// column if it was deleted.
+func defaultColumn(b Board) string {
+	cols := orderedColumns(b)
+	if len(cols) == 0 || slices.ContainsFunc(cols, func(c Column) bool { return c.ID == "todo" }) {
+		return "todo"
+	}
+	return cols[0].ID
+}
+
+// orderColumns gives the columns of bs distinct orders, as they are shown,
+// if any two share one.
+func orderColumns(bs *BoardState) {
+	seen := map[float64]bool{}
+	for _, col := range bs.Board.Columns {
+		if seen[col.Order] {
+			for i, ordered := range orderedColumns(bs.Board) {
+				bs.Board.Columns[columnIndex(bs.Board, ordered.ID)].Order = float64(i+1) * 1000
+			}
+			return
+		}
+		seen[col.Order] = true
+	}
+}
+
+// columnIndex returns the index of column id in b.Columns, or -1.
+func columnIndex(b Board, id string) int {
+	return slices.IndexFunc(b.Columns, func(c Column) bool { return c.ID == id })
+}
+
+// AddColumn adds a column titled title after the others and returns its ID,
+// made from the title.
+func (s *Store) AddColumn(title string) (string, error) {
+	title = strings.TrimSpace(title)
+	if title == "" {
+		return "", fmt.Errorf("%w: a column needs a title", ErrBadColumn)
+	}
+	var id string
+	err := s.tryMutate(func(bs *BoardState) error {
+		slug := strings.Trim(nonSlug.ReplaceAllString(strings.ToLower(title), "-"), "-")
+		if slug == "" {
+			slug = "column"
+		}
+		id = slug
+		for n := 2; columnIndex(bs.Board, id) >= 0; n++ {
+			id = fmt.Sprintf("%s-%d", slug, n)
+		}
+		orderColumns(bs)
+		order := 1000.0
+		if cols := orderedColumns(bs.Board); len(cols) > 0 {
+			order = cols[len(cols)-1].Order + 1000
+		}
+		bs.Board.Columns = append(bs.Board.Columns, Column{ID: id, Title: title, Order: order})
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// RenameColumn sets the title of column id.
+func (s *Store) RenameColumn(id, title string) error {
+	title = strings.TrimSpace(title)
+	if title == "" {
+		return fmt.Errorf("%w: a column needs a title", ErrBadColumn)
+	}
+	return s.tryMutate(func(bs *BoardState) error {
+		i := columnIndex(bs.Board, id)
+		if i < 0 {
+			return ErrColumnNotFound
+		}
+		bs.Board.Columns[i].Title = title
+		return nil
+	})
+}
+
+// MoveColumn shows column id at index among the columns; past the end puts
+// it last.
+func (s *Store) MoveColumn(id string, index int) error {
+	return s.tryMutate(func(bs *BoardState) error {
+		if columnIndex(bs.Board, id) < 0 {
+			return ErrColumnNotFound
+		}
+		orderColumns(bs)
+		others := slices.DeleteFunc(orderedColumns(bs.Board), func(c Column) bool { return c.ID == id })
+		var order float64
+		switch {
+		case len(others) == 0:
+			order = 1000
+		case index <= 0:
+			order = others[0].Order - 1000
+		case index >= len(others):
+			order = others[len(others)-1].Order + 1000
+		default:
+			order = (others[index-1].Order + others[index].Order) / 2
+		}
+		bs.Board.Columns[columnIndex(bs.Board, id)].Order = order
+		return nil
+	})
+}
+
+// DeleteColumn deletes column id. Its cards are moved to the end of column
+// into, which may only be empty if it has none.
+func (s *Store) DeleteColumn(id, into string) error {
+	if into == id {
+		return fmt.Errorf("%w: cards cannot move into the column being deleted", ErrBadColumn)
+	}
+	return s.tryMutate(func(bs *BoardState) error {
+		i := columnIndex(bs.Board, id)
+		if i < 0 {
+			return ErrColumnNotFound
+		}
+		if len(bs.Board.Columns) == 1 {
+			return ErrLastColumn
+		}
+		var cards []Card
+		maxOrder := 0.0
+		for _, c := range bs.Board.Cards {
+			switch c.ColumnID {
+			case id:
+				cards = append(cards, c)
+			case into:
+				maxOrder = max(maxOrder, c.Order)
+			}
+		}
+		if len(cards) > 0 {
+			if into == "" {
+				return ErrColumnNotEmpty
+			}
+			if columnIndex(bs.Board, into) < 0 {
+				return ErrColumnNotFound
+			}
+		}
+		sortCards(cards)
+		now := time.Now().Unix()
+		for _, c := range cards {
+			maxOrder += 1000
+			c.ColumnID, c.Order, c.EnteredAt, c.Overdue = into, maxOrder, now, false
+			bs.Board.Cards[c.ID] = c
+			s.stage(Event{Type: EventCardMoved, CardID: c.ID, Title: c.Title, From: id, Column: into})
+		}
+		bs.Board.Columns = slices.Delete(bs.Board.Columns, i, i+1)
+		return nil
+	})
+}
+
+// ApplyColumnOp applies a client's change to the columns.
+func (s *Store) ApplyColumnOp(op ColumnOp) error {
+	switch op.Op {
+	case "add":
+		_, err := s.AddColumn(op.Title)
+		return err
+	case "rename":
+		return s.RenameColumn(op.ColumnID, op.Title)
+	case "move":
+		return s.MoveColumn(op.ColumnID, op.Index)
+	case "delete":
+		return s.DeleteColumn(op.ColumnID, op.Into)
+	}
+	return fmt.Errorf("%w: unknown column op %q", ErrBadColumn, op.Op)
+}
+
+// handleColumns serves /api/admin/columns: GET lists the columns in order,
+// and POST applies a ColumnOp, e.g. {"op": "add", "title": "Review"}, and
+// answers the columns.
+func handleColumns(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			var op ColumnOp
+			if err := json.NewDecoder(r.Body).Decode(&op); err != nil {
+				writeError(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := s.ApplyColumnOp(op); err != nil {
+				writeMutationError(w, err)
+				return
+			}
+			s.Audit(r, AuditColumnChange, op.Op+" "+cmp.Or(op.ColumnID, op.Title))
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(orderedColumns(s.GetBoard().Board))
+	}
+}
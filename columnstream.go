@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// A dashboard can follow one column instead of the whole board: GET
+// /api/columns/{id}/stream is a server-sent event stream of the cards that
+// enter and leave the column, whether created, moved or deleted, on this
+// node or on a peer. A deployment dashboard subscribes to "done" and reacts
+// as cards reach it. Streams are not replayed: a client that reconnects
+// reads /api/cards for what it missed, and one that falls too far behind is
+// disconnected so that it does.
+
+// Column stream events.
+const (
+	ColumnEnter = "enter"
+	ColumnLeave = "leave"
+)
+
+// Column stream tuning.
+const (
+	columnStreamBuffer = 64               // events queued per subscriber
+	columnStreamPing   = 30 * time.Second // between keep-alive comments
+)
+
+// ColumnEvent is a card entering or leaving a column, sent as the data of an
+// "enter" or "leave" event.
+type ColumnEvent struct {
+	Type   string `json:"type"` // ColumnEnter or ColumnLeave
+	CardID string `json:"cardId"`
+	Title  string `json:"title,omitempty"`
+	Column string `json:"column"`
+	From   string `json:"from,omitempty"` // column it came from, on enter by a move
+	To     string `json:"to,omitempty"`   // column it went to, on leave by a move
+	Cause  string `json:"cause"`          // card.created, card.moved or card.deleted
+	Time   int64  `json:"time"`
+	Remote bool   `json:"remote,omitempty"`
+}
+
+// columnFeeds holds the subscribers of column streams, with the column each
+// follows.
+type columnFeeds struct {
+	mu   sync.Mutex
+	subs map[chan ColumnEvent]string
+}
+
+func (f *columnFeeds) subscribe(column string) chan ColumnEvent {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.subs == nil {
+		f.subs = make(map[chan ColumnEvent]string)
+	}
+	ch := make(chan ColumnEvent, columnStreamBuffer)
+	f.subs[ch] = column
+	return ch
+}
+
+func (f *columnFeeds) unsubscribe(ch chan ColumnEvent) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.subs[ch]; ok {
+		delete(f.subs, ch)
+		close(ch)
+	}
+}
+
+// publish sends ev to the subscribers of its column, closing the channels of
+// those whose queue is full.
+func (f *columnFeeds) publish(ev ColumnEvent) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for ch, column := range f.subs {
+		if column != ev.Column {
+			continue
+		}
+		select {
+		case ch <- ev:
+		default:
+			delete(f.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// columnEvents returns the enter and leave events of the card event ev.
+func columnEvents(ev Event) []ColumnEvent {
+	base := ColumnEvent{CardID: ev.CardID, Title: ev.Title, Cause: ev.Type, Time: ev.Time, Remote: ev.Remote}
+	enter, leave := base, base
+	enter.Type, enter.Column = ColumnEnter, ev.Column
+	leave.Type, leave.Column = ColumnLeave, ev.Column
+	switch ev.Type {
+	case EventCardCreated:
+		return []ColumnEvent{enter}
+	case EventCardDeleted:
+		return []ColumnEvent{leave}
+	case EventCardMoved:
+		if ev.From == ev.Column {
+			return nil
+		}
+		enter.From = ev.From
+		leave.Column, leave.To = ev.From, ev.Column
+		return []ColumnEvent{leave, enter}
+	}
+	return nil
+}
+
+// feedColumns streams the card event ev to the subscribers of the columns
+// it changes.
+func (s *Store) feedColumns(ev Event) {
+	for _, cev := range columnEvents(ev) {
+		s.columnFeeds.publish(cev)
+	}
+}
+
+// handleColumnStream serves GET /api/columns/{id}/stream, the cards entering
+// and leaving column id as server-sent events:
+//
+//	event: enter
+//	data: {"type": "enter", "cardId": "...", "column": "done", "from": "in-progress", "cause": "card.moved", ...}
+//
+// with a comment every columnStreamPing to keep proxies from closing it.
+func handleColumnStream(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		column := r.PathValue("id")
+		if !slices.ContainsFunc(s.snap.Load().state.Board.Columns, func(c Column) bool { return c.ID == column }) {
+			writeMutationError(w, ErrColumnNotFound)
+			return
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			writeError(w, "streaming not supported", http.StatusInternalServerError)
+			return
+		}
+		ch := s.columnFeeds.subscribe(column)
+		defer s.columnFeeds.unsubscribe(ch)
+
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("X-Accel-Buffering", "no") // nginx would hold events back
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		ping := time.NewTicker(columnStreamPing)
+		defer ping.Stop()
+		for {
+			select {
+			case ev, ok := <-ch:
+				if !ok {
+					return // fell behind
+				}
+				data, _ := json.Marshal(ev)
+				if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, data); err != nil {
+					return
+				}
+			case <-ping.C:
+				if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+					return
+				}
+			case <-r.Context().Done():
+				return
+			case <-s.done:
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
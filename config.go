@@ -0,0 +1,28 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// Config holds settings that are too structured for command-line flags. It is
+// read from the JSON file given with -config.
+type Config struct {
+	LDAP     *LDAPConfig            `json:"ldap"`
+	Events   *EventStreamConfig     `json:"events"`
+	History  map[string]string      `json:"history"`  // summary templates by field, see summary.go
+	Features map[string]FeatureFlag `json:"features"` // default feature flags, see flags.go
+}
+
+func loadConfig(path string) (Config, error) {
+	var cfg Config
+	if path == "" {
+		return cfg, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	err = json.Unmarshal(data, &cfg)
+	return cfg, err
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// "deepboard demo" runs a small cluster in one process: several nodes, each
+// with its own database, replicating over an in-memory transport. A launcher
+// page shows every node side by side and can cut a node off from the others
+// to show how edits made during a partition converge once it heals.
+
+// ErrPartitioned is returned by the demo transport for links to or from a
+// node that is cut off.
+var ErrPartitioned = errors.New("node is partitioned")
+
+// memNetwork connects the nodes of a demo cluster.
+type memNetwork struct {
+	mu       sync.RWMutex
+	nodes    map[string]*Store
+	isolated map[string]bool
+}
+
+func newMemNetwork() *memNetwork {
+	return &memNetwork{nodes: map[string]*Store{}, isolated: map[string]bool{}}
+}
+
+// add connects node id, served by s.
+func (n *memNetwork) add(id string, s *Store) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.nodes[id] = s
+}
+
+// link returns the transport node from uses to reach the others.
+func (n *memNetwork) link(from string) Transport {
+	return memTransport{net: n, from: from}
+}
+
+// peer returns the node to, unless it or from is cut off.
+func (n *memNetwork) peer(from, to string) (*Store, error) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	if n.isolated[from] || n.isolated[to] {
+		return nil, ErrPartitioned
+	}
+	s, ok := n.nodes[to]
+	if !ok {
+		return nil, fmt.Errorf("unknown node %s", to)
+	}
+	return s, nil
+}
+
+// SetIsolated cuts node off from the others, or heals it. Healing wakes the
+// background sync of every node so they converge right away.
+func (n *memNetwork) SetIsolated(node string, isolated bool) {
+	n.mu.Lock()
+	n.isolated[node] = isolated
+	n.mu.Unlock()
+	if !isolated {
+		n.mu.RLock()
+		defer n.mu.RUnlock()
+		for _, s := range n.nodes {
+			s.SyncSoon()
+		}
+	}
+}
+
+// memTransport is the Transport of one demo node. Peers are node IDs.
+type memTransport struct {
+	net  *memNetwork
+	from string
+}
+
+func (t memTransport) SendDelta(peer, board string, delta []byte, digest string) error {
+	s, err := t.net.peer(t.from, peer)
+	if err != nil {
+		return err
+	}
+	return s.receiveDelta(delta, digest, t.from)
+}
+
+func (t memTransport) FetchState(peer, board string) ([]byte, error) {
+	s, err := t.net.peer(t.from, peer)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkRemote(t.from); err != nil {
+		return nil, err
+	}
+	return s.snap.Load().crdtJSON, nil
+}
+
+func (t memTransport) Digest(peer, board string) (DigestInfo, error) {
+	s, err := t.net.peer(t.from, peer)
+	if err != nil {
+		return DigestInfo{}, err
+	}
+	if err := s.checkRemote(t.from); err != nil {
+		return DigestInfo{}, err
+	}
+	return s.Digest(), nil
+}
+
+// DemoNode is a node as the launcher page shows it.
+type DemoNode struct {
+	ID       string
+	Path     string
+	Isolated bool
+}
+
+// runDemo is "deepboard demo": it starts the cluster and serves it until
+// interrupted. Databases live in a temporary directory removed on exit.
+func runDemo(args []string) {
+	fs := flag.NewFlagSet("demo", flag.ExitOnError)
+	count := fs.Int("nodes", 3, "number of nodes in the cluster")
+	addr := fs.String("addr", ":8080", "http service address")
+	fs.Parse(args)
+	if *count < 2 || *count > 9 {
+		log.Fatal("demo: -nodes must be between 2 and 9")
+	}
+
+	dir, err := os.MkdirTemp("", "deepboard-demo-")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	network := newMemNetwork()
+	var ids []string
+	for i := range *count {
+		ids = append(ids, fmt.Sprintf("node-%d", i+1))
+	}
+	var stores []*Store
+	router := newTenantRouter(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, "not found", http.StatusNotFound)
+	}), "")
+	for _, id := range ids {
+		peers := slices.DeleteFunc(slices.Clone(ids), func(p string) bool { return p == id })
+		s, err := NewStore(filepath.Join(dir, id+".db"), id, peers)
+		if err != nil {
+			log.Fatalf("demo: failed to start %s: %v", id, err)
+		}
+		s.SetBasePath(tenantPathPrefix + id)
+		s.SetTransport(network.link(id))
+		network.add(id, s)
+		router.Add(id, withNode(id, withBots(s, nil)))
+		stores = append(stores, s)
+	}
+	for _, s := range stores {
+		go startBackgroundSync(s)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws/{board}", handleBoardWS(newBoards(stores...)))
+	mux.HandleFunc("/demo/partition", handleDemoPartition(network))
+	mux.HandleFunc("/{$}", handleDemoLauncher(network, ids))
+	mux.Handle("/", router)
+
+	fmt.Printf("DeepBoard demo: %d nodes on http://localhost%s\n", *count, *addr)
+	srv := &http.Server{Addr: *addr, Handler: withRequestID(mux)}
+	go func() {
+		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	<-sig
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	srv.Shutdown(ctx)
+	for _, s := range stores {
+		s.Close()
+	}
+}
+
+// demoNodes returns the nodes of network in ids order.
+func demoNodes(network *memNetwork, ids []string) []DemoNode {
+	network.mu.RLock()
+	defer network.mu.RUnlock()
+	var nodes []DemoNode
+	for _, id := range ids {
+		nodes = append(nodes, DemoNode{ID: id, Path: tenantPathPrefix + id + "/", Isolated: network.isolated[id]})
+	}
+	return nodes
+}
+
+// handleDemoLauncher serves the launcher page: every node side by side.
+func handleDemoLauncher(network *memNetwork, ids []string) http.HandlerFunc {
+	tmpl := template.Must(template.New("demo").Parse(demoHTML))
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		tmpl.Execute(w, demoNodes(network, ids))
+	}
+}
+
+// handleDemoPartition serves POST /demo/partition?node=node-2&isolated=true,
+// which cuts a node off from the others or, with isolated=false, heals it.
+func handleDemoPartition(network *memNetwork) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		node := r.FormValue("node")
+		network.mu.RLock()
+		_, ok := network.nodes[node]
+		network.mu.RUnlock()
+		if !ok {
+			writeError(w, "unknown node", http.StatusNotFound)
+			return
+		}
+		network.SetIsolated(node, r.FormValue("isolated") == "true")
+		w.WriteHeader(http.StatusOK)
+	}
+}
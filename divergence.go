@@ -0,0 +1,241 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/brunoga/deep/v5"
+	"github.com/brunoga/deep/v5/crdt"
+)
+
+const (
+	// digestHeader carries the sender's board digest on /api/sync pushes.
+	digestHeader = "X-Board-Digest"
+
+	// divergenceSettle gives in-flight deltas time to land before a suspected
+	// divergence is confirmed against peers.
+	divergenceSettle = 3 * time.Second
+	// divergenceCooldown bounds how often a check may run.
+	divergenceCooldown = 10 * time.Second
+	// digestWatchInterval is how often digests are compared with every peer
+	// for deepboard_peer_divergence_seconds.
+	digestWatchInterval = 30 * time.Second
+)
+
+// stateDigest hashes the replicated board content. Text is reduced to its
+// visible string: nodes that converged can still hold differently split
+// runs, which must not count as divergence. Presence is excluded since it
+// changes constantly and is not user content. b must be a copy (as returned
+// by View), since its card map is rewritten in place.
+func stateDigest(b Board) string {
+	for id, c := range b.Cards {
+		c.Description = crdt.Text{{Value: textString(c.Description)}}
+		b.Cards[id] = c
+	}
+	data, _ := json.Marshal(b)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// DigestInfo is what a node reports about its state to peers.
+type DigestInfo struct {
+	Node         string `json:"node"`
+	Digest       string `json:"digest"`
+	LastModified int64  `json:"lastModified"`
+	Model        int    `json:"model"` // version of the board model, see modelversion.go
+}
+
+// Digest returns this node's current digest and the wall time of the latest
+// change it has applied.
+func (s *Store) Digest() DigestInfo {
+	snap := s.snap.Load()
+	return DigestInfo{
+		Node:         s.nodeID,
+		Digest:       stateDigest(deep.Clone(snap.state.Board)),
+		LastModified: snap.lastModified,
+		Model:        modelVersion,
+	}
+}
+
+// Divergences returns how many divergences have been confirmed and repaired.
+func (s *Store) Divergences() int64 {
+	s.peerMu.RLock()
+	defer s.peerMu.RUnlock()
+	return s.divergences
+}
+
+// SuspectDivergence schedules a divergence check. It is cheap to call: checks
+// are coalesced and rate limited.
+func (s *Store) SuspectDivergence(reason string) {
+	s.peerMu.Lock()
+	s.syncTroubleLocked()
+	if s.divergenceCheck || time.Since(s.lastDivergenceCheck) < divergenceCooldown {
+		s.peerMu.Unlock()
+		return
+	}
+	s.divergenceCheck = true
+	s.peerMu.Unlock()
+
+	log.Printf("Possible divergence (%s), verifying in %s", reason, divergenceSettle)
+	go func() {
+		time.Sleep(divergenceSettle)
+		s.checkDivergence()
+		s.peerMu.Lock()
+		s.divergenceCheck = false
+		s.lastDivergenceCheck = time.Now()
+		s.peerMu.Unlock()
+	}()
+}
+
+// checkDivergence compares digests with every peer and, if any differ, pulls
+// full state from the most recently modified differing peer.
+func (s *Store) checkDivergence() {
+	local := s.Digest()
+	var best *DigestInfo
+	var bestPeer string
+	for _, peer := range s.GetPeers() {
+		if s.checkPeer(peer) != nil {
+			continue
+		}
+		remote, err := s.transport.Digest(peer, s.basePath)
+		if err != nil {
+			log.Printf("Divergence check: failed to reach %s: %v", peer, err)
+			continue
+		}
+		if modelOf(remote.Model) != modelVersion {
+			// Its state cannot be merged here, whatever the digests say.
+			continue
+		}
+		s.recordDigest(peer, remote.Digest == local.Digest)
+		if remote.Digest == local.Digest {
+			continue
+		}
+		if best == nil || remote.LastModified > best.LastModified {
+			best, bestPeer = &remote, peer
+		}
+	}
+	if best == nil {
+		return
+	}
+
+	s.peerMu.Lock()
+	s.divergences++
+	s.peerMu.Unlock()
+	log.Printf("DIVERGENCE: local digest %.12s differs from %s (%.12s); pulling full state",
+		local.Digest, best.Node, best.Digest)
+	syncWithPeer(s, bestPeer)
+}
+
+// recordDigest notes whether the digest of peer matched this node's. A peer
+// whose digest never matched is counted as diverging from the first
+// comparison.
+func (s *Store) recordDigest(peer string, matched bool) {
+	s.peerMu.Lock()
+	defer s.peerMu.Unlock()
+	if _, ok := s.digestMatched[peer]; matched || !ok {
+		s.digestMatched[peer] = time.Now()
+	}
+}
+
+// PeerDivergence returns, for each current peer whose digest was compared,
+// how long it has been since it last matched this node's: zero while they
+// agree.
+func (s *Store) PeerDivergence() map[string]time.Duration {
+	s.peerMu.RLock()
+	defer s.peerMu.RUnlock()
+	now := time.Now()
+	out := map[string]time.Duration{}
+	for _, p := range s.peers {
+		if at, ok := s.digestMatched[p]; ok {
+			out[p] = now.Sub(at)
+		}
+	}
+	return out
+}
+
+// watchDigests compares digests with every reachable peer, for
+// PeerDivergence. Unlike checkDivergence, it repairs nothing: a mismatch
+// may be an edit still on its way.
+func (s *Store) watchDigests() {
+	local := s.Digest()
+	for _, peer := range s.GetPeers() {
+		if s.checkPeer(peer) != nil {
+			continue
+		}
+		remote, err := s.transport.Digest(peer, s.basePath)
+		if err != nil {
+			continue // the peer's status tells
+		}
+		s.recordDigest(peer, remote.Digest == local.Digest)
+	}
+}
+
+// startDigestWatch compares digests with the peers every
+// digestWatchInterval until the store is closed.
+func startDigestWatch(s *Store) {
+	ticker := time.NewTicker(digestWatchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.watchDigests()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// handleMetrics serves GET /metrics in the Prometheus text format:
+// deepboard_peer_divergence_seconds, per board and peer, is how long the
+// board of this node has differed from the peer's, for alerting on a
+// cluster that stays inconsistent.
+func handleMetrics(boards *Boards) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		fmt.Fprintln(w, "# HELP deepboard_peer_divergence_seconds Seconds since the board's digest last matched the peer's.")
+		fmt.Fprintln(w, "# TYPE deepboard_peer_divergence_seconds gauge")
+		for _, s := range boards.All() {
+			divergence := s.PeerDivergence()
+			for _, peer := range slices.Sorted(maps.Keys(divergence)) {
+				fmt.Fprintf(w, "deepboard_peer_divergence_seconds{board=%s,peer=%s} %g\n",
+					promLabel(s.BoardKey()), promLabel(peer), divergence[peer].Seconds())
+			}
+		}
+	}
+}
+
+// promLabel quotes v as a Prometheus label value.
+func promLabel(v string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v) + `"`
+}
+
+func handleDigest(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := s.checkRemote(r.RemoteAddr); err != nil {
+			writeError(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.Digest())
+	}
+}
+
+// RecordClientDrift counts a browser that found its rendered board out of
+// step with the server's (a missed or misapplied update) and re-rendered it.
+func (s *Store) RecordClientDrift(connID string) {
+	n := s.clientDrifts.Add(1)
+	log.Printf("Client %s drifted from the board and re-rendered (%d so far)", connID, n)
+}
+
+// ClientDrifts returns how many client drifts have been reported.
+func (s *Store) ClientDrifts() int64 {
+	return s.clientDrifts.Load()
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// An end-to-end encrypted board keeps its card titles and descriptions
+// sealed by the browser with AES-GCM, under a key derived with PBKDF2 from a
+// passphrase the server never sees. The server, its database and its peers
+// only ever hold ciphertext, "e2e:" followed by the base64 of the IV and the
+// sealed text. Since ciphertext cannot be spliced, descriptions are edited
+// with "replace" text ops carrying the whole sealed description, stored as a
+// single run. Clients check a passphrase against the board's key check, the
+// salt and a sealed known text, served on /api/e2e.
+
+// e2ePrefix starts every sealed text.
+const e2ePrefix = "e2e:"
+
+// e2eSealedMin is the size of the IV and the authentication tag a sealed
+// text carries besides its content.
+const e2eSealedMin = 12 + 16
+
+// ErrPlaintext is returned when plaintext content is written to an
+// encrypted board.
+var ErrPlaintext = errors.New("board is end-to-end encrypted; content must be encrypted by the client")
+
+// ErrBoardNotEmpty is returned when enabling encryption on a board that
+// already has cards, which would stay in plaintext.
+var ErrBoardNotEmpty = errors.New("encryption can only be enabled on an empty board")
+
+// ErrAlreadyEncrypted is returned when enabling encryption twice.
+var ErrAlreadyEncrypted = errors.New("board is already encrypted")
+
+// ErrBadKeyCheck is returned for a malformed key check.
+var ErrBadKeyCheck = errors.New("invalid key check")
+
+// Encryption is the key check of an encrypted board: the PBKDF2 salt and a
+// known text sealed with the derived key. It lives in the CRDT, so every
+// node serves it.
+type Encryption struct {
+	Salt  string `json:"salt,omitempty"`  // base64
+	Check string `json:"check,omitempty"` // sealed
+}
+
+// Enabled reports whether the board is encrypted.
+func (e Encryption) Enabled() bool {
+	return e.Check != ""
+}
+
+// isSealed reports whether s looks like a sealed text. The server cannot tell
+// ciphertext from a lookalike, so this only keeps honest clients from
+// writing plaintext by mistake.
+func isSealed(s string) bool {
+	data, ok := strings.CutPrefix(s, e2ePrefix)
+	if !ok {
+		return false
+	}
+	raw, err := base64.StdEncoding.DecodeString(data)
+	return err == nil && len(raw) >= e2eSealedMin
+}
+
+// checkSealed returns ErrPlaintext if the board is encrypted and text is not
+// sealed. Empty text gives nothing away and is always allowed.
+func checkSealed(bs *BoardState, text string) error {
+	if text != "" && bs.Board.Encryption.Enabled() && !isSealed(text) {
+		return ErrPlaintext
+	}
+	return nil
+}
+
+// e2eKeyCheck returns the key check of an encrypted board, nil if it is not
+// encrypted.
+func e2eKeyCheck(state BoardState) *Encryption {
+	if !state.Board.Encryption.Enabled() {
+		return nil
+	}
+	return &state.Board.Encryption
+}
+
+// IsEncrypted reports whether the board is end-to-end encrypted.
+func (s *Store) IsEncrypted() bool {
+	return s.snap.Load().state.Board.Encryption.Enabled()
+}
+
+// EnableEncryption makes the board end-to-end encrypted with the key check
+// made by the client. It only works on an empty board and cannot be undone
+// short of a reset.
+func (s *Store) EnableEncryption(salt, check string) error {
+	if raw, err := base64.StdEncoding.DecodeString(salt); err != nil || len(raw) < 16 || !isSealed(check) {
+		return ErrBadKeyCheck
+	}
+	return s.tryMutate(func(bs *BoardState) error {
+		if bs.Board.Encryption.Enabled() {
+			return ErrAlreadyEncrypted
+		}
+		if len(bs.Board.Cards) > 0 {
+			return ErrBoardNotEmpty
+		}
+		bs.Board.Encryption = Encryption{Salt: salt, Check: check}
+		return nil
+	})
+}
+
+// handleKeyCheck serves the key check, GET /api/e2e. An unencrypted board
+// answers {"enabled": false}.
+func handleKeyCheck(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		enc := s.GetBoard().Board.Encryption
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Enabled bool `json:"enabled"`
+			Encryption
+		}{enc.Enabled(), enc})
+	}
+}
+
+// handleEnableEncryption encrypts the board, POST /api/admin/e2e with the
+// salt and check form values.
+func handleEnableEncryption(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		log.Printf("ADMIN: Enabling end-to-end encryption")
+		if err := s.EnableEncryption(r.FormValue("salt"), r.FormValue("check")); err != nil {
+			writeMutationError(w, err)
+			return
+		}
+		s.Audit(r, AuditBoardEncrypt, "")
+		w.WriteHeader(http.StatusOK)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// Effects let pages celebrate notable edits, with confetti when a card is
+// done or a sprint ends. They are derived from the edit pipeline's events,
+// so, like events, they are only sent to the clients of the node where the
+// edit happened. Each user can mute them per board; muted connections are
+// not sent any.
+
+// Effect kinds.
+const (
+	EffectCardCompleted = "card.completed"
+	EffectSprintEnded   = "sprint.ended"
+)
+
+// Effect is a client effect for a notable edit.
+type Effect struct {
+	Kind   string `json:"kind"`
+	CardID string `json:"cardId,omitempty"`
+	Title  string `json:"title,omitempty"` // of the card or sprint
+}
+
+// effectOf returns the effect ev calls for, nil if none.
+func effectOf(ev Event) *Effect {
+	switch {
+	case ev.Type == EventCardMoved && ev.Column == doneColumn && ev.From != doneColumn:
+		return &Effect{Kind: EffectCardCompleted, CardID: ev.CardID, Title: ev.Title}
+	case ev.Type == EventSprintEnded:
+		return &Effect{Kind: EffectSprintEnded, Title: ev.Title}
+	}
+	return nil
+}
+
+// playEffect sends the effect of ev, if any, to this node's clients.
+func (s *Store) playEffect(ev Event) {
+	if fx := effectOf(ev); fx != nil {
+		s.Broadcast(WSMessage{Type: "effect", Silent: true, Effect: fx})
+	}
+}
+
+// EffectsMuted reports whether user muted effects on this board.
+func (s *Store) EffectsMuted(user string) (bool, error) {
+	var muted bool
+	err := s.db.QueryRow("SELECT mute_effects FROM board_users WHERE user = ?", user).Scan(&muted)
+	if err == sql.ErrNoRows {
+		err = nil
+	}
+	return muted, err
+}
+
+// MuteEffects mutes or unmutes effects on this board for user.
+func (s *Store) MuteEffects(user string, muted bool) error {
+	_, err := s.db.Exec(`INSERT INTO board_users (user, mute_effects) VALUES (?, ?)
+		ON CONFLICT (user) DO UPDATE SET mute_effects = excluded.mute_effects`, user, muted)
+	return err
+}
+
+// handleEffects reads and sets the viewer's mute preference: GET returns
+// {"muted": false}, POST with the same body sets it.
+func handleEffects(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := homeUser(r)
+		var pref struct {
+			Muted bool `json:"muted"`
+		}
+		var err error
+		switch r.Method {
+		case http.MethodGet:
+			pref.Muted, err = s.EffectsMuted(user)
+		case http.MethodPost:
+			if err := json.NewDecoder(r.Body).Decode(&pref); err != nil {
+				writeError(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			err = s.MuteEffects(user, pref.Muted)
+		default:
+			writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to access the effects preference of %s: %v", user, err)
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(pref)
+	}
+}
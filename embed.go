@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// An embed is a read-only link to the board, or one column of it, for
+// iframes in wikis and status pages. The token in /embed/{token} is the
+// only credential, so embeds bypass login; admins revoke them by deleting
+// them. Like bot tokens, only hashes of embed tokens are stored.
+
+var (
+	// ErrEmbedNotFound is returned for an unknown embed.
+	ErrEmbedNotFound = errors.New("embed not found")
+	// ErrBadEmbed is returned for an embed of an unknown column or with a
+	// frame ancestor that is not a CSP source.
+	ErrBadEmbed = errors.New("embed needs a name, a known column if any and frame ancestors like https://wiki.example.com or 'self'")
+)
+
+// frameSource matches the CSP sources an embed may be framed by: 'self', or
+// a host with an optional scheme, wildcard subdomain and port.
+var frameSource = regexp.MustCompile(`^('self'|(https?://)?(\*\.)?[A-Za-z0-9.-]+(:[0-9]+)?)$`)
+
+// Embed is a read-only link to the board.
+type Embed struct {
+	ID             string   `deep:"key" json:"id"`
+	Name           string   `json:"name"`
+	TokenHash      string   `json:"tokenHash,omitempty"`
+	Column         string   `json:"column,omitempty"`         // the only column shown, "" for any
+	FrameAncestors []string `json:"frameAncestors,omitempty"` // sites that may frame it, none for any
+	CreatedBy      string   `json:"createdBy"`
+	CreatedAt      int64    `json:"createdAt"`
+}
+
+// frameAncestors returns the CSP frame-ancestors sources of e.
+func (e Embed) frameAncestors() string {
+	if len(e.FrameAncestors) == 0 {
+		return "*"
+	}
+	return strings.Join(e.FrameAncestors, " ")
+}
+
+// CreateEmbed adds an embed called name, limited to column unless it is "",
+// that the frameAncestors sites may frame, and returns it with its token.
+func (s *Store) CreateEmbed(name, column string, frameAncestors []string, creator string) (Embed, string, error) {
+	if name == "" || slices.ContainsFunc(frameAncestors, func(a string) bool { return !frameSource.MatchString(a) }) {
+		return Embed{}, "", ErrBadEmbed
+	}
+	token := randomToken()
+	embed := Embed{
+		ID:             uuid.New().String(),
+		Name:           name,
+		TokenHash:      hashToken(token),
+		Column:         column,
+		FrameAncestors: frameAncestors,
+		CreatedBy:      creator,
+		CreatedAt:      time.Now().Unix(),
+	}
+	err := s.tryMutate(func(bs *BoardState) error {
+		if column != "" && !slices.ContainsFunc(bs.Board.Columns, func(c Column) bool { return c.ID == column }) {
+			return ErrBadEmbed
+		}
+		bs.Board.Embeds = append(bs.Board.Embeds, embed)
+		return nil
+	})
+	if err != nil {
+		return Embed{}, "", err
+	}
+	return embed, token, nil
+}
+
+// DeleteEmbed revokes embed id.
+func (s *Store) DeleteEmbed(id string) error {
+	return s.tryMutate(func(bs *BoardState) error {
+		i := slices.IndexFunc(bs.Board.Embeds, func(e Embed) bool { return e.ID == id })
+		if i < 0 {
+			return ErrEmbedNotFound
+		}
+		bs.Board.Embeds = slices.Delete(bs.Board.Embeds, i, i+1)
+		return nil
+	})
+}
+
+// EmbedByToken returns the embed token belongs to.
+func (s *Store) EmbedByToken(token string) (Embed, bool) {
+	hash := hashToken(token)
+	for _, e := range s.GetBoard().Board.Embeds {
+		if e.TokenHash == hash {
+			return e, true
+		}
+	}
+	return Embed{}, false
+}
+
+// handleEmbed serves GET /embed/{token}: the embed's column, or the column
+// picked with ?column= (all of them by default), as a self-contained page
+// without scripts that reloads every minute. Its CSP only lets the embed's
+// frame ancestors frame it.
+func handleEmbed(s *Store) http.HandlerFunc {
+	tmpl := template.Must(template.New("embed").Parse(embedHTML))
+	return func(w http.ResponseWriter, r *http.Request) {
+		embed, ok := s.EmbedByToken(r.PathValue("token"))
+		if !ok {
+			writeError(w, ErrEmbedNotFound.Error(), http.StatusNotFound)
+			return
+		}
+		column := r.URL.Query().Get("column")
+		if embed.Column != "" {
+			if column != "" && column != embed.Column {
+				writeError(w, ErrForbidden.Error(), http.StatusForbidden)
+				return
+			}
+			column = embed.Column
+		}
+		state := s.GetBoard()
+		cols := wallboardColumns(state, queryInt(r, "cards", 50, 1, 500))
+		if column != "" {
+			i := slices.IndexFunc(state.Board.Columns, func(c Column) bool { return c.ID == column })
+			if i < 0 {
+				writeMutationError(w, ErrColumnNotFound)
+				return
+			}
+			cols = cols[i : i+1]
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; frame-ancestors "+embed.frameAncestors())
+		w.Header().Set("Referrer-Policy", "no-referrer")
+		tmpl.Execute(w, struct {
+			Title   string
+			Columns []WallboardColumn
+		}{state.Board.Title, cols})
+	}
+}
+
+// handleEmbeds manages the board's embeds: GET lists them, POST {"name":
+// "status page", "column": "done", "frameAncestors": ["https://wiki.example.com"]}
+// creates one and returns its URL, which is not shown again, and DELETE ?id=
+// revokes one.
+func handleEmbeds(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			embeds := slices.Clone(s.GetBoard().Board.Embeds)
+			for i := range embeds {
+				embeds[i].TokenHash = ""
+			}
+			slices.SortFunc(embeds, func(a, b Embed) int { return strings.Compare(a.Name, b.Name) })
+			if embeds == nil {
+				embeds = []Embed{}
+			}
+			w.Header().Set("Cache-Control", "no-store")
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(embeds)
+		case http.MethodPost:
+			var req struct {
+				Name           string   `json:"name"`
+				Column         string   `json:"column"`
+				FrameAncestors []string `json:"frameAncestors"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeError(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			embed, token, err := s.CreateEmbed(req.Name, req.Column, req.FrameAncestors, requestActor(r))
+			if err != nil {
+				writeMutationError(w, err)
+				return
+			}
+			s.Audit(r, AuditEmbedCreate, embed.ID+" "+embed.Name)
+			embed.TokenHash = ""
+			w.Header().Set("Cache-Control", "no-store")
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(struct {
+				Embed Embed  `json:"embed"`
+				URL   string `json:"url"`
+			}{embed, requestBase(r) + "/embed/" + token})
+		case http.MethodDelete:
+			id := r.URL.Query().Get("id")
+			if err := s.DeleteEmbed(id); err != nil {
+				writeMutationError(w, err)
+				return
+			}
+			s.Audit(r, AuditEmbedRevoke, id)
+			w.WriteHeader(http.StatusOK)
+		default:
+			writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
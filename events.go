@@ -0,0 +1,52 @@
+package main
+
+// Event types emitted by local card operations.
+const (
+	EventCardCreated   = "card.created"
+	EventCardUpdated   = "card.updated"
+	EventCardMoved     = "card.moved"
+	EventCardDeleted   = "card.deleted"
+	EventCardLabeled   = "card.labeled"
+	EventCardAssigned  = "card.assigned"
+	EventCardCommented = "card.commented"
+	EventCardOverdue   = "card.overdue"
+	EventSprintEnded   = "sprint.ended" // Title is the sprint's name
+)
+
+// Event describes a change made through this node's edit pipeline. Events are
+// only emitted for local edits that actually changed the board; deltas merged
+// from peers do not produce events, so each change is observed exactly once
+// across the cluster (on the node where it happened).
+type Event struct {
+	Type     string `json:"type"`
+	CardID   string `json:"cardId"`
+	Title    string `json:"title,omitempty"`
+	Column   string `json:"column,omitempty"`
+	From     string `json:"from,omitempty"`
+	Label    string `json:"label,omitempty"`
+	Assignee string `json:"assignee,omitempty"`
+	Author   string `json:"author,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+	Time     int64  `json:"time"`
+	Remote   bool   `json:"remote,omitempty"`
+}
+
+// OnEvent registers fn to be called for every local event, once its edit is
+// saved. Listeners run synchronously on the goroutine that made the edit
+// (after the store lock is released) and must not block. The change feed
+// records events with their edit instead, see recordChanges.
+func (s *Store) OnEvent(fn func(Event)) {
+	s.listenMu.Lock()
+	defer s.listenMu.Unlock()
+	s.listeners = append(s.listeners, fn)
+}
+
+// emit passes ev, staged by an edit that is now saved, to the listeners.
+func (s *Store) emit(ev Event) {
+	s.listenMu.RLock()
+	listeners := s.listeners
+	s.listenMu.RUnlock()
+	for _, fn := range listeners {
+		fn(ev)
+	}
+}
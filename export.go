@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode"
+)
+
+// markdownEscaper escapes the characters that format Markdown anywhere in a
+// line.
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", `*`, `\*`, `_`, `\_`, `[`, `\[`, `]`, `\]`,
+	`<`, `\<`, `>`, `\>`, `|`, `\|`, `#`, `\#`, `~`, `\~`,
+)
+
+// escapeMarkdown returns line as Markdown text that renders as itself: its
+// formatting characters are escaped, and so is a start that would make it a
+// list item or a heading underline.
+func escapeMarkdown(line string) string {
+	line = markdownEscaper.Replace(line)
+	indent := len(line) - len(strings.TrimLeft(line, " \t"))
+	rest := line[indent:]
+	if strings.HasPrefix(rest, "-") || strings.HasPrefix(rest, "+") || strings.HasPrefix(rest, "=") {
+		return line[:indent] + `\` + rest
+	}
+	// "1." and "1)" start ordered lists.
+	if i := strings.IndexFunc(rest, func(r rune) bool { return !unicode.IsDigit(r) }); i > 0 && (rest[i] == '.' || rest[i] == ')') {
+		return line[:indent+i] + `\` + rest[i:]
+	}
+	return line
+}
+
+// escapeMarkdownLine is escapeMarkdown for text shown on one line, such as a
+// title.
+func escapeMarkdownLine(s string) string {
+	return escapeMarkdown(strings.Join(strings.Fields(s), " "))
+}
+
+// renderMarkdown produces a Markdown report of the board: one heading per
+// column and one bullet per card, in display order.
+func renderMarkdown(state BoardState) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n", escapeMarkdownLine(state.Board.Title))
+
+	for _, col := range buildUIColumns(state) {
+		fmt.Fprintf(&b, "\n## %s (%d)\n\n", escapeMarkdownLine(col.Title), len(col.Cards))
+		if len(col.Cards) == 0 {
+			b.WriteString("_No cards._\n")
+			continue
+		}
+		for _, card := range col.Cards {
+			fmt.Fprintf(&b, "- **%s**", escapeMarkdownLine(card.Title))
+			if card.Assignee != "" {
+				fmt.Fprintf(&b, " (@%s)", escapeMarkdownLine(card.Assignee))
+			}
+			b.WriteString("\n")
+			desc := strings.TrimSpace(textString(card.Description))
+			if desc == "" {
+				continue
+			}
+			// Indent every line so multi-line descriptions stay inside the bullet.
+			for _, line := range strings.Split(desc, "\n") {
+				fmt.Fprintf(&b, "  %s\n", escapeMarkdown(line))
+			}
+		}
+	}
+	return b.String()
+}
+
+func handleExportMarkdown(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+		w.Header().Set("Content-Disposition", `inline; filename="board.md"`)
+		fmt.Fprint(w, renderMarkdown(s.GetBoard()))
+	}
+}
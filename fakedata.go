@@ -0,0 +1,174 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"slices"
+	"time"
+)
+
+// "deepboard seed" fills a board with made-up cards for performance tests
+// and screenshots. The cards are drawn from a generator seeded with -seed,
+// so the same seed always produces the same titles, people, labels and
+// histories. Card IDs are random, as always.
+
+// Fake card material.
+var (
+	fakeVerbs = []string{
+		"Fix", "Add", "Refactor", "Document", "Investigate", "Speed up", "Remove",
+		"Migrate", "Test", "Redesign", "Clean up", "Support",
+	}
+	fakeSubjects = []string{
+		"login page", "password reset", "search results", "CSV export", "billing emails",
+		"dark mode", "mobile layout", "API rate limits", "onboarding flow", "audit log",
+		"webhook retries", "image uploads", "settings screen", "notification digest",
+		"SSO callback", "release notes", "error pages", "database backups", "cache warmup",
+		"team invitations",
+	}
+	fakeQualifiers = []string{
+		"", "", "", " on Safari", " for large accounts", " in the admin area",
+		" after the last release", " behind a feature flag", " for new users",
+	}
+	fakePeople = []string{
+		"Ada Lovelace", "Grace Hopper", "Alan Turing", "Barbara Liskov", "Ken Thompson",
+		"Margaret Hamilton", "Dennis Ritchie", "Radia Perlman",
+	}
+	fakeLabels     = []string{"bug", "feature", "tech-debt", "ux", "backend", "frontend", "security", "docs"}
+	fakePriorities = []string{"", "", "low", "medium", "medium", "high", "critical"}
+	fakeEstimates  = []string{"1", "2", "3", "5", "8", "13"}
+	fakeSentences  = []string{
+		"Reported by two customers this week.",
+		"Steps to reproduce are in the support ticket.",
+		"We should agree on the approach before starting.",
+		"Blocked until the new API version ships.",
+		"The current behavior is confusing and undocumented.",
+		"Check the metrics dashboard before and after.",
+		"Needs a migration for existing data.",
+		"Design mockups are linked in the spec.",
+	}
+	fakeComments = []string{
+		"I can take this one.", "Is this still relevant?", "Pushed a first draft.",
+		"Reviewed, looks good to me.", "Moved to next sprint's candidates.",
+		"Found the root cause, fix incoming.", "Can we split this into smaller cards?",
+	}
+)
+
+// FakeComment is a comment on a fake card.
+type FakeComment struct {
+	Author string
+	Body   string
+}
+
+// FakeCard is a made-up card: the card itself, the columns it moves
+// through after being created in the first one, and what is said about it.
+type FakeCard struct {
+	Draft    CardDraft
+	Path     []string
+	Comments []FakeComment
+	Estimate string
+}
+
+// fakeCards draws n cards for a board with columns, the same ones for the
+// same seed. About half stay in the first column, and the rest are spread
+// over the later ones, each card moving through every column before its own.
+func fakeCards(n int, seed uint64, columns []string) []FakeCard {
+	rng := rand.New(rand.NewPCG(seed, seed))
+	pick := func(from []string) string { return from[rng.IntN(len(from))] }
+	cards := make([]FakeCard, n)
+	for i := range cards {
+		c := &cards[i]
+		c.Draft = CardDraft{
+			Title:    pick(fakeVerbs) + " " + pick(fakeSubjects) + pick(fakeQualifiers),
+			ColumnID: columns[0],
+			Priority: pick(fakePriorities),
+		}
+		for range rng.IntN(3) {
+			if c.Draft.Description != "" {
+				c.Draft.Description += " "
+			}
+			c.Draft.Description += pick(fakeSentences)
+		}
+		if rng.IntN(4) > 0 {
+			c.Draft.Assignee = pick(fakePeople)
+		}
+		for range rng.IntN(3) {
+			if l := pick(fakeLabels); !slices.Contains(c.Draft.Labels, l) {
+				c.Draft.Labels = append(c.Draft.Labels, l)
+			}
+		}
+		if rng.IntN(2) == 0 && len(columns) > 1 {
+			c.Path = columns[1 : 2+rng.IntN(len(columns)-1)]
+		}
+		for range rng.IntN(4) {
+			c.Comments = append(c.Comments, FakeComment{Author: pick(fakePeople), Body: pick(fakeComments)})
+		}
+		if rng.IntN(3) > 0 {
+			c.Estimate = pick(fakeEstimates)
+		}
+	}
+	return cards
+}
+
+// SeedFakeCards adds n fake cards drawn with seed to the board, one edit at
+// a time so that each card gets a history.
+func (s *Store) SeedFakeCards(n int, seed uint64) error {
+	var columns []string
+	for _, col := range s.GetBoard().Board.Columns {
+		columns = append(columns, col.ID)
+	}
+	if len(columns) == 0 {
+		return ErrColumnNotFound
+	}
+	for _, c := range fakeCards(n, seed, columns) {
+		ids, err := s.ImportCards([]CardDraft{c.Draft})
+		if err != nil {
+			return err
+		}
+		id := ids[0]
+		for _, col := range c.Path {
+			if err := s.MoveCard(id, col, 0); err != nil {
+				return err
+			}
+		}
+		if c.Estimate != "" {
+			if err := s.SetEstimate(id, c.Estimate); err != nil {
+				return err
+			}
+		}
+		for _, comment := range c.Comments {
+			if err := s.AddComment(id, comment.Author, comment.Body); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// runSeedFake is "deepboard seed": it adds fake cards to a database. The
+// node must not be running on it.
+func runSeedFake(args []string) {
+	fs := flag.NewFlagSet("seed", flag.ExitOnError)
+	db := fs.String("db", "deepboard.db", "path to the sqlite database to fill")
+	cards := fs.Int("cards", 100, "number of cards to add")
+	seed := fs.Uint64("seed", 1, "generator seed; the same seed adds the same cards")
+	fs.Parse(args)
+	if *cards < 1 {
+		log.Fatal("seed: -cards must be positive")
+	}
+
+	s, err := NewStore(*db, "seed", nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	start := time.Now()
+	if err := s.SeedFakeCards(*cards, *seed); err != nil {
+		s.Close()
+		log.Fatalf("seed: %v", err)
+	}
+	if err := s.Close(); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Added %d cards to %s in %v\n", *cards, *db, time.Since(start).Round(time.Millisecond))
+}
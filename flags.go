@@ -0,0 +1,198 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Risky features are rolled out gradually behind flags. A flag is on, or on
+// for some users: those it names and a share of the others, always the same
+// ones, picked by a hash of the user and the flag. Features of a node rather
+// than of its users, like pushing edits to peers, are evaluated for the node
+// instead, so a share of the nodes gets them; flags name nodes as
+// "node:<id>". Defaults come from the "features" section of -config and hold
+// for every board; an admin overrides them for one board on one node with
+// /api/admin/features, kept in the node's settings, so a feature can be
+// tried on a board of one node before the rest of the cluster. Overrides are
+// read from the settings when the board is opened and kept in memory, and
+// written through when they change, so evaluating a flag never touches the
+// database and cannot fall back to the defaults. Pages get the
+// flags that are on for their viewer as `features`, and GET /api/features
+// returns them. Visitors who are not signed in get the node's.
+
+// FeatureDeltaPush sends each edit to the peers as it is made. Without it,
+// edits reach them with the periodic sync.
+const FeatureDeltaPush = "deltaPush"
+
+// builtinFeatures are the flags this version knows, with their defaults.
+var builtinFeatures = map[string]FeatureFlag{
+	FeatureDeltaPush: {Enabled: true},
+}
+
+// featuresSetting is the setting a board's overrides are kept in.
+const featuresSetting = "features"
+
+// FeatureFlag says who a feature is on for.
+type FeatureFlag struct {
+	Enabled bool     `json:"enabled"`           // for everyone
+	Percent int      `json:"percent,omitempty"` // share of users it is on for, 0 to 100
+	Users   []string `json:"users,omitempty"`   // it is on for
+}
+
+// on reports whether f, the flag of feature name, is on for subject.
+func (f FeatureFlag) on(name, subject string) bool {
+	if f.Enabled || slices.Contains(f.Users, subject) {
+		return true
+	}
+	h := fnv.New32a()
+	h.Write([]byte(name + "/" + subject))
+	return int(h.Sum32()%100) < f.Percent
+}
+
+// SetFeatures sets the default flags of the board, over the built-in ones.
+// It must be called before serving requests.
+func (s *Store) SetFeatures(flags map[string]FeatureFlag) {
+	s.features = flags
+}
+
+// loadFeatureOverrides reads the flags set for this board on this node from
+// the settings.
+func (s *Store) loadFeatureOverrides() error {
+	overrides := map[string]FeatureFlag{}
+	if _, err := s.GetSetting(featuresSetting, &overrides); err != nil {
+		return fmt.Errorf("load feature flags: %w", err)
+	}
+	s.overrides.Store(&overrides)
+	return nil
+}
+
+// featureOverrides returns the flags set for this board on this node. The
+// map must not be modified.
+func (s *Store) featureOverrides() map[string]FeatureFlag {
+	if o := s.overrides.Load(); o != nil {
+		return *o
+	}
+	return nil
+}
+
+// SetFeatureOverride overrides feature name for this board on this node
+// with flag, or drops the override if flag is nil. The override is in effect
+// once it is saved.
+func (s *Store) SetFeatureOverride(name string, flag *FeatureFlag) error {
+	s.featureMu.Lock()
+	defer s.featureMu.Unlock()
+	overrides := maps.Clone(s.featureOverrides())
+	if overrides == nil {
+		overrides = map[string]FeatureFlag{}
+	}
+	if flag == nil {
+		delete(overrides, name)
+	} else {
+		overrides[name] = *flag
+	}
+	if err := s.SetSetting(featuresSetting, overrides); err != nil {
+		return err
+	}
+	s.overrides.Store(&overrides)
+	return nil
+}
+
+// FeatureFlags returns the flags of the board: the built-in ones, replaced
+// by those configured and then by the board's overrides.
+func (s *Store) FeatureFlags() map[string]FeatureFlag {
+	flags := maps.Clone(builtinFeatures)
+	maps.Copy(flags, s.features)
+	maps.Copy(flags, s.featureOverrides())
+	return flags
+}
+
+// Feature reports whether feature name is on for user, or for this node if
+// user is "".
+func (s *Store) Feature(name, user string) bool {
+	f, ok := s.FeatureFlags()[name]
+	return ok && f.on(name, cmp.Or(user, "node:"+s.nodeID))
+}
+
+// Features returns the features that are on for user, or for this node if
+// user is "", as the page and /api/features give them.
+func (s *Store) Features(user string) map[string]bool {
+	on := map[string]bool{}
+	for name, f := range s.FeatureFlags() {
+		if f.on(name, cmp.Or(user, "node:"+s.nodeID)) {
+			on[name] = true
+		}
+	}
+	return on
+}
+
+// featureUser returns who the flags of r are evaluated for, "" for the
+// node.
+func featureUser(r *http.Request) string {
+	if u := currentUser(r); u != nil {
+		return u.ID
+	}
+	return ""
+}
+
+// handleFeatures serves GET /api/features, the features on for the viewer:
+// {"deltaPush": true}.
+func handleFeatures(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.Features(featureUser(r)))
+	}
+}
+
+// handleAdminFeatures serves /api/admin/features: GET lists the flags of the
+// board, POST {"name": "deltaPush", "percent": 25} overrides one for this
+// board on this node, and DELETE ?name=deltaPush drops the override. Each
+// answers the flags.
+func handleAdminFeatures(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost, http.MethodDelete:
+			var req struct {
+				Name string `json:"name"`
+				FeatureFlag
+			}
+			if r.Method == http.MethodDelete {
+				req.Name = r.URL.Query().Get("name")
+			} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeError(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if strings.TrimSpace(req.Name) == "" || req.Percent < 0 || req.Percent > 100 {
+				writeError(w, "a flag needs a name and a percent from 0 to 100", http.StatusBadRequest)
+				return
+			}
+			flag, detail := &req.FeatureFlag, req.Name+" reset"
+			if r.Method == http.MethodDelete {
+				flag = nil
+			} else {
+				data, _ := json.Marshal(req.FeatureFlag)
+				detail = req.Name + " " + string(data)
+			}
+			if err := s.SetFeatureOverride(req.Name, flag); err != nil {
+				writeError(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			log.Printf("ADMIN: Feature %s", detail)
+			s.Audit(r, AuditFeatureChange, detail)
+		default:
+			writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.FeatureFlags())
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const githubSettingKey = "integration.github"
+
+var githubAPIBase = "https://api.github.com"
+
+var githubHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// GitHubConfig links the board to a GitHub repository. It is stored as a
+// node-local setting so the token never enters the replicated board state.
+type GitHubConfig struct {
+	Repo           string `json:"repo"` // "owner/name"
+	Token          string `json:"token,omitempty"`
+	WebhookSecret  string `json:"webhookSecret,omitempty"`
+	CreateIssues   bool   `json:"createIssues"`
+	MirrorComments bool   `json:"mirrorComments"`
+	DoneColumn     string `json:"doneColumn,omitempty"` // where closed issues go; the last column if empty
+}
+
+func loadGitHubConfig(s *Store) (GitHubConfig, bool) {
+	var cfg GitHubConfig
+	ok, err := s.GetSetting(githubSettingKey, &cfg)
+	if err != nil {
+		log.Printf("Failed to load GitHub config: %v", err)
+		return cfg, false
+	}
+	return cfg, ok && cfg.Repo != ""
+}
+
+// githubOnCardCreated opens an issue for a newly created card and links the
+// two. It is a no-op unless issue creation is enabled.
+func githubOnCardCreated(s *Store, cardID, title string) {
+	cfg, ok := loadGitHubConfig(s)
+	if !ok || !cfg.CreateIssues || cfg.Token == "" {
+		return
+	}
+
+	body, _ := json.Marshal(map[string]string{
+		"title": title,
+		"body":  "Created from DeepBoard card " + cardID,
+	})
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/repos/%s/issues", githubAPIBase, cfg.Repo), bytes.NewReader(body))
+	if err != nil {
+		log.Printf("GitHub: failed to build request: %v", err)
+		return
+	}
+	req.Header.Set("Authorization", "Bearer "+cfg.Token)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := githubHTTPClient.Do(req)
+	if err != nil {
+		log.Printf("GitHub: failed to create issue for card %s: %v", cardID, err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		log.Printf("GitHub: issue creation for card %s returned %s: %s", cardID, resp.Status, msg)
+		return
+	}
+
+	var issue struct {
+		Number  int    `json:"number"`
+		HTMLURL string `json:"html_url"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&issue); err != nil {
+		log.Printf("GitHub: failed to decode issue response: %v", err)
+		return
+	}
+	if err := s.LinkIssue(cardID, issue.Number, issue.HTMLURL); err != nil {
+		log.Printf("GitHub: failed to link issue #%d to card %s: %v", issue.Number, cardID, err)
+	}
+}
+
+// verifyGitHubSignature checks the X-Hub-Signature-256 header against the
+// configured webhook secret.
+func verifyGitHubSignature(secret string, body []byte, header string) bool {
+	sig, ok := strings.CutPrefix(header, "sha256=")
+	if !ok {
+		return false
+	}
+	got, err := hex.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+type githubWebhookPayload struct {
+	Action string `json:"action"`
+	Issue  struct {
+		Number int `json:"number"`
+	} `json:"issue"`
+	Comment struct {
+		Body string `json:"body"`
+		User struct {
+			Login string `json:"login"`
+		} `json:"user"`
+	} `json:"comment"`
+}
+
+// doneColumn returns the column a card goes to when its issue is closed:
+// the configured one, or the last column of b.
+func (cfg GitHubConfig) doneColumn(b Board) (string, error) {
+	if cfg.DoneColumn != "" {
+		if columnIndex(b, cfg.DoneColumn) < 0 {
+			return "", ErrColumnNotFound
+		}
+		return cfg.DoneColumn, nil
+	}
+	cols := orderedColumns(b)
+	if len(cols) == 0 {
+		return "", ErrColumnNotFound
+	}
+	return cols[len(cols)-1].ID, nil
+}
+
+// handleGitHubWebhook receives "issues" and "issue_comment" events. A closed
+// issue moves its card to the Done column; new comments are mirrored if
+// enabled. The webhook is unauthenticated but for its signature, so it is
+// refused until a webhook secret is configured.
+func handleGitHubWebhook(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cfg, ok := loadGitHubConfig(s)
+		if !ok {
+			writeError(w, "GitHub integration not configured", http.StatusNotFound)
+			return
+		}
+
+		if cfg.WebhookSecret == "" {
+			writeError(w, "GitHub webhook secret not configured", http.StatusForbidden)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+		if err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !verifyGitHubSignature(cfg.WebhookSecret, body, r.Header.Get("X-Hub-Signature-256")) {
+			writeError(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+
+		var p githubWebhookPayload
+		if err := json.Unmarshal(body, &p); err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		cardID, found := s.CardByIssue(p.Issue.Number)
+		if !found {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		var opErr error
+		switch event := r.Header.Get("X-GitHub-Event"); {
+		case event == "issues" && p.Action == "closed":
+			var done string
+			if done, opErr = cfg.doneColumn(s.GetBoard().Board); opErr == nil {
+				log.Printf("GitHub: issue #%d closed, moving card %s to %s", p.Issue.Number, cardID, done)
+				opErr = s.MoveCard(cardID, done, math.MaxInt)
+			}
+		case event == "issue_comment" && p.Action == "created" && cfg.MirrorComments:
+			opErr = s.AddComment(cardID, "github:"+p.Comment.User.Login, p.Comment.Body)
+		}
+		if opErr != nil {
+			writeMutationError(w, opErr)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// handleGitHubConfig reads (GET) or replaces (POST) the GitHub integration
+// config. Secrets are never echoed back; posting an empty token or secret
+// keeps the stored value.
+func handleGitHubConfig(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var current GitHubConfig
+		if _, err := s.GetSetting(githubSettingKey, &current); err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if r.Method == http.MethodPost {
+			var cfg GitHubConfig
+			if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+				writeError(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if cfg.Token == "" {
+				cfg.Token = current.Token
+			}
+			if cfg.WebhookSecret == "" {
+				cfg.WebhookSecret = current.WebhookSecret
+			}
+			if err := s.SetSetting(githubSettingKey, cfg); err != nil {
+				writeError(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			log.Printf("ADMIN: GitHub integration configured for %s", cfg.Repo)
+			s.Audit(r, AuditIntegrationChange, "github repo="+cfg.Repo)
+			current = cfg
+		}
+
+		current.Token = ""
+		current.WebhookSecret = ""
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(current)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Heatmap counts the board's edits in the patch log, by calendar day and by
+// hour of the week, in one time zone. Presence updates, like connection
+// counts, are not edits and are left out. Like the history, it only covers the retained
+// patches.
+type Heatmap struct {
+	Days  []HeatmapDay `json:"days"`  // oldest first; days without edits are left out
+	Hours [7][24]int   `json:"hours"` // by weekday, Sunday first, and hour
+	Total int          `json:"total"`
+}
+
+// HeatmapDay is the number of edits made on one day.
+type HeatmapDay struct {
+	Date  string `json:"date"` // 2006-01-02
+	Edits int    `json:"edits"`
+}
+
+// patchTime returns the wall time of a patch log timestamp, an HLC as
+// "wall:logical:node".
+func patchTime(timestamp string) (time.Time, bool) {
+	wall, _, _ := strings.Cut(timestamp, ":")
+	ns, err := strconv.ParseInt(wall, 10, 64)
+	if err != nil || ns <= 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(0, ns), true
+}
+
+// ActivityHeatmap aggregates the patch log into a heatmap in loc.
+func (s *Store) ActivityHeatmap(loc *time.Location) (Heatmap, error) {
+	s.histMu.RLock()
+	defer s.histMu.RUnlock()
+
+	rows, err := s.db.Query("SELECT timestamp FROM patches WHERE kind != ?", PatchPresence)
+	if err != nil {
+		return Heatmap{}, err
+	}
+	defer rows.Close()
+
+	var hm Heatmap
+	days := make(map[string]int)
+	for rows.Next() {
+		var timestamp string
+		if err := rows.Scan(&timestamp); err != nil {
+			return Heatmap{}, err
+		}
+		t, ok := patchTime(timestamp)
+		if !ok {
+			continue
+		}
+		t = t.In(loc)
+		days[t.Format(time.DateOnly)]++
+		hm.Hours[t.Weekday()][t.Hour()]++
+		hm.Total++
+	}
+	if err := rows.Err(); err != nil {
+		return Heatmap{}, err
+	}
+	hm.Days = make([]HeatmapDay, 0, len(days))
+	for date, n := range days {
+		hm.Days = append(hm.Days, HeatmapDay{Date: date, Edits: n})
+	}
+	slices.SortFunc(hm.Days, func(a, b HeatmapDay) int { return strings.Compare(a.Date, b.Date) })
+	return hm, nil
+}
+
+// handleHeatmap serves the activity heatmap, GET /api/metrics/heatmap. The
+// optional tz parameter names the IANA time zone to bucket edits in; it
+// defaults to UTC.
+func handleHeatmap(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		loc := time.UTC
+		if tz := r.URL.Query().Get("tz"); tz != "" {
+			var err error
+			if loc, err = time.LoadLocation(tz); err != nil {
+				writeError(w, "invalid tz", http.StatusBadRequest)
+				return
+			}
+		}
+		hm, err := s.ActivityHeatmap(loc)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(hm)
+	}
+}
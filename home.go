@@ -0,0 +1,152 @@
+package main
+
+import (
+	"cmp"
+	"database/sql"
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// maxRecentBoards is how many recently visited boards the home page lists.
+const maxRecentBoards = 10
+
+// HomeBoard is a board as listed on a user's home page.
+type HomeBoard struct {
+	Key       string `json:"key"`
+	Path      string `json:"path"`
+	Title     string `json:"title"`
+	Cards     int    `json:"cards"`
+	Starred   bool   `json:"starred"`
+	LastVisit int64  `json:"lastVisit"` // unix milliseconds, 0 if never visited
+}
+
+// homeUser returns the key under which a request's favorites and visits are
+// kept. Without sign-in everyone shares the anonymous ones.
+func homeUser(r *http.Request) string {
+	if u := currentUser(r); u != nil {
+		return u.ID
+	}
+	return "anonymous"
+}
+
+// RecordVisit notes that user opened this board.
+func (s *Store) RecordVisit(user string, now time.Time) {
+	_, err := s.db.Exec(`INSERT INTO board_users (user, last_visit) VALUES (?, ?)
+		ON CONFLICT (user) DO UPDATE SET last_visit = excluded.last_visit`, user, now.UnixMilli())
+	if err != nil {
+		log.Printf("Failed to record visit of %s: %v", user, err)
+	}
+}
+
+// SetStarred stars or unstars this board for user.
+func (s *Store) SetStarred(user string, starred bool) error {
+	_, err := s.db.Exec(`INSERT INTO board_users (user, starred) VALUES (?, ?)
+		ON CONFLICT (user) DO UPDATE SET starred = excluded.starred`, user, starred)
+	return err
+}
+
+// boardUser returns whether user starred this board and when they last
+// visited it.
+func (s *Store) boardUser(user string) (starred bool, lastVisit int64, err error) {
+	err = s.db.QueryRow("SELECT starred, last_visit FROM board_users WHERE user = ?", user).Scan(&starred, &lastVisit)
+	if err == sql.ErrNoRows {
+		err = nil
+	}
+	return starred, lastVisit, err
+}
+
+// homeBoards returns the boards user starred, by title, and the boards they
+// visited, most recent first.
+func homeBoards(stores []*Store, user string) (starred, recent []HomeBoard, err error) {
+	starred, recent = []HomeBoard{}, []HomeBoard{}
+	for _, s := range stores {
+		isStarred, lastVisit, err := s.boardUser(user)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !isStarred && lastVisit == 0 {
+			continue
+		}
+		state := s.GetBoard()
+		b := HomeBoard{
+			Key:       s.BoardKey(),
+			Path:      s.basePath + "/",
+			Title:     state.Board.Title,
+			Cards:     len(state.Board.Cards),
+			Starred:   isStarred,
+			LastVisit: lastVisit,
+		}
+		if isStarred {
+			starred = append(starred, b)
+		}
+		if lastVisit != 0 {
+			recent = append(recent, b)
+		}
+	}
+	slices.SortFunc(starred, func(a, b HomeBoard) int {
+		return cmp.Or(cmp.Compare(a.Title, b.Title), cmp.Compare(a.Key, b.Key))
+	})
+	slices.SortFunc(recent, func(a, b HomeBoard) int { return cmp.Compare(b.LastVisit, a.LastVisit) })
+	if len(recent) > maxRecentBoards {
+		recent = recent[:maxRecentBoards]
+	}
+	return starred, recent, nil
+}
+
+// handleHome serves the personal home page, GET /home. Like search it is
+// mounted above the tenant router and covers every board.
+func handleHome(boards *Boards) http.HandlerFunc {
+	tmpl := template.Must(template.New("home").Parse(homeHTML))
+	return func(w http.ResponseWriter, r *http.Request) {
+		starred, recent, err := homeBoards(boards.All(), homeUser(r))
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		tmpl.Execute(w, struct{ Starred, Recent []HomeBoard }{starred, recent})
+	}
+}
+
+// handleHomeAPI serves the home page data, GET /api/home, which the page
+// polls to keep card counts live.
+func handleHomeAPI(boards *Boards) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		starred, recent, err := homeBoards(boards.All(), homeUser(r))
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Starred []HomeBoard `json:"starred"`
+			Recent  []HomeBoard `json:"recent"`
+		}{starred, recent})
+	}
+}
+
+// handleFavorite serves POST /api/favorites with a board key and
+// star=true|false.
+func handleFavorite(boards *Boards) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s := boards.Get(r.FormValue("board"))
+		if s == nil {
+			writeError(w, "board not found", http.StatusNotFound)
+			return
+		}
+		if err := s.SetStarred(homeUser(r), r.FormValue("star") != "false"); err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
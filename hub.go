@@ -0,0 +1,168 @@
+package main
+
+import (
+	"log"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	subscriberShardBits = 4
+	subscriberShards    = 1 << subscriberShardBits
+
+	// broadcastQueueSize bounds the messages waiting for the dispatcher.
+	// Messages are full-refresh hints, so dropping one under extreme load
+	// only delays clients until the next one.
+	broadcastQueueSize = 1024
+)
+
+type subscriberShard struct {
+	mu   sync.Mutex
+	subs map[chan WSMessage]time.Time // last heartbeat
+}
+
+// Hub owns a store's WebSocket subscribers. Broadcast only enqueues: a
+// dedicated dispatcher goroutine does the fan-out, so callers (which usually
+// hold the store lock) never pay for the number of subscribers. Subscribers
+// are spread over independently locked shards so that connects, heartbeats
+// and fan-out to one shard do not wait on the others.
+type Hub struct {
+	shards  [subscriberShards]subscriberShard
+	queue   chan WSMessage
+	done    chan struct{}
+	count   atomic.Int64
+	dropped atomic.Int64
+}
+
+func newHub() *Hub {
+	h := &Hub{
+		queue: make(chan WSMessage, broadcastQueueSize),
+		done:  make(chan struct{}),
+	}
+	for i := range h.shards {
+		h.shards[i].subs = make(map[chan WSMessage]time.Time)
+	}
+	go h.dispatch()
+	return h
+}
+
+// shard picks the shard of ch by hashing the channel's address.
+func (h *Hub) shard(ch chan WSMessage) *subscriberShard {
+	p := uint64(reflect.ValueOf(ch).Pointer())
+	return &h.shards[(p*0x9E3779B97F4A7C15)>>(64-subscriberShardBits)]
+}
+
+// Add registers ch.
+func (h *Hub) Add(ch chan WSMessage) {
+	sh := h.shard(ch)
+	sh.mu.Lock()
+	sh.subs[ch] = time.Now()
+	sh.mu.Unlock()
+	h.count.Add(1)
+}
+
+// Remove unregisters and closes ch. It reports false if ch was not
+// registered.
+func (h *Hub) Remove(ch chan WSMessage) bool {
+	sh := h.shard(ch)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if _, ok := sh.subs[ch]; !ok {
+		return false
+	}
+	delete(sh.subs, ch)
+	close(ch)
+	h.count.Add(-1)
+	return true
+}
+
+// Touch records a heartbeat from ch.
+func (h *Hub) Touch(ch chan WSMessage) {
+	sh := h.shard(ch)
+	sh.mu.Lock()
+	if _, ok := sh.subs[ch]; ok {
+		sh.subs[ch] = time.Now()
+	}
+	sh.mu.Unlock()
+}
+
+// Expire removes subscribers without a heartbeat for longer than maxAge and
+// returns how many were removed.
+func (h *Hub) Expire(maxAge time.Duration) int {
+	now := time.Now()
+	removed := 0
+	for i := range h.shards {
+		sh := &h.shards[i]
+		sh.mu.Lock()
+		for ch, lastSeen := range sh.subs {
+			if now.Sub(lastSeen) > maxAge {
+				delete(sh.subs, ch)
+				close(ch)
+				removed++
+			}
+		}
+		sh.mu.Unlock()
+	}
+	h.count.Add(-int64(removed))
+	return removed
+}
+
+// Len returns the number of subscribers.
+func (h *Hub) Len() int {
+	return int(h.count.Load())
+}
+
+// Send delivers msg to ch right away, if it is still registered.
+func (h *Hub) Send(ch chan WSMessage, msg WSMessage) {
+	sh := h.shard(ch)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if _, ok := sh.subs[ch]; !ok {
+		return
+	}
+	select {
+	case ch <- msg:
+	default:
+	}
+}
+
+// Broadcast queues msg for every subscriber without blocking.
+func (h *Hub) Broadcast(msg WSMessage) {
+	select {
+	case h.queue <- msg:
+	default:
+		if h.dropped.Add(1)%100 == 1 {
+			log.Printf("Broadcast queue full; dropped %d messages so far", h.dropped.Load())
+		}
+	}
+}
+
+// Close disconnects every subscriber and stops the dispatcher.
+func (h *Hub) Close() {
+	close(h.done)
+	h.Expire(-1)
+}
+
+func (h *Hub) dispatch() {
+	for {
+		var msg WSMessage
+		select {
+		case msg = <-h.queue:
+		case <-h.done:
+			return
+		}
+		for i := range h.shards {
+			sh := &h.shards[i]
+			sh.mu.Lock()
+			for ch := range sh.subs {
+				select {
+				case ch <- msg:
+				default:
+				}
+			}
+			sh.mu.Unlock()
+		}
+	}
+}
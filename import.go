@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// CardDraft is a card that has not been added to the board yet.
+type CardDraft struct {
+	Title       string   `json:"title"`
+	Description string   `json:"description"`
+	ColumnID    string   `json:"columnID"`
+	Assignee    string   `json:"assignee"`
+	Labels      []string `json:"labels"`
+	Priority    string   `json:"priority"`
+}
+
+type ImportResult struct {
+	DryRun  bool        `json:"dryRun"`
+	Cards   []CardDraft `json:"cards"`
+	Created []string    `json:"created,omitempty"`
+}
+
+// jiraStatusColumns maps common Jira workflow statuses to the default columns.
+var jiraStatusColumns = map[string]string{
+	"to do":       "todo",
+	"open":        "todo",
+	"backlog":     "todo",
+	"selected":    "todo",
+	"reopened":    "todo",
+	"in progress": "in-progress",
+	"in review":   "in-progress",
+	"review":      "in-progress",
+	"blocked":     "in-progress",
+	"done":        "done",
+	"closed":      "done",
+	"resolved":    "done",
+}
+
+// jiraColumnFor maps a Jira status to a column ID, preferring columns whose ID
+// or title matches the status and falling back to the well-known statuses.
+func jiraColumnFor(status string, columns []Column) string {
+	status = strings.ToLower(strings.TrimSpace(status))
+	for _, col := range columns {
+		if strings.ToLower(col.ID) == status || strings.ToLower(col.Title) == status {
+			return col.ID
+		}
+	}
+	if id, ok := jiraStatusColumns[status]; ok {
+		return id
+	}
+	return "todo"
+}
+
+type jiraIssue struct {
+	Key    string `json:"key"`
+	Fields struct {
+		Summary     string   `json:"summary"`
+		Description string   `json:"description"`
+		Labels      []string `json:"labels"`
+		Status      struct {
+			Name string `json:"name"`
+		} `json:"status"`
+		Assignee *struct {
+			DisplayName string `json:"displayName"`
+		} `json:"assignee"`
+		Priority *struct {
+			Name string `json:"name"`
+		} `json:"priority"`
+	} `json:"fields"`
+}
+
+// parseJiraJSON accepts either a Jira search response ({"issues": [...]}) or
+// a bare array of issues.
+func parseJiraJSON(data []byte, columns []Column) ([]CardDraft, error) {
+	var issues []jiraIssue
+	if err := json.Unmarshal(data, &issues); err != nil {
+		var search struct {
+			Issues []jiraIssue `json:"issues"`
+		}
+		if err := json.Unmarshal(data, &search); err != nil {
+			return nil, err
+		}
+		issues = search.Issues
+	}
+
+	drafts := make([]CardDraft, 0, len(issues))
+	for _, is := range issues {
+		d := CardDraft{
+			Title:       jiraTitle(is.Key, is.Fields.Summary),
+			Description: is.Fields.Description,
+			ColumnID:    jiraColumnFor(is.Fields.Status.Name, columns),
+			Labels:      is.Fields.Labels,
+		}
+		if is.Fields.Assignee != nil {
+			d.Assignee = is.Fields.Assignee.DisplayName
+		}
+		if is.Fields.Priority != nil {
+			d.Priority = is.Fields.Priority.Name
+		}
+		drafts = append(drafts, d)
+	}
+	return drafts, nil
+}
+
+// parseJiraCSV reads a Jira CSV export. Jira repeats the "Labels" header once
+// per label, so every column with that name contributes to the card's labels.
+func parseJiraCSV(r io.Reader, columns []Column) ([]CardDraft, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	index := make(map[string]int)
+	var labelCols []int
+	for i, h := range header {
+		h = strings.ToLower(strings.TrimSpace(h))
+		if h == "labels" {
+			labelCols = append(labelCols, i)
+			continue
+		}
+		if _, ok := index[h]; !ok {
+			index[h] = i
+		}
+	}
+	if _, ok := index["summary"]; !ok {
+		return nil, fmt.Errorf("missing Summary column")
+	}
+
+	field := func(rec []string, name string) string {
+		i, ok := index[name]
+		if !ok || i >= len(rec) {
+			return ""
+		}
+		return strings.TrimSpace(rec[i])
+	}
+
+	var drafts []CardDraft
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		d := CardDraft{
+			Title:       jiraTitle(field(rec, "issue key"), field(rec, "summary")),
+			Description: field(rec, "description"),
+			ColumnID:    jiraColumnFor(field(rec, "status"), columns),
+			Assignee:    field(rec, "assignee"),
+			Priority:    field(rec, "priority"),
+		}
+		for _, i := range labelCols {
+			if i < len(rec) && strings.TrimSpace(rec[i]) != "" {
+				d.Labels = append(d.Labels, strings.TrimSpace(rec[i]))
+			}
+		}
+		drafts = append(drafts, d)
+	}
+	return drafts, nil
+}
+
+func jiraTitle(key, summary string) string {
+	if key == "" {
+		return summary
+	}
+	return key + ": " + summary
+}
+
+// handleImportJira imports a Jira CSV or JSON export. The format is taken
+// from the "format" parameter or, failing that, the Content-Type. With
+// dryRun=true the parsed cards are reported without touching the board.
+func handleImportJira(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dryRun"))
+
+		data, err := s.readUpload(w, r)
+		if errors.Is(err, ErrUploadTooLarge) {
+			writeMutationError(w, err)
+			return
+		}
+		if err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			if strings.Contains(r.Header.Get("Content-Type"), "csv") {
+				format = "csv"
+			} else {
+				format = "json"
+			}
+		}
+
+		columns := s.GetBoard().Board.Columns
+		var drafts []CardDraft
+		switch format {
+		case "csv":
+			drafts, err = parseJiraCSV(bytes.NewReader(data), columns)
+		case "json":
+			drafts, err = parseJiraJSON(data, columns)
+		default:
+			err = fmt.Errorf("unsupported format %q", format)
+		}
+		if err != nil {
+			writeError(w, "invalid Jira export: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		state := s.GetBoard()
+		for _, d := range drafts {
+			if err := checkColumnAccess(state, currentUser(r), d.ColumnID); err != nil {
+				writeMutationError(w, err)
+				return
+			}
+		}
+
+		result := ImportResult{DryRun: dryRun, Cards: drafts}
+		if !dryRun {
+			ids, err := s.ImportCards(drafts)
+			if err != nil {
+				writeMutationError(w, err)
+				return
+			}
+			result.Created = ids
+			log.Printf("Imported %d cards from Jira", len(ids))
+			s.Audit(r, AuditImport, fmt.Sprintf("jira %s: %d cards", format, len(ids)))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}
+}
@@ -236,6 +236,28 @@ func TestIntegration_TextSync(t *testing.T) {
 	})
 }
 
+func TestIntegration_TitleSync(t *testing.T) {
+	env := setupIntegration(t)
+	defer env.teardown()
+
+	page1, page2 := setupPages(t, env)
+
+	// Titles stream while typed, before the edit ends.
+	title := page1.Locator(".card-title").First()
+	title.Click()
+	title.Press("End")
+	title.Type(" (renamed)")
+
+	waitForCondition(t, "User 2 to see the title", func() bool {
+		titles2, _ := page2.QuerySelectorAll(".card-title")
+		if len(titles2) == 0 {
+			return false
+		}
+		text, _ := titles2[0].TextContent()
+		return strings.HasSuffix(text, " (renamed)")
+	})
+}
+
 func TestIntegration_DeleteCard(t *testing.T) {
 	env := setupIntegration(t)
 	defer env.teardown()
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brunoga/deep/v5/crdt"
+)
+
+// The stored state is verified when a board is opened: it must decode, keep
+// the invariants of the board (no two items of a keyed list share a key,
+// every card is in a column) and be at least as recent as the last patch
+// the journal says it reflects. A state that fails is not served. The board
+// is rebuilt instead from the last good state, a copy saved whenever the
+// board opens cleanly, by replaying the patches journaled since. Edits that
+// arrived by full merges, or whose patches were pruned or cleared from the
+// history, are missing from a rebuilt board until peers sync them back.
+// GET /api/admin/integrity reports how the board was last opened.
+
+// Integrity is how the stored state of a board held up when it was opened.
+type Integrity struct {
+	CheckedAt int64    `json:"checkedAt"`          // unix seconds
+	Problems  []string `json:"problems,omitempty"` // found in the stored state
+	Recovered bool     `json:"recovered"`          // rebuilt from the last good state
+	Replayed  int      `json:"replayed,omitempty"` // patches replayed to rebuild it
+	// Unresolved lists what is still wrong with the board served, when the
+	// rebuilt state was no better.
+	Unresolved []string `json:"unresolved,omitempty"`
+}
+
+// Integrity returns how the stored state held up when the board was opened.
+func (s *Store) Integrity() Integrity {
+	return s.integrity
+}
+
+// loadState loads the stored state data into s, verifying it and rebuilding
+// the board if it fails. A state of an older model is migrated first, and
+// one of a newer model refused: this node would drop what it cannot read.
+func (s *Store) loadState(data []byte) error {
+	version, _ := payloadVersion(data)
+	data, err := upgradePayload(payloadState, data)
+	if err != nil {
+		return err
+	}
+	migrated := version != modelVersion
+	if migrated {
+		log.Printf("Migrated the stored state from model version %d to %d", version, modelVersion)
+	}
+	c, problems := s.verifyState(data)
+	s.integrity = Integrity{CheckedAt: time.Now().Unix(), Problems: problems}
+	if len(problems) == 0 {
+		s.crdt = c
+		if migrated {
+			return s.saveState()
+		}
+		s.publish(data)
+		return nil
+	}
+	log.Printf("Integrity: stored state failed verification: %s", strings.Join(problems, "; "))
+
+	rebuilt, pos, replayed, err := s.rebuildState()
+	if err != nil && c == nil {
+		return err
+	}
+	var unresolved []string
+	if err != nil {
+		log.Printf("Integrity: failed to rebuild the state: %v", err)
+	} else if unresolved = checkInvariants(rebuilt.View()); len(unresolved) > 0 {
+		log.Printf("Integrity: rebuilt state failed verification: %s", strings.Join(unresolved, "; "))
+	}
+	if (err != nil || len(unresolved) > 0) && c != nil {
+		// The rebuilt state is no better, and the stored one at least
+		// decodes: keep it, as the latest the node had.
+		log.Printf("Integrity: keeping the stored state")
+		s.integrity.Unresolved = problems
+		s.crdt = c
+		s.publish(data)
+		return nil
+	}
+	s.integrity.Recovered, s.integrity.Replayed, s.integrity.Unresolved = true, replayed, unresolved
+	s.crdt = rebuilt
+	if err := s.saveState(); err != nil {
+		return err
+	}
+	if _, err := s.db.Exec("INSERT OR REPLACE INTO state (id, data) VALUES ('journal', ?)", strconv.FormatInt(pos, 10)); err != nil {
+		return err
+	}
+	log.Printf("Integrity: rebuilt the board from the last good state and %d patch(es)", replayed)
+	return nil
+}
+
+// verifyState decodes the stored state data and returns it with what is
+// wrong with it. The state is nil if it does not decode.
+func (s *Store) verifyState(data []byte) (*crdt.CRDT[BoardState], []string) {
+	c := crdt.NewCRDT(BoardState{}, s.nodeID)
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, []string{fmt.Sprintf("state does not decode: %v", err)}
+	}
+	problems := checkInvariants(c.View())
+
+	var pos, timestamp string
+	err := s.db.QueryRow("SELECT data FROM state WHERE id = 'journal'").Scan(&pos)
+	if err == nil {
+		err = s.db.QueryRow("SELECT timestamp FROM patches WHERE id = ?", pos).Scan(&timestamp)
+	}
+	if err != nil {
+		return c, problems // no journal yet, or its patch was pruned
+	}
+	last, ok := parseHLC(timestamp)
+	latest := c.Clock().Latest
+	if ok && (latest.WallTime < last.wall || latest.WallTime == last.wall && int64(latest.Logical) < last.logical) {
+		problems = append(problems, fmt.Sprintf("state clock %s is behind its last patch %s", latest, timestamp))
+	}
+	return c, problems
+}
+
+// checkInvariants returns what is wrong with bs.
+func checkInvariants(bs BoardState) []string {
+	b := bs.Board
+	var problems []string
+	problems = append(problems, duplicateKeys("column", b.Columns, func(c Column) string { return c.ID })...)
+	problems = append(problems, duplicateKeys("template", b.Templates, func(t CardTemplate) string { return t.Name })...)
+	problems = append(problems, duplicateKeys("sprint", b.Sprints, func(sp Sprint) string { return sp.ID })...)
+	problems = append(problems, duplicateKeys("view", b.Views, func(v BoardView) string { return v.ID })...)
+	problems = append(problems, duplicateKeys("bot", b.Bots, func(bot Bot) string { return bot.ID })...)
+	problems = append(problems, duplicateKeys("embed", b.Embeds, func(e Embed) string { return e.ID })...)
+	problems = append(problems, duplicateKeys("color", b.Colors, func(c UserColor) string { return c.Name })...)
+	problems = append(problems, duplicateKeys("board", b.Boards, func(r BoardRef) string { return r.Name })...)
+	problems = append(problems, duplicateKeys("user", b.Users, func(u BoardUser) string { return u.ID })...)
+	problems = append(problems, duplicateKeys("node connection", bs.NodeConnections, func(n NodeConnection) string { return n.NodeID })...)
+	for _, key := range slices.Sorted(maps.Keys(b.Cards)) {
+		card := b.Cards[key]
+		if card.ID != key {
+			problems = append(problems, fmt.Sprintf("card %q stored as %q", card.ID, key))
+		}
+		if columnIndex(b, card.ColumnID) < 0 {
+			problems = append(problems, fmt.Sprintf("card %q in unknown column %q", key, card.ColumnID))
+		}
+		problems = append(problems, duplicateKeys("comment", card.Comments, func(c Comment) string { return c.ID })...)
+		problems = append(problems, duplicateKeys("vote", card.Votes, func(v Vote) string { return v.Voter })...)
+		problems = append(problems, duplicateKeys("checklist item", card.Checklist, func(it ChecklistItem) string { return it.ID })...)
+	}
+	return problems
+}
+
+// duplicateKeys reports the keys shared by items of a keyed list of what.
+func duplicateKeys[T any](what string, items []T, key func(T) string) []string {
+	var problems []string
+	seen := map[string]bool{}
+	for _, item := range items {
+		k := key(item)
+		if seen[k] {
+			problems = append(problems, fmt.Sprintf("duplicate %s %q", what, k))
+		}
+		seen[k] = true
+	}
+	return problems
+}
+
+// rebuildState replays the patches journaled since the last good state onto
+// it, or onto the seed if the board never opened cleanly, and returns the
+// result with the position of the last patch replayed.
+func (s *Store) rebuildState() (c *crdt.CRDT[BoardState], pos int64, replayed int, err error) {
+	var data, posData []byte
+	err = s.db.QueryRow("SELECT data FROM state WHERE id = 'good'").Scan(&data)
+	if err == nil {
+		err = s.db.QueryRow("SELECT data FROM state WHERE id = 'goodJournal'").Scan(&posData)
+	}
+	switch {
+	case err == sql.ErrNoRows:
+		log.Printf("Integrity: no good state saved, rebuilding from the seed")
+		c = crdt.NewCRDT(s.seed.State, s.nodeID)
+	case err != nil:
+		return nil, 0, 0, err
+	default:
+		if data, err = upgradePayload(payloadState, data); err != nil {
+			return nil, 0, 0, fmt.Errorf("good state: %w", err)
+		}
+		c = crdt.NewCRDT(BoardState{}, s.nodeID)
+		if err := json.Unmarshal(data, c); err != nil {
+			return nil, 0, 0, fmt.Errorf("good state does not decode: %w", err)
+		}
+		if pos, err = strconv.ParseInt(string(posData), 10, 64); err != nil {
+			return nil, 0, 0, err
+		}
+	}
+	var first int64
+	if err := s.db.QueryRow("SELECT COALESCE(MIN(id), 0) FROM patches WHERE id > ?", pos).Scan(&first); err != nil {
+		return nil, 0, 0, err
+	}
+	if first > pos+1 {
+		log.Printf("Integrity: patches %d to %d are gone from the history, their edits are lost until peers sync", pos+1, first-1)
+	}
+	pos, replayed, err = s.replayPatches(c, pos)
+	return c, pos, replayed, err
+}
+
+// markGood saves the state as the last good one, along with the journal
+// position it reflects.
+func (s *Store) markGood() error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("INSERT OR REPLACE INTO state (id, data) VALUES ('good', ?)", s.snap.Load().crdtJSON); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`INSERT OR REPLACE INTO state (id, data)
+		VALUES ('goodJournal', (SELECT data FROM state WHERE id = 'journal'))`); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// handleIntegrity serves GET /api/admin/integrity, how the stored state held
+// up when the board was opened.
+func handleIntegrity(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.Integrity())
+	}
+}
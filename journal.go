@@ -0,0 +1,134 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/brunoga/deep/v5/crdt"
+)
+
+// The patches table doubles as a write-ahead journal of local and remote
+// edits. The "journal" row of the state table records the last patch that
+// is reflected in the saved state; both are written in one transaction.
+
+// commitChange persists an edit atomically: the new state, its history entry
+// and the journal position. The entry is written by summarize, given the new
+// board, and classified by the paths the patch changed (see patchkind.go).
+// The new state is published only once the transaction commits, so readers
+// never see an edit whose history is not written yet. If writing fails,
+// nothing is published and the error is returned for the caller to undo the
+// edit. It must be called with s.mu held.
+func (s *Store) commitChange(timestamp string, patchData []byte, summarize func(after Board) string, actor string, events []Event) error {
+	data, _ := json.Marshal(s.crdt)
+	data = stampModel(data)
+	next := s.snapshotOf(data)
+	summary := summarize(next.state.Board)
+	kind := patchKind(parseDeltaPaths(patchData))
+
+	log.Printf("Saving patch: %s", summary)
+	s.histMu.Lock()
+	defer s.histMu.Unlock()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("INSERT INTO patches (timestamp, patch, summary, actor, kind) VALUES (?, ?, ?, ?, ?)",
+		timestamp, patchData, summary, actor, kind)
+	if err != nil {
+		return fmt.Errorf("save patch: %w", err)
+	}
+	id, _ := res.LastInsertId()
+	if _, err := tx.Exec("INSERT OR REPLACE INTO state (id, data) VALUES ('latest', ?)", data); err != nil {
+		return fmt.Errorf("save state: %w", err)
+	}
+	if _, err := tx.Exec("INSERT OR REPLACE INTO state (id, data) VALUES ('journal', ?)", strconv.FormatInt(id, 10)); err != nil {
+		return fmt.Errorf("save journal position: %w", err)
+	}
+	if err := s.pruneHistory(tx); err != nil {
+		return fmt.Errorf("prune history: %w", err)
+	}
+	if err := recordChanges(tx, events); err != nil {
+		return fmt.Errorf("record changes: %w", err)
+	}
+	queued, err := s.queueEvents(tx, events, next.state.Board)
+	if err != nil {
+		return fmt.Errorf("queue events: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit change: %w", err)
+	}
+	s.snap.Store(next)
+	if queued {
+		s.kickOutbox()
+	}
+	return nil
+}
+
+// recoverJournal replays journaled patches that are missing from the saved
+// state, which happens if a previous run died between the two writes (or
+// predates the transactional journal). Replaying is safe: applying a delta
+// the CRDT already has is a no-op.
+func (s *Store) recoverJournal() error {
+	var pos int64
+	var data []byte
+	err := s.db.QueryRow("SELECT data FROM state WHERE id = 'journal'").Scan(&data)
+	switch {
+	case err == sql.ErrNoRows:
+		// First run with a journal: trust the state as is.
+		_, err = s.db.Exec(`INSERT OR REPLACE INTO state (id, data)
+			VALUES ('journal', CAST((SELECT COALESCE(MAX(id), 0) FROM patches) AS TEXT))`)
+		return err
+	case err != nil:
+		return err
+	}
+	if pos, err = strconv.ParseInt(string(data), 10, 64); err != nil {
+		return err
+	}
+	pos, replayed, err := s.replayPatches(s.crdt, pos)
+	if err != nil || replayed == 0 {
+		return err
+	}
+
+	log.Printf("Journal: state was behind history, replayed %d patch(es)", replayed)
+	if err := s.saveState(); err != nil {
+		return err
+	}
+	_, err = s.db.Exec("INSERT OR REPLACE INTO state (id, data) VALUES ('journal', ?)", strconv.FormatInt(pos, 10))
+	return err
+}
+
+// replayPatches applies the journaled patches after position after to c and
+// returns the position of the last one, and how many there were.
+func (s *Store) replayPatches(c *crdt.CRDT[BoardState], after int64) (pos int64, replayed int, err error) {
+	pos = after
+	rows, err := s.db.Query("SELECT id, patch FROM patches WHERE id > ? ORDER BY id ASC", after)
+	if err != nil {
+		return pos, 0, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var patch []byte
+		if err := rows.Scan(&pos, &patch); err != nil {
+			return pos, replayed, err
+		}
+		if patch, err = upgradePayload(payloadDelta, patch); err != nil {
+			return pos, replayed, fmt.Errorf("patch %d: %w", pos, err)
+		}
+		var delta crdt.Delta[BoardState]
+		if err := json.Unmarshal(patch, &delta); err != nil {
+			log.Printf("Journal: skipping unreadable patch %d: %v", pos, err)
+			continue
+		}
+		c.ApplyDelta(delta)
+		s.lastModified = max(s.lastModified, delta.Timestamp.WallTime)
+		replayed++
+	}
+	return pos, replayed, rows.Err()
+}
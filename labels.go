@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"maps"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Cards carry labels, such as "bug". The board defines labels with a color,
+// shown on the chips of the cards that have them; cards may also have
+// labels it does not define, such as imported ones, shown in gray. A card's
+// labels merge as a set: labels attached or detached on different nodes at
+// once all take effect. /api/labels manages the definitions,
+// /api/cards/labels attaches and detaches labels, and /api/cards?label=bug
+// lists the cards with one.
+
+var (
+	// ErrBadLabel is returned for a label definition without a name or
+	// with a color that is not #rgb or #rrggbb.
+	ErrBadLabel = errors.New("a label needs a name and a color as #rgb or #rrggbb")
+	// ErrLabelNotFound is returned for a label the board does not define.
+	ErrLabelNotFound = errors.New("label not found")
+)
+
+// labelColorPattern matches the colors labels may have.
+var labelColorPattern = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+// Label is a label the board defines.
+type Label struct {
+	Name  string `deep:"key" json:"name"`
+	Color string `json:"color"`
+}
+
+// LabelSet is the labels of a card. It is encoded as a list of names, in
+// order. Cards start with an empty set, not nil: labels attached at once on
+// two nodes to a nil set would each replace it, and only one would stay.
+type LabelSet map[string]bool
+
+// cleanLabel returns name as a label: trimmed, and without the "/" and "~"
+// that would address something else in a patch path.
+func cleanLabel(name string) string {
+	return strings.NewReplacer("/", "-", "~", "-").Replace(strings.TrimSpace(name))
+}
+
+// labelSetOf returns the set of the given labels, cleaned, leaving out empty
+// ones.
+func labelSetOf(names ...string) LabelSet {
+	set := LabelSet{}
+	for _, name := range names {
+		if name = cleanLabel(name); name != "" {
+			set[name] = true
+		}
+	}
+	return set
+}
+
+// Names returns the labels in l, in order.
+func (l LabelSet) Names() []string {
+	return slices.Sorted(maps.Keys(l))
+}
+
+// with returns l with label attached, or detached if attach is false,
+// leaving l as is.
+func (l LabelSet) with(label string, attach bool) LabelSet {
+	set := maps.Clone(l)
+	if set == nil {
+		set = LabelSet{}
+	}
+	if attach {
+		set[label] = true
+	} else {
+		delete(set, label)
+	}
+	return set
+}
+
+func (l LabelSet) MarshalJSON() ([]byte, error) {
+	names := l.Names()
+	if names == nil {
+		names = []string{}
+	}
+	return json.Marshal(names)
+}
+
+func (l *LabelSet) UnmarshalJSON(data []byte) error {
+	var names []string
+	if err := json.Unmarshal(data, &names); err != nil {
+		return err
+	}
+	*l = labelSetOf(names...)
+	return nil
+}
+
+// DefineLabel defines label name with color, or changes its color.
+func (s *Store) DefineLabel(name, color string) error {
+	name = cleanLabel(name)
+	if name == "" || !labelColorPattern.MatchString(color) {
+		return ErrBadLabel
+	}
+	return s.mutate(func(bs *BoardState) {
+		if i := slices.IndexFunc(bs.Board.Labels, func(l Label) bool { return l.Name == name }); i >= 0 {
+			bs.Board.Labels[i].Color = color
+			return
+		}
+		bs.Board.Labels = append(bs.Board.Labels, Label{Name: name, Color: color})
+	})
+}
+
+// DeleteLabel deletes the definition of label name. Cards keep the label.
+func (s *Store) DeleteLabel(name string) error {
+	return s.tryMutate(func(bs *BoardState) error {
+		i := slices.IndexFunc(bs.Board.Labels, func(l Label) bool { return l.Name == name })
+		if i < 0 {
+			return ErrLabelNotFound
+		}
+		bs.Board.Labels = slices.Delete(bs.Board.Labels, i, i+1)
+		return nil
+	})
+}
+
+// LabelCard attaches label to the card, or detaches it if attach is false.
+func (s *Store) LabelCard(cardID, label string, attach bool) error {
+	label = cleanLabel(label)
+	if label == "" {
+		return ErrBadLabel
+	}
+	return s.tryMutate(func(bs *BoardState) error {
+		card, ok := bs.Board.Cards[cardID]
+		if !ok {
+			return ErrCardNotFound
+		}
+		if card.Labels[label] == attach {
+			return nil
+		}
+		card.Labels = card.Labels.with(label, attach)
+		bs.Board.Cards[cardID] = card
+		if attach {
+			s.stage(Event{Type: EventCardLabeled, CardID: cardID, Label: label})
+		}
+		return nil
+	})
+}
+
+// handleLabels serves /api/labels: GET lists the labels the board defines,
+// POST {"name": "bug", "color": "#e74c3c"} defines one or changes its color,
+// and DELETE ?name=bug deletes one.
+func handleLabels(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("Cache-Control", "no-store")
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(s.GetBoard().Board.Labels)
+			return
+		case http.MethodPost, http.MethodDelete:
+		default:
+			writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !canEditCards(w, r) {
+			return
+		}
+		var err error
+		if r.Method == http.MethodDelete {
+			err = s.DeleteLabel(r.URL.Query().Get("name"))
+		} else {
+			var label Label
+			if err := json.NewDecoder(r.Body).Decode(&label); err != nil {
+				writeError(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			err = s.DefineLabel(label.Name, label.Color)
+		}
+		if err != nil {
+			writeMutationError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// handleCardLabels attaches a label to a card, POST /api/cards/labels with
+// {"cardId": "...", "label": "bug"}, or detaches it with "op": "detach".
+func handleCardLabels(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req struct {
+			CardID string `json:"cardId"`
+			Label  string `json:"label"`
+			Op     string `json:"op"` // "attach" by default, or "detach"
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Op != "" && req.Op != "attach" && req.Op != "detach" {
+			writeError(w, "op must be attach or detach", http.StatusBadRequest)
+			return
+		}
+		if err := s.LabelCard(req.CardID, req.Label, req.Op != "detach"); err != nil {
+			writeMutationError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+const maxUserSearchResults = 20
+
+// LDAPConfig configures the directory used for user lookup and, optionally,
+// bind authentication. Filters use %s for the (escaped) user input.
+type LDAPConfig struct {
+	URL          string `json:"url"` // ldap://host:389 or ldaps://host:636
+	BindDN       string `json:"bindDN"`
+	BindPassword string `json:"bindPassword"`
+	BaseDN       string `json:"baseDN"`
+	UserFilter   string `json:"userFilter"`   // default "(uid=%s)"
+	SearchFilter string `json:"searchFilter"` // default "(|(uid=%s*)(cn=%s*)(mail=%s*))"
+
+	UsernameAttr string `json:"usernameAttr"` // default "uid"
+	NameAttr     string `json:"nameAttr"`     // default "cn"
+	EmailAttr    string `json:"emailAttr"`    // default "mail"
+	GroupAttr    string `json:"groupAttr"`    // default "memberOf"
+
+	// EnableBind allows users to log in with their directory password.
+	EnableBind bool `json:"enableBind"`
+	// Roles maps group DNs to roles for LDAP logins.
+	Roles       map[string]string `json:"roles"`
+	DefaultRole string            `json:"defaultRole"`
+}
+
+// DirectoryUser is a user as returned by the search API.
+type DirectoryUser struct {
+	Username string `json:"username"`
+	Name     string `json:"name"`
+	Email    string `json:"email"`
+}
+
+// LDAPDirectory performs lookups against an LDAP server. A new connection is
+// made per operation; lookups are infrequent and this keeps failure handling
+// trivial.
+type LDAPDirectory struct {
+	cfg  LDAPConfig
+	dial func(url string) (ldap.Client, error)
+}
+
+func dialLDAP(url string) (ldap.Client, error) {
+	conn, err := ldap.DialURL(url)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+func newLDAPDirectory(cfg LDAPConfig) (*LDAPDirectory, error) {
+	if cfg.URL == "" || cfg.BaseDN == "" {
+		return nil, fmt.Errorf("ldap: url and baseDN are required")
+	}
+	if cfg.UserFilter == "" {
+		cfg.UserFilter = "(uid=%s)"
+	}
+	if cfg.SearchFilter == "" {
+		cfg.SearchFilter = "(|(uid=%s*)(cn=%s*)(mail=%s*))"
+	}
+	if cfg.UsernameAttr == "" {
+		cfg.UsernameAttr = "uid"
+	}
+	if cfg.NameAttr == "" {
+		cfg.NameAttr = "cn"
+	}
+	if cfg.EmailAttr == "" {
+		cfg.EmailAttr = "mail"
+	}
+	if cfg.GroupAttr == "" {
+		cfg.GroupAttr = "memberOf"
+	}
+	if cfg.DefaultRole == "" {
+		cfg.DefaultRole = RoleViewer
+	}
+	for group, role := range cfg.Roles {
+		if roleRank[role] == 0 {
+			return nil, fmt.Errorf("ldap: invalid role %q for group %q", role, group)
+		}
+	}
+	return &LDAPDirectory{cfg: cfg, dial: dialLDAP}, nil
+}
+
+// connect dials the server and binds with the service account, if any.
+func (d *LDAPDirectory) connect() (ldap.Client, error) {
+	conn, err := d.dial(d.cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+	if d.cfg.BindDN != "" {
+		if err := conn.Bind(d.cfg.BindDN, d.cfg.BindPassword); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// filter expands every %s in tmpl with the escaped value.
+func (d *LDAPDirectory) filter(tmpl, value string) string {
+	return strings.ReplaceAll(tmpl, "%s", ldap.EscapeFilter(value))
+}
+
+func (d *LDAPDirectory) search(conn ldap.Client, filter string, limit int) ([]*ldap.Entry, error) {
+	req := ldap.NewSearchRequest(
+		d.cfg.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, limit, 10, false,
+		filter,
+		[]string{d.cfg.UsernameAttr, d.cfg.NameAttr, d.cfg.EmailAttr, d.cfg.GroupAttr},
+		nil,
+	)
+	res, err := conn.Search(req)
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil, err
+	}
+	if res == nil {
+		return nil, nil
+	}
+	return res.Entries, nil
+}
+
+// Search returns users whose username, name or email starts with query.
+func (d *LDAPDirectory) Search(query string) ([]DirectoryUser, error) {
+	conn, err := d.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	entries, err := d.search(conn, d.filter(d.cfg.SearchFilter, query), maxUserSearchResults)
+	if err != nil {
+		return nil, err
+	}
+	users := make([]DirectoryUser, 0, len(entries))
+	for _, e := range entries {
+		users = append(users, DirectoryUser{
+			Username: e.GetAttributeValue(d.cfg.UsernameAttr),
+			Name:     e.GetAttributeValue(d.cfg.NameAttr),
+			Email:    e.GetAttributeValue(d.cfg.EmailAttr),
+		})
+	}
+	return users, nil
+}
+
+// Authenticate resolves username to a DN with the service account, then
+// verifies the password by binding as that DN.
+func (d *LDAPDirectory) Authenticate(username, password string) (*User, error) {
+	if username == "" || password == "" {
+		// An empty password would be an unauthenticated bind, which many
+		// servers accept.
+		return nil, fmt.Errorf("missing credentials")
+	}
+	conn, err := d.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	entries, err := d.search(conn, d.filter(d.cfg.UserFilter, username), 2)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) != 1 {
+		return nil, fmt.Errorf("user lookup returned %d entries", len(entries))
+	}
+	entry := entries[0]
+	if err := conn.Bind(entry.DN, password); err != nil {
+		return nil, err
+	}
+
+	groups := entry.GetAttributeValues(d.cfg.GroupAttr)
+	user := &User{
+		ID:     entry.DN,
+		Name:   entry.GetAttributeValue(d.cfg.NameAttr),
+		Email:  entry.GetAttributeValue(d.cfg.EmailAttr),
+		Groups: groups,
+		Role:   d.cfg.DefaultRole,
+	}
+	if user.Name == "" {
+		user.Name = username
+	}
+	for _, g := range groups {
+		if r, ok := d.cfg.Roles[g]; ok && roleRank[r] > roleRank[user.Role] {
+			user.Role = r
+		}
+	}
+	return user, nil
+}
+
+// handleUserSearch backs assignee and @mention autocompletion:
+// GET /api/users/search?q=ali.
+func handleUserSearch(d *LDAPDirectory) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := strings.TrimPrefix(strings.TrimSpace(r.URL.Query().Get("q")), "@")
+		users := []DirectoryUser{}
+		if d != nil && q != "" {
+			var err error
+			users, err = d.Search(q)
+			if err != nil {
+				writeError(w, "directory lookup failed: "+err.Error(), http.StatusBadGateway)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(users)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// JSON list endpoints share their query parameters:
+//
+//	limit   items per page, up to the endpoint's maximum
+//	cursor  where to continue, from the previous page's nextCursor
+//	sort    a sort key, "-" first for descending, e.g. sort=-votes
+//	fields  a comma-separated subset of the item fields to return
+//
+// and their response: {"items": [...], "nextCursor": "..."}, without
+// nextCursor on the last page. Cursors are opaque to clients.
+
+// ErrBadQuery is returned for invalid list parameters.
+var ErrBadQuery = errors.New("invalid list parameters")
+
+// ListParams are the parsed list parameters of a request.
+type ListParams struct {
+	Limit  int
+	Cursor string
+	Sort   string // a sort key of the endpoint
+	Desc   bool
+	Fields []string // JSON names of the fields to return, nil for all
+}
+
+// listSpec describes what a list endpoint accepts. Orders maps its sort
+// keys to how they compare items, ascending.
+type listSpec[T any] struct {
+	DefaultLimit int
+	MaxLimit     int
+	DefaultSort  string // with "-" for descending
+	Orders       map[string]func(a, b T) int
+}
+
+// parse reads the list parameters of r.
+func (spec listSpec[T]) parse(r *http.Request) (ListParams, error) {
+	q := r.URL.Query()
+	p := ListParams{Limit: spec.DefaultLimit, Cursor: q.Get("cursor")}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return p, fmt.Errorf("%w: invalid limit %q", ErrBadQuery, v)
+		}
+		p.Limit = min(n, spec.MaxLimit)
+	}
+	sort := q.Get("sort")
+	if sort == "" {
+		sort = spec.DefaultSort
+	}
+	p.Sort, p.Desc = strings.CutPrefix(sort, "-")
+	if _, ok := spec.Orders[p.Sort]; !ok {
+		return p, fmt.Errorf("%w: cannot sort by %q; use one of %s", ErrBadQuery, p.Sort, strings.Join(slices.Sorted(maps.Keys(spec.Orders)), ", "))
+	}
+	if v := q.Get("fields"); v != "" {
+		known := jsonFields(reflect.TypeFor[T]())
+		for _, f := range strings.Split(v, ",") {
+			if f = strings.TrimSpace(f); !slices.Contains(known, f) {
+				return p, fmt.Errorf("%w: unknown field %q", ErrBadQuery, f)
+			}
+			p.Fields = append(p.Fields, f)
+		}
+	}
+	return p, nil
+}
+
+// jsonFields returns the JSON names of the fields of struct type t.
+func jsonFields(t reflect.Type) []string {
+	var names []string
+	for i := range t.NumField() {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		switch {
+		case name == "-" || !f.IsExported():
+		case name == "":
+			names = append(names, f.Name)
+		default:
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Page is one page of a list.
+type Page[T any] struct {
+	Items      []T    `json:"items"`
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+// page sorts items by p and returns the page p asks for. Its cursors are
+// offsets into the sorted list.
+func (spec listSpec[T]) page(items []T, p ListParams) (Page[T], error) {
+	offset := 0
+	if p.Cursor != "" {
+		n, err := strconv.Atoi(p.Cursor)
+		if err != nil || n < 0 {
+			return Page[T]{}, fmt.Errorf("%w: invalid cursor", ErrBadQuery)
+		}
+		offset = min(n, len(items))
+	}
+	order := spec.Orders[p.Sort]
+	slices.SortStableFunc(items, func(a, b T) int {
+		if p.Desc {
+			return order(b, a)
+		}
+		return order(a, b)
+	})
+	end := min(offset+p.Limit, len(items))
+	page := Page[T]{Items: items[offset:end]}
+	if end < len(items) {
+		page.NextCursor = strconv.Itoa(end)
+	}
+	return page, nil
+}
+
+// writePage replies with page, its items cut down to the fields p asks for.
+func writePage[T any](w http.ResponseWriter, page Page[T], p ListParams) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	if page.Items == nil {
+		page.Items = []T{}
+	}
+	if p.Fields == nil {
+		json.NewEncoder(w).Encode(page)
+		return
+	}
+	items := make([]map[string]json.RawMessage, len(page.Items))
+	for i, item := range page.Items {
+		data, err := json.Marshal(item)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		var all map[string]json.RawMessage
+		json.Unmarshal(data, &all)
+		items[i] = make(map[string]json.RawMessage, len(p.Fields))
+		for _, f := range p.Fields {
+			if v, ok := all[f]; ok {
+				items[i][f] = v
+			}
+		}
+	}
+	json.NewEncoder(w).Encode(Page[map[string]json.RawMessage]{Items: items, NextCursor: page.NextCursor})
+}
@@ -1,15 +1,21 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"html/template"
+	"io"
 	"log"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/brunoga/deep/v5/crdt"
@@ -23,6 +29,36 @@ var (
 	peers         = flag.String("peers", "", "comma-separated list of peer addresses")
 	nodeID        = flag.String("node-id", uuid.New().String(), "unique identifier for this node")
 	nodeIDFromEnv = flag.Bool("node-id-from-env", false, "use NODE_ID_ENV environment variable for node ID")
+	configPath    = flag.String("config", "", "path to a JSON config file (LDAP, ...)")
+	seedPath      = flag.String("seed", "", "path to a YAML board definition used to initialize an empty database")
+	tenants       = flag.String("tenants", "", "comma-separated tenant names; each gets an isolated board at /t/<name>/")
+	tenantDomain  = flag.String("tenant-domain", "", "also route <tenant>.<domain> host names to tenant boards")
+	readOnly      = flag.Bool("read-only-replica", false, "merge state from peers but reject all local changes")
+	transport     = flag.String("transport", TransportHTTP, "peer replication transport: http, websocket or grpc")
+	relay         = flag.String("relay", "", "address of a relay node; for nodes that cannot accept inbound connections")
+	announceAddr  = flag.String("announce-addr", "", "host:port other nodes reach this node at, announced on the board so they sync with it; $VARS are expanded, e.g. ${HOSTNAME}:8080")
+	unfurlAllow   = flag.String("unfurl-allow", "", "comma-separated hosts to show link previews from, e.g. github.com,*.example.com")
+	wsRecord      = flag.String("ws-record", "", "append every WebSocket message from browsers to this file, for \"deepboard replay\"")
+	idleAfter     = flag.Duration("idle-after", defaultIdleAfter, "show users as away after they have done nothing for this long")
+
+	maxCards       = flag.Int("max-cards", 0, "maximum number of cards per board (0 = unlimited)")
+	maxDescription = flag.Int("max-description", 0, "maximum card description size in bytes (0 = unlimited)")
+	maxHistory     = flag.Int("max-history", 0, "maximum history rows kept per board (0 = unlimited)")
+	maxBoardSize   = flag.Int("max-board-size", 0, "maximum stored size of a board in bytes (0 = unlimited)")
+	maxUpload      = flag.Int("max-upload", 10<<20, "maximum size of an uploaded file, such as an import, in bytes (0 = unlimited)")
+	rateLimit      = flag.Int("rate-limit", 0, "maximum requests per minute per user, or per address without login (0 = unlimited)")
+	botRateLimit   = flag.Int("bot-rate-limit", 0, "maximum requests per minute per bot token (0 = unlimited)")
+
+	oidcIssuer       = flag.String("oidc-issuer", "", "OpenID Connect issuer URL; enables single sign-on")
+	oidcClientID     = flag.String("oidc-client-id", "", "OpenID Connect client ID")
+	oidcClientSecret = flag.String("oidc-client-secret", os.Getenv("OIDC_CLIENT_SECRET"), "OpenID Connect client secret (defaults to $OIDC_CLIENT_SECRET)")
+	oidcRedirectURL  = flag.String("oidc-redirect-url", "", "OpenID Connect redirect URL, e.g. https://board.example.com/auth/callback")
+	oidcScopes       = flag.String("oidc-scopes", "openid,profile,email", "comma-separated OpenID Connect scopes")
+	oidcGroupsClaim  = flag.String("oidc-groups-claim", "groups", "userinfo claim holding the user's groups")
+	oidcRoles        = flag.String("oidc-roles", "", "comma-separated group=role mappings (roles: viewer, editor, admin)")
+	oidcDefaultRole  = flag.String("oidc-default-role", RoleViewer, "role for users matching no group mapping")
+
+	peerSecretFlag = flag.String("peer-secret", os.Getenv("PEER_SECRET"), "secret shared by the nodes of the cluster, required to replicate once sign-in is enabled (defaults to $PEER_SECRET)")
 )
 
 var upgrader = websocket.Upgrader{
@@ -43,6 +79,18 @@ const (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "demo" {
+		runDemo(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		runReplay(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "seed" {
+		runSeedFake(os.Args[2:])
+		return
+	}
 	flag.Parse()
 
 	if *nodeIDFromEnv {
@@ -61,44 +109,384 @@ func main() {
 		peerList = strings.Split(*peers, ",")
 	}
 
-	store, err := NewStore(*dbPath, *nodeID, peerList)
+	tenantNames, err := parseTenants(*tenants)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	// Dynamic Peer Discovery if peers look like a single hostname without comma
-	if len(peerList) == 1 && !strings.Contains(peerList[0], ":") {
-		go discoverPeers(store, peerList[0])
+	// Containers are started without a shell to expand variables.
+	*announceAddr = os.ExpandEnv(*announceAddr)
+	if *announceAddr != "" {
+		if _, _, err := net.SplitHostPort(*announceAddr); err != nil {
+			log.Fatalf("Bad -announce-addr: %v", err)
+		}
+		if *relay != "" {
+			log.Fatal("-announce-addr cannot be used with -relay: nodes behind a relay are not reachable")
+		}
+	}
+
+	peerTransport, err := newTransport(*transport)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *relay != "" {
+		peerTransport = newRelayTransport(*relay, peerTransport)
+		peerList = append(peerList, *relay)
+		log.Printf("Joining the cluster through relay %s", *relay)
 	}
 
-	http.HandleFunc("/", handleIndex(store))
-	http.HandleFunc("/ws", handleWS(store))
-	http.HandleFunc("/board", handleBoard(store))
-	http.HandleFunc("/stats", handleStats(store))
-	http.HandleFunc("/history", handleHistory(store))
-	http.HandleFunc("/api/add", handleAdd(store))
-	http.HandleFunc("/api/sync", handleSync(store))
-	http.HandleFunc("/api/state", handleState(store))
-	http.HandleFunc("/api/history/clear", handleClearHistory(store))
-	http.HandleFunc("/api/connections/cleanup", handleCleanupConnections(store))
-	http.HandleFunc("/api/admin/reset", handleReset(store))
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+	summaries, err := parseSummaries(cfg.History)
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+
+	if *unfurlAllow != "" {
+		unfurler = newUnfurler(*unfurlAllow)
+		log.Printf("Link previews enabled for %s", *unfurlAllow)
+	}
+
+	seed := defaultSeed()
+	if *seedPath != "" {
+		if seed, err = loadSeed(*seedPath); err != nil {
+			log.Fatalf("Failed to load seed: %v", err)
+		}
+	}
+
+	store, err := NewSeededStore(*dbPath, *nodeID, peerList, seed)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *readOnly {
+		log.Printf("Running as a read-only replica")
+		store.SetReadOnly(true)
+	}
+	quota := Quota{MaxCards: *maxCards, MaxDescription: *maxDescription, MaxHistory: *maxHistory, MaxBoardSize: *maxBoardSize, MaxUpload: *maxUpload}
+	store.SetQuota(quota)
+	store.SetTransport(peerTransport)
+	store.SetIdleAfter(*idleAfter)
+	store.SetSummaryTemplates(summaries)
+	store.SetFeatures(cfg.Features)
+	store.SetAnnounceAddr(*announceAddr)
+
+	var recording *wsRecording
+	if *wsRecord != "" {
+		if recording, err = openWSRecording(*wsRecord); err != nil {
+			log.Fatalf("Failed to open the WebSocket recording: %v", err)
+		}
+		store.SetWSRecording(recording)
+		log.Printf("Recording WebSocket messages to %s", *wsRecord)
+	}
+
+	if *oidcIssuer != "" {
+		roleMap, err := parseRoleMap(*oidcRoles)
+		if err != nil {
+			log.Fatal(err)
+		}
+		authn, err = newAuthenticator(OIDCConfig{
+			Issuer:       *oidcIssuer,
+			ClientID:     *oidcClientID,
+			ClientSecret: *oidcClientSecret,
+			RedirectURL:  *oidcRedirectURL,
+			Scopes:       strings.Split(*oidcScopes, ","),
+			GroupsClaim:  *oidcGroupsClaim,
+			RoleMap:      roleMap,
+			DefaultRole:  *oidcDefaultRole,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Single sign-on enabled via %s", *oidcIssuer)
+	}
+
+	var directory *LDAPDirectory
+	if cfg.LDAP != nil {
+		directory, err = newLDAPDirectory(*cfg.LDAP)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if cfg.LDAP.EnableBind {
+			if authn == nil {
+				authn = newLDAPAuthenticator(directory)
+			} else {
+				authn.ldap = directory
+			}
+			log.Printf("LDAP login enabled via %s", cfg.LDAP.URL)
+		}
+	}
+
+	peerSecret = *peerSecretFlag
+	if authn != nil && peerSecret == "" {
+		log.Printf("Peer replication is closed: sign-in is enabled but -peer-secret is not set")
+	}
+
+	mux := http.NewServeMux()
+	if authn != nil {
+		authn.audit = store.AuditAs
+		if authn.cfg.Issuer != "" {
+			mux.HandleFunc("/auth/callback", authn.handleCallback)
+		}
+		mux.HandleFunc("/login", authn.handleLogin)
+		mux.HandleFunc("/logout", authn.handleLogout)
+	}
+
+	var events EventPublisher
+	if cfg.Events != nil {
+		events, err = newEventPublisher(*cfg.Events)
+		if err != nil {
+			log.Fatalf("Failed to set up the event stream: %v", err)
+		}
+		store.StreamEvents(events)
+		log.Printf("Streaming board events to %s %s", cfg.Events.Driver, cfg.Events.URL)
+	}
+
+	boards := newBoards(store)
+	router := newTenantRouter(withBots(store, directory), *tenantDomain)
+	// openTenant opens and mounts a tenant, or a board created at runtime.
+	openTenant := func(name string) (*Store, error) {
+		ts, err := NewSeededStore(tenantDBPath(*dbPath, name), *nodeID, peerList, seed)
+		if err != nil {
+			return nil, err
+		}
+		ts.SetBasePath(tenantPathPrefix + name)
+		ts.SetReadOnly(*readOnly)
+		ts.SetQuota(quota)
+		ts.SetTransport(peerTransport)
+		ts.SetIdleAfter(*idleAfter)
+		ts.SetSummaryTemplates(summaries)
+		ts.SetFeatures(cfg.Features)
+		ts.SetAnnounceAddr(*announceAddr)
+		ts.SetWSRecording(recording)
+		if events != nil {
+			ts.StreamEvents(events)
+		}
+		router.Add(name, withBots(ts, directory))
+		startBoard(ts, peerList)
+		return ts, nil
+	}
+	for _, name := range tenantNames {
+		ts, err := openTenant(name)
+		if err != nil {
+			log.Fatalf("Failed to open tenant %s: %v", name, err)
+		}
+		boards.Add(ts)
+		log.Printf("Tenant %s mounted at %s%s/", name, tenantPathPrefix, name)
+	}
+	boards.Serve(openTenant, func(s *Store) {
+		router.Remove(s.BoardKey())
+		if err := s.Close(); err != nil {
+			log.Printf("Failed to close board %s: %v", s.BoardKey(), err)
+		}
+	})
+	mux.HandleFunc("/api/search", withAuth(RoleViewer, handleSearch(boards)))
+	mux.HandleFunc("/ws/{board}", withAuth(RoleViewer, handleBoardWS(boards)))
+	mux.HandleFunc("/home", withAuth(RoleViewer, handleHome(boards)))
+	mux.HandleFunc("/api/home", withAuth(RoleViewer, handleHomeAPI(boards)))
+	mux.HandleFunc("/api/favorites", withAuth(RoleViewer, handleFavorite(boards)))
+	mux.HandleFunc("/api/boards", withAuth(RoleViewer, handleBoards(boards)))
+	mux.HandleFunc("/api/boards/{id}/clone", withAuth(RoleAdmin, handleCloneBoard(boards)))
+	mux.HandleFunc("/b/{board}", withAuth(RoleViewer, handleBoardLink(boards)))
+	mux.HandleFunc("/b/{board}/{path...}", withAuth(RoleViewer, handleBoardLink(boards)))
+	// Like /api/digest, metrics carry no board content and stay
+	// unauthenticated for scrapers.
+	mux.HandleFunc("/metrics", handleMetrics(boards))
+	mux.Handle("/", router)
+	startBoard(store, peerList)
+	go boards.Follow()
 
 	fmt.Printf("DeepBoard starting on http://localhost%s (Node ID: %s)\n", *addr, *nodeID)
 	if len(peerList) > 0 {
 		fmt.Printf("Peers: %v\n", peerList)
+	}
+
+	// Peers using the gRPC transport speak HTTP/2 without TLS on this port.
+	srv := &http.Server{Addr: *addr, Handler: withGRPC(newReplicationServer(boards), withNode(*nodeID, withRequestID(withRateLimit(*rateLimit, *botRateLimit, mux))))}
+	srv.Protocols = new(http.Protocols)
+	srv.Protocols.SetHTTP1(true)
+	srv.Protocols.SetUnencryptedHTTP2(true)
+	go func() {
+		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	<-sig
+	log.Printf("Shutting down")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	srv.Shutdown(ctx)
+	for _, s := range boards.All() {
+		if err := s.Close(); err != nil {
+			log.Printf("Failed to close store: %v", err)
+		}
+	}
+	if events != nil {
+		events.Close()
+	}
+	if recording != nil {
+		recording.Close()
+	}
+}
+
+// startBoard starts the background work of one board: automation rules, the
+// dwell-time evaluator, due date reminders, key and board repair, peer
+// discovery and announcements, periodic sync and digest comparisons.
+func startBoard(store *Store, peerList []string) {
+	startRulesEngine(store)
+	go startDwellEvaluator(store)
+	go startDueReminders(store)
+	go startKeyRepair(store)
+	go startRepair(store)
+	go startAnnouncements(store)
+
+	if rt, ok := store.transport.(*relayTransport); ok {
+		go rt.link(store.basePath).run(store, rt.relay)
+	}
+
+	// Dynamic Peer Discovery if peers look like a single hostname without comma
+	if len(peerList) == 1 && !strings.Contains(peerList[0], ":") {
+		go discoverPeers(store, peerList[0])
+	}
+
+	// Announcing nodes sync with the nodes announced to them even without
+	// -peers.
+	if len(peerList) > 0 || store.announceAddr != "" {
 		go startBackgroundSync(store)
+		go startDigestWatch(store)
 		go startConnectionCleanup(store)
 	}
-	log.Fatal(http.ListenAndServe(*addr, nil))
+}
+
+// newBoardMux registers the routes of one board. Every board (the default one
+// and each tenant) gets its own mux over its own store.
+func newBoardMux(store *Store, directory *LDAPDirectory) *http.ServeMux {
+	mux := http.NewServeMux()
+
+	// Peer replication (/api/sync, /api/state, /api/digest, /api/replicate,
+	// /api/relay) is node-to-node: it needs the peer secret rather than a
+	// sign-in, and is closed without one once sign-in is enabled. The GitHub
+	// webhook carries its own signature, and is refused without a secret.
+	// The static PWA files, which browsers fetch without credentials, and
+	// embeds, whose token is their credential, stay unauthenticated.
+	mux.HandleFunc("/", withAuth(RoleViewer, handleIndex(store)))
+	mux.HandleFunc("/sw.js", handleServiceWorker)
+	mux.HandleFunc("/manifest.webmanifest", handleManifest)
+	mux.HandleFunc("/icon.svg", handleIcon)
+	mux.HandleFunc("/ws", withAuth(RoleViewer, handleWS(store)))
+	mux.HandleFunc("/board", withAuth(RoleViewer, handleBoard(store)))
+	mux.HandleFunc("/api/presence", withAuth(RoleViewer, handlePresence(store)))
+	mux.HandleFunc("/board/column/{id}", withAuth(RoleViewer, handleBoardColumn(store)))
+	mux.HandleFunc("/stats", withAuth(RoleViewer, handleStats(store)))
+	mux.HandleFunc("/wallboard", withAuth(RoleViewer, handleWallboard(store)))
+	mux.HandleFunc("/wallboard.png", withAuth(RoleViewer, handleWallboardPNG(store)))
+	mux.HandleFunc("/embed/{token}", handleEmbed(store))
+	mux.HandleFunc("/badge.svg", handleBadge(store))
+	mux.HandleFunc("/history", withAuth(RoleViewer, handleHistory(store)))
+	mux.HandleFunc("/api/me", withAuth(RoleViewer, handleMe))
+	mux.HandleFunc("/api/ws-schema", handleWSSchema)
+	mux.HandleFunc("/api/users/search", withAuth(RoleViewer, handleUserSearch(directory)))
+	mux.HandleFunc("/api/add", withAuth(RoleEditor, handleAdd(store)))
+	mux.HandleFunc("/api/sync", withPeerAuth(handleSync(store)))
+	mux.HandleFunc("/api/state", withPeerAuth(handleState(store)))
+	mux.HandleFunc("/api/digest", withPeerAuth(handleDigest(store)))
+	mux.HandleFunc("/api/replicate", withPeerAuth(handleReplicate(store)))
+	mux.HandleFunc("/api/relay", withPeerAuth(handleRelay(store)))
+	mux.HandleFunc("/api/import/jira", withAuth(RoleEditor, handleImportJira(store)))
+	mux.HandleFunc("/api/templates", withAuth(RoleViewer, handleTemplates(store)))
+	mux.HandleFunc("/api/cards/from-template", withAuth(RoleEditor, handleCreateFromTemplate(store)))
+	mux.HandleFunc("/api/sprints", withAuth(RoleViewer, handleSprints(store)))
+	mux.HandleFunc("/api/sprints/end", withAuth(RoleEditor, handleEndSprint(store)))
+	mux.HandleFunc("/api/cards/sprint", withAuth(RoleEditor, handleCardSprint(store)))
+	mux.HandleFunc("/api/cards/due", withAuth(RoleViewer, handleCardDue(store)))
+	mux.HandleFunc("/api/cards/labels", withAuth(RoleEditor, handleCardLabels(store)))
+	mux.HandleFunc("/api/labels", withAuth(RoleViewer, handleLabels(store)))
+	mux.HandleFunc("/api/cards/assign", withAuth(RoleEditor, handleCardAssign(store)))
+	mux.HandleFunc("/api/cards/checklist", withAuth(RoleEditor, handleCardChecklist(store)))
+	mux.HandleFunc("/api/users", withAuth(RoleViewer, handleUsers(store)))
+	mux.HandleFunc("/api/export/markdown", withAuth(RoleViewer, handleExportMarkdown(store)))
+	mux.HandleFunc("/api/archive", withAuth(RoleViewer, handleArchiveDownload(store)))
+	mux.HandleFunc("/api/e2e", withAuth(RoleViewer, handleKeyCheck(store)))
+	mux.HandleFunc("/api/features", withAuth(RoleViewer, handleFeatures(store)))
+	mux.HandleFunc("/archive", withAuth(RoleViewer, handleArchiveView(store)))
+	mux.HandleFunc("/api/changes", withAuth(RoleViewer, handleChanges(store)))
+	mux.HandleFunc("/api/seen", withAuth(RoleViewer, handleSeen(store)))
+	mux.HandleFunc("/api/effects", withAuth(RoleViewer, handleEffects(store)))
+	mux.HandleFunc("/api/unfurl", withAuth(RoleViewer, handleUnfurl))
+	mux.HandleFunc("/api/cards/versions", withAuth(RoleViewer, handleDescriptionVersions(store)))
+	mux.HandleFunc("/api/cards/restore", withAuth(RoleEditor, handleRestoreDescription(store)))
+	mux.HandleFunc("/api/cards", withAuth(RoleViewer, handleCards(store)))
+	mux.HandleFunc("/api/cards/{card}", withAuth(RoleViewer, handleCard(store)))
+	mux.HandleFunc("/api/columns/{id}/stream", withAuth(RoleViewer, handleColumnStream(store)))
+	mux.HandleFunc("/api/history", withAuth(RoleViewer, handleHistoryAPI(store)))
+	mux.HandleFunc("/api/cards/links", withAuth(RoleViewer, handleCardLinks(store)))
+	mux.HandleFunc("/api/views", withAuth(RoleViewer, handleViews(store)))
+	mux.HandleFunc("/api/cards/watch", withAuth(RoleViewer, handleWatch(store)))
+	mux.HandleFunc("/api/notifications", withAuth(RoleViewer, handleNotifications(store)))
+	mux.HandleFunc("/api/notifications/read", withAuth(RoleViewer, handleNotificationsRead(store)))
+	mux.HandleFunc("/api/peers", withAuth(RoleViewer, handlePeers(store)))
+	mux.HandleFunc("/api/peers/stats", withAuth(RoleViewer, handlePeerStats(store)))
+	mux.HandleFunc("/api/metrics/heatmap", withAuth(RoleViewer, handleHeatmap(store)))
+	mux.HandleFunc("/api/reconciliations", withAuth(RoleViewer, handleReconciliations(store)))
+	mux.HandleFunc("/api/integrations/github", withAuth(RoleAdmin, handleGitHubConfig(store)))
+	mux.HandleFunc("/api/integrations/github/webhook", handleGitHubWebhook(store))
+	mux.HandleFunc("/api/history/clear", withAuth(RoleEditor, handleClearHistory(store)))
+	mux.HandleFunc("/api/connections/cleanup", withAuth(RoleEditor, handleCleanupConnections(store)))
+	mux.HandleFunc("/api/admin/reset", withAuth(RoleAdmin, handleReset(store)))
+	mux.HandleFunc("/api/admin/freeze", withAuth(RoleAdmin, handleFreeze(store)))
+	mux.HandleFunc("/api/admin/archive", withAuth(RoleAdmin, handleArchive(store)))
+	mux.HandleFunc("/api/admin/e2e", withAuth(RoleAdmin, handleEnableEncryption(store)))
+	mux.HandleFunc("/api/admin/rules", withAuth(RoleAdmin, handleRules(store)))
+	mux.HandleFunc("/api/admin/templates", withAuth(RoleAdmin, handleAdminTemplates(store)))
+	mux.HandleFunc("/api/admin/variables", withAuth(RoleAdmin, handleVariables(store)))
+	mux.HandleFunc("/api/admin/columns", withAuth(RoleAdmin, handleColumns(store)))
+	mux.HandleFunc("/api/admin/columns/permissions", withAuth(RoleAdmin, handleColumnPermissions(store)))
+	mux.HandleFunc("/api/admin/columns/dwell", withAuth(RoleAdmin, handleColumnDwell(store)))
+	mux.HandleFunc("/api/admin/columns/sort", withAuth(RoleAdmin, handleColumnSort(store)))
+	mux.HandleFunc("/api/admin/bots", withAuth(RoleAdmin, handleBots(store)))
+	mux.HandleFunc("/api/admin/embeds", withAuth(RoleAdmin, handleEmbeds(store)))
+	mux.HandleFunc("/api/admin/partition", withAuth(RoleAdmin, handlePartition(store)))
+	mux.HandleFunc("/api/admin/adopt", withAuth(RoleAdmin, handleAdopt(store)))
+	mux.HandleFunc("/api/admin/integrity", withAuth(RoleAdmin, handleIntegrity(store)))
+	mux.HandleFunc("/api/admin/repair", withAuth(RoleAdmin, handleRepair(store)))
+	mux.HandleFunc("/api/admin/features", withAuth(RoleAdmin, handleAdminFeatures(store)))
+	mux.HandleFunc("/api/admin/audit", withAuth(RoleAdmin, handleAudit(store)))
+	mux.HandleFunc("/admin", withAuth(RoleAdmin, handleAdminDashboard(store)))
+	return mux
+}
+
+// nodeHeader names the node that served a response. The proxy pins
+// sessions by it, and the board footer shows it.
+const nodeHeader = "X-DeepBoard-Node"
+
+// withNode names node in the X-DeepBoard-Node header of every response.
+func withNode(node string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(nodeHeader, node)
+		h.ServeHTTP(w, r)
+	})
 }
 
 func startConnectionCleanup(s *Store) {
 	ticker := time.NewTicker(1 * time.Minute)
-	for range ticker.C {
-		removeStaleConnections(s)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			removeStaleConnections(s)
+		case <-s.done:
+			return
+		}
 	}
 }
 
+// removeStaleConnections drops the connections of nodes that are neither
+// peers named after their host nor announced, and the announcements that
+// expired.
 func removeStaleConnections(s *Store) {
 	currentPeers := s.GetPeers()
 	peerMap := make(map[string]bool)
@@ -107,20 +495,23 @@ func removeStaleConnections(s *Store) {
 	}
 	peerMap[s.nodeID] = true
 
+	now := time.Now()
 	s.SilentEdit(func(bs *BoardState) {
 		newConns := []NodeConnection{}
 		for _, nc := range bs.NodeConnections {
-			if peerMap[nc.NodeID] {
+			if _, ok := announcedAddr(*bs, nc.NodeID, now); ok || peerMap[nc.NodeID] {
 				newConns = append(newConns, nc)
 			}
 		}
 		bs.NodeConnections = newConns
+		dropExpiredAnnouncements(bs, now)
 	})
 }
 
 func handleClearHistory(s *Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		s.ClearHistory()
+		s.Audit(r, AuditHistoryClear, "")
 		w.WriteHeader(http.StatusOK)
 	}
 }
@@ -135,7 +526,34 @@ func handleCleanupConnections(s *Store) http.HandlerFunc {
 func handleReset(s *Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		log.Printf("ADMIN: Resetting board to initial state")
-		s.Reset()
+		if err := s.Reset(); err != nil {
+			writeMutationError(w, err)
+			return
+		}
+		s.Audit(r, AuditBoardReset, "")
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// handleFreeze sets the board freeze flag. Without a "frozen" parameter it
+// toggles the current value.
+func handleFreeze(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		frozen := !s.IsFrozen()
+		if v := r.FormValue("frozen"); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				writeError(w, "invalid frozen value", http.StatusBadRequest)
+				return
+			}
+			frozen = b
+		}
+		log.Printf("ADMIN: Setting board frozen=%v", frozen)
+		if err := s.SetFrozen(frozen); err != nil {
+			writeMutationError(w, err)
+			return
+		}
+		s.Audit(r, AuditBoardFreeze, fmt.Sprintf("frozen=%v", frozen))
 		w.WriteHeader(http.StatusOK)
 	}
 }
@@ -143,6 +561,16 @@ func handleReset(s *Store) http.HandlerFunc {
 func discoverPeers(s *Store, serviceName string) {
 	log.Printf("Starting peer discovery for service: %s", serviceName)
 	for {
+		// Announced addresses name the nodes found here by IP: once there
+		// are some, discovery has served to join.
+		if s.announceAddr != "" && len(s.announcedPeers()) > 0 {
+			if len(s.configured()) > 0 {
+				log.Printf("Peers announce themselves, leaving discovery")
+				s.UpdatePeers(nil)
+			}
+			time.Sleep(30 * time.Second)
+			continue
+		}
 		ips, err := net.LookupIP(serviceName)
 		if err == nil {
 			newPeers := []string{}
@@ -161,53 +589,81 @@ func discoverPeers(s *Store, serviceName string) {
 
 func handleState(s *Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		s.mu.RLock()
-		defer s.mu.RUnlock()
-		json.NewEncoder(w).Encode(s.crdt)
+		if err := s.checkRemote(r.RemoteAddr); err != nil {
+			writeError(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(s.snap.Load().crdtJSON)
 	}
 }
 
+// startBackgroundSync pulls state from every peer on an adaptive schedule
+// (see nextSyncInterval) until the store is closed.
 func startBackgroundSync(s *Store) {
-	// Periodic sync every 30 seconds
-	ticker := time.NewTicker(30 * time.Second)
-	for range ticker.C {
+	for s.waitSync() {
+		if s.IsArchived() {
+			continue
+		}
 		currentPeers := s.GetPeers()
 		for _, peer := range currentPeers {
 			syncWithPeer(s, peer)
 		}
+		s.adaptSyncInterval()
 	}
 }
 
 func syncWithPeer(s *Store, peer string) {
-	url := fmt.Sprintf("http://%s/api/state", peer)
-	resp, err := peerHTTPClient.Get(url)
+	start := time.Now()
+	if err := s.checkPeer(peer); err != nil {
+		s.recordPeerResult(peer, 0, err)
+		return
+	}
+	data, err := s.transport.FetchState(peer, s.basePath)
 	if err != nil {
+		s.recordPeerResult(peer, 0, err)
+		return
+	}
+	s.recordTraffic(peer, PeerTraffic{BytesReceived: int64(len(data))})
+	if data, err = upgradePayload(payloadState, data); err != nil {
+		log.Printf("Sync: rejected the state of %s: %v", peer, err)
+		s.recordPeerResult(peer, 0, err)
 		return
 	}
-	defer resp.Body.Close()
 
 	var remoteCRDT crdt.CRDT[BoardState]
-	if err := json.NewDecoder(resp.Body).Decode(&remoteCRDT); err != nil {
+	if err := json.Unmarshal(data, &remoteCRDT); err != nil {
+		s.recordPeerResult(peer, 0, err)
 		return
 	}
+	s.recordPeerResult(peer, time.Since(start), nil)
 
-	s.Merge(&remoteCRDT)
+	s.MergeFromPeer(peer, &remoteCRDT)
 }
 
 func handleSync(s *Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var delta crdt.Delta[BoardState]
-		if err := json.NewDecoder(r.Body).Decode(&delta); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = s.receiveDelta(data, r.Header.Get(digestHeader), r.RemoteAddr)
+		if errors.Is(err, errBadDelta) {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var version *ModelVersionError
+		if errors.As(err, &version) {
+			writeError(w, err.Error(), http.StatusConflict)
 			return
 		}
-		if delta.Timestamp.WallTime == 0 {
-			// Empty delta — nothing to apply
-			w.WriteHeader(http.StatusOK)
+		if errors.Is(err, ErrPeerBlocked) {
+			writeError(w, err.Error(), http.StatusServiceUnavailable)
 			return
 		}
-		if err := s.ApplyDelta(delta); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 		w.WriteHeader(http.StatusOK)
@@ -215,22 +671,66 @@ func handleSync(s *Store) http.HandlerFunc {
 }
 
 func handleIndex(s *Store) http.HandlerFunc {
+	tmpl := template.Must(template.New("index").Funcs(uiFuncs).Parse(indexHTML))
 	return func(w http.ResponseWriter, r *http.Request) {
-		tmpl, err := template.New("index").Parse(indexHTML)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+		data := prepareUIData(s)
+		data.Base = requestBase(r)
+		data.View = requestView(w, r)
+		if u := currentUser(r); u != nil {
+			data.Voter = u.ID
+		}
+		data.Unfurl = unfurler != nil
+		data.Features = s.Features(featureUser(r))
+		if r.URL.Path == "/" {
+			user := homeUser(r)
+			s.RecordVisit(user, time.Now())
+			data.Starred, _, _ = s.boardUser(user)
+			if unseen, err := s.Unseen(user); err != nil {
+				log.Printf("Failed to find changes unseen by %s: %v", user, err)
+			} else {
+				data.Unseen = unseen
+			}
 		}
-		tmpl.Execute(w, prepareUIData(s))
+		render(w, tmpl, data)
 	}
 }
 
+// viewCookieName remembers a view chosen with ?view=, so it survives the
+// redirects back to the board.
+const viewCookieName = "deepboard_view"
+
+// requestView returns the board view asked for with ?view=mobile or
+// ?view=desktop, or remembered from an earlier request. ?view=auto forgets
+// the choice.
+func requestView(w http.ResponseWriter, r *http.Request) string {
+	switch v := r.URL.Query().Get("view"); v {
+	case "mobile", "desktop":
+		http.SetCookie(w, &http.Cookie{Name: viewCookieName, Value: v, Path: "/", SameSite: http.SameSiteLaxMode})
+		return v
+	case "auto":
+		http.SetCookie(w, &http.Cookie{Name: viewCookieName, Value: "", Path: "/", MaxAge: -1})
+		return ""
+	}
+	if c, err := r.Cookie(viewCookieName); err == nil && (c.Value == "mobile" || c.Value == "desktop") {
+		return c.Value
+	}
+	return ""
+}
+
 func handleStats(s *Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Cache-Control", "no-store")
 		state := s.GetBoard()
 		localCount, totalCount := getConnectionCounts(state, s.nodeID)
 		fmt.Fprintf(w, "Local: %d | Total: %d", localCount, totalCount)
+		if state.Board.Archived {
+			fmt.Fprint(w, " | ARCHIVED")
+		} else if state.Board.Frozen {
+			fmt.Fprint(w, " | FROZEN")
+		}
+		if s.IsReadOnly() {
+			fmt.Fprint(w, " | READ-ONLY")
+		}
 	}
 }
 
@@ -247,30 +747,39 @@ func getConnectionCounts(state BoardState, nodeID string) (int, int) {
 }
 
 func handleBoard(s *Store) http.HandlerFunc {
+	tmpl := template.Must(template.New("board").Funcs(uiFuncs).Parse(boardHTML))
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Cache-Control", "no-store")
-		tmpl, err := template.New("board").Parse(boardHTML)
+		snap := s.snap.Load()
+		cols, err := requestColumns(snap.state, r)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeError(w, err.Error(), http.StatusNotFound)
 			return
 		}
-		tmpl.Execute(w, UIData{Columns: buildUIColumns(s.GetBoard())})
+		// Hash what is shown: a view, or the first pages of large columns.
+		cols = pageColumns(cols, queryInt(r, "limit", columnPageSize, 1, maxColumnPage))
+		hash := snap.hash
+		if r.URL.Query().Has("view") || truncated(cols) {
+			hash = columnsHash(cols)
+		}
+		w.Header().Set("X-Board-Hash", hash)
+		render(w, tmpl, UIData{Columns: cols, Labels: snap.state.Board.Labels, Users: snap.state.Board.Users})
 	}
 }
 
 func handleHistory(s *Store) http.HandlerFunc {
+	tmpl := template.Must(template.New("history").Parse("{{range .}}" + historyHTML + "{{end}}"))
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Cache-Control", "no-store")
-		history := s.GetHistory(15)
-		for _, h := range history {
-			fmt.Fprintf(w, "<div class=\"history-entry\">%s</div>", h)
-		}
+		all, _ := strconv.ParseBool(r.URL.Query().Get("all"))
+		render(w, tmpl, s.recentHistory(all))
 	}
 }
 
 func handleWS(s *Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		conn, err := upgrader.Upgrade(w, r, nil)
+		requestID := w.Header().Get(requestIDHeader)
+		conn, err := upgrader.Upgrade(w, r, http.Header{requestIDHeader: {requestID}, nodeHeader: {s.nodeID}})
 		if err != nil {
 			log.Printf("WebSocket upgrade failed: %v", err)
 			return
@@ -285,9 +794,17 @@ func handleWS(s *Store) http.HandlerFunc {
 		})
 
 		connID := uuid.New().String()
+		user := currentUser(r)
 
-		sub := s.Subscribe()
+		sub := s.SubscribeAs(presenceName(user))
 		defer s.Unsubscribe(sub)
+		s.registerViewer(user)
+		if op := s.Presenting(); op != nil {
+			s.Notify(sub, WSMessage{Type: "presenting", Presence: op})
+		}
+		for _, round := range s.PokerRounds() {
+			s.Notify(sub, WSMessage{Type: "poker", Poker: round})
+		}
 
 		// Create a channel to signal when the connection is closed
 		done := make(chan struct{})
@@ -308,6 +825,11 @@ func handleWS(s *Store) http.HandlerFunc {
 						conn.WriteMessage(websocket.CloseMessage, []byte{})
 						return
 					}
+					if msg.Effect != nil {
+						if muted, _ := s.EffectsMuted(homeUser(r)); muted {
+							continue
+						}
+					}
 					if !msg.Silent {
 						log.Printf("Refresh triggered for client %s", connID)
 					}
@@ -327,30 +849,110 @@ func handleWS(s *Store) http.HandlerFunc {
 		}()
 
 		for {
-			var msg WSMessage
-			if err := conn.ReadJSON(&msg); err != nil {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
 				if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 					log.Printf("WebSocket error from %s: %v", connID, err)
 				}
 				break
 			}
+			if s.wsRecording != nil {
+				s.wsRecording.record(s.BoardKey(), connID, data)
+			}
+			msg, opErr := decodeWSMessage(data)
 			log.Printf("WS message from %s: type=%s", connID, msg.Type)
 
+			// Heartbeats, drift reports and presence queries are sent by the
+			// page on its own; anything else means the user is there.
+			automatic := msg.Type == "heartbeat" || msg.Type == "presenceQuery" || msg.Type == "drift"
+			if opErr == nil && !automatic {
+				s.NoteActivity(sub)
+			}
+			readOnlyMsg := automatic || msg.Type == "active"
+			if opErr == nil && !readOnlyMsg && user != nil && !user.HasRole(RoleEditor) {
+				opErr = ErrForbidden
+				msg.Type = ""
+			}
 			switch msg.Type {
 			case "move":
 				if msg.Move != nil {
-					s.MoveCard(msg.Move.CardID, msg.Move.ToCol, msg.Move.ToIndex)
+					opErr = moveCardAs(s, user, msg.Move)
 				}
 			case "textOp":
 				if msg.TextOp != nil {
-					s.UpdateCardText(msg.TextOp.CardID, msg.TextOp.Op, msg.TextOp.Val, msg.TextOp.Pos, msg.TextOp.Length)
+					opErr = s.ApplyTextOp(*msg.TextOp)
+				}
+			case "titleOp":
+				if msg.TitleOp != nil {
+					opErr = s.UpdateCardTitle(msg.TitleOp.CardID, msg.TitleOp.Op, msg.TitleOp.Val, msg.TitleOp.Pos, msg.TitleOp.Length)
+				}
+			case "column":
+				if user != nil && !user.HasRole(RoleAdmin) {
+					opErr = ErrForbidden
+				} else if msg.Column != nil {
+					opErr = s.ApplyColumnOp(*msg.Column)
 				}
 			case "delete":
 				if msg.Delete != nil {
-					s.DeleteCard(msg.Delete.CardID)
+					if opErr = s.DeleteCard(msg.Delete.CardID); opErr == nil {
+						s.Notify(sub, WSMessage{Type: "deleted", Delete: &DeleteOp{
+							CardID:      msg.Delete.CardID,
+							UndoSeconds: int(undoWindow / time.Second),
+						}})
+					}
+				}
+			case "due":
+				if msg.Due != nil {
+					opErr = s.SetCardDue(msg.Due.CardID, msg.Due.Due)
+				}
+			case "assign":
+				if msg.Assign != nil {
+					opErr = s.AssignCard(msg.Assign.CardID, msg.Assign.Assignee)
+				}
+			case "checklist":
+				if msg.Checklist != nil {
+					_, opErr = s.EditChecklist(*msg.Checklist)
+				}
+			case "vote":
+				if msg.Vote != nil {
+					opErr = s.Vote(msg.Vote.CardID, voterID(user, msg.Vote.Voter), msg.Vote.Up)
+				}
+			case "poker":
+				if msg.Poker != nil {
+					opErr = s.Poker(*msg.Poker, voterID(user, msg.Poker.Voter), pokerName(user, msg.Poker.Voter))
+				}
+			case "undoDelete":
+				if msg.Delete != nil {
+					opErr = s.UndoDelete(msg.Delete.CardID)
 				}
 			case "heartbeat":
 				s.Heartbeat(sub)
+			case "drift":
+				s.RecordClientDrift(connID)
+			case "active":
+				// Noted above.
+			case "editing":
+				if msg.Presence != nil {
+					s.SetEditing(sub, msg.Presence.CardID, presenceName(user))
+				}
+			case "present":
+				if msg.Presence != nil {
+					s.Present(sub, msg.Presence.CardID, presenceName(user))
+				}
+			case "presenceQuery":
+				if msg.Presence != nil {
+					editors := s.Editors(msg.Presence.CardID, sub)
+					s.Notify(sub, WSMessage{Type: "presence", Presence: &PresenceOp{
+						CardID:  msg.Presence.CardID,
+						Editors: editors,
+						Colors:  userColorMap(s.snap.Load().state.Board, editors...),
+						Away:    s.Away(editors),
+					}})
+				}
+			}
+			if opErr != nil {
+				log.Printf("Rejected %s from %s: %v", msg.Type, connID, opErr)
+				s.Notify(sub, WSMessage{Type: "error", Error: wsError(opErr, requestID)})
 			}
 		}
 		close(done)
@@ -363,7 +965,17 @@ func handleAdd(store *Store) http.HandlerFunc {
 		if title == "" {
 			title = "New Task"
 		}
-		store.AddCard(title)
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+		state := store.GetBoard()
+		if err := checkColumnAccess(state, currentUser(r), defaultColumn(state.Board)); err != nil {
+			writeMutationError(w, err)
+			return
+		}
+		id, err := store.AddCard(title)
+		if err != nil {
+			writeMutationError(w, err)
+			return
+		}
+		go githubOnCardCreated(store, id, title)
+		http.Redirect(w, r, requestBase(r)+"/", http.StatusSeeOther)
 	}
 }
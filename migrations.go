@@ -0,0 +1,149 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// migration is one versioned schema change. Migrations are applied in order,
+// each in its own transaction, and recorded in schema_migrations so they run
+// exactly once per database. Never edit a released migration; add a new one.
+type migration struct {
+	version     int
+	description string
+	sql         string
+}
+
+var migrations = []migration{
+	{1, "initial schema", `
+		CREATE TABLE IF NOT EXISTS state (
+			id TEXT PRIMARY KEY,
+			data BLOB
+		);
+		CREATE TABLE IF NOT EXISTS patches (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			timestamp TEXT,
+			patch BLOB,
+			summary TEXT
+		);
+		CREATE TABLE IF NOT EXISTS settings (
+			key TEXT PRIMARY KEY,
+			value BLOB
+		);
+		CREATE TABLE IF NOT EXISTS rules (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			data BLOB
+		);
+		CREATE TABLE IF NOT EXISTS changes (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			data BLOB
+		);
+		CREATE TABLE IF NOT EXISTS reconciliations (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			data BLOB
+		);
+		CREATE TABLE IF NOT EXISTS audit (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			time INTEGER,
+			actor TEXT,
+			ip TEXT,
+			action TEXT,
+			detail TEXT
+		);
+		CREATE TRIGGER IF NOT EXISTS audit_no_update BEFORE UPDATE ON audit
+		BEGIN SELECT RAISE(ABORT, 'audit log is append-only'); END;
+		CREATE TRIGGER IF NOT EXISTS audit_no_delete BEFORE DELETE ON audit
+		BEGIN SELECT RAISE(ABORT, 'audit log is append-only'); END;
+	`},
+	{2, "notifications and watches", `
+		CREATE TABLE notifications (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			recipient TEXT NOT NULL,
+			time INTEGER,
+			kind TEXT,
+			card_id TEXT,
+			title TEXT,
+			detail TEXT,
+			read INTEGER NOT NULL DEFAULT 0
+		);
+		CREATE INDEX notifications_recipient ON notifications (recipient, id);
+		CREATE TABLE watches (
+			recipient TEXT NOT NULL,
+			card_id TEXT NOT NULL,
+			PRIMARY KEY (recipient, card_id)
+		);
+	`},
+	{3, "board favorites and visits", `
+		CREATE TABLE board_users (
+			user TEXT PRIMARY KEY,
+			starred INTEGER NOT NULL DEFAULT 0,
+			last_visit INTEGER NOT NULL DEFAULT 0
+		);
+	`},
+	{4, "read markers", `
+		ALTER TABLE board_users ADD COLUMN last_seen TEXT NOT NULL DEFAULT '';
+	`},
+	{5, "effect mute preference", `
+		ALTER TABLE board_users ADD COLUMN mute_effects INTEGER NOT NULL DEFAULT 0;
+	`},
+	{6, "patch actors", `
+		ALTER TABLE patches ADD COLUMN actor TEXT NOT NULL DEFAULT '';
+	`},
+	{7, "event outbox", `
+		CREATE TABLE event_outbox (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			data BLOB
+		);
+	`},
+	{8, "patch kinds", `
+		ALTER TABLE patches ADD COLUMN kind TEXT NOT NULL DEFAULT 'content';
+		UPDATE patches SET kind = 'presence'
+			WHERE summary LIKE '/NodeConnections%' AND summary NOT LIKE '%/Board%';
+	`},
+}
+
+// migrate brings db up to the latest schema version. The first migration
+// uses IF NOT EXISTS throughout, so databases created before versioning
+// adopt it without changes.
+func migrate(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		applied_at INTEGER
+	)`)
+	if err != nil {
+		return err
+	}
+
+	var current int
+	if err := db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&current); err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		if err := applyMigration(db, m); err != nil {
+			return fmt.Errorf("migration %d (%s): %w", m.version, m.description, err)
+		}
+		log.Printf("Applied schema migration %d: %s", m.version, m.description)
+	}
+	return nil
+}
+
+func applyMigration(db *sql.DB, m migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(m.sql); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)",
+		m.version, time.Now().Unix()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
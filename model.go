@@ -6,44 +6,110 @@ import (
 )
 
 type Card struct {
-	ID          string    `deep:"key" json:"id"`
-	Title       string    `json:"title"`
-	Description crdt.Text `json:"description"`
-	ColumnID    string    `json:"columnID"`
-	Order       float64   `json:"order"`
+	ID          string          `deep:"key" json:"id"`
+	Title       string          `json:"title"`
+	Description crdt.Text       `json:"description"`
+	ColumnID    string          `json:"columnID"`
+	Order       float64         `json:"order"`
+	Assignee    string          `json:"assignee"`
+	Labels      LabelSet        `json:"labels"` // see labels.go
+	Priority    string          `json:"priority"`
+	IssueNumber int             `json:"issueNumber"`
+	IssueURL    string          `json:"issueURL"`
+	Comments    []Comment       `json:"comments"`
+	EnteredAt   int64           `json:"enteredAt"` // unix seconds it entered its column, 0 if unknown
+	Overdue     bool            `json:"overdue"`   // in its column longer than the column allows
+	Votes       []Vote          `json:"votes"`
+	Sprint      string          `json:"sprint"` // ID of the card's sprint, "" for the backlog
+	Estimate    string          `json:"estimate"`
+	Number      int             `json:"number"`    // of the card's key, DB-<number>; 0 until assigned
+	Due         string          `json:"due"`       // due date, as 2006-01-02; "" for none
+	Checklist   []ChecklistItem `json:"checklist"` // see checklist.go
+}
+
+type Comment struct {
+	ID     string `deep:"key" json:"id"`
+	Author string `json:"author"`
+	Body   string `json:"body"`
+	Time   int64  `json:"time"`
 }
 
 type NodeConnection struct {
-	NodeID string `deep:"key" json:"nodeID"`
-	Count  int    `json:"count"`
+	NodeID string       `deep:"key" json:"nodeID"`
+	Count  int          `json:"count"`
+	Users  []OnlineUser `json:"users"` // who is connected, see presence.go
 }
 
 type Column struct {
 	ID    string `deep:"key" json:"id"`
 	Title string `json:"title"`
+	// MoveGroups restricts who may put cards into this column. Empty means
+	// anyone with edit rights.
+	MoveGroups []string `json:"moveGroups"`
+	// MaxDwell is how many seconds a card may stay in this column before it
+	// is flagged overdue. Zero means no limit.
+	MaxDwell int64 `json:"maxDwell"`
+	// Sort is how the column orders its cards: ColumnSortManual,
+	// ColumnSortPriority or ColumnSortDue.
+	Sort string `json:"sort"`
+	// Order places the column among the others, see columns.go.
+	Order float64 `json:"order"`
 }
 
+// Board is the replicated board. Columns hold no cards: every card is in
+// Cards, keyed by its ID, and placed by its ColumnID and Order, so moves and
+// concurrent inserts merge per card. Column lists of cards, as UIColumn, are
+// only built from it to render.
 type Board struct {
-	ID      string          `json:"id"`
-	Title   string          `json:"title"`
-	Columns []Column        `json:"columns"`
-	Cards   map[string]Card `json:"cards"`
+	ID         string            `json:"id"`
+	Title      string            `json:"title"`
+	Columns    []Column          `json:"columns"`
+	Cards      map[string]Card   `json:"cards"`
+	Frozen     bool              `json:"frozen"`
+	Archived   bool              `json:"archived"`   // frozen and left out of periodic sync
+	Encryption Encryption        `json:"encryption"` // key check of an end-to-end encrypted board
+	Templates  []CardTemplate    `json:"templates"`
+	Variables  map[string]string `json:"variables"` // template variables, e.g. "sprint"
+	Sprints    []Sprint          `json:"sprints"`
+	Views      []BoardView       `json:"views"`  // saved filters, each visible to its owner only
+	Bots       []Bot             `json:"bots"`   // automation accounts, see bots.go
+	Embeds     []Embed           `json:"embeds"` // read-only iframe links, see embed.go
+	Colors     []UserColor       `json:"colors"` // of each user, see colors.go
+	Boards     []BoardRef        `json:"boards"` // created at runtime, on the default board only; see boards.go
+	Labels     []Label           `json:"labels"` // defined, with their colors; see labels.go
+	Users      []BoardUser       `json:"users"`  // cards can be assigned to, see users.go
 }
 
 // BoardState is the top-level structure we wrap in a CRDT.
 type BoardState struct {
 	Board           Board            `json:"board"`
 	NodeConnections []NodeConnection `json:"nodeConnections"`
+	// Announcements are where nodes started with -announce-addr are
+	// reached, by node ID, as "host:port@unix"; see announce.go.
+	Announcements map[string]string `json:"announcements"`
 }
 
 type WSMessage struct {
-	Type   string    `json:"type"`
-	Silent bool      `json:"silent,omitempty"`
-	Move   *MoveOp   `json:"move,omitempty"`
-	TextOp *TextOp   `json:"textOp,omitempty"`
-	Delete *DeleteOp `json:"delete,omitempty"`
+	Type      string       `json:"type"`
+	Silent    bool         `json:"silent,omitempty"`
+	Move      *MoveOp      `json:"move,omitempty"`
+	TextOp    *TextOp      `json:"textOp,omitempty"`
+	TitleOp   *TextOp      `json:"titleOp,omitempty"`
+	Delete    *DeleteOp    `json:"delete,omitempty"`
+	Presence  *PresenceOp  `json:"presence,omitempty"`
+	Vote      *VoteOp      `json:"vote,omitempty"`
+	Poker     *PokerOp     `json:"poker,omitempty"`
+	Effect    *Effect      `json:"effect,omitempty"`
+	Column    *ColumnOp    `json:"column,omitempty"`
+	Due       *DueOp       `json:"due,omitempty"`
+	Assign    *AssignOp    `json:"assign,omitempty"`
+	Checklist *ChecklistOp `json:"checklist,omitempty"`
+	Hash      string       `json:"hash,omitempty"` // boardHash of the state after a refresh
+	Error     *APIError    `json:"error,omitempty"`
 }
 
+// MoveOp is a card move requested by a client. Refresh broadcasts also carry
+// one when the change moved a single card, so clients can animate it.
 type MoveOp struct {
 	CardID  string `json:"cardId"`
 	FromCol string `json:"from"`
@@ -51,6 +117,9 @@ type MoveOp struct {
 	ToIndex int    `json:"toIndex"`
 }
 
+// TextOp edits a card description, or its title in a "titleOp" message:
+// "insert" Val at Pos, "delete" Length bytes at Pos, or "replace" it whole
+// with Val, as on encrypted boards.
 type TextOp struct {
 	CardID string `json:"cardId"`
 	Op     string `json:"op"`
@@ -59,8 +128,19 @@ type TextOp struct {
 	Length int    `json:"length"`
 }
 
+// DeleteOp names the card of a "delete" or "undoDelete" request. The
+// "deleted" reply to the deleting client sets UndoSeconds to how long the
+// deletion can be undone.
 type DeleteOp struct {
+	CardID      string `json:"cardId"`
+	UndoSeconds int    `json:"undoSeconds,omitempty"`
+}
+
+// DueOp sets the due date of a card in a "due" request, as 2006-01-02, or
+// clears it if Due is "".
+type DueOp struct {
 	CardID string `json:"cardId"`
+	Due    string `json:"due"`
 }
 
 func NewInitialBoard() BoardState {
@@ -84,7 +164,11 @@ func NewInitialBoard() BoardState {
 					},
 				},
 			},
+			Colors: []UserColor{},
+			Boards: []BoardRef{},
+			Users:  []BoardUser{},
 		},
 		NodeConnections: []NodeConnection{},
+		Announcements:   map[string]string{},
 	}
 }
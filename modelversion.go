@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// Nodes of a cluster run different versions during a rolling upgrade. The
+// deltas and full states they exchange carry the version of the board model
+// they were encoded with, as a top-level "model" field that nodes from
+// before versioning ignore; payloads without one are version 1. A node
+// applies payloads of its own version, migrates those of older versions it
+// still knows with modelMigrations, and rejects the others with a
+// ModelVersionError instead of decoding them into the wrong fields. Digests
+// carry the version too, so a node does not take a peer on another model
+// for a diverging one. Stored states, journaled patches and archives are
+// stamped and migrated the same way as a board is opened.
+
+// modelMigration turns payloads of the version before into payloads of
+// version: state changes the BoardState of a full CRDT and delta the patch
+// of a delta, either nil if that kind is unchanged. Migrations are applied
+// in order. Never edit a released migration; add a new one and bump
+// modelVersion to it.
+type modelMigration struct {
+	version     int
+	description string
+	state       func(value map[string]any)
+	delta       func(patch map[string]any)
+}
+
+var modelMigrations = []modelMigration{
+	{2, "cards, users and announcements start empty rather than nil", migrateEmptyContainers, nil},
+}
+
+// modelVersion is the version of the board model this node encodes, that of
+// the last migration.
+const modelVersion = 2
+
+// minModelVersion is the oldest version whose payloads this node accepts.
+const minModelVersion = 1
+
+// Kinds of payloads, for migrations and errors.
+const (
+	payloadDelta = "delta"
+	payloadState = "state"
+)
+
+// migrateEmptyContainers gives cards an empty label set and checklist, and
+// the board an empty user registry and announcements, where they are nil:
+// a keyed list or set that is nil when items are added to it on two nodes
+// at once would be replaced by each, keeping one item, and a patch setting
+// an announcement in a nil map cannot apply.
+func migrateEmptyContainers(value map[string]any) {
+	setDefault(value, "announcements", map[string]any{})
+	board, _ := value["board"].(map[string]any)
+	if board == nil {
+		return
+	}
+	setDefault(board, "users", []any{})
+	cards, _ := board["cards"].(map[string]any)
+	for _, c := range cards {
+		if card, ok := c.(map[string]any); ok {
+			setDefault(card, "labels", []any{})
+			setDefault(card, "checklist", []any{})
+		}
+	}
+}
+
+// setDefault sets key of m to v if it is missing or null.
+func setDefault(m map[string]any, key string, v any) {
+	if m[key] == nil {
+		m[key] = v
+	}
+}
+
+// ModelVersionError is returned for a payload of a model version this node
+// cannot apply.
+type ModelVersionError struct {
+	Kind    string `json:"kind"` // payloadDelta or payloadState
+	Version int    `json:"version"`
+}
+
+func (e *ModelVersionError) Error() string {
+	if e.Version > modelVersion {
+		return fmt.Sprintf("%s of model version %d is newer than this node's %d: upgrade this node", e.Kind, e.Version, modelVersion)
+	}
+	return fmt.Sprintf("%s of model version %d is older than this node accepts (%d to %d): upgrade the node that sent it",
+		e.Kind, e.Version, minModelVersion, modelVersion)
+}
+
+// modelPrefix starts a payload stamped with its version.
+var modelPrefix = []byte(`{"model":`)
+
+// stampModel returns the encoded delta or state data with this node's model
+// version, unless it has one.
+func stampModel(data []byte) []byte {
+	if len(data) < 2 || data[0] != '{' || bytes.HasPrefix(data, modelPrefix) {
+		return data
+	}
+	stamp := fmt.Appendf(nil, "%s%d", modelPrefix, modelVersion)
+	if data[1] != '}' {
+		stamp = append(stamp, ',')
+	}
+	return append(stamp, data[1:]...)
+}
+
+// modelOf returns the model version a peer reported, v, with the 0 of nodes
+// from before versioning as 1.
+func modelOf(v int) int {
+	return max(v, 1)
+}
+
+// payloadVersion returns the model version of the encoded delta or state
+// data, and false if it does not decode.
+func payloadVersion(data []byte) (int, bool) {
+	var stamp struct {
+		Model *int `json:"model"`
+	}
+	if json.Unmarshal(data, &stamp) != nil {
+		return 0, false
+	}
+	if stamp.Model == nil {
+		return 1, true
+	}
+	return *stamp.Model, true
+}
+
+// upgradePayload returns the encoded delta or state data as this node's
+// model version, migrating it if it is older. Data that does not decode is
+// returned as is, for the caller to report.
+func upgradePayload(kind string, data []byte) ([]byte, error) {
+	version, ok := payloadVersion(data)
+	if !ok || version == modelVersion {
+		return data, nil
+	}
+	if version > modelVersion || version < minModelVersion {
+		return nil, &ModelVersionError{Kind: kind, Version: version}
+	}
+	var payload map[string]any
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber() // clocks do not fit in a float64
+	if err := dec.Decode(&payload); err != nil {
+		return data, nil
+	}
+	for _, m := range modelMigrations {
+		if m.version > version {
+			m.apply(kind, payload)
+		}
+	}
+	payload["model"] = modelVersion
+	return json.Marshal(payload)
+}
+
+// apply migrates payload, of the given kind.
+func (m modelMigration) apply(kind string, payload map[string]any) {
+	switch {
+	case kind == payloadState && m.state != nil:
+		if value, ok := payload["value"].(map[string]any); ok {
+			m.state(value)
+		}
+	case kind == payloadDelta && m.delta != nil:
+		if patch, ok := payload["p"].(map[string]any); ok {
+			m.delta(patch)
+		}
+	}
+}
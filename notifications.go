@@ -0,0 +1,419 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"maps"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Notification kinds.
+const (
+	NotifyAssigned = "assigned"
+	NotifyMention  = "mention"
+	NotifyWatch    = "watch"
+	NotifyOverdue  = "overdue"
+	NotifyDueSoon  = "due"
+)
+
+// maxNotifications is how many notifications the inbox returns.
+const maxNotifications = 50
+
+// Filing a notification is retried notifyAttempts times, notifyRetryDelay
+// apart, so a busy database does not lose it.
+const (
+	notifyAttempts   = 3
+	notifyRetryDelay = 100 * time.Millisecond
+)
+
+// dueCheckInterval is how often the cards due soon are looked for.
+const dueCheckInterval = time.Hour
+
+// Notification is one entry of a user's inbox. Notifications are derived
+// from the change feed, local and replicated changes alike, so every node
+// fills the same inboxes; read state is kept per node.
+type Notification struct {
+	ID     int64  `json:"id"`
+	Time   int64  `json:"time"` // unix milliseconds
+	Kind   string `json:"kind"`
+	CardID string `json:"cardId"`
+	Title  string `json:"title"`
+	Detail string `json:"detail"`
+	Read   bool   `json:"read"`
+}
+
+var mentionPattern = regexp.MustCompile(`(?:^|[^\w@])@([\w.\-]+\w)`)
+
+// mentions returns the lowercased handles @mentioned in text.
+func mentions(text string) []string {
+	var handles []string
+	for _, m := range mentionPattern.FindAllStringSubmatch(text, -1) {
+		handles = append(handles, strings.ToLower(m[1]))
+	}
+	return handles
+}
+
+// userHandles returns the lowercased names u can be addressed by in
+// assignments and @mentions: its ID, display name, email and the email's
+// local part, and for LDAP DNs the value of the first RDN.
+func userHandles(u *User) []string {
+	var handles []string
+	add := func(h string) {
+		if h = strings.ToLower(strings.TrimSpace(h)); h != "" {
+			handles = append(handles, h)
+		}
+	}
+	add(u.ID)
+	add(u.Name)
+	add(u.Email)
+	if local, _, ok := strings.Cut(u.Email, "@"); ok {
+		add(local)
+	}
+	if rdn, _, _ := strings.Cut(u.ID, ","); strings.Contains(rdn, "=") {
+		_, value, _ := strings.Cut(rdn, "=")
+		add(value)
+	}
+	return handles
+}
+
+// notice is a notification to file: its kind and detail.
+type notice struct{ kind, detail string }
+
+// notify files the notifications ev causes: the new assignee, the assignee
+// of an overdue card, the users mentioned in a comment and the card's
+// watchers each get one.
+func (s *Store) notify(ev Event) {
+	title := ev.Title
+	if title == "" {
+		title = s.GetBoard().Board.Cards[ev.CardID].Title
+	}
+	inbox := map[string]notice{}
+	switch ev.Type {
+	case EventCardAssigned:
+		if ev.Assignee != "" {
+			inbox[strings.ToLower(ev.Assignee)] = notice{NotifyAssigned, "You were assigned to this card"}
+		}
+	case EventCardOverdue:
+		if ev.Assignee != "" {
+			inbox[strings.ToLower(ev.Assignee)] = notice{NotifyOverdue, "Overdue in " + ev.Column}
+		}
+	case EventCardCommented:
+		for _, h := range mentions(ev.Comment) {
+			if h != strings.ToLower(ev.Author) {
+				inbox[h] = notice{NotifyMention, ev.Author + " mentioned you: " + snippet(ev.Comment, "@"+h)}
+			}
+		}
+	}
+	s.fileNotifications(ev.CardID, title, ev.Time, inbox, notice{NotifyWatch, describeEvent(ev)}, false)
+}
+
+// fileNotifications files the notices of inbox about a card, and watch for
+// the card's watchers that inbox leaves out. With once, a recipient who
+// already has a notice of the same kind and detail about the card does not
+// get it again. The notices are filed together, retrying if the database
+// is busy.
+func (s *Store) fileNotifications(cardID, title string, at int64, inbox map[string]notice, watch notice, once bool) {
+	var err error
+	for attempt := range notifyAttempts {
+		if attempt > 0 {
+			time.Sleep(notifyRetryDelay)
+		}
+		if err = s.tryFileNotifications(cardID, title, at, inbox, watch, once); err == nil {
+			return
+		}
+	}
+	log.Printf("Failed to file notifications of %s: %v", cardID, err)
+}
+
+func (s *Store) tryFileNotifications(cardID, title string, at int64, inbox map[string]notice, watch notice, once bool) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	notices := maps.Clone(inbox)
+	rows, err := tx.Query("SELECT recipient FROM watches WHERE card_id = ?", cardID)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var w string
+		if err := rows.Scan(&w); err != nil {
+			rows.Close()
+			return err
+		}
+		if _, ok := notices[w]; !ok {
+			notices[w] = watch
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	insert := "INSERT INTO notifications (recipient, time, kind, card_id, title, detail) SELECT ?, ?, ?, ?, ?, ?"
+	if once {
+		insert += " WHERE NOT EXISTS (SELECT 1 FROM notifications WHERE recipient = ? AND kind = ? AND card_id = ? AND detail = ?)"
+	}
+	for recipient, n := range notices {
+		args := []any{recipient, at, n.kind, cardID, title, n.detail}
+		if once {
+			args = append(args, recipient, n.kind, cardID, n.detail)
+		}
+		if _, err := tx.Exec(insert, args...); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// dueSoon reports whether due, a card's due date, is today or tomorrow as
+// of now.
+func dueSoon(due string, now time.Time) bool {
+	return due != "" && !pastDue(due, now) && due <= now.AddDate(0, 0, 1).Format(dueLayout)
+}
+
+// CheckDueSoon reminds the assignee and watchers of every card due soon as
+// of now, once per card and due date. Cards in the last column, where
+// finished work goes, are left alone. Notifications are local, so every
+// node reminds its own inboxes.
+func (s *Store) CheckDueSoon(now time.Time) {
+	b := s.GetBoard().Board
+	done := ""
+	if cols := orderedColumns(b); len(cols) > 0 {
+		done = cols[len(cols)-1].ID
+	}
+	for id, c := range b.Cards {
+		if c.ColumnID == done || !dueSoon(c.Due, now) {
+			continue
+		}
+		n := notice{NotifyDueSoon, "Due on " + c.Due}
+		inbox := map[string]notice{}
+		if c.Assignee != "" {
+			inbox[strings.ToLower(c.Assignee)] = n
+		}
+		s.fileNotifications(id, c.Title, now.UnixMilli(), inbox, n, true)
+	}
+}
+
+// startDueReminders looks for cards due soon every dueCheckInterval until
+// the store is closed.
+func startDueReminders(s *Store) {
+	ticker := time.NewTicker(dueCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			s.CheckDueSoon(now)
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// describeEvent summarizes ev for the watchers of its card.
+func describeEvent(ev Event) string {
+	switch ev.Type {
+	case EventCardCreated:
+		return "Created in " + ev.Column
+	case EventCardMoved:
+		return "Moved from " + ev.From + " to " + ev.Column
+	case EventCardDeleted:
+		return "Deleted"
+	case EventCardLabeled:
+		return "Labeled " + ev.Label
+	case EventCardAssigned:
+		if ev.Assignee == "" {
+			return "Unassigned"
+		}
+		return "Assigned to " + ev.Assignee
+	case EventCardCommented:
+		return ev.Author + " commented"
+	case EventCardOverdue:
+		return "Overdue in " + ev.Column
+	default:
+		return "Edited"
+	}
+}
+
+// GetNotifications returns the newest notifications addressed to any of
+// handles and how many of all of them are unread.
+func (s *Store) GetNotifications(handles []string) ([]Notification, int, error) {
+	notifications := []Notification{}
+	if len(handles) == 0 {
+		return notifications, 0, nil
+	}
+	in := "(?" + strings.Repeat(", ?", len(handles)-1) + ")"
+	args := make([]any, len(handles))
+	for i, h := range handles {
+		args[i] = h
+	}
+	var unread int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM notifications WHERE read = 0 AND recipient IN "+in, args...).Scan(&unread)
+	if err != nil {
+		return nil, 0, err
+	}
+	rows, err := s.db.Query("SELECT id, time, kind, card_id, title, detail, read FROM notifications WHERE recipient IN "+in+
+		" ORDER BY id DESC LIMIT ?", append(args, maxNotifications)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var n Notification
+		if err := rows.Scan(&n.ID, &n.Time, &n.Kind, &n.CardID, &n.Title, &n.Detail, &n.Read); err != nil {
+			return nil, 0, err
+		}
+		notifications = append(notifications, n)
+	}
+	return notifications, unread, rows.Err()
+}
+
+// MarkNotificationsRead marks notification id as read, or all of them when
+// id is 0. Only notifications addressed to one of handles are touched.
+func (s *Store) MarkNotificationsRead(handles []string, id int64) error {
+	if len(handles) == 0 {
+		return nil
+	}
+	query := "UPDATE notifications SET read = 1 WHERE recipient IN (?" + strings.Repeat(", ?", len(handles)-1) + ")"
+	args := make([]any, 0, len(handles)+1)
+	for _, h := range handles {
+		args = append(args, h)
+	}
+	if id != 0 {
+		query += " AND id = ?"
+		args = append(args, id)
+	}
+	_, err := s.db.Exec(query, args...)
+	return err
+}
+
+// Watch subscribes the user with the given handle to every change of
+// cardID, or unsubscribes them.
+func (s *Store) Watch(handle, cardID string, watch bool) error {
+	var err error
+	if watch {
+		_, err = s.db.Exec("INSERT OR IGNORE INTO watches (recipient, card_id) VALUES (?, ?)", handle, cardID)
+	} else {
+		_, err = s.db.Exec("DELETE FROM watches WHERE recipient = ? AND card_id = ?", handle, cardID)
+	}
+	return err
+}
+
+// Watching returns the cards the user with the given handle watches.
+func (s *Store) Watching(handle string) ([]string, error) {
+	return s.queryStrings("SELECT card_id FROM watches WHERE recipient = ?", handle)
+}
+
+func (s *Store) queryStrings(query string, args ...any) ([]string, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	values := []string{}
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, rows.Err()
+}
+
+// handleNotifications serves the signed-in user's inbox:
+// GET /api/notifications.
+func handleNotifications(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := currentUser(r)
+		if user == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		handles := userHandles(user)
+		notifications, unread, err := s.GetNotifications(handles)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		watching, err := s.Watching(handles[0])
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Notifications []Notification `json:"notifications"`
+			Unread        int            `json:"unread"`
+			Watching      []string       `json:"watching"`
+		}{notifications, unread, watching})
+	}
+}
+
+// handleNotificationsRead serves POST /api/notifications/read. With an "id"
+// it marks that notification read, otherwise all of them.
+func handleNotificationsRead(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := signedInPost(w, r)
+		if !ok {
+			return
+		}
+		var id int64
+		if v := r.FormValue("id"); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				writeError(w, "invalid id", http.StatusBadRequest)
+				return
+			}
+			id = n
+		}
+		if err := s.MarkNotificationsRead(userHandles(user), id); err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// handleWatch serves POST /api/cards/watch with a card and watch=true|false.
+func handleWatch(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := signedInPost(w, r)
+		if !ok {
+			return
+		}
+		cardID := r.FormValue("card")
+		watch := r.FormValue("watch") != "false"
+		if _, exists := s.GetBoard().Board.Cards[cardID]; watch && !exists {
+			writeError(w, ErrCardNotFound.Error(), http.StatusNotFound)
+			return
+		}
+		if err := s.Watch(userHandles(user)[0], cardID, watch); err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// signedInPost checks that r is a POST by a signed-in user; inboxes and
+// watches are personal, so they need one even when sign-in is disabled.
+func signedInPost(w http.ResponseWriter, r *http.Request) (*User, bool) {
+	if r.Method != http.MethodPost {
+		writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return nil, false
+	}
+	user := currentUser(r)
+	if user == nil {
+		writeError(w, "sign-in required", http.StatusUnauthorized)
+		return nil, false
+	}
+	return user, true
+}
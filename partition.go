@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+)
+
+// Admins can cut a board off from chosen peers to demonstrate or test
+// divergence and convergence deterministically: while a peer is blocked the
+// board neither sends to nor accepts anything from it. Failed syncs mark the
+// peer partitioned as a real outage would, so unblocking it reconciles the
+// two sides the same way.
+
+// ErrPeerBlocked is returned for sync traffic to or from a blocked peer.
+var ErrPeerBlocked = errors.New("peer is blocked by an admin partition")
+
+// Partition states of a peer.
+const (
+	PartitionBlocked = "blocked"
+	PartitionOpen    = "open"
+)
+
+// SetPeerBlocked blocks or unblocks sync traffic with peer. Unblocking
+// syncs right away rather than waiting for the next background round.
+func (s *Store) SetPeerBlocked(peer string, blocked bool) {
+	s.peerMu.Lock()
+	if blocked {
+		s.blocked[peer] = true
+	} else {
+		delete(s.blocked, peer)
+	}
+	s.peerMu.Unlock()
+	if !blocked {
+		s.SyncSoon()
+	}
+}
+
+// BlockedPeers returns the blocked peers, sorted.
+func (s *Store) BlockedPeers() []string {
+	s.peerMu.RLock()
+	defer s.peerMu.RUnlock()
+	peers := make([]string, 0, len(s.blocked))
+	for p := range s.blocked {
+		peers = append(peers, p)
+	}
+	slices.Sort(peers)
+	return peers
+}
+
+// checkPeer returns ErrPeerBlocked if traffic with peer is blocked.
+func (s *Store) checkPeer(peer string) error {
+	s.peerMu.RLock()
+	defer s.peerMu.RUnlock()
+	if s.blocked[peer] {
+		return ErrPeerBlocked
+	}
+	return nil
+}
+
+// checkRemote is checkPeer for a request from remoteAddr.
+func (s *Store) checkRemote(remoteAddr string) error {
+	return s.checkPeer(s.trafficPeer(remoteAddr))
+}
+
+// handlePartition serves /api/admin/partition: GET lists the blocked peers,
+// and POST ?peer=host:8081&state=blocked blocks one (state=open unblocks it).
+func handlePartition(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("Cache-Control", "no-store")
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(s.BlockedPeers())
+		case http.MethodPost:
+			peer, state := r.FormValue("peer"), r.FormValue("state")
+			if peer == "" || (state != PartitionBlocked && state != PartitionOpen) {
+				writeError(w, "partition needs a peer and a state of blocked or open", http.StatusBadRequest)
+				return
+			}
+			s.SetPeerBlocked(peer, state == PartitionBlocked)
+			s.Audit(r, AuditPartitionChange, peer+" "+state)
+			w.WriteHeader(http.StatusOK)
+		default:
+			writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import "strings"
+
+// Every patch in the history is classified when it is saved. Most are
+// content: cards, columns, sprints, what people work on. The rest is noise
+// to someone reading the Activity sidebar: presence (connection counts and
+// user colors, which every node rewrites as people come and go, and which
+// also arrive through merges) and admin (freezing, archiving, encryption,
+// bots, embeds and the list of boards). The history lists content only
+// unless asked for all of it; the kind is stored, so filtering is a query,
+// not a guess from the summary.
+
+// Patch kinds.
+const (
+	PatchContent  = "content"
+	PatchPresence = "presence"
+	PatchAdmin    = "admin"
+)
+
+// Path prefixes of the noise kinds.
+var (
+	presencePaths = []string{"/NodeConnections", "/Announcements", "/Board/Colors"}
+	adminPaths    = []string{"/Board/Frozen", "/Board/Archived", "/Board/Encryption", "/Board/Bots", "/Board/Embeds", "/Board/Boards"}
+)
+
+// patchKind classifies a patch that changed paths: content if it changed
+// any content at all, otherwise admin if it changed any admin setting, and
+// presence if it changed nothing else.
+func patchKind(paths []string) string {
+	if len(paths) == 0 {
+		return PatchContent
+	}
+	kind := PatchPresence
+	for _, p := range paths {
+		switch {
+		case hasPathPrefix(p, presencePaths):
+		case hasPathPrefix(p, adminPaths):
+			kind = PatchAdmin
+		default:
+			return PatchContent
+		}
+	}
+	return kind
+}
+
+// hasPathPrefix reports whether p is, or is under, one of prefixes.
+func hasPathPrefix(p string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if p == prefix || strings.HasPrefix(p, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+)
+
+var (
+	// ErrPeerSecretRequired is returned by the peer routes of a node that
+	// has sign-in enabled but no peer secret.
+	ErrPeerSecretRequired = errors.New("peer replication needs -peer-secret while sign-in is enabled")
+	// ErrBadPeerSecret is returned for peer requests without the node's
+	// peer secret.
+	ErrBadPeerSecret = errors.New("invalid peer secret")
+)
+
+// peerSecretHeader carries the peer secret on replication requests; gRPC
+// sends it as metadata of the same name.
+const peerSecretHeader = "X-Peer-Secret"
+
+// peerSecret is the secret the nodes of a cluster share (-peer-secret).
+// Every replication request carries it, and a node with one refuses
+// requests that do not.
+var peerSecret string
+
+// checkPeerSecret reports whether a replication request carrying got may
+// be served. The peer routes read and rewrite the whole board, so once users
+// must sign in they are closed until a peer secret is set.
+func checkPeerSecret(got string) error {
+	if peerSecret == "" {
+		if authn != nil {
+			return ErrPeerSecretRequired
+		}
+		return nil
+	}
+	if subtle.ConstantTimeCompare([]byte(got), []byte(peerSecret)) != 1 {
+		return ErrBadPeerSecret
+	}
+	return nil
+}
+
+// withPeerAuth serves h only to requests carrying the peer secret.
+func withPeerAuth(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := checkPeerSecret(r.Header.Get(peerSecretHeader)); err != nil {
+			writeMutationError(w, err)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// peerHeader returns the headers replication requests to peers carry.
+func peerHeader() http.Header {
+	h := http.Header{}
+	if peerSecret != "" {
+		h.Set(peerSecretHeader, peerSecret)
+	}
+	return h
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// Peer health thresholds. Background sync runs at least every
+// maxSyncInterval, so a healthy peer is never much older than that.
+const (
+	peerHealthyWindow  = maxSyncInterval + 30*time.Second
+	peerDegradedWindow = 5 * time.Minute
+)
+
+// Peer health states, named after the header indicator colors.
+const (
+	PeerGreen  = "green"
+	PeerYellow = "yellow"
+	PeerRed    = "red"
+)
+
+// PeerStatus tracks the outcome of sync attempts with one peer.
+type PeerStatus struct {
+	Peer          string    `json:"peer"`
+	LastSuccess   time.Time `json:"lastSuccess"`
+	LastError     string    `json:"lastError,omitempty"`
+	LastErrorTime time.Time `json:"lastErrorTime"`
+	LatencyMs     int64     `json:"latencyMs"`
+	Health        string    `json:"health"`
+
+	// PartitionedSince is the last success before a run of failures. It is
+	// cleared once the peer's full state has been merged again.
+	PartitionedSince time.Time `json:"partitionedSince"`
+}
+
+// recordPeerResult updates the status of peer after a sync attempt.
+func (s *Store) recordPeerResult(peer string, latency time.Duration, err error) {
+	s.peerMu.Lock()
+	defer s.peerMu.Unlock()
+	st, ok := s.peerStatus[peer]
+	if !ok {
+		st = &PeerStatus{Peer: peer}
+		s.peerStatus[peer] = st
+	}
+	if err != nil {
+		s.syncTroubleLocked()
+		s.recordTrafficLocked(peer, PeerTraffic{Errors: 1})
+		if st.PartitionedSince.IsZero() {
+			st.PartitionedSince = st.LastSuccess
+		}
+		st.LastError = err.Error()
+		st.LastErrorTime = time.Now()
+		return
+	}
+	st.LastSuccess = time.Now()
+	st.LatencyMs = latency.Milliseconds()
+}
+
+// peerHealth classifies a peer: green when it synced recently and the last
+// attempt succeeded, yellow when it is lagging or the last attempt failed,
+// red when it has not synced for a long time (or ever).
+func peerHealth(st PeerStatus, now time.Time) string {
+	since := now.Sub(st.LastSuccess)
+	switch {
+	case st.LastSuccess.IsZero() || since > peerDegradedWindow:
+		return PeerRed
+	case since > peerHealthyWindow || st.LastErrorTime.After(st.LastSuccess):
+		return PeerYellow
+	}
+	return PeerGreen
+}
+
+// GetPeerStatus returns the status of every current peer.
+func (s *Store) GetPeerStatus() []PeerStatus {
+	s.peerMu.RLock()
+	defer s.peerMu.RUnlock()
+	now := time.Now()
+	out := make([]PeerStatus, 0, len(s.peers))
+	for _, p := range s.peers {
+		st := PeerStatus{Peer: p}
+		if known, ok := s.peerStatus[p]; ok {
+			st = *known
+		}
+		st.Health = peerHealth(st, now)
+		out = append(out, st)
+	}
+	return out
+}
+
+// clusterHealth summarizes peers as the worst individual health, or "" when
+// the node has no peers.
+func clusterHealth(peers []PeerStatus) string {
+	health := ""
+	rank := map[string]int{"": 0, PeerGreen: 1, PeerYellow: 2, PeerRed: 3}
+	for _, p := range peers {
+		if rank[p.Health] > rank[health] {
+			health = p.Health
+		}
+	}
+	return health
+}
+
+func handlePeers(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		peers := s.GetPeerStatus()
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Health         string       `json:"health"`
+			Divergences    int64        `json:"divergences"`
+			ClientDrifts   int64        `json:"clientDrifts"`
+			SyncIntervalMs int64        `json:"syncIntervalMs"`
+			Peers          []PeerStatus `json:"peers"`
+		}{clusterHealth(peers), s.Divergences(), s.ClientDrifts(), s.SyncInterval().Milliseconds(), peers})
+	}
+}
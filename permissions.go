@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// checkColumnAccess reports whether user may put cards into column colID.
+// Restrictions only apply when authentication is enabled; admins bypass them.
+func checkColumnAccess(state BoardState, user *User, colID string) error {
+	if user == nil || user.HasRole(RoleAdmin) {
+		return nil
+	}
+	for _, col := range state.Board.Columns {
+		if col.ID != colID || len(col.MoveGroups) == 0 {
+			continue
+		}
+		for _, g := range col.MoveGroups {
+			if user.InGroup(g) {
+				return nil
+			}
+		}
+		return fmt.Errorf("%w: only %s can move cards into %q", ErrForbidden, strings.Join(col.MoveGroups, ", "), col.Title)
+	}
+	return nil
+}
+
+// moveCardAs performs a move on behalf of user, enforcing column permissions.
+func moveCardAs(s *Store, user *User, move *MoveOp) error {
+	if err := checkColumnAccess(s.GetBoard(), user, move.ToCol); err != nil {
+		return err
+	}
+	return s.MoveCard(move.CardID, move.ToCol, move.ToIndex)
+}
+
+// handleColumnPermissions sets the groups allowed to move cards into a
+// column: POST {"columnId": "done", "groups": ["qa"]}. An empty list lifts the
+// restriction.
+func handleColumnPermissions(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(s.GetBoard().Board.Columns)
+			return
+		}
+		var req struct {
+			ColumnID string   `json:"columnId"`
+			Groups   []string `json:"groups"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := s.SetColumnMoveGroups(req.ColumnID, req.Groups); err != nil {
+			writeMutationError(w, err)
+			return
+		}
+		log.Printf("ADMIN: Column %s restricted to groups %v", req.ColumnID, req.Groups)
+		s.Audit(r, AuditColumnPermissions, fmt.Sprintf("column=%s groups=%s", req.ColumnID, strings.Join(req.Groups, ",")))
+		w.WriteHeader(http.StatusOK)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Card templates may contain placeholders such as {{sprint}} or {{date}}.
+// They are expanded when a card is created, from, in order of precedence,
+// what the user typed, the board's variables and the built-in variables.
+
+// placeholderPattern matches a placeholder; the name is the first group.
+var placeholderPattern = regexp.MustCompile(`\{\{\s*([\w.-]+)\s*\}\}`)
+
+// MissingVariablesError is returned when placeholders cannot be resolved.
+type MissingVariablesError []string
+
+func (e MissingVariablesError) Error() string {
+	return "missing template variables: " + strings.Join(e, ", ")
+}
+
+// builtinVariables are always available: the creation date and ISO week,
+// and the creating user.
+func builtinVariables(now time.Time, user string) map[string]string {
+	year, week := now.ISOWeek()
+	return map[string]string{
+		"date": now.Format(time.DateOnly),
+		"week": fmt.Sprintf("%d-W%02d", year, week),
+		"user": user,
+	}
+}
+
+// VarResolver resolves placeholder names from layered variable sets, the
+// first set defining a name winning.
+type VarResolver []map[string]string
+
+// Lookup returns the value of name.
+func (r VarResolver) Lookup(name string) (string, bool) {
+	for _, vars := range r {
+		if v, ok := vars[name]; ok {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// Expand replaces the placeholders in text. Placeholders it cannot resolve
+// are left in place and their names returned, sorted and unique.
+func (r VarResolver) Expand(text string) (string, []string) {
+	var missing []string
+	out := placeholderPattern.ReplaceAllStringFunc(text, func(m string) string {
+		name := placeholderPattern.FindStringSubmatch(m)[1]
+		if v, ok := r.Lookup(name); ok {
+			return v
+		}
+		missing = append(missing, name)
+		return m
+	})
+	slices.Sort(missing)
+	return out, slices.Compact(missing)
+}
+
+// placeholders returns the names used in texts, sorted and unique.
+func placeholders(texts ...string) []string {
+	var names []string
+	for _, t := range texts {
+		for _, m := range placeholderPattern.FindAllStringSubmatch(t, -1) {
+			names = append(names, m[1])
+		}
+	}
+	slices.Sort(names)
+	return slices.Compact(names)
+}
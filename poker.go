@@ -0,0 +1,196 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"sync"
+	"unicode/utf8"
+)
+
+// Planning poker estimates a card in rounds. Any client can start a round on
+// a card; participants then submit estimates, which the server keeps hidden:
+// until the round is revealed, broadcasts only say who has estimated. Once
+// revealed, everyone sees every estimate, and accepting a value writes it to
+// the card's estimate, which, unlike the round, replicates.
+//
+// Like presence, rounds are node-local: they cover the clients connected to
+// this node only.
+
+// maxEstimateLen bounds an estimate, in characters ("13", "?", "XL").
+const maxEstimateLen = 8
+
+var (
+	// ErrNoRound is returned for a poker action on a card without a round.
+	ErrNoRound = errors.New("no estimation round for this card")
+	// ErrNotRevealed is returned when a value is accepted before the round
+	// is revealed.
+	ErrNotRevealed = errors.New("estimates have not been revealed")
+	// ErrBadEstimate is returned for an empty or overlong estimate.
+	ErrBadEstimate = errors.New("invalid estimate")
+)
+
+// PokerOp is the payload of "poker" messages. Clients send an Action
+// ("start", "estimate" with Value, "reveal", "accept" with Value, or
+// "cancel"); the server answers every change with a "poker" message
+// describing the round: who has estimated, and, once revealed, the
+// estimates. Closed is set when the round ends.
+type PokerOp struct {
+	CardID    string            `json:"cardId"`
+	Action    string            `json:"action,omitempty"`
+	Value     string            `json:"value,omitempty"`
+	Voter     string            `json:"voter,omitempty"`
+	Voted     []string          `json:"voted,omitempty"`
+	Estimates map[string]string `json:"estimates,omitempty"` // by name, once revealed
+	Revealed  bool              `json:"revealed,omitempty"`
+	Closed    bool              `json:"closed,omitempty"`
+}
+
+// pokerTable tracks the open rounds, by card.
+type pokerTable struct {
+	mu     sync.Mutex
+	rounds map[string]*pokerRound
+}
+
+type pokerRound struct {
+	estimates map[string]pokerEstimate // by voter
+	revealed  bool
+}
+
+type pokerEstimate struct {
+	name  string
+	value string
+}
+
+// view describes the round on cardID for clients, hiding the estimates
+// until it is revealed. Callers hold the table lock.
+func (r *pokerRound) view(cardID string) *PokerOp {
+	op := &PokerOp{CardID: cardID, Revealed: r.revealed}
+	for _, e := range r.estimates {
+		op.Voted = append(op.Voted, e.name)
+	}
+	slices.Sort(op.Voted)
+	op.Voted = slices.Compact(op.Voted)
+	if r.revealed {
+		op.Estimates = make(map[string]string, len(r.estimates))
+		for _, e := range r.estimates {
+			op.Estimates[e.name] = e.value
+		}
+	}
+	return op
+}
+
+// Poker applies a poker action from voter, shown to others as name, and
+// broadcasts the resulting round.
+func (s *Store) Poker(op PokerOp, voter, name string) error {
+	if voter == "" {
+		return ErrNoVoter
+	}
+	if _, ok := s.GetBoard().Board.Cards[op.CardID]; !ok {
+		return ErrCardNotFound
+	}
+	if op.Action == "accept" {
+		return s.acceptEstimate(op.CardID, op.Value)
+	}
+
+	t := &s.poker
+	t.mu.Lock()
+	round := t.rounds[op.CardID]
+	var view *PokerOp
+	var err error
+	switch {
+	case op.Action == "start":
+		if t.rounds == nil {
+			t.rounds = make(map[string]*pokerRound)
+		}
+		round = &pokerRound{estimates: make(map[string]pokerEstimate)}
+		t.rounds[op.CardID] = round
+		view = round.view(op.CardID)
+	case round == nil:
+		err = ErrNoRound
+	case op.Action == "estimate":
+		if op.Value == "" || utf8.RuneCountInString(op.Value) > maxEstimateLen {
+			err = ErrBadEstimate
+			break
+		}
+		round.estimates[voter] = pokerEstimate{name: name, value: op.Value}
+		view = round.view(op.CardID)
+	case op.Action == "reveal":
+		round.revealed = true
+		view = round.view(op.CardID)
+	case op.Action == "cancel":
+		delete(t.rounds, op.CardID)
+		view = &PokerOp{CardID: op.CardID, Closed: true}
+	default:
+		err = errors.New("unknown poker action")
+	}
+	t.mu.Unlock()
+
+	if view != nil {
+		s.Broadcast(WSMessage{Type: "poker", Poker: view})
+	}
+	return err
+}
+
+// acceptEstimate writes value to the card's estimate and closes its revealed
+// round.
+func (s *Store) acceptEstimate(cardID, value string) error {
+	t := &s.poker
+	t.mu.Lock()
+	round := t.rounds[cardID]
+	t.mu.Unlock()
+	switch {
+	case round == nil:
+		return ErrNoRound
+	case !round.revealed:
+		return ErrNotRevealed
+	}
+	if err := s.SetEstimate(cardID, value); err != nil {
+		return err
+	}
+	t.mu.Lock()
+	delete(t.rounds, cardID)
+	t.mu.Unlock()
+	s.Broadcast(WSMessage{Type: "poker", Poker: &PokerOp{CardID: cardID, Value: value, Closed: true}})
+	return nil
+}
+
+// pokerName is how a participant is shown to others: signed-in users by
+// name, anonymous browsers as guests told apart by their ID.
+func pokerName(u *User, client string) string {
+	if u != nil {
+		return presenceName(u)
+	}
+	if len(client) > 4 {
+		client = client[:4]
+	}
+	return "Guest " + client
+}
+
+// PokerRounds describes the open rounds, for clients that connect while
+// they run.
+func (s *Store) PokerRounds() []*PokerOp {
+	t := &s.poker
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var ops []*PokerOp
+	for cardID, r := range t.rounds {
+		ops = append(ops, r.view(cardID))
+	}
+	return ops
+}
+
+// SetEstimate sets a card's estimate. An empty value clears it.
+func (s *Store) SetEstimate(cardID, value string) error {
+	if utf8.RuneCountInString(value) > maxEstimateLen {
+		return ErrBadEstimate
+	}
+	return s.tryMutate(func(bs *BoardState) error {
+		card, ok := bs.Board.Cards[cardID]
+		if !ok {
+			return ErrCardNotFound
+		}
+		card.Estimate = value
+		bs.Board.Cards[cardID] = card
+		return nil
+	})
+}
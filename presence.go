@@ -0,0 +1,316 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PresenceOp is the payload of the presence messages. A client sends
+// "editing" with the card whose description it focused (an empty CardID when
+// it blurs) and "presenceQuery" to ask who else is editing a card; the reply
+// is a "presence" message listing their names in Editors.
+//
+// A presenter sends "present" with the card they focus, and every client gets
+// a "presenting" message naming it and the presenter, to highlight it; an
+// empty CardID ends the presentation.
+//
+// Presence is node-local: it covers the clients connected to this node only.
+// Who is online, and which card they are editing, is shared with the cluster
+// through each node's entry in NodeConnections instead.
+type PresenceOp struct {
+	CardID    string            `json:"cardId"`
+	Editors   []string          `json:"editors,omitempty"`
+	Presenter string            `json:"presenter,omitempty"`
+	Colors    map[string]string `json:"colors,omitempty"` // of the editors and presenter
+	Away      []string          `json:"away,omitempty"`   // editors who are idle
+}
+
+// OnlineUser is a user connected to a node: their presence name and color,
+// how many connections they have open to it, the card they last started
+// editing on one of them, if they still are, and whether they are away.
+type OnlineUser struct {
+	Name        string `deep:"key" json:"name"`
+	Color       string `json:"color"`
+	Connections int    `json:"connections"`
+	CardID      string `json:"cardId,omitempty"`
+	Away        bool   `json:"away,omitempty"` // idle on every connection
+}
+
+// defaultIdleAfter is how long a user may do nothing before they are shown
+// as away, unless SetIdleAfter says otherwise.
+const defaultIdleAfter = 5 * time.Minute
+
+// presenceSet tracks who each connection is, when its user last did
+// something, which card description it is editing, and the connection
+// presenting, if any.
+type presenceSet struct {
+	mu         sync.Mutex
+	online     map[chan WSMessage]string
+	active     map[chan WSMessage]time.Time
+	away       map[string]bool // users away when who is online was last published
+	idleAfter  time.Duration
+	editing    map[chan WSMessage]editor
+	edits      int // editing starts so far, to find each user's latest
+	presenter  chan WSMessage
+	presenting editor
+}
+
+// SetIdleAfter shows users as away once they have done nothing for d. It
+// must be called before serving requests.
+func (s *Store) SetIdleAfter(d time.Duration) {
+	s.presence.idleAfter = d
+}
+
+type editor struct {
+	cardID string
+	name   string
+	seq    int
+}
+
+// join records that the client on ch is shown as name in who is online.
+func (p *presenceSet) join(ch chan WSMessage, name string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.online == nil {
+		p.online = make(map[chan WSMessage]string)
+		p.active = make(map[chan WSMessage]time.Time)
+	}
+	p.online[ch] = name
+	p.active[ch] = time.Now()
+}
+
+// leave forgets the client on ch, reporting whether it was online.
+func (p *presenceSet) leave(ch chan WSMessage) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, ok := p.online[ch]
+	delete(p.online, ch)
+	delete(p.active, ch)
+	delete(p.editing, ch)
+	return ok
+}
+
+// touch records activity on ch, reporting whether its user was away.
+func (p *presenceSet) touch(ch chan WSMessage) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	name, ok := p.online[ch]
+	if !ok {
+		return false
+	}
+	p.active[ch] = time.Now()
+	return p.away[name]
+}
+
+// awayUsers returns the users idle on all their connections at now. Callers
+// hold p.mu.
+func (p *presenceSet) awayUsers(now time.Time) map[string]bool {
+	idleAfter := cmp.Or(p.idleAfter, defaultIdleAfter)
+	away := map[string]bool{}
+	for ch, name := range p.online {
+		if now.Sub(p.active[ch]) < idleAfter {
+			away[name] = false
+		} else if _, seen := away[name]; !seen {
+			away[name] = true
+		}
+	}
+	maps.DeleteFunc(away, func(_ string, v bool) bool { return !v })
+	return away
+}
+
+// awayChanged reports whether anyone went away, or came back, since who is
+// online was last published.
+func (p *presenceSet) awayChanged() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return !maps.Equal(p.awayUsers(time.Now()), p.away)
+}
+
+// NoteActivity records that the user on ch did something, as opposed to the
+// messages their page sends on its own. A user who was away is back at once.
+func (s *Store) NoteActivity(ch chan WSMessage) {
+	if !s.presence.touch(ch) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.updateConnectionsLocked(s.hub.Len())
+}
+
+// Away returns those of names who are away from this node.
+func (s *Store) Away(names []string) []string {
+	p := &s.presence
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	away := p.awayUsers(time.Now())
+	var list []string
+	for _, name := range names {
+		if away[name] {
+			list = append(list, name)
+		}
+	}
+	return list
+}
+
+// SetEditing records that the client on ch is editing cardID under the given
+// display name, and publishes it to who is online. An empty cardID clears it.
+func (s *Store) SetEditing(ch chan WSMessage, cardID, name string) {
+	p := &s.presence
+	p.mu.Lock()
+	if cardID == "" {
+		delete(p.editing, ch)
+	} else {
+		if p.editing == nil {
+			p.editing = make(map[chan WSMessage]editor)
+		}
+		p.edits++
+		p.editing[ch] = editor{cardID: cardID, name: name, seq: p.edits}
+	}
+	p.mu.Unlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.updateConnectionsLocked(s.hub.Len())
+}
+
+// dropEditing forgets that clients are editing cards for which exists is
+// false.
+func (p *presenceSet) dropEditing(exists func(cardID string) bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	maps.DeleteFunc(p.editing, func(_ chan WSMessage, e editor) bool { return !exists(e.cardID) })
+}
+
+// onlineUsers returns who is connected to this node, sorted by name.
+func (p *presenceSet) onlineUsers() []OnlineUser {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.away = p.awayUsers(time.Now())
+	users := map[string]*OnlineUser{}
+	latest := map[string]int{}
+	for ch, name := range p.online {
+		u := users[name]
+		if u == nil {
+			u = &OnlineUser{Name: name, Away: p.away[name]}
+			users[name] = u
+		}
+		u.Connections++
+		if e, ok := p.editing[ch]; ok && e.seq > latest[name] {
+			u.CardID, latest[name] = e.cardID, e.seq
+		}
+	}
+	list := make([]OnlineUser, 0, len(users))
+	for _, u := range users {
+		list = append(list, *u)
+	}
+	slices.SortFunc(list, func(a, b OnlineUser) int { return strings.Compare(a.Name, b.Name) })
+	return list
+}
+
+// Editors returns the sorted names of the clients other than ch that are
+// editing cardID.
+func (s *Store) Editors(cardID string, ch chan WSMessage) []string {
+	p := &s.presence
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var names []string
+	for c, e := range p.editing {
+		if c != ch && e.cardID == cardID && !slices.Contains(names, e.name) {
+			names = append(names, e.name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Present makes the client on ch the presenter, showing cardID to everyone,
+// and broadcasts it. It takes over from any other presenter. An empty cardID
+// ends the presentation if ch is presenting.
+func (s *Store) Present(ch chan WSMessage, cardID, name string) {
+	p := &s.presence
+	p.mu.Lock()
+	if cardID == "" && p.presenter != ch {
+		p.mu.Unlock()
+		return
+	}
+	if cardID == "" {
+		p.presenter, p.presenting = nil, editor{}
+	} else {
+		p.presenter, p.presenting = ch, editor{cardID: cardID, name: name}
+	}
+	op := p.presentingOp()
+	p.mu.Unlock()
+	op.Colors = userColorMap(s.snap.Load().state.Board, op.Presenter)
+	s.Broadcast(WSMessage{Type: "presenting", Presence: op})
+}
+
+// Presenting describes the current presentation, for clients that connect
+// during one. It returns nil if nobody is presenting.
+func (s *Store) Presenting() *PresenceOp {
+	p := &s.presence
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.presenter == nil {
+		return nil
+	}
+	op := p.presentingOp()
+	op.Colors = userColorMap(s.snap.Load().state.Board, op.Presenter)
+	return op
+}
+
+// presentingOp describes the presentation. Callers hold p.mu.
+func (p *presenceSet) presentingOp() *PresenceOp {
+	return &PresenceOp{CardID: p.presenting.cardID, Presenter: p.presenting.name}
+}
+
+// presenceName is how a user is shown to others in presence warnings.
+func presenceName(u *User) string {
+	if u == nil {
+		return "Someone"
+	}
+	if u.Name != "" {
+		return u.Name
+	}
+	return u.ID
+}
+
+// NodePresence is a node's entry in /api/presence.
+type NodePresence struct {
+	Node        string       `json:"node"`
+	Local       bool         `json:"local"` // the node that answered
+	Connections int          `json:"connections"`
+	Users       []OnlineUser `json:"users"`
+}
+
+// Presence returns who is online on every node of the cluster that has
+// anyone connected, sorted by node, as last heard from each.
+func (s *Store) Presence() []NodePresence {
+	nodes := []NodePresence{}
+	for _, nc := range s.GetBoard().NodeConnections {
+		if nc.Count == 0 {
+			continue
+		}
+		users := nc.Users
+		if users == nil {
+			users = []OnlineUser{}
+		}
+		nodes = append(nodes, NodePresence{Node: nc.NodeID, Local: nc.NodeID == s.nodeID, Connections: nc.Count, Users: users})
+	}
+	slices.SortFunc(nodes, func(a, b NodePresence) int { return strings.Compare(a.Node, b.Node) })
+	return nodes
+}
+
+// handlePresence serves GET /api/presence, who is online across the cluster.
+func handlePresence(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.Presence())
+	}
+}
@@ -19,6 +19,7 @@ type Backend struct {
 	URL          *url.URL
 	ReverseProxy *httputil.ReverseProxy
 	Alive        bool
+	node         string // DeepBoard node ID from its last response, "" until then
 	mux          sync.RWMutex
 }
 
@@ -35,6 +36,29 @@ func (b *Backend) IsAlive() bool {
 	return alive
 }
 
+// Node returns the DeepBoard node ID the backend last answered as.
+func (b *Backend) Node() string {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+	return b.node
+}
+
+// observeNode records the node ID of a response from the backend. An
+// address that starts answering as another node has been restarted with a
+// new ID or reassigned by discovery.
+func (b *Backend) observeNode(node string) {
+	if node == "" {
+		return
+	}
+	b.mux.Lock()
+	prev := b.node
+	b.node = node
+	b.mux.Unlock()
+	if prev != "" && prev != node {
+		log.Printf("Backend %s (id=%s) now serves node %s instead of %s", b.URL, b.ID, node, prev)
+	}
+}
+
 type ServerPool struct {
 	backends []*Backend
 	current  uint64
@@ -93,6 +117,18 @@ func (s *ServerPool) GetBackendByID(id string) *Backend {
 	return nil
 }
 
+// GetBackendByNode looks up a backend by the DeepBoard node it serves.
+func (s *ServerPool) GetBackendByNode(node string) *Backend {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	for _, b := range s.backends {
+		if b.Node() == node {
+			return b
+		}
+	}
+	return nil
+}
+
 // GetBackendByURL looks up a backend by its full URL string.
 func (s *ServerPool) GetBackendByURL(u string) *Backend {
 	s.mux.RLock()
@@ -129,6 +165,14 @@ var serverPool ServerPool
 
 const stickyCookieName = "SERVERID"
 
+// Backends name themselves in nodeHeader. The proxy passes it on to clients
+// and also pins them to it with nodeCookieName, so affinity can be checked
+// against the node a session actually talked to.
+const (
+	nodeHeader     = "X-DeepBoard-Node"
+	nodeCookieName = "DEEPBOARD_NODE"
+)
+
 var backendCounter uint64
 
 func newBackendID() string {
@@ -161,12 +205,7 @@ func lbHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Check for sticky cookie
 	if backend == nil {
-		if cookie, err := r.Cookie(stickyCookieName); err == nil {
-			backend = serverPool.GetBackendByID(cookie.Value)
-			if backend != nil && !backend.IsAlive() {
-				backend = nil
-			}
-		}
+		backend = stickyBackend(w, r)
 	}
 
 	if backend == nil {
@@ -185,6 +224,33 @@ func lbHandler(w http.ResponseWriter, r *http.Request) {
 	backend.ReverseProxy.ServeHTTP(w, r)
 }
 
+// stickyBackend returns the live backend r is pinned to, if any. The
+// SERVERID cookie names a backend, which is an address, while the node cookie
+// names the node that last answered. When the address no longer serves that
+// node, the session follows the node to its new address if it has one.
+func stickyBackend(w http.ResponseWriter, r *http.Request) *Backend {
+	var backend *Backend
+	if cookie, err := r.Cookie(stickyCookieName); err == nil {
+		backend = serverPool.GetBackendByID(cookie.Value)
+	}
+	if cookie, err := r.Cookie(nodeCookieName); err == nil && cookie.Value != "" {
+		if backend == nil || (backend.Node() != "" && backend.Node() != cookie.Value) {
+			if b := serverPool.GetBackendByNode(cookie.Value); b != nil && b.IsAlive() {
+				log.Printf("Affinity: following node %s to %s", cookie.Value, b.URL)
+				setCookie(w, b)
+				return b
+			}
+			if backend != nil {
+				log.Printf("Affinity: node %s is gone, %s now serves %s", cookie.Value, backend.URL, backend.Node())
+			}
+		}
+	}
+	if backend != nil && !backend.IsAlive() {
+		return nil
+	}
+	return backend
+}
+
 func main() {
 	backendStr := os.Getenv("BACKENDS")
 	if backendStr != "" {
@@ -280,6 +346,15 @@ func addBackend(target *url.URL) {
 		ReverseProxy: proxy,
 		Alive:        true,
 	}
+	// Learn which node answered and pin the client to it.
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		node := resp.Header.Get(nodeHeader)
+		backend.observeNode(node)
+		if node != "" {
+			resp.Header.Add("Set-Cookie", (&http.Cookie{Name: nodeCookieName, Value: node, Path: "/"}).String())
+		}
+		return nil
+	}
 	serverPool.AddBackend(backend)
 	log.Printf("Added new backend: %s (id=%s)", target, backend.ID)
 }
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// The board is installable as a Progressive Web App. The service worker keeps
+// the last copy of the page and the board so they open without a network;
+// edits made meanwhile are queued by the page and replayed on reconnect.
+
+// serviceWorkerJS caches the app shell network first: online it always serves
+// fresh responses, offline it falls back to the last one seen. Everything
+// else (the API, the WebSocket) goes straight to the network.
+const serviceWorkerJS = `
+const CACHE = 'deepboard-v1';
+
+self.addEventListener('install', () => self.skipWaiting());
+self.addEventListener('activate', e => e.waitUntil(self.clients.claim()));
+
+self.addEventListener('fetch', e => {
+    const req = e.request;
+    if (req.method !== 'GET') return;
+    const url = new URL(req.url);
+    const scope = new URL(self.registration.scope).pathname;
+    const shell = url.origin !== location.origin || url.pathname === scope || url.pathname === scope + 'board';
+    if (!shell) return;
+    e.respondWith(fetch(req).then(resp => {
+        if (resp.ok || resp.type === 'opaque') {
+            const copy = resp.clone();
+            caches.open(CACHE).then(c => c.put(req, copy));
+        }
+        return resp;
+    }).catch(() => caches.match(req, {ignoreSearch: true})));
+});
+`
+
+const appIconSVG = `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 512 512">
+<rect width="512" height="512" rx="96" fill="#2c3e50"/>
+<rect x="72" y="96" width="104" height="320" rx="16" fill="#3498db"/>
+<rect x="204" y="96" width="104" height="220" rx="16" fill="#f39c12"/>
+<rect x="336" y="96" width="104" height="140" rx="16" fill="#27ae60"/>
+</svg>`
+
+// handleServiceWorker serves the service worker. It is served under the
+// board's base path so its scope covers that board only.
+func handleServiceWorker(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/javascript")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write([]byte(serviceWorkerJS))
+}
+
+func handleManifest(w http.ResponseWriter, r *http.Request) {
+	base := requestBase(r)
+	w.Header().Set("Content-Type", "application/manifest+json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"name":             "DeepBoard",
+		"short_name":       "DeepBoard",
+		"start_url":        base + "/",
+		"scope":            base + "/",
+		"display":          "standalone",
+		"background_color": "#f0f2f5",
+		"theme_color":      "#2c3e50",
+		"icons": []map[string]string{
+			{"src": base + "/icon.svg", "sizes": "any", "type": "image/svg+xml"},
+		},
+	})
+}
+
+func handleIcon(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Header().Set("Cache-Control", "max-age=86400")
+	w.Write([]byte(appIconSVG))
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// ErrQuotaExceeded is returned by mutations that would take the board past
+// one of its configured limits.
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// ErrUploadTooLarge is returned for uploaded files over the board's upload
+// limit.
+var ErrUploadTooLarge = errors.New("uploaded file too large")
+
+// Quota bounds the growth of a board. The whole board is a single replicated
+// CRDT, so without limits one busy board can grow every node's memory and
+// sync payloads without bound. Zero means unlimited.
+type Quota struct {
+	MaxCards       int // cards on the board
+	MaxDescription int // bytes of visible description text per card
+	MaxHistory     int // rows kept in the local history table
+	MaxBoardSize   int // bytes of the stored CRDT, deleted text and metadata included
+	MaxUpload      int // bytes per uploaded file, such as an import
+}
+
+// QuotaError is the ErrQuotaExceeded of an edit over one of the limits. It
+// is sent to clients as the details of the error.
+type QuotaError struct {
+	Limit string `json:"limit"` // "cards", "description" or "boardSize"
+	Max   int    `json:"max"`
+}
+
+func (e *QuotaError) Error() string {
+	switch e.Limit {
+	case "cards":
+		return fmt.Sprintf("%v: the board is limited to %d cards", ErrQuotaExceeded, e.Max)
+	case "description":
+		return fmt.Sprintf("%v: descriptions are limited to %d bytes", ErrQuotaExceeded, e.Max)
+	}
+	return fmt.Sprintf("%v: the board is limited to %d bytes", ErrQuotaExceeded, e.Max)
+}
+
+func (e *QuotaError) Unwrap() error { return ErrQuotaExceeded }
+
+func (q Quota) checkCards(n int) error {
+	if q.MaxCards > 0 && n > q.MaxCards {
+		return &QuotaError{"cards", q.MaxCards}
+	}
+	return nil
+}
+
+func (q Quota) checkDescription(n int) error {
+	if q.MaxDescription > 0 && n > q.MaxDescription {
+		return &QuotaError{"description", q.MaxDescription}
+	}
+	return nil
+}
+
+// checkGrowth rejects an edit adding n bytes of content if it would take the
+// board past its size limit. It must be called from an edit. The size is
+// that of the CRDT as last saved; deleted text stays in it as tombstones, so
+// deleting does not make room, but it is always allowed. Edits from peers
+// are never rejected, or replicas would diverge.
+func (s *Store) checkGrowth(n int) error {
+	q := s.quota
+	if q.MaxBoardSize > 0 && n > 0 && len(s.snap.Load().crdtJSON)+n > q.MaxBoardSize {
+		return &QuotaError{"boardSize", q.MaxBoardSize}
+	}
+	return nil
+}
+
+// readUpload reads the file uploaded as r's body. A file over the upload
+// limit is ErrUploadTooLarge; nothing past the limit is read.
+func (s *Store) readUpload(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	body := r.Body
+	if s.quota.MaxUpload > 0 {
+		body = http.MaxBytesReader(w, r.Body, int64(s.quota.MaxUpload))
+	}
+	data, err := io.ReadAll(body)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return nil, fmt.Errorf("%w: files are limited to %d bytes", ErrUploadTooLarge, tooLarge.Limit)
+	}
+	return data, err
+}
+
+// SetQuota sets the board's limits. It must be called before serving requests.
+func (s *Store) SetQuota(q Quota) {
+	s.quota = q
+}
+
+// pruneHistory drops the oldest history rows beyond the quota.
+func (s *Store) pruneHistory(tx *sql.Tx) error {
+	if s.quota.MaxHistory <= 0 {
+		return nil
+	}
+	_, err := tx.Exec("DELETE FROM patches WHERE id <= (SELECT id FROM patches ORDER BY id DESC LIMIT 1 OFFSET ?)",
+		s.quota.MaxHistory)
+	return err
+}
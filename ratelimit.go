@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited is returned when a client made too many requests.
+var ErrRateLimited = errors.New("too many requests; retry later")
+
+// rateWindow is how long rate limits count requests for.
+const rateWindow = time.Minute
+
+// peerRoutes are the node-to-node routes, which rate limits leave alone so
+// replication keeps up under load.
+var peerRoutes = []string{"/api/sync", "/api/state", "/api/digest", "/api/replicate", "/api/relay"}
+
+// rateLimiter allows each client limit requests per window. Windows are
+// fixed and shared by all clients, so one map of counts is reset at once.
+type rateLimiter struct {
+	limit int
+
+	mu     sync.Mutex
+	start  time.Time // of the current window
+	counts map[string]int
+}
+
+func newRateLimiter(limit int) *rateLimiter {
+	return &rateLimiter{limit: limit, counts: make(map[string]int)}
+}
+
+// take counts a request by key at now and reports whether it is allowed,
+// how many more are and when the window resets.
+func (l *rateLimiter) take(key string, now time.Time) (ok bool, remaining int, reset time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if start := now.Truncate(rateWindow); !start.Equal(l.start) {
+		l.start = start
+		clear(l.counts)
+	}
+	reset = l.start.Add(rateWindow)
+	if l.counts[key] >= l.limit {
+		return false, 0, reset
+	}
+	l.counts[key]++
+	return true, l.limit - l.counts[key], reset
+}
+
+// rateKey identifies the client of r: its bearer token, else its signed-in
+// user, else its address. It also reports whether the client is a token,
+// which has its own limit.
+func rateKey(r *http.Request) (string, bool) {
+	if token, ok := bearerToken(r); ok {
+		return "token:" + hashToken(token), true
+	}
+	if authn != nil {
+		if u := authn.userFromRequest(r); u != nil {
+			return "user:" + u.ID, false
+		}
+	}
+	return "ip:" + requestIP(r), false
+}
+
+// withRateLimit limits each user or address to users requests per minute
+// and each API token to tokens, 0 meaning unlimited. Responses carry the
+// client's X-RateLimit-Limit, X-RateLimit-Remaining and X-RateLimit-Reset
+// (Unix seconds); refused ones get a 429 with Retry-After.
+func withRateLimit(users, tokens int, h http.Handler) http.Handler {
+	if users <= 0 && tokens <= 0 {
+		return h
+	}
+	limiters := map[bool]*rateLimiter{}
+	if users > 0 {
+		limiters[false] = newRateLimiter(users)
+	}
+	if tokens > 0 {
+		limiters[true] = newRateLimiter(tokens)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, token := rateKey(r)
+		l := limiters[token]
+		if l == nil || slices.ContainsFunc(peerRoutes, func(p string) bool { return strings.HasSuffix(r.URL.Path, p) }) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		now := time.Now()
+		ok, remaining, reset := l.take(key, now)
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(l.limit))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(reset.Sub(now).Seconds()))))
+			writeMutationError(w, ErrRateLimited)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
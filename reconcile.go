@@ -0,0 +1,224 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/brunoga/deep/v5/crdt"
+)
+
+// reconcileMinPartition is how long a peer must have been unreachable before
+// healing the partition produces a reconciliation report.
+var reconcileMinPartition = peerDegradedWindow
+
+// ReconciledCard is a card that changed on both sides of a partition. Title
+// and Column reflect the merged result.
+type ReconciledCard struct {
+	CardID  string  `json:"cardId"`
+	Title   string  `json:"title"`
+	Column  string  `json:"column,omitempty"`
+	Deleted bool    `json:"deleted,omitempty"`
+	Local   []Event `json:"local"`
+	Remote  []Event `json:"remote"`
+}
+
+// ReconciliationReport lists the cards edited concurrently while this node
+// and a peer could not reach each other, so the merged result can be checked
+// against what both teams intended.
+type ReconciliationReport struct {
+	ID             int64            `json:"id"`
+	Peer           string           `json:"peer"`
+	PartitionStart int64            `json:"partitionStart"`
+	PartitionEnd   int64            `json:"partitionEnd"`
+	Cards          []ReconciledCard `json:"cards"`
+}
+
+// endPartition returns when peer was last known to be in sync if it has been
+// unreachable since, and clears the marker.
+func (s *Store) endPartition(peer string) time.Time {
+	s.peerMu.Lock()
+	defer s.peerMu.Unlock()
+	st, ok := s.peerStatus[peer]
+	if !ok {
+		return time.Time{}
+	}
+	since := st.PartitionedSince
+	st.PartitionedSince = time.Time{}
+	return since
+}
+
+// MergeFromPeer merges a peer's full state. If it ends a long partition, the
+// cards changed on both sides are recorded in a reconciliation report.
+func (s *Store) MergeFromPeer(peer string, other *crdt.CRDT[BoardState]) {
+	since := s.endPartition(peer)
+	if since.IsZero() || time.Since(since) < reconcileMinPartition {
+		s.Merge(other)
+		return
+	}
+
+	var cursor int64
+	if err := s.db.QueryRow("SELECT COALESCE(MAX(id), 0) FROM changes").Scan(&cursor); err != nil {
+		log.Printf("Reconcile: failed to read change cursor: %v", err)
+		s.Merge(other)
+		return
+	}
+	if !s.Merge(other) {
+		return
+	}
+	report, err := s.reconcile(peer, since, cursor)
+	if err != nil {
+		log.Printf("Reconcile: failed to build report for %s: %v", peer, err)
+		return
+	}
+	log.Printf("Partition with %s healed after %s: %d card(s) changed on both sides",
+		peer, time.Since(since).Round(time.Second), len(report.Cards))
+}
+
+// reconcile pairs local changes made since the partition started with the
+// remote changes recorded by the merge (those after cursor).
+func (s *Store) reconcile(peer string, since time.Time, cursor int64) (ReconciliationReport, error) {
+	report := ReconciliationReport{
+		Peer:           peer,
+		PartitionStart: since.UnixMilli(),
+		PartitionEnd:   time.Now().UnixMilli(),
+		Cards:          []ReconciledCard{},
+	}
+
+	local := map[string][]Event{}
+	rows, err := s.db.Query("SELECT data FROM changes WHERE id <= ? ORDER BY id DESC", cursor)
+	if err != nil {
+		return report, err
+	}
+	err = scanEvents(rows, func(ev Event) bool {
+		if ev.Time < report.PartitionStart {
+			return false
+		}
+		if !ev.Remote {
+			local[ev.CardID] = append([]Event{ev}, local[ev.CardID]...)
+		}
+		return true
+	})
+	if err != nil {
+		return report, err
+	}
+
+	remote := map[string][]Event{}
+	var order []string
+	rows, err = s.db.Query("SELECT data FROM changes WHERE id > ? ORDER BY id ASC", cursor)
+	if err != nil {
+		return report, err
+	}
+	err = scanEvents(rows, func(ev Event) bool {
+		if ev.Remote {
+			if _, seen := remote[ev.CardID]; !seen {
+				order = append(order, ev.CardID)
+			}
+			remote[ev.CardID] = append(remote[ev.CardID], ev)
+		}
+		return true
+	})
+	if err != nil {
+		return report, err
+	}
+
+	cards := s.GetBoard().Board.Cards
+	for _, id := range order {
+		if len(local[id]) == 0 {
+			continue
+		}
+		rc := ReconciledCard{CardID: id, Local: local[id], Remote: remote[id]}
+		if c, ok := cards[id]; ok {
+			rc.Title, rc.Column = c.Title, c.ColumnID
+		} else {
+			rc.Deleted = true
+			for _, ev := range slices.Concat(rc.Local, rc.Remote) {
+				if ev.Title != "" {
+					rc.Title = ev.Title
+				}
+			}
+		}
+		report.Cards = append(report.Cards, rc)
+	}
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		return report, err
+	}
+	res, err := s.db.Exec("INSERT INTO reconciliations (data) VALUES (?)", data)
+	if err != nil {
+		return report, err
+	}
+	report.ID, _ = res.LastInsertId()
+	return report, nil
+}
+
+// scanEvents decodes change rows, stopping early when fn returns false.
+func scanEvents(rows *sql.Rows, fn func(Event) bool) error {
+	defer rows.Close()
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return err
+		}
+		var ev Event
+		if err := json.Unmarshal(data, &ev); err != nil {
+			continue
+		}
+		if !fn(ev) {
+			break
+		}
+	}
+	return rows.Err()
+}
+
+// GetReconciliations returns the newest reconciliation reports.
+func (s *Store) GetReconciliations(limit int) ([]ReconciliationReport, error) {
+	rows, err := s.db.Query("SELECT id, data FROM reconciliations ORDER BY id DESC LIMIT ?", limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reports := []ReconciliationReport{}
+	for rows.Next() {
+		var id int64
+		var data []byte
+		if err := rows.Scan(&id, &data); err != nil {
+			return nil, err
+		}
+		var r ReconciliationReport
+		if err := json.Unmarshal(data, &r); err != nil {
+			continue
+		}
+		r.ID = id
+		reports = append(reports, r)
+	}
+	return reports, rows.Err()
+}
+
+// handleReconciliations serves GET /api/reconciliations?limit=N.
+func handleReconciliations(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit := 20
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				writeError(w, "invalid limit", http.StatusBadRequest)
+				return
+			}
+			limit = min(n, 100)
+		}
+		reports, err := s.GetReconciliations(limit)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(reports)
+	}
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brunoga/deep/v5/crdt"
+	"github.com/gorilla/websocket"
+)
+
+// A node that cannot accept inbound connections (behind NAT or a firewall)
+// joins the cluster as an edge of a relay node, any reachable node, given
+// with -relay. Per board, the edge keeps one outbound WebSocket to the
+// relay's /api/relay: it sends its full state on connect and its deltas as
+// it makes them, and receives every delta the relay applies or makes. The
+// relay forwards deltas from an edge to its own peers and its other edges.
+// Full state is still pulled from the relay periodically, outbound too.
+
+// Relay link message types.
+const (
+	relayState = "state" // full CRDT, edge to relay on connect
+	relayDelta = "delta"
+)
+
+// relayEdgeQueue bounds the messages waiting to be sent to one edge. An edge
+// that falls behind misses deltas and catches up at its next state pull.
+const relayEdgeQueue = 256
+
+// ErrRelayDown is returned when sending through a relay link that is not
+// connected.
+var ErrRelayDown = errors.New("relay link is down")
+
+type relayMessage struct {
+	Type   string `json:"type"`
+	Data   []byte `json:"data"`
+	Digest string `json:"digest,omitempty"`
+}
+
+// relayEdges are the edges connected to a board on its relay node.
+type relayEdges struct {
+	mu    sync.Mutex
+	edges map[chan relayMessage]string // edge node ID
+}
+
+func (r *relayEdges) add(node string) chan relayMessage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.edges == nil {
+		r.edges = make(map[chan relayMessage]string)
+	}
+	ch := make(chan relayMessage, relayEdgeQueue)
+	r.edges[ch] = node
+	return ch
+}
+
+func (r *relayEdges) remove(ch chan relayMessage) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.edges, ch)
+}
+
+// Len returns how many edges are connected.
+func (r *relayEdges) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.edges)
+}
+
+// forward queues msg for every edge but except, dropping it for edges that
+// are behind.
+func (r *relayEdges) forward(msg relayMessage, except chan relayMessage) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for ch, node := range r.edges {
+		if ch == except {
+			continue
+		}
+		select {
+		case ch <- msg:
+		default:
+			log.Printf("Relay: edge %s is behind, dropping a delta", node)
+		}
+	}
+}
+
+// forwardToEdges sends an encoded delta to every edge relayed by this node
+// but except. digest is this node's digest after the change, "" to compute
+// it.
+func (s *Store) forwardToEdges(data []byte, digest string, except chan relayMessage) {
+	if s.edges.Len() == 0 {
+		return
+	}
+	if digest == "" {
+		digest = s.Digest().Digest
+	}
+	s.edges.forward(relayMessage{Type: relayDelta, Data: data, Digest: digest}, except)
+}
+
+// mergeEdgeState merges the full state an edge sent on connect.
+func (s *Store) mergeEdgeState(node string, data []byte) {
+	data, err := upgradePayload(payloadState, data)
+	if err != nil {
+		log.Printf("Relay: rejected the state of edge %s: %v", node, err)
+		return
+	}
+	other := crdt.NewCRDT(BoardState{}, s.nodeID)
+	if err := json.Unmarshal(data, other); err != nil {
+		log.Printf("Relay: undecodable state from edge %s: %v", node, err)
+		return
+	}
+	if s.Merge(other) {
+		log.Printf("Relay: merged state from edge %s", node)
+	}
+}
+
+// handleRelay serves edge nodes, /api/relay?node=<id>.
+func handleRelay(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		node := r.URL.Query().Get("node")
+		ch := s.edges.add(node)
+		defer s.edges.remove(ch)
+		log.Printf("Relay: edge %s connected from %s", node, r.RemoteAddr)
+
+		// Pings keep NAT mappings on the edge's side from expiring.
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			ticker := time.NewTicker(pingPeriod)
+			defer ticker.Stop()
+			for {
+				var err error
+				select {
+				case msg := <-ch:
+					conn.SetWriteDeadline(time.Now().Add(writeWait))
+					err = conn.WriteJSON(msg)
+				case <-ticker.C:
+					err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait))
+				case <-done:
+					return
+				}
+				if err != nil {
+					conn.Close()
+					return
+				}
+			}
+		}()
+
+		for {
+			var msg relayMessage
+			if err := conn.ReadJSON(&msg); err != nil {
+				log.Printf("Relay: edge %s disconnected: %v", node, err)
+				return
+			}
+			switch msg.Type {
+			case relayState:
+				s.mergeEdgeState(node, msg.Data)
+			case relayDelta:
+				if err := s.applyPushedDelta(msg.Data, msg.Digest, r.RemoteAddr); err != nil {
+					continue
+				}
+				digest := s.Digest().Digest
+				s.forwardToEdges(msg.Data, digest, ch)
+				go s.pushToPeers(msg.Data, digest)
+			}
+		}
+	}
+}
+
+// relayTransport is the transport of an edge node: deltas for the relay go
+// up the board's relay link, everything else over base.
+type relayTransport struct {
+	relay string
+	base  Transport
+
+	mu    sync.Mutex
+	links map[string]*relayLink // by board
+}
+
+func newRelayTransport(relay string, base Transport) *relayTransport {
+	return &relayTransport{relay: relay, base: base, links: make(map[string]*relayLink)}
+}
+
+// link returns the relay link of board.
+func (t *relayTransport) link(board string) *relayLink {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	l, ok := t.links[board]
+	if !ok {
+		l = &relayLink{}
+		t.links[board] = l
+	}
+	return l
+}
+
+func (t *relayTransport) SendDelta(peer, board string, delta []byte, digest string) error {
+	if peer != t.relay {
+		return t.base.SendDelta(peer, board, delta, digest)
+	}
+	return t.link(board).send(relayMessage{Type: relayDelta, Data: delta, Digest: digest})
+}
+
+func (t *relayTransport) FetchState(peer, board string) ([]byte, error) {
+	return t.base.FetchState(peer, board)
+}
+
+func (t *relayTransport) Digest(peer, board string) (DigestInfo, error) {
+	return t.base.Digest(peer, board)
+}
+
+// relayLink is an edge's connection to its relay for one board.
+type relayLink struct {
+	mu   sync.Mutex // serializes writes
+	conn *websocket.Conn
+}
+
+func (l *relayLink) send(msg relayMessage) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.conn == nil {
+		return ErrRelayDown
+	}
+	l.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	return l.conn.WriteJSON(msg)
+}
+
+// run keeps s connected to the relay until s is closed, reconnecting with
+// backoff.
+func (l *relayLink) run(s *Store, relay string) {
+	url := "ws" + strings.TrimPrefix(peerBoardURL(relay, s.basePath, "/api/relay"), "http") + "?node=" + s.nodeID
+	backoff := minSyncInterval
+	for {
+		dialer := websocket.Dialer{HandshakeTimeout: peerHTTPClient.Timeout}
+		conn, _, err := dialer.Dial(url, peerHeader())
+		if err == nil {
+			log.Printf("Relay: connected to %s", relay)
+			backoff = minSyncInterval
+			l.serve(s, relay, conn)
+			log.Printf("Relay: lost connection to %s", relay)
+		} else {
+			log.Printf("Relay: failed to connect to %s: %v", relay, err)
+		}
+		select {
+		case <-time.After(backoff):
+		case <-s.done:
+			return
+		}
+		backoff = min(backoff*2, defaultSyncInterval)
+	}
+}
+
+// serve sends s's state up conn and applies the deltas coming down until
+// the connection or s is closed.
+func (l *relayLink) serve(s *Store, relay string, conn *websocket.Conn) {
+	l.mu.Lock()
+	l.conn = conn
+	l.mu.Unlock()
+	defer func() {
+		l.mu.Lock()
+		l.conn = nil
+		l.mu.Unlock()
+		conn.Close()
+	}()
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-s.done:
+			conn.Close()
+		case <-stop:
+		}
+	}()
+
+	// The relay cannot pull from us, so hand it everything made while the
+	// link was down.
+	if err := l.send(relayMessage{Type: relayState, Data: s.snap.Load().crdtJSON}); err != nil {
+		return
+	}
+	for {
+		var msg relayMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			return
+		}
+		if msg.Type == relayDelta {
+			s.receiveDelta(msg.Data, msg.Digest, relay)
+		}
+	}
+}
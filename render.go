@@ -0,0 +1,34 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"sync"
+)
+
+// Pages are rendered into a pooled buffer before anything is written, so a
+// template error becomes a clean 500 rather than half a page, and busy
+// boards do not allocate a fresh buffer per request.
+
+// maxPooledBuffer caps the buffers kept for reuse; rare huge renders, like
+// a board with every card of a large column, are left to the collector.
+const maxPooledBuffer = 4 << 20
+
+var renderBuffers = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+
+// render executes tmpl with data and writes the result to w.
+func render(w http.ResponseWriter, tmpl *template.Template, data any) {
+	buf := renderBuffers.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer func() {
+		if buf.Cap() <= maxPooledBuffer {
+			renderBuffers.Put(buf)
+		}
+	}()
+	if err := tmpl.Execute(buf, data); err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Concurrent edits on different nodes can leave the board inconsistent in
+// ways no single edit could: a card moved into a column deleted elsewhere,
+// a user shown editing a card deleted elsewhere. Every node periodically
+// repairs what breaks the invariants checked at startup (see integrity.go)
+// in one edit, and logs what it fixed. Cards of unknown columns move to the
+// end of the default column, cards users are shown editing that no longer
+// exist are cleared, and of items sharing a key the first is kept. As with
+// card keys, nodes working from the same state make the same repair. Admins
+// can run it at once with POST /api/admin/repair.
+
+// repairInterval is how often each node looks for something to repair.
+const repairInterval = time.Minute
+
+// repairBoard fixes what is inconsistent in bs and returns what it fixed.
+func repairBoard(bs *BoardState) []string {
+	var fixed []string
+	b := &bs.Board
+	b.Columns = dropDuplicates("column", b.Columns, func(c Column) string { return c.ID }, &fixed)
+	b.Templates = dropDuplicates("template", b.Templates, func(t CardTemplate) string { return t.Name }, &fixed)
+	b.Sprints = dropDuplicates("sprint", b.Sprints, func(sp Sprint) string { return sp.ID }, &fixed)
+	b.Views = dropDuplicates("view", b.Views, func(v BoardView) string { return v.ID }, &fixed)
+	b.Bots = dropDuplicates("bot", b.Bots, func(bot Bot) string { return bot.ID }, &fixed)
+	b.Embeds = dropDuplicates("embed", b.Embeds, func(e Embed) string { return e.ID }, &fixed)
+	b.Colors = dropDuplicates("color", b.Colors, func(c UserColor) string { return c.Name }, &fixed)
+	b.Boards = dropDuplicates("board", b.Boards, func(r BoardRef) string { return r.Name }, &fixed)
+	b.Users = dropDuplicates("user", b.Users, func(u BoardUser) string { return u.ID }, &fixed)
+	bs.NodeConnections = dropDuplicates("node connection", bs.NodeConnections, func(n NodeConnection) string { return n.NodeID }, &fixed)
+
+	var orphans []Card
+	for _, key := range slices.Sorted(maps.Keys(b.Cards)) {
+		c := b.Cards[key]
+		if c.ID != key {
+			fixed = append(fixed, fmt.Sprintf("card %q stored as %q took its key", c.ID, key))
+			c.ID = key
+		}
+		c.Comments = dropDuplicates(fmt.Sprintf("comment of card %q", key), c.Comments, func(cm Comment) string { return cm.ID }, &fixed)
+		c.Votes = dropDuplicates(fmt.Sprintf("vote on card %q", key), c.Votes, func(v Vote) string { return v.Voter }, &fixed)
+		c.Checklist = dropDuplicates(fmt.Sprintf("checklist item of card %q", key), c.Checklist, func(it ChecklistItem) string { return it.ID }, &fixed)
+		b.Cards[key] = c
+		if columnIndex(*b, c.ColumnID) < 0 && len(b.Columns) > 0 {
+			orphans = append(orphans, c)
+		}
+	}
+
+	if len(orphans) > 0 {
+		into := defaultColumn(*b)
+		maxOrder := 0.0
+		for _, c := range b.Cards {
+			if c.ColumnID == into {
+				maxOrder = max(maxOrder, c.Order)
+			}
+		}
+		sortCards(orphans)
+		for _, c := range orphans {
+			fixed = append(fixed, fmt.Sprintf("card %q moved from unknown column %q to %q", c.ID, c.ColumnID, into))
+			maxOrder += 1000
+			c.ColumnID, c.Order = into, maxOrder
+			b.Cards[c.ID] = c
+		}
+	}
+
+	for i, nc := range bs.NodeConnections {
+		for j, u := range nc.Users {
+			if _, ok := b.Cards[u.CardID]; u.CardID != "" && !ok {
+				fixed = append(fixed, fmt.Sprintf("%s on %s no longer shown editing deleted card %q", u.Name, nc.NodeID, u.CardID))
+				bs.NodeConnections[i].Users[j].CardID = ""
+			}
+		}
+	}
+	return fixed
+}
+
+// dropDuplicates returns items without those sharing the key of an earlier
+// one, adding what it dropped to fixed. Without duplicates, it returns items
+// as is.
+func dropDuplicates[T any](what string, items []T, key func(T) string, fixed *[]string) []T {
+	if len(duplicateKeys(what, items, key)) == 0 {
+		return items
+	}
+	seen := map[string]bool{}
+	return slices.DeleteFunc(slices.Clone(items), func(item T) bool {
+		k := key(item)
+		if seen[k] {
+			*fixed = append(*fixed, fmt.Sprintf("dropped a duplicate %s %q", what, k))
+			return true
+		}
+		seen[k] = true
+		return false
+	})
+}
+
+// RepairBoard fixes what concurrent edits left inconsistent on the board,
+// in one edit, and returns what it fixed.
+func (s *Store) RepairBoard() ([]string, error) {
+	if s.readOnly {
+		return nil, nil
+	}
+	state := s.GetBoard()
+	// Or this node would show its users editing deleted cards again.
+	s.presence.dropEditing(func(cardID string) bool {
+		_, ok := state.Board.Cards[cardID]
+		return ok
+	})
+	if len(repairBoard(&state)) == 0 {
+		return nil, nil
+	}
+	var fixed []string
+	err := s.tryMutateAs(&WSMessage{Type: "refresh"}, func(Board) string {
+		return "Repaired the board: " + strings.Join(fixed, "; ")
+	}, func(bs *BoardState) error {
+		fixed = repairBoard(bs)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range fixed {
+		log.Printf("Repair: %s", f)
+	}
+	return fixed, nil
+}
+
+// startRepair repairs the board now and then every repairInterval until the
+// store is closed.
+func startRepair(s *Store) {
+	ticker := time.NewTicker(repairInterval)
+	defer ticker.Stop()
+	for {
+		if _, err := s.RepairBoard(); err != nil && err != ErrBoardFrozen {
+			log.Printf("Failed to repair the board: %v", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// handleRepair serves POST /api/admin/repair, repairing the board at once,
+// and answers what was fixed: {"fixed": ["card \"c1\" moved from ..."]}.
+func handleRepair(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		fixed, err := s.RepairBoard()
+		if err != nil {
+			writeMutationError(w, err)
+			return
+		}
+		if len(fixed) > 0 {
+			s.Audit(r, AuditBoardRepair, fmt.Sprintf("%d fix(es)", len(fixed)))
+		} else {
+			fixed = []string{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Fixed []string `json:"fixed"`
+		}{fixed})
+	}
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rule is an event→condition→action automation, e.g. "when a card is labeled
+// bug, assign it to QA".
+type Rule struct {
+	ID         int64          `json:"id"`
+	Name       string         `json:"name"`
+	Enabled    bool           `json:"enabled"`
+	Event      string         `json:"event"`
+	Conditions RuleConditions `json:"conditions"`
+	Actions    []RuleAction   `json:"actions"`
+}
+
+// RuleConditions are matched against the event and the card's current state.
+// Empty fields match anything.
+type RuleConditions struct {
+	Label         string `json:"label,omitempty"`
+	Column        string `json:"column,omitempty"`
+	Assignee      string `json:"assignee,omitempty"`
+	TitleContains string `json:"titleContains,omitempty"`
+}
+
+// RuleAction is one of "move" (Value: column ID), "label" (Value: label),
+// "assign" (Value: assignee) or "webhook" (Value: URL receiving the event).
+type RuleAction struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// validate rejects rules that could never run against board b, such as a
+// move to a column b does not have.
+func (r Rule) validate(b Board) error {
+	if r.Event == "" {
+		return fmt.Errorf("rule event is required")
+	}
+	if len(r.Actions) == 0 {
+		return fmt.Errorf("rule needs at least one action")
+	}
+	for _, a := range r.Actions {
+		switch a.Type {
+		case "move":
+			if columnIndex(b, a.Value) < 0 {
+				return fmt.Errorf("move action: %w: %q", ErrColumnNotFound, a.Value)
+			}
+		case "label", "assign", "webhook":
+		default:
+			return fmt.Errorf("unknown action type %q", a.Type)
+		}
+	}
+	return nil
+}
+
+func listRules(s *Store) ([]Rule, error) {
+	rows, err := s.db.Query("SELECT id, data FROM rules ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []Rule{}
+	for rows.Next() {
+		var id int64
+		var data []byte
+		if err := rows.Scan(&id, &data); err != nil {
+			return nil, err
+		}
+		var r Rule
+		if err := json.Unmarshal(data, &r); err != nil {
+			log.Printf("Skipping unreadable rule %d: %v", id, err)
+			continue
+		}
+		r.ID = id
+		rules = append(rules, r)
+	}
+	return rules, rows.Err()
+}
+
+// saveRule inserts r, or replaces the existing rule when r.ID is set.
+func saveRule(s *Store, r Rule) (int64, error) {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return 0, err
+	}
+	if r.ID != 0 {
+		_, err := s.db.Exec("INSERT OR REPLACE INTO rules (id, data) VALUES (?, ?)", r.ID, data)
+		return r.ID, err
+	}
+	res, err := s.db.Exec("INSERT INTO rules (data) VALUES (?)", data)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func deleteRule(s *Store, id int64) error {
+	_, err := s.db.Exec("DELETE FROM rules WHERE id = ?", id)
+	return err
+}
+
+// maxRuleFirings bounds how often a rule may fire for the same card within
+// ruleFiringWindow. Actions that change nothing emit no event, so well-formed
+// rule sets settle on their own; this only stops rules that undo each other.
+const (
+	maxRuleFirings   = 10
+	ruleFiringWindow = time.Minute
+)
+
+type ruleFiring struct {
+	count int
+	start time.Time
+}
+
+// RulesEngine evaluates rules against local events on a single worker
+// goroutine, so actions never run on (or block) the editing goroutine.
+type RulesEngine struct {
+	store   *Store
+	events  chan Event
+	mu      sync.Mutex
+	firings map[string]*ruleFiring
+}
+
+func newRulesEngine(s *Store) *RulesEngine {
+	return &RulesEngine{
+		store:   s,
+		events:  make(chan Event, 256),
+		firings: make(map[string]*ruleFiring),
+	}
+}
+
+// startRulesEngine subscribes a rules engine to the store's events.
+func startRulesEngine(s *Store) *RulesEngine {
+	e := newRulesEngine(s)
+	s.OnEvent(func(ev Event) {
+		select {
+		case e.events <- ev:
+		default:
+			log.Printf("Rules: event queue full, dropping %s for card %s", ev.Type, ev.CardID)
+		}
+	})
+	go func() {
+		for ev := range e.events {
+			e.handle(ev)
+		}
+	}()
+	return e
+}
+
+func (e *RulesEngine) handle(ev Event) {
+	rules, err := listRules(e.store)
+	if err != nil {
+		log.Printf("Rules: failed to load rules: %v", err)
+		return
+	}
+	for _, r := range rules {
+		if !r.Enabled || r.Event != ev.Type || !e.matches(r.Conditions, ev) {
+			continue
+		}
+		if !e.allow(r.ID, ev.CardID) {
+			log.Printf("Rules: rule %d (%s) is firing too often for card %s, skipping", r.ID, r.Name, ev.CardID)
+			continue
+		}
+		log.Printf("Rules: rule %d (%s) matched %s on card %s", r.ID, r.Name, ev.Type, ev.CardID)
+		for _, a := range r.Actions {
+			if err := e.run(a, ev); err != nil {
+				log.Printf("Rules: action %s of rule %d failed: %v", a.Type, r.ID, err)
+			}
+		}
+	}
+}
+
+func (e *RulesEngine) matches(c RuleConditions, ev Event) bool {
+	card, ok := e.store.GetBoard().Board.Cards[ev.CardID]
+	if !ok {
+		// Deleted cards can only be matched on the event itself.
+		return c.Label == "" && c.Assignee == "" &&
+			(c.Column == "" || c.Column == ev.Column) &&
+			(c.TitleContains == "" || strings.Contains(strings.ToLower(ev.Title), strings.ToLower(c.TitleContains)))
+	}
+	if c.Label != "" && ev.Label != c.Label && !card.Labels[c.Label] {
+		return false
+	}
+	if c.Column != "" && card.ColumnID != c.Column {
+		return false
+	}
+	if c.Assignee != "" && card.Assignee != c.Assignee {
+		return false
+	}
+	if c.TitleContains != "" && !strings.Contains(strings.ToLower(card.Title), strings.ToLower(c.TitleContains)) {
+		return false
+	}
+	return true
+}
+
+func (e *RulesEngine) allow(ruleID int64, cardID string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	key := fmt.Sprintf("%d/%s", ruleID, cardID)
+	now := time.Now()
+	f, ok := e.firings[key]
+	if !ok || now.Sub(f.start) > ruleFiringWindow {
+		e.firings[key] = &ruleFiring{count: 1, start: now}
+		return true
+	}
+	f.count++
+	return f.count <= maxRuleFirings
+}
+
+func (e *RulesEngine) run(a RuleAction, ev Event) error {
+	switch a.Type {
+	case "move":
+		return e.store.MoveCard(ev.CardID, a.Value, math.MaxInt)
+	case "label":
+		return e.store.AddLabel(ev.CardID, a.Value)
+	case "assign":
+		return e.store.AssignCard(ev.CardID, a.Value)
+	case "webhook":
+		data, _ := json.Marshal(ev)
+		resp, err := peerHTTPClient.Post(a.Value, "application/json", bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("webhook returned %s", resp.Status)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown action type %q", a.Type)
+}
+
+// handleRules is the admin API for rules: GET lists, POST creates or (with an
+// "id") replaces, DELETE ?id= removes.
+func handleRules(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			rules, err := listRules(s)
+			if err != nil {
+				writeError(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(rules)
+		case http.MethodPost:
+			var rule Rule
+			if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+				writeError(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := rule.validate(s.GetBoard().Board); err != nil {
+				writeError(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			id, err := saveRule(s, rule)
+			if err != nil {
+				writeError(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			rule.ID = id
+			log.Printf("ADMIN: Saved rule %d (%s)", id, rule.Name)
+			s.Audit(r, AuditRulesChange, fmt.Sprintf("saved rule %d (%s)", id, rule.Name))
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(rule)
+		case http.MethodDelete:
+			id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+			if err != nil {
+				writeError(w, "invalid rule id", http.StatusBadRequest)
+				return
+			}
+			if err := deleteRule(s, id); err != nil {
+				writeError(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			log.Printf("ADMIN: Deleted rule %d", id)
+			s.Audit(r, AuditRulesChange, fmt.Sprintf("deleted rule %d", id))
+			w.WriteHeader(http.StatusOK)
+		default:
+			writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import "time"
+
+// Background sync intervals. A node pulls state from its peers every
+// defaultSyncInterval while the board is changing. After a failed push or
+// pull, or a digest mismatch, it retries after minSyncInterval, doubling on
+// every further troubled round up to defaultSyncInterval. While nothing
+// changes, it backs off, doubling up to maxSyncInterval.
+const (
+	minSyncInterval     = 5 * time.Second
+	defaultSyncInterval = 30 * time.Second
+	maxSyncInterval     = 2 * time.Minute
+)
+
+// nextSyncInterval returns the interval after a sync round. failures is the
+// number of consecutive troubled rounds, including this one; quiet reports
+// that the board did not change since the previous round.
+func nextSyncInterval(cur time.Duration, failures int, quiet bool) time.Duration {
+	switch {
+	case failures > 0:
+		return min(minSyncInterval<<min(failures-1, 8), defaultSyncInterval)
+	case quiet:
+		return min(max(cur*2, defaultSyncInterval), maxSyncInterval)
+	}
+	return defaultSyncInterval
+}
+
+// SyncInterval returns the current background sync interval.
+func (s *Store) SyncInterval() time.Duration {
+	s.peerMu.RLock()
+	defer s.peerMu.RUnlock()
+	return s.syncInterval
+}
+
+// syncTroubleLocked notes that a sync went wrong and wakes the background
+// sync to retry soon. It must be called with s.peerMu held.
+func (s *Store) syncTroubleLocked() {
+	s.syncTrouble = true
+	select {
+	case s.syncKick <- struct{}{}:
+	default:
+	}
+}
+
+// SyncSoon wakes the background sync for a round now, e.g. once a peer is
+// reachable again.
+func (s *Store) SyncSoon() {
+	select {
+	case s.syncKick <- struct{}{}:
+	default:
+	}
+}
+
+// adaptSyncInterval picks the interval to the next sync round from how the
+// round that just ended went.
+func (s *Store) adaptSyncInterval() {
+	snap := s.snap.Load()
+	s.peerMu.Lock()
+	defer s.peerMu.Unlock()
+	if s.syncTrouble {
+		s.syncFailures++
+	} else {
+		s.syncFailures = 0
+	}
+	s.syncTrouble = false
+	quiet := snap == s.syncSnap
+	s.syncSnap = snap
+	s.syncInterval = nextSyncInterval(s.syncInterval, s.syncFailures, quiet)
+}
+
+// waitSync blocks until the next sync round is due: after the current
+// interval, or sooner when a sync goes wrong meanwhile. It reports false
+// once the store is closed.
+func (s *Store) waitSync() bool {
+	// Trouble during the last round is already reflected in the interval.
+	select {
+	case <-s.syncKick:
+	default:
+	}
+	timer := time.NewTimer(s.SyncInterval())
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-s.syncKick:
+	case <-s.done:
+		return false
+	}
+	// Give the failure a moment to settle (and other ones to coalesce).
+	retry := time.NewTimer(minSyncInterval)
+	defer retry.Stop()
+	select {
+	case <-retry.C:
+		return true
+	case <-s.done:
+		return false
+	}
+}
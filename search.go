@@ -0,0 +1,191 @@
+package main
+
+import (
+	"cmp"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+	maxSearchResults   = 500 // best matches a search pages through
+)
+
+// searchList is how search results can be paged and sorted.
+var searchList = listSpec[SearchResult]{
+	DefaultLimit: defaultSearchLimit,
+	MaxLimit:     maxSearchLimit,
+	DefaultSort:  "-score",
+	Orders: map[string]func(a, b SearchResult) int{
+		"score":   func(a, b SearchResult) int { return cmp.Compare(a.Score, b.Score) },
+		"title":   func(a, b SearchResult) int { return strings.Compare(a.Title, b.Title) },
+		"updated": func(a, b SearchResult) int { return cmp.Compare(a.Updated, b.Updated) },
+	},
+}
+
+// Field weights for search ranking. A match in a better field always ranks
+// above one in a worse field; recency only orders matches within a field.
+var searchFieldWeight = map[string]float64{
+	"title":       3,
+	"description": 2,
+	"comment":     1,
+}
+
+// SearchResult is one card matching a search query.
+type SearchResult struct {
+	Board      string  `json:"board"` // mount path of the board ("" for the default one)
+	BoardTitle string  `json:"boardTitle"`
+	CardID     string  `json:"cardId"`
+	Title      string  `json:"title"`
+	Column     string  `json:"column"`
+	Field      string  `json:"field"`
+	Snippet    string  `json:"snippet"`
+	Updated    int64   `json:"updated"` // unix seconds of the latest card activity, 0 if unknown
+	Score      float64 `json:"score"`
+}
+
+// Search returns the cards of this board matching query, case-insensitively,
+// each reported once for its best matching field. Results are unordered.
+func (s *Store) Search(query string, now time.Time) []SearchResult {
+	q := strings.ToLower(strings.TrimSpace(query))
+	if q == "" {
+		return nil
+	}
+	state := s.GetBoard()
+	if state.Board.Encryption.Enabled() {
+		return nil // only ciphertext to search
+	}
+	var results []SearchResult
+	for _, c := range state.Board.Cards {
+		field, text := matchCard(c, q)
+		if field == "" {
+			continue
+		}
+		updated := cardActivity(c)
+		results = append(results, SearchResult{
+			Board:      s.basePath,
+			BoardTitle: state.Board.Title,
+			CardID:     c.ID,
+			Title:      c.Title,
+			Column:     c.ColumnID,
+			Field:      field,
+			Snippet:    snippet(text, q),
+			Updated:    updated,
+			Score:      searchFieldWeight[field] + recencyBoost(updated, now),
+		})
+	}
+	return results
+}
+
+// matchCard returns the best field of c containing q and its text.
+func matchCard(c Card, q string) (string, string) {
+	if strings.Contains(strings.ToLower(c.Title), q) {
+		return "title", c.Title
+	}
+	if desc := textString(c.Description); strings.Contains(strings.ToLower(desc), q) {
+		return "description", desc
+	}
+	for _, cm := range c.Comments {
+		if strings.Contains(strings.ToLower(cm.Body), q) {
+			return "comment", cm.Body
+		}
+	}
+	return "", ""
+}
+
+// cardActivity approximates when a card last changed from the timestamps it
+// carries: description edits and comments.
+func cardActivity(c Card) int64 {
+	var latest int64
+	for _, seg := range c.Description {
+		latest = max(latest, seg.ID.WallTime/int64(time.Second))
+	}
+	for _, cm := range c.Comments {
+		latest = max(latest, cm.Time)
+	}
+	return latest
+}
+
+// recencyBoost maps an activity time to [0, 1): just under 1 for a card
+// touched now, half that after a day, a third after two, and so on.
+func recencyBoost(updated int64, now time.Time) float64 {
+	if updated <= 0 {
+		return 0
+	}
+	days := now.Sub(time.Unix(updated, 0)).Hours() / 24
+	return 0.99 / (1 + max(days, 0))
+}
+
+// snippet returns the part of text around the first match of q.
+func snippet(text, q string) string {
+	const radius = 40
+	i := strings.Index(strings.ToLower(text), q)
+	if i < 0 {
+		return ""
+	}
+	start, end := max(i-radius, 0), min(i+len(q)+radius, len(text))
+	// Do not cut through a multi-byte character.
+	for start > 0 && !isRuneStart(text[start]) {
+		start--
+	}
+	for end < len(text) && !isRuneStart(text[end]) {
+		end++
+	}
+	out := strings.Join(strings.Fields(text[start:end]), " ")
+	if start > 0 {
+		out = "…" + out
+	}
+	if end < len(text) {
+		out += "…"
+	}
+	return out
+}
+
+func isRuneStart(b byte) bool {
+	return b&0xC0 != 0x80
+}
+
+// searchBoards runs query over every board and returns the best limit
+// matches across all of them.
+func searchBoards(stores []*Store, query string, limit int) []SearchResult {
+	now := time.Now()
+	results := []SearchResult{}
+	for _, s := range stores {
+		results = append(results, s.Search(query, now)...)
+	}
+	slices.SortStableFunc(results, func(a, b SearchResult) int {
+		return cmp.Or(cmp.Compare(b.Score, a.Score), strings.Compare(a.Title, b.Title))
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+// handleSearch serves the cross-board search: GET /api/search?q=..., with
+// the list parameters (sort by score, title or updated; best first by
+// default). It is mounted once, above the tenant router, and covers every
+// board.
+func handleSearch(boards *Boards) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := strings.TrimSpace(r.URL.Query().Get("q"))
+		if query == "" {
+			writeError(w, "missing query", http.StatusBadRequest)
+			return
+		}
+		p, err := searchList.parse(r)
+		if err != nil {
+			writeMutationError(w, err)
+			return
+		}
+		page, err := searchList.page(searchBoards(boards.All(), query, maxSearchResults), p)
+		if err != nil {
+			writeMutationError(w, err)
+			return
+		}
+		writePage(w, page, p)
+	}
+}
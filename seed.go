@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/brunoga/deep/v5/crdt"
+	"github.com/brunoga/deep/v5/crdt/hlc"
+	"gopkg.in/yaml.v3"
+)
+
+// Seed is the starting content of a new database: the board plus node-local
+// settings. It is only applied when the database is empty.
+type Seed struct {
+	State    BoardState
+	Settings map[string]any
+}
+
+// seedFile is the YAML layout accepted by -seed.
+type seedFile struct {
+	Title   string `yaml:"title"`
+	Columns []struct {
+		ID    string `yaml:"id"`
+		Title string `yaml:"title"`
+	} `yaml:"columns"`
+	Cards []struct {
+		ID          string   `yaml:"id"`
+		Title       string   `yaml:"title"`
+		Column      string   `yaml:"column"`
+		Description string   `yaml:"description"`
+		Assignee    string   `yaml:"assignee"`
+		Labels      []string `yaml:"labels"`
+		Priority    string   `yaml:"priority"`
+	} `yaml:"cards"`
+	Settings map[string]any `yaml:"settings"`
+}
+
+// defaultSeed is the board used when no seed file is given.
+func defaultSeed() *Seed {
+	return &Seed{State: NewInitialBoard()}
+}
+
+// loadSeed reads a board definition from a YAML file. Cards without an ID get
+// a positional one ("card-1", ...) so that nodes seeded from the same file
+// start from identical states and converge cleanly.
+func loadSeed(path string) (*Seed, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f seedFile
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("seed %s: %w", path, err)
+	}
+	if len(f.Columns) == 0 {
+		return nil, fmt.Errorf("seed %s: at least one column is required", path)
+	}
+
+	board := Board{
+		ID:      "main-board",
+		Title:   f.Title,
+		Columns: []Column{},
+		Cards:   map[string]Card{},
+		Colors:  []UserColor{},
+		Boards:  []BoardRef{},
+		Users:   []BoardUser{},
+	}
+	if board.Title == "" {
+		board.Title = NewInitialBoard().Board.Title
+	}
+	perColumn := map[string]int{}
+	for _, c := range f.Columns {
+		if c.ID == "" {
+			return nil, fmt.Errorf("seed %s: column %q has no id", path, c.Title)
+		}
+		if _, dup := perColumn[c.ID]; dup {
+			return nil, fmt.Errorf("seed %s: duplicate column %q", path, c.ID)
+		}
+		perColumn[c.ID] = 0
+		title := c.Title
+		if title == "" {
+			title = c.ID
+		}
+		board.Columns = append(board.Columns, Column{ID: c.ID, Title: title})
+	}
+
+	for i, c := range f.Cards {
+		id := c.ID
+		if id == "" {
+			id = fmt.Sprintf("card-%d", i+1)
+		}
+		if _, dup := board.Cards[id]; dup {
+			return nil, fmt.Errorf("seed %s: duplicate card %q", path, id)
+		}
+		col := c.Column
+		if col == "" {
+			col = board.Columns[0].ID
+		}
+		if _, ok := perColumn[col]; !ok {
+			return nil, fmt.Errorf("seed %s: card %q uses unknown column %q", path, c.Title, col)
+		}
+		perColumn[col]++
+		card := Card{
+			ID:        id,
+			Title:     c.Title,
+			ColumnID:  col,
+			Order:     float64(perColumn[col] * 1000),
+			Assignee:  c.Assignee,
+			Labels:    labelSetOf(c.Labels...),
+			Checklist: []ChecklistItem{},
+			Priority:  c.Priority,
+		}
+		if c.Description != "" {
+			card.Description = crdt.Text{{ID: hlc.HLC{NodeID: "system"}, Value: c.Description}}
+		}
+		board.Cards[id] = card
+	}
+
+	return &Seed{
+		State:    BoardState{Board: board, NodeConnections: []NodeConnection{}, Announcements: map[string]string{}},
+		Settings: f.Settings,
+	}, nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// A column can limit how long cards may dwell in it. Cards record when they
+// entered their column, and a background evaluator on every node flags the
+// ones that stayed too long. The flag lives in the CRDT, so every node shows
+// it, and the node that raises it emits EventCardOverdue, which notifies the
+// card's assignee and watchers and can trigger rules, such as a webhook.
+// Cards that entered their column before entry times were recorded are only
+// evaluated once they move.
+
+// dwellCheckInterval is how often the evaluator looks for overdue cards.
+const dwellCheckInterval = time.Minute
+
+// isOverdue reports whether c has been in its column longer than limit
+// seconds as of now. A limit of 0 means no limit.
+func isOverdue(c Card, limit int64, now time.Time) bool {
+	return limit > 0 && c.EnteredAt > 0 && now.Unix()-c.EnteredAt > limit
+}
+
+// dwellLimits returns the dwell limit of every limited column.
+func dwellLimits(bs *BoardState) map[string]int64 {
+	limits := make(map[string]int64)
+	for _, col := range bs.Board.Columns {
+		if col.MaxDwell > 0 {
+			limits[col.ID] = col.MaxDwell
+		}
+	}
+	return limits
+}
+
+// SetColumnMaxDwell limits how long cards may stay in a column. Zero lifts
+// the limit.
+func (s *Store) SetColumnMaxDwell(colID string, d time.Duration) error {
+	found := false
+	err := s.mutate(func(bs *BoardState) {
+		for i, col := range bs.Board.Columns {
+			if col.ID == colID {
+				bs.Board.Columns[i].MaxDwell = int64(d / time.Second)
+				found = true
+				return
+			}
+		}
+	})
+	if err == nil && !found {
+		return ErrColumnNotFound
+	}
+	return err
+}
+
+// CheckDwell flags the cards that are overdue as of now, and clears the flag
+// of those that no longer are because their column's limit changed.
+func (s *Store) CheckDwell(now time.Time) error {
+	if s.readOnly {
+		return nil
+	}
+	state := s.GetBoard()
+	limits := dwellLimits(&state)
+	stale := false
+	for _, c := range state.Board.Cards {
+		if isOverdue(c, limits[c.ColumnID], now) != c.Overdue {
+			stale = true
+			break
+		}
+	}
+	if !stale {
+		return nil
+	}
+
+	return s.tryMutate(func(bs *BoardState) error {
+		limits := dwellLimits(bs)
+		for id, c := range bs.Board.Cards {
+			overdue := isOverdue(c, limits[c.ColumnID], now)
+			if overdue == c.Overdue {
+				continue
+			}
+			c.Overdue = overdue
+			bs.Board.Cards[id] = c
+			if overdue {
+				s.stage(Event{Type: EventCardOverdue, CardID: id, Title: c.Title, Column: c.ColumnID, Assignee: c.Assignee})
+			}
+		}
+		return nil
+	})
+}
+
+// startDwellEvaluator checks the board for overdue cards every
+// dwellCheckInterval until the store is closed.
+func startDwellEvaluator(s *Store) {
+	ticker := time.NewTicker(dwellCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			if err := s.CheckDwell(now); err != nil && err != ErrBoardFrozen {
+				log.Printf("Failed to check dwell times: %v", err)
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// handleColumnDwell sets how long cards may stay in a column: POST
+// {"columnId": "in-progress", "maxDwell": "72h"}. An empty or zero maxDwell
+// lifts the limit. GET lists the columns.
+func handleColumnDwell(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(s.GetBoard().Board.Columns)
+			return
+		}
+		var req struct {
+			ColumnID string `json:"columnId"`
+			MaxDwell string `json:"maxDwell"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var d time.Duration
+		if req.MaxDwell != "" {
+			var err error
+			if d, err = time.ParseDuration(req.MaxDwell); err != nil || d < 0 {
+				writeError(w, "invalid maxDwell", http.StatusBadRequest)
+				return
+			}
+		}
+		if err := s.SetColumnMaxDwell(req.ColumnID, d); err != nil {
+			writeMutationError(w, err)
+			return
+		}
+		log.Printf("ADMIN: Column %s max dwell set to %v", req.ColumnID, d)
+		s.Audit(r, AuditColumnDwell, fmt.Sprintf("column=%s maxDwell=%v", req.ColumnID, d))
+		w.WriteHeader(http.StatusOK)
+	}
+}
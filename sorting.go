@@ -0,0 +1,193 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// A column can sort its cards automatically instead of keeping the order
+// they were dragged into. The policy lives on the column in the CRDT and is
+// applied when the board is rendered, so a new or changed card shows up in
+// its place right away, and the cards' manual order is kept for when the
+// column goes back to it.
+
+// Column sort policies. The zero value is the manual order.
+const (
+	ColumnSortManual   = ""
+	ColumnSortPriority = "priority" // most urgent first, see priorityRank
+	ColumnSortDue      = "due"      // earliest due date first, undated last
+)
+
+// dueLayout is the format of card due dates.
+const dueLayout = time.DateOnly
+
+var (
+	ErrBadSort = errors.New("unknown sort policy")
+	ErrBadDue  = errors.New("due date must look like 2006-01-02")
+)
+
+// columnOrder returns how policy orders a column's cards, nil for the
+// manual order.
+func columnOrder(policy string) func(a, b Card) int {
+	switch policy {
+	case ColumnSortPriority:
+		return func(a, b Card) int { return cmp.Compare(priorityRank(a.Priority), priorityRank(b.Priority)) }
+	case ColumnSortDue:
+		return func(a, b Card) int { return compareDue(a.Due, b.Due) }
+	}
+	return nil
+}
+
+// pastDue reports whether due, a card's due date, is before the day of now.
+// A card is due through its due date.
+func pastDue(due string, now time.Time) bool {
+	return due != "" && due < now.Format(dueLayout)
+}
+
+// compareDue orders due dates, earliest first and none last.
+func compareDue(a, b string) int {
+	if (a == "") != (b == "") {
+		return cmp.Compare(b, a) // the dated one first
+	}
+	return cmp.Compare(a, b)
+}
+
+// sortColumn orders cards, already in manual order, by policy. Cards the
+// policy ranks the same keep their manual order.
+func sortColumn(cards []Card, policy string) {
+	if order := columnOrder(policy); order != nil {
+		slices.SortStableFunc(cards, order)
+	}
+}
+
+// SetColumnSort sets how a column sorts its cards.
+func (s *Store) SetColumnSort(colID, policy string) error {
+	if policy != ColumnSortManual && columnOrder(policy) == nil {
+		return ErrBadSort
+	}
+	found := false
+	err := s.mutate(func(bs *BoardState) {
+		for i, col := range bs.Board.Columns {
+			if col.ID == colID {
+				bs.Board.Columns[i].Sort = policy
+				found = true
+				return
+			}
+		}
+	})
+	if err == nil && !found {
+		return ErrColumnNotFound
+	}
+	return err
+}
+
+// SetCardDue sets a card's due date, "" for none.
+func (s *Store) SetCardDue(cardID, due string) error {
+	if due != "" {
+		if _, err := time.Parse(dueLayout, due); err != nil {
+			return ErrBadDue
+		}
+	}
+	return s.tryMutate(func(bs *BoardState) error {
+		card, ok := bs.Board.Cards[cardID]
+		if !ok {
+			return ErrCardNotFound
+		}
+		card.Due = due
+		bs.Board.Cards[cardID] = card
+		return nil
+	})
+}
+
+// handleColumnSort sets how a column sorts its cards: POST {"columnId":
+// "todo", "sort": "priority"}, with "due" or "" (manual) as the other
+// policies. GET lists the columns.
+func handleColumnSort(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(s.GetBoard().Board.Columns)
+			return
+		}
+		var req struct {
+			ColumnID string `json:"columnId"`
+			Sort     string `json:"sort"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := s.SetColumnSort(req.ColumnID, req.Sort); err != nil {
+			writeMutationError(w, err)
+			return
+		}
+		log.Printf("ADMIN: Column %s sort set to %q", req.ColumnID, req.Sort)
+		s.Audit(r, AuditColumnSort, fmt.Sprintf("column=%s sort=%s", req.ColumnID, req.Sort))
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// handleCardDue sets a card's due date: POST {"cardId": "...", "due":
+// "2024-03-15"}, or an empty due to clear it. GET lists the cards due before
+// a day, ?before=2024-03-15, by default today's: those past due. It takes
+// the list parameters of /api/cards.
+func handleCardDue(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			listDue(s, w, r)
+			return
+		case http.MethodPost:
+		default:
+			writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !canEditCards(w, r) {
+			return
+		}
+		var req struct {
+			CardID string `json:"cardId"`
+			Due    string `json:"due"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := s.SetCardDue(req.CardID, req.Due); err != nil {
+			writeMutationError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// listDue serves GET /api/cards/due, the cards due before ?before.
+func listDue(s *Store, w http.ResponseWriter, r *http.Request) {
+	before := r.URL.Query().Get("before")
+	if before == "" {
+		before = time.Now().Format(dueLayout)
+	} else if _, err := time.Parse(dueLayout, before); err != nil {
+		writeMutationError(w, ErrBadDue)
+		return
+	}
+	p, err := cardList.parse(r)
+	if err != nil {
+		writeMutationError(w, err)
+		return
+	}
+	infos := slices.DeleteFunc(cardInfos(s.GetBoard()), func(c CardInfo) bool {
+		return c.Due == "" || c.Due >= before
+	})
+	page, err := cardList.page(infos, p)
+	if err != nil {
+		writeMutationError(w, err)
+		return
+	}
+	writePage(w, page, p)
+}
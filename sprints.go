@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Sprints are time boxes cards can belong to. Ending a sprint is a single
+// edit, so every node sees it all at once: the cards in "done" leave the
+// board and are kept, as a record, in the ended sprint, and the unfinished
+// ones roll into the next sprint (or back to the backlog). The edit's
+// history entry summarizes the sprint.
+
+// doneColumn is the column whose cards count as finished.
+const doneColumn = "done"
+
+var (
+	// ErrSprintNotFound is returned for an unknown sprint.
+	ErrSprintNotFound = errors.New("sprint not found")
+	// ErrSprintEnded is returned when an ended sprint is changed.
+	ErrSprintEnded = errors.New("sprint has ended")
+	// ErrBadSprint is returned for a sprint without a name or with its end
+	// before its start.
+	ErrBadSprint = errors.New("sprint needs a name and an end after its start")
+)
+
+// Sprint is a time box. Start and End are dates (2006-01-02).
+type Sprint struct {
+	ID      string       `deep:"key" json:"id"`
+	Name    string       `json:"name"`
+	Start   string       `json:"start"`
+	End     string       `json:"end"`
+	EndedAt int64        `json:"endedAt,omitempty"` // unix seconds, 0 while open
+	Done    []SprintCard `json:"done,omitempty"`    // cards finished in the sprint, once ended
+	Summary string       `json:"summary,omitempty"`
+}
+
+// SprintCard records a card finished in a sprint.
+type SprintCard struct {
+	ID       string   `deep:"key" json:"id"`
+	Title    string   `json:"title"`
+	Assignee string   `json:"assignee"`
+	Labels   []string `json:"labels"`
+	Priority string   `json:"priority"`
+}
+
+// findSprint returns the index of the sprint id in bs, or -1.
+func findSprint(bs *BoardState, id string) int {
+	return slices.IndexFunc(bs.Board.Sprints, func(sp Sprint) bool { return sp.ID == id })
+}
+
+// CreateSprint adds an open sprint running from start to end and returns its
+// ID.
+func (s *Store) CreateSprint(name string, start, end time.Time) (string, error) {
+	if name == "" || end.Before(start) {
+		return "", ErrBadSprint
+	}
+	id := uuid.New().String()
+	err := s.mutate(func(bs *BoardState) {
+		bs.Board.Sprints = append(bs.Board.Sprints, Sprint{
+			ID:    id,
+			Name:  name,
+			Start: start.Format(time.DateOnly),
+			End:   end.Format(time.DateOnly),
+		})
+	})
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// SetCardSprint puts a card in an open sprint, or, with an empty sprintID,
+// back in the backlog.
+func (s *Store) SetCardSprint(cardID, sprintID string) error {
+	return s.tryMutate(func(bs *BoardState) error {
+		card, ok := bs.Board.Cards[cardID]
+		if !ok {
+			return ErrCardNotFound
+		}
+		if sprintID != "" {
+			i := findSprint(bs, sprintID)
+			if i < 0 {
+				return ErrSprintNotFound
+			}
+			if bs.Board.Sprints[i].EndedAt != 0 {
+				return ErrSprintEnded
+			}
+		}
+		card.Sprint = sprintID
+		bs.Board.Cards[cardID] = card
+		return nil
+	})
+}
+
+// EndSprint ends the sprint id: its cards in doneColumn are removed from the
+// board and recorded in the sprint, and its other cards move to the sprint
+// next, or to the backlog if next is empty. It returns the summary written to
+// the history.
+func (s *Store) EndSprint(id, next string, now time.Time) (string, error) {
+	if id == next {
+		return "", fmt.Errorf("%w: a sprint cannot roll into itself", ErrBadSprint)
+	}
+	var summary string
+	err := s.tryMutateAs(&WSMessage{Type: "refresh"}, func(Board) string { return summary }, func(bs *BoardState) error {
+		i := findSprint(bs, id)
+		if i < 0 {
+			return ErrSprintNotFound
+		}
+		sp := bs.Board.Sprints[i]
+		if sp.EndedAt != 0 {
+			return ErrSprintEnded
+		}
+		nextName := "the backlog"
+		if next != "" {
+			j := findSprint(bs, next)
+			if j < 0 {
+				return ErrSprintNotFound
+			}
+			if bs.Board.Sprints[j].EndedAt != 0 {
+				return ErrSprintEnded
+			}
+			nextName = bs.Board.Sprints[j].Name
+		}
+
+		rolled := 0
+		for _, cardID := range slices.Sorted(maps.Keys(bs.Board.Cards)) {
+			c := bs.Board.Cards[cardID]
+			if c.Sprint != id {
+				continue
+			}
+			if c.ColumnID == doneColumn {
+				sp.Done = append(sp.Done, SprintCard{ID: c.ID, Title: c.Title, Assignee: c.Assignee, Labels: c.Labels.Names(), Priority: c.Priority})
+				delete(bs.Board.Cards, cardID)
+				s.stage(Event{Type: EventCardDeleted, CardID: cardID, Title: c.Title, Column: c.ColumnID})
+				continue
+			}
+			c.Sprint = next
+			bs.Board.Cards[cardID] = c
+			rolled++
+		}
+		sp.EndedAt = now.Unix()
+		sp.Summary = fmt.Sprintf("Sprint %q ended: %d done, %d rolled into %s", sp.Name, len(sp.Done), rolled, nextName)
+		bs.Board.Sprints[i] = sp
+		summary = sp.Summary
+		s.stage(Event{Type: EventSprintEnded, Title: sp.Name})
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return summary, nil
+}
+
+// handleSprints lists the sprints, GET /api/sprints, and creates one, POST
+// {"name": "Sprint 13", "start": "2024-03-04", "end": "2024-03-15"}, which
+// takes edit rights.
+func handleSprints(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			sprints := s.GetBoard().Board.Sprints
+			if sprints == nil {
+				sprints = []Sprint{}
+			}
+			w.Header().Set("Cache-Control", "no-store")
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(sprints)
+		case http.MethodPost:
+			if u := currentUser(r); u != nil && !u.HasRole(RoleEditor) {
+				writeError(w, ErrForbidden.Error(), http.StatusForbidden)
+				return
+			}
+			var req struct {
+				Name  string `json:"name"`
+				Start string `json:"start"`
+				End   string `json:"end"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeError(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			start, err1 := time.Parse(time.DateOnly, req.Start)
+			end, err2 := time.Parse(time.DateOnly, req.End)
+			if err1 != nil || err2 != nil {
+				writeError(w, "start and end must be dates (2006-01-02)", http.StatusBadRequest)
+				return
+			}
+			id, err := s.CreateSprint(req.Name, start, end)
+			if err != nil {
+				writeMutationError(w, err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(struct {
+				ID string `json:"id"`
+			}{id})
+		default:
+			writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// handleCardSprint puts a card in a sprint, POST /api/cards/sprint with
+// {"cardId": "...", "sprint": "..."}; an empty sprint moves it to the
+// backlog.
+func handleCardSprint(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req struct {
+			CardID string `json:"cardId"`
+			Sprint string `json:"sprint"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := s.SetCardSprint(req.CardID, req.Sprint); err != nil {
+			writeMutationError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// handleEndSprint ends a sprint, POST /api/sprints/end with {"sprint": "...",
+// "next": "..."}, and answers its summary.
+func handleEndSprint(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req struct {
+			Sprint string `json:"sprint"`
+			Next   string `json:"next"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		summary, err := s.EndSprint(req.Sprint, req.Next, time.Now())
+		if err != nil {
+			writeMutationError(w, err)
+			return
+		}
+		log.Printf("%s", summary)
+		s.Audit(r, AuditSprintEnd, summary)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Summary string `json:"summary"`
+		}{summary})
+	}
+}
@@ -1,76 +1,211 @@
 package main
 
 import (
-	"bytes"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"maps"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/brunoga/deep/v5"
 	"github.com/brunoga/deep/v5/crdt"
-	"github.com/brunoga/deep/v5/crdt/hlc"
 	"github.com/google/uuid"
 	_ "modernc.org/sqlite"
 )
 
-type Store struct {
+// ErrBoardFrozen is returned by mutating operations while the board is frozen.
+var ErrBoardFrozen = errors.New("board is frozen for maintenance; changes are temporarily disabled")
+
+// ErrReadOnly is returned by mutating operations on a read-only replica.
+var ErrReadOnly = errors.New("this node is a read-only replica; make changes on a primary node")
+
+// ErrNotSaved is returned by mutating operations whose change could not be
+// written to the database. The change is undone.
+var ErrNotSaved = errors.New("the change could not be saved; try again")
+
+// sqliteParams configures every connection to the database: writers from
+// background work (sync, feeds, notifications) wait for each other instead
+// of failing with SQLITE_BUSY, and readers do not block them.
+const sqliteParams = "_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+
+// storeCore is one board: its replicated document, local persistence,
+// connected clients and peers. State is split over independent locks so that traffic
+// on one (heartbeats, peer probes, history reads) does not stall card edits:
+//
+//   - mu serializes writes to the document: crdt, lastCount and
+//     lastModified. Readers use the lock-free snapshot instead.
+//   - histMu serializes the history table (patches).
+//   - peerMu guards peers, peerStatus, peerTraffic, blocked, the
+//     configured and announced peers and the divergence and sync schedule
+//     fields.
+//   - listenMu guards listeners.
+//   - featureMu serializes changes to the feature flag overrides, which
+//     readers load from overrides without it.
+//   - presence has its own lock for who is online and editing what, and
+//     poker for the estimation rounds.
+//   - edges has its own lock for the edge nodes relayed by this one.
+//   - columnFeeds has its own lock for column stream subscribers.
+//   - hub has its own per-shard locks for subscribers.
+//
+// When nested, locks are taken in the order mu, histMu, hub. peerMu,
+// listenMu, featureMu, presence, poker, edges and columnFeeds are leaves:
+// nothing else is acquired while holding them.
+type storeCore struct {
 	mu        sync.RWMutex
 	db        *sql.DB
 	crdt      *crdt.CRDT[BoardState]
-	subs      map[chan WSMessage]time.Time
-	peers     []string
+	snap      atomic.Pointer[snapshot]
 	nodeID    string
 	lastCount int
+	staged    []Event       // by the edit in progress, see stage
+	outbox    chan struct{} // wakes the event stream, nil if there is none; see eventstream.go
+
+	histMu sync.RWMutex
+	hub    *Hub
+
+	listenMu  sync.RWMutex
+	listeners []func(Event)
+
+	presence presenceSet
+	trash    trashBin
+	poker    pokerTable
+	edges    relayEdges
+
+	columnFeeds columnFeeds
+
+	peerMu      sync.RWMutex
+	peers       []string // configured and announced
+	peerStatus  map[string]*PeerStatus
+	peerTraffic map[string]*trafficLog
+	blocked     map[string]bool // peers cut off by an admin partition
+
+	configuredPeers []string // of -peers or discovery
+	announced       []string // peers announced on the board, see announce.go
+
+	readOnly     bool
+	quota        Quota
+	transport    Transport
+	wsRecording  *wsRecording           // nil unless -ws-record is set
+	summaries    SummaryTemplates       // of history entries, nil for the built-in ones
+	seed         *Seed                  // initial content, reapplied by Reset
+	basePath     string                 // mount path of this board on every node ("" or /t/<tenant>)
+	integrity    Integrity              // of the stored state when the board was opened
+	features     map[string]FeatureFlag // configured defaults, see flags.go
+	announceAddr string                 // where peers reach this node, "" to announce none
+
+	featureMu sync.Mutex
+	overrides atomic.Pointer[map[string]FeatureFlag] // flag overrides of this board on this node, see flags.go
+
+	done      chan struct{} // closed by Close
+	closeOnce sync.Once
+
+	lastModified        int64 // wall time of the latest applied change, under mu
+	divergences         int64
+	clientDrifts        atomic.Int64
+	divergenceCheck     bool
+	lastDivergenceCheck time.Time
+	digestMatched       map[string]time.Time // when each peer's digest last matched ours
+
+	syncInterval time.Duration // until the next background sync round
+	syncFailures int           // consecutive troubled sync rounds
+	syncTrouble  bool          // a sync went wrong since the last round
+	syncSnap     *snapshot     // snapshot as of the last round
+	syncKick     chan struct{} // wakes the background sync after trouble
+}
+
+// Store is a handle on a board. Handles made with As share the board and
+// differ only in the actor their edits are recorded under in the history.
+type Store struct {
+	*storeCore
+	actor string // "" for people, whose edits are not attributed
+}
+
+// As returns a handle on the same board whose edits are attributed to actor.
+func (s *Store) As(actor string) *Store {
+	return &Store{storeCore: s.storeCore, actor: actor}
 }
 
 func NewStore(dbPath string, nodeID string, peers []string) (*Store, error) {
-	db, err := sql.Open("sqlite", dbPath)
+	return NewSeededStore(dbPath, nodeID, peers, defaultSeed())
+}
+
+// NewSeededStore is like NewStore, but initializes an empty database from
+// seed instead of the built-in sample board.
+func NewSeededStore(dbPath string, nodeID string, peers []string, seed *Seed) (*Store, error) {
+	sep := "?"
+	if strings.Contains(dbPath, "?") {
+		sep = "&"
+	}
+	db, err := sql.Open("sqlite", dbPath+sep+sqliteParams)
 	if err != nil {
 		return nil, err
 	}
 
-	_, err = db.Exec(`
-		CREATE TABLE IF NOT EXISTS state (
-			id TEXT PRIMARY KEY,
-			data BLOB
-		);
-		CREATE TABLE IF NOT EXISTS patches (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			timestamp TEXT,
-			patch BLOB,
-			summary TEXT
-		);
-	`)
-	if err != nil {
+	if err := migrate(db); err != nil {
+		db.Close()
 		return nil, err
 	}
 
-	s := &Store{
+	s := &Store{storeCore: &storeCore{
 		db:        db,
-		subs:      make(map[chan WSMessage]time.Time),
+		hub:       newHub(),
+		done:      make(chan struct{}),
 		peers:     peers,
 		nodeID:    nodeID,
 		lastCount: -1,
-	}
+		seed:      seed,
+
+		peerStatus:      make(map[string]*PeerStatus),
+		configuredPeers: peers,
+		peerTraffic:     make(map[string]*trafficLog),
+		digestMatched:   make(map[string]time.Time),
+		blocked:         make(map[string]bool),
+		transport:       httpTransport{},
+		syncInterval:    defaultSyncInterval,
+		syncKick:        make(chan struct{}, 1),
+	}}
 
 	// Load or initialize state
 	var data []byte
 	err = db.QueryRow("SELECT data FROM state WHERE id = 'latest'").Scan(&data)
 	if err == sql.ErrNoRows {
-		s.crdt = crdt.NewCRDT(NewInitialBoard(), nodeID)
-		s.saveState()
+		s.crdt = crdt.NewCRDT(seed.State, nodeID)
+		s.integrity.CheckedAt = time.Now().Unix()
+		if err := s.saveState(); err != nil {
+			return nil, err
+		}
+		for key, v := range seed.Settings {
+			if err := s.SetSetting(key, v); err != nil {
+				return nil, err
+			}
+		}
 	} else if err != nil {
 		return nil, err
-	} else {
-		s.crdt = crdt.NewCRDT(BoardState{}, nodeID)
-		if err := json.Unmarshal(data, s.crdt); err != nil {
-			return nil, err
+	} else if err := s.loadState(data); err != nil {
+		return nil, err
+	}
+	if err := s.recoverJournal(); err != nil {
+		return nil, err
+	}
+	if err := s.loadFeatureOverrides(); err != nil {
+		return nil, err
+	}
+	if len(s.integrity.Unresolved) == 0 {
+		if err := s.markGood(); err != nil {
+			log.Printf("Integrity: failed to save the good state: %v", err)
 		}
 	}
 
+	s.OnEvent(s.notify)
+	s.OnEvent(s.playEffect)
+	s.OnEvent(s.feedColumns)
+
 	s.mu.Lock()
 	s.updateConnectionsLocked(0)
 	s.mu.Unlock()
@@ -82,19 +217,17 @@ func NewStore(dbPath string, nodeID string, peers []string) (*Store, error) {
 
 func (s *Store) connectionManager() {
 	ticker := time.NewTicker(5 * time.Second)
-	for range ticker.C {
-		s.mu.Lock()
-		now := time.Now()
-		changed := false
-		for ch, lastSeen := range s.subs {
-			if now.Sub(lastSeen) > 30*time.Second {
-				delete(s.subs, ch)
-				close(ch)
-				changed = true
-			}
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.done:
+			return
 		}
+		changed := s.hub.Expire(30*time.Second) > 0 || s.presence.awayChanged()
 
-		count := len(s.subs)
+		s.mu.Lock()
+		count := s.hub.Len()
 		if count != s.lastCount || changed {
 			s.lastCount = count
 			s.updateConnectionsLocked(count)
@@ -103,96 +236,233 @@ func (s *Store) connectionManager() {
 	}
 }
 
+// Close flushes the state to disk, disconnects all subscribers, stops
+// background work and closes the database. The store must not be used
+// afterwards.
+func (s *Store) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.hub.Close()
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		err = errors.Join(s.saveState(), s.db.Close())
+	})
+	return err
+}
+
+// UpdatePeers sets the configured peers, those of -peers or found by
+// discovery, and syncs with them at once. Nodes announced on the board stay
+// peers as well.
 func (s *Store) UpdatePeers(peers []string) {
-	s.mu.Lock()
-	s.peers = peers
-	s.mu.Unlock()
+	s.peerMu.Lock()
+	s.configuredPeers = peers
+	s.peers = joinPeers(peers, s.announced)
+	s.peerMu.Unlock()
 
 	// Trigger immediate sync with new peers
+	if s.IsArchived() {
+		return
+	}
 	for _, p := range peers {
 		go syncWithPeer(s, p)
 	}
 }
 
+// SetBasePath sets the path this board is mounted at on every node. Peer
+// requests are sent below it.
+func (s *Store) SetBasePath(path string) {
+	s.basePath = path
+}
+
+// peerURL returns the URL of endpoint for this board on peer.
+func (s *Store) peerURL(peer, endpoint string) string {
+	return peerBoardURL(peer, s.basePath, endpoint)
+}
+
+// SetTransport sets how this board replicates to its peers. The default is
+// plain HTTP. It must be called before serving requests.
+func (s *Store) SetTransport(t Transport) {
+	s.transport = t
+}
+
 func (s *Store) GetPeers() []string {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	s.peerMu.RLock()
+	defer s.peerMu.RUnlock()
 	p := make([]string, len(s.peers))
 	copy(p, s.peers)
 	return p
 }
 
+// GetBoard returns a copy of the latest published board. It never waits on
+// an edit in progress.
 func (s *Store) GetBoard() BoardState {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	return s.crdt.View()
+	return deep.Clone(s.snap.Load().state)
 }
 
 func (s *Store) ApplyDelta(delta crdt.Delta[BoardState]) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	prev := s.snap.Load().state.Board
+	before := s.crdt.View().Board.Cards
+	lastModified := s.lastModified
 	if s.crdt.ApplyDelta(delta) {
+		s.lastModified = max(s.lastModified, delta.Timestamp.WallTime)
 		data, _ := json.Marshal(delta)
+		data = stampModel(data)
 		paths := parseDeltaPaths(data)
-		summary := deltaSummary(paths)
-		log.Printf("Applied delta from remote: %s", summary)
-		s.saveState()
-		s.savePatchData(delta.Timestamp.String(), data, summary)
+		log.Printf("Applied delta from remote: %s", deltaSummary(paths))
+		changes := remoteChanges(before, s.crdt.View().Board.Cards)
+		if err := s.commitChange(delta.Timestamp.String(), data, s.summaryOf(paths, prev), "", changes); err != nil {
+			s.lastModified = lastModified
+			s.revert()
+			return fmt.Errorf("%w: %v", ErrNotSaved, err)
+		}
+		s.observeRemote(changes)
 		// Remote updates for connections are silent
-		s.Broadcast(WSMessage{Type: "refresh", Silent: isConnectionOnlyDelta(paths)})
+		s.Broadcast(WSMessage{
+			Type:   "refresh",
+			Silent: isConnectionOnlyDelta(paths),
+			Move:   moveHint(before, s.crdt.View().Board.Cards),
+		})
 	}
 	return nil
 }
 
-func (s *Store) Edit(fn func(*BoardState)) crdt.Delta[BoardState] {
+// Edit applies fn to the board and returns the delta it made, empty if it
+// changed nothing. If the change cannot be saved, it is undone and Edit
+// returns ErrNotSaved.
+func (s *Store) Edit(fn func(*BoardState)) (crdt.Delta[BoardState], error) {
+	return s.edit(fn, &WSMessage{Type: "refresh"}, nil)
+}
+
+// edit is Edit with the message broadcast on change. fn may fill in msg
+// (e.g. a move hint) as it runs, and stage the events of the change.
+// summary, called after fn with the edited board, returns the history entry
+// of the edit; if it is nil, the changed paths are listed. The events are
+// saved with the edit and emitted once the store is unlocked.
+func (s *Store) edit(fn func(*BoardState), msg *WSMessage, summary func(after Board) string) (crdt.Delta[BoardState], error) {
+	delta, events, err := s.commitEdit(fn, msg, summary)
+	for _, ev := range events {
+		s.emit(ev)
+	}
+	return delta, err
+}
+
+// commitEdit makes and saves the edit of edit, returning its delta and the
+// events fn staged. Events of an edit that changed nothing are dropped.
+func (s *Store) commitEdit(fn func(*BoardState), msg *WSMessage, summary func(after Board) string) (crdt.Delta[BoardState], []Event, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	wasArchived := s.IsArchived()
+	prev := s.snap.Load().state.Board
+	lastModified := s.lastModified
+	s.staged = nil
 	delta := s.crdt.Edit(fn)
-	if delta.Timestamp.WallTime != 0 {
-		data, _ := json.Marshal(delta)
-		s.saveState()
-		s.savePatchData(delta.Timestamp.String(), data, deltaSummary(parseDeltaPaths(data)))
-		s.Broadcast(WSMessage{Type: "refresh"})
-		go s.syncToPeers(delta)
+	events := s.staged
+	s.staged = nil
+	if delta.Timestamp.WallTime == 0 {
+		return delta, nil, nil
+	}
+	now := time.Now().UnixMilli()
+	for i := range events {
+		events[i].Time = now
+	}
+	data, _ := json.Marshal(delta)
+	data = stampModel(data)
+	s.lastModified = delta.Timestamp.WallTime
+	if summary == nil {
+		summary = s.summaryOf(parseDeltaPaths(data), prev)
+	}
+	if err := s.commitChange(delta.Timestamp.String(), data, summary, s.actor, events); err != nil {
+		s.lastModified = lastModified
+		s.revert()
+		return crdt.Delta[BoardState]{}, nil, fmt.Errorf("%w: %v", ErrNotSaved, err)
+	}
+	s.Broadcast(*msg)
+	if !wasArchived || !s.IsArchived() {
+		go s.syncToPeers(delta, stateDigest(s.crdt.View().Board))
+	}
+	return delta, events, nil
+}
+
+// stage adds events to those of the edit in progress, which are recorded in
+// the change feed along with it and emitted once it is saved. It must only
+// be called from the fn of an edit.
+func (s *Store) stage(events ...Event) {
+	s.staged = append(s.staged, events...)
+}
+
+// revert undoes the changes made to the CRDT since the last published
+// snapshot, which is the state last written to the database, after writing
+// them failed. The clock is kept, so the undone edits' timestamps are not
+// reused. It must be called with s.mu held.
+func (s *Store) revert() {
+	c := crdt.NewCRDT(BoardState{}, s.nodeID)
+	if err := json.Unmarshal(s.snap.Load().crdtJSON, c); err != nil {
+		log.Printf("Failed to revert an unsaved change: %v", err)
+		return
 	}
-	return delta
+	c.Clock().Update(s.crdt.Clock().Now())
+	s.crdt = c
 }
 
 func (s *Store) SilentEdit(fn func(*BoardState)) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	lastModified := s.lastModified
 	delta := s.crdt.Edit(fn)
 	if delta.Timestamp.WallTime != 0 {
-		s.saveState()
+		s.lastModified = delta.Timestamp.WallTime
+		if err := s.saveState(); err != nil {
+			log.Printf("Failed to save state: %v", err)
+			s.lastModified = lastModified
+			s.revert()
+			return
+		}
 		s.Broadcast(WSMessage{Type: "refresh", Silent: true})
-		go s.syncToPeers(delta)
+		if !s.IsArchived() {
+			go s.syncToPeers(delta, stateDigest(s.crdt.View().Board))
+		}
 	}
 }
 
-func (s *Store) syncToPeers(delta crdt.Delta[BoardState]) {
+// syncToPeers pushes delta to every peer and relayed edge along with the
+// digest of the state it produced, so receivers can detect divergence.
+func (s *Store) syncToPeers(delta crdt.Delta[BoardState], digest string) {
+	if !s.Feature(FeatureDeltaPush, "") {
+		return // peers get the edit with the next periodic sync
+	}
 	data, err := json.Marshal(delta)
 	if err != nil {
 		log.Printf("Failed to marshal delta for sync: %v", err)
 		return
 	}
+	data = stampModel(data)
+	s.forwardToEdges(data, digest, nil)
+	s.pushToPeers(data, digest)
+}
 
-	s.mu.RLock()
-	currentPeers := make([]string, len(s.peers))
-	copy(currentPeers, s.peers)
-	s.mu.RUnlock()
-
-	for _, peer := range currentPeers {
+// pushToPeers sends an encoded delta to every peer.
+func (s *Store) pushToPeers(data []byte, digest string) {
+	for _, peer := range s.GetPeers() {
 		go func(p string) {
-			url := fmt.Sprintf("http://%s/api/sync", p)
-			resp, err := peerHTTPClient.Post(url, "application/json", bytes.NewReader(data))
+			start := time.Now()
+			err := s.checkPeer(p)
+			if err == nil {
+				err = s.transport.SendDelta(p, s.basePath, data, digest)
+			}
 			if err != nil {
 				log.Printf("Failed to sync with peer %s: %v", p, err)
+				s.recordPeerResult(p, 0, err)
 				return
 			}
-			resp.Body.Close()
+			s.recordTraffic(p, PeerTraffic{BytesSent: int64(len(data)), DeltasSent: 1})
+			s.recordPeerResult(p, time.Since(start), nil)
 		}(peer)
 	}
 }
@@ -201,9 +471,16 @@ func (s *Store) Merge(other *crdt.CRDT[BoardState]) bool {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	before := s.crdt.View().Board.Cards
 	if s.crdt.Merge(other) {
 		log.Printf("Merged state from remote")
-		s.saveState()
+		changes := remoteChanges(before, s.crdt.View().Board.Cards)
+		if err := s.saveState(changes...); err != nil {
+			log.Printf("Failed to save merged state: %v", err)
+			s.revert()
+			return false
+		}
+		s.observeRemote(changes)
 		s.Broadcast(WSMessage{Type: "refresh"}) // Merge is always a full refresh
 		return true
 	}
@@ -211,10 +488,10 @@ func (s *Store) Merge(other *crdt.CRDT[BoardState]) bool {
 }
 
 func (s *Store) GetHistory(limit int) []string {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	s.histMu.RLock()
+	defer s.histMu.RUnlock()
 
-	rows, err := s.db.Query("SELECT summary FROM patches ORDER BY id DESC LIMIT ?", limit)
+	rows, err := s.db.Query("SELECT summary, actor FROM patches ORDER BY id DESC LIMIT ?", limit)
 	if err != nil {
 		return nil
 	}
@@ -222,8 +499,11 @@ func (s *Store) GetHistory(limit int) []string {
 
 	var history []string
 	for rows.Next() {
-		var summary string
-		if err := rows.Scan(&summary); err == nil {
+		var summary, actor string
+		if err := rows.Scan(&summary, &actor); err == nil {
+			if actor != "" {
+				summary += " (by " + actor + ")"
+			}
 			history = append(history, summary)
 		}
 	}
@@ -231,86 +511,243 @@ func (s *Store) GetHistory(limit int) []string {
 }
 
 func (s *Store) ClearHistory() {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	s.histMu.Lock()
+	defer s.histMu.Unlock()
 	s.db.Exec("DELETE FROM patches")
 	s.Broadcast(WSMessage{Type: "refresh"})
 }
 
-func (s *Store) Reset() {
+func (s *Store) Reset() error {
 	// Perform a "Soft Reset" via Edit so that changes propagate as a Delta.
 	// Replacing the CRDT instance breaks synchronization (clocks reset).
-	s.Edit(func(bs *BoardState) {
-		// 1. Clear Cards
+	err := s.mutate(func(bs *BoardState) {
+		// 1. Clear Cards, and encryption: the sample cards are plaintext
 		bs.Board.Cards = make(map[string]Card)
+		bs.Board.Encryption = Encryption{}
 
 		// 2. Clear Connections (except self, maybe? Logic handles re-add)
 		bs.NodeConnections = []NodeConnection{}
 
-		// 3. Add initial sample data (matching NewInitialBoard)
-		id := "card-1"
-		bs.Board.Cards[id] = Card{
-			ID:       id,
-			Title:    "Try Deep Library",
-			ColumnID: "todo",
-			Order:    1000,
-			Description: crdt.Text{
-				{ID: hlc.HLC{NodeID: "system"}, Value: "Explore the features of the deep library."},
-			},
+		// 3. Add initial sample data (from the seed)
+		for id, c := range s.seed.State.Board.Cards {
+			c.Description = slices.Clone(c.Description)
+			c.Labels = maps.Clone(c.Labels)
+			bs.Board.Cards[id] = c
 		}
 	})
+	if err != nil {
+		return err
+	}
 
 	// Force re-register connection
-	s.mu.Lock()
-	count := len(s.subs)
-	s.mu.Unlock()
-	s.UpdateConnections(count)
+	s.UpdateConnections(s.hub.Len())
+	return nil
+}
+
+// ErrColumnNotFound is returned when an operation names an unknown column.
+var ErrColumnNotFound = errors.New("column not found")
+
+// SetColumnMoveGroups restricts which groups may move cards into a column.
+func (s *Store) SetColumnMoveGroups(colID string, groups []string) error {
+	found := false
+	err := s.mutate(func(bs *BoardState) {
+		for i, col := range bs.Board.Columns {
+			if col.ID == colID {
+				bs.Board.Columns[i].MoveGroups = groups
+				found = true
+				return
+			}
+		}
+	})
+	if err == nil && !found {
+		return ErrColumnNotFound
+	}
+	return err
+}
+
+// IsFrozen reports whether the board currently rejects mutations.
+func (s *Store) IsFrozen() bool {
+	return s.GetBoard().Board.Frozen
+}
+
+// SetFrozen toggles the board-level freeze. The flag lives in the CRDT so it
+// replicates to every peer like any other board change.
+func (s *Store) SetFrozen(frozen bool) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	if !frozen && s.IsArchived() {
+		return ErrBoardArchived
+	}
+	_, err := s.Edit(func(bs *BoardState) {
+		bs.Board.Frozen = frozen
+	})
+	return err
+}
+
+// SetReadOnly makes the store reject all local board mutations while still
+// merging changes from peers. It must be called before serving requests.
+func (s *Store) SetReadOnly(readOnly bool) {
+	s.readOnly = readOnly
+}
+
+// IsReadOnly reports whether the store is a read-only replica.
+func (s *Store) IsReadOnly() bool {
+	return s.readOnly
 }
 
-func (s *Store) saveState() {
+// mutate runs fn through Edit unless the board is frozen or this node is a
+// read-only replica. The freeze check happens inside the edit so it is atomic
+// with respect to concurrent toggles.
+func (s *Store) mutate(fn func(*BoardState)) error {
+	return s.tryMutate(func(bs *BoardState) error {
+		fn(bs)
+		return nil
+	})
+}
+
+// tryMutate is like mutate, but fn may reject the edit (e.g. over quota) by
+// returning an error. It must do so before changing bs.
+func (s *Store) tryMutate(fn func(*BoardState) error) error {
+	return s.tryMutateMsg(&WSMessage{Type: "refresh"}, fn)
+}
+
+// tryMutateMsg is like tryMutate, but broadcasts msg, which fn may fill in.
+func (s *Store) tryMutateMsg(msg *WSMessage, fn func(*BoardState) error) error {
+	return s.tryMutateAs(msg, nil, fn)
+}
+
+// tryMutateAs is like tryMutateMsg, but records what summary returns, once
+// fn has run, in the history in place of the changed paths.
+func (s *Store) tryMutateAs(msg *WSMessage, summary func(after Board) string, fn func(*BoardState) error) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	var err error
+	if _, editErr := s.edit(func(bs *BoardState) {
+		if bs.Board.Frozen {
+			err = ErrBoardFrozen
+			return
+		}
+		err = fn(bs)
+	}, msg, summary); editErr != nil {
+		return editErr
+	}
+	return err
+}
+
+// GetSetting loads a node-local setting into v. It reports false if the
+// setting has never been saved. Settings are not replicated to peers.
+func (s *Store) GetSetting(key string, v any) (bool, error) {
+	var data []byte
+	err := s.db.QueryRow("SELECT value FROM settings WHERE key = ?", key).Scan(&data)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, json.Unmarshal(data, v)
+}
+
+// SetSetting persists a node-local setting.
+func (s *Store) SetSetting(key string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec("INSERT OR REPLACE INTO settings (key, value) VALUES (?, ?)", key, data)
+	return err
+}
+
+// snapshot is an immutable copy of the board, published after every change.
+// Readers load it without the store lock, so they neither block edits nor
+// observe one half applied. Nothing may modify a published snapshot.
+type snapshot struct {
+	state        BoardState
+	crdtJSON     []byte // the full CRDT, including metadata, as served to peers
+	lastModified int64
+	hash         string // boardHash of state, sent to clients to detect drift
+}
+
+// saveState persists the CRDT and, once it is written, publishes a new
+// snapshot. It must be called with s.mu held after every change.
+func (s *Store) saveState(changes ...Event) error {
 	data, _ := json.Marshal(s.crdt)
-	s.db.Exec("INSERT OR REPLACE INTO state (id, data) VALUES ('latest', ?)", data)
+	data = stampModel(data)
+	next := s.snapshotOf(data)
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("INSERT OR REPLACE INTO state (id, data) VALUES ('latest', ?)", data); err != nil {
+		return err
+	}
+	if err := recordChanges(tx, changes); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	s.snap.Store(next)
+	return nil
 }
 
-func (s *Store) savePatchData(timestamp string, patchData []byte, summary string) {
-	log.Printf("Saving patch: %s", summary)
-	s.db.Exec("INSERT INTO patches (timestamp, patch, summary) VALUES (?, ?, ?)",
-		timestamp, patchData, summary)
+// publish makes the CRDT, encoded as crdtJSON, the snapshot readers see. The
+// CRDT must already be persisted.
+func (s *Store) publish(crdtJSON []byte) {
+	s.snap.Store(s.snapshotOf(crdtJSON))
+}
+
+// snapshotOf returns a snapshot of the CRDT, encoded as crdtJSON.
+func (s *Store) snapshotOf(crdtJSON []byte) *snapshot {
+	state := s.crdt.View()
+	return &snapshot{
+		state:        state,
+		crdtJSON:     crdtJSON,
+		lastModified: s.lastModified,
+		hash:         boardHash(state),
+	}
 }
 
 func (s *Store) Subscribe() chan WSMessage {
+	return s.SubscribeAs("")
+}
+
+// SubscribeAs is Subscribe for a client shown to the cluster as name in who
+// is online. An empty name leaves it out.
+func (s *Store) SubscribeAs(name string) chan WSMessage {
 	ch := make(chan WSMessage, 256)
+	s.hub.Add(ch)
+	if name != "" {
+		s.presence.join(ch, name)
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
-
-	s.subs[ch] = time.Now()
-	count := len(s.subs)
+	count := s.hub.Len()
 	s.lastCount = count
 	s.updateConnectionsLocked(count)
 	return ch
 }
 
 func (s *Store) Unsubscribe(ch chan WSMessage) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	if _, ok := s.subs[ch]; !ok {
+	online := s.presence.leave(ch)
+	s.Present(ch, "", "")
+	if !s.hub.Remove(ch) && !online {
 		return
 	}
-	delete(s.subs, ch)
-	close(ch)
 
-	count := len(s.subs)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	count := s.hub.Len()
 	s.lastCount = count
 	s.updateConnectionsLocked(count)
 }
 
 func (s *Store) Heartbeat(ch chan WSMessage) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if _, ok := s.subs[ch]; ok {
-		s.subs[ch] = time.Now()
-	}
+	s.hub.Touch(ch)
 }
 
 func (s *Store) UpdateConnections(count int) {
@@ -321,11 +758,16 @@ func (s *Store) UpdateConnections(count int) {
 }
 
 func (s *Store) updateConnectionsLocked(count int) {
+	users := s.presence.onlineUsers()
 	delta := s.crdt.Edit(func(bs *BoardState) {
+		for i := range users {
+			users[i].Color = bs.Board.assignColor(users[i].Name)
+		}
 		found := false
 		for i, nc := range bs.NodeConnections {
 			if nc.NodeID == s.nodeID {
 				bs.NodeConnections[i].Count = count
+				bs.NodeConnections[i].Users = users
 				found = true
 				break
 			}
@@ -334,26 +776,47 @@ func (s *Store) updateConnectionsLocked(count int) {
 			bs.NodeConnections = append(bs.NodeConnections, NodeConnection{
 				NodeID: s.nodeID,
 				Count:  count,
+				Users:  users,
 			})
 		}
 	})
 	if delta.Timestamp.WallTime != 0 {
-		s.saveState()
+		lastModified := s.lastModified
+		s.lastModified = delta.Timestamp.WallTime
+		if err := s.saveState(); err != nil {
+			log.Printf("Failed to save connections: %v", err)
+			s.lastModified = lastModified
+			s.revert()
+			return
+		}
 		s.Broadcast(WSMessage{Type: "refresh", Silent: true})
-		go s.syncToPeers(delta)
+		if !s.IsArchived() {
+			go s.syncToPeers(delta, stateDigest(s.crdt.View().Board))
+		}
 	}
 }
 
-func (s *Store) AddCard(title string) string {
+func (s *Store) AddCard(title string) (string, error) {
 	id := uuid.New().String()
-	s.Edit(func(bs *BoardState) {
+	var column string
+	err := s.tryMutate(func(bs *BoardState) error {
+		if err := s.quota.checkCards(len(bs.Board.Cards) + 1); err != nil {
+			return err
+		}
+		if err := s.checkGrowth(len(title)); err != nil {
+			return err
+		}
+		if err := checkSealed(bs, title); err != nil {
+			return err
+		}
 		if bs.Board.Cards == nil {
 			bs.Board.Cards = make(map[string]Card)
 		}
-		// Find max order in todo
+		// Find max order in the default column
+		column = defaultColumn(bs.Board)
 		maxOrder := 0.0
 		for _, c := range bs.Board.Cards {
-			if c.ColumnID == "todo" && c.Order > maxOrder {
+			if c.ColumnID == column && c.Order > maxOrder {
 				maxOrder = c.Order
 			}
 		}
@@ -361,81 +824,375 @@ func (s *Store) AddCard(title string) string {
 			ID:          id,
 			Title:       title,
 			Description: crdt.Text{},
-			ColumnID:    "todo",
+			Labels:      LabelSet{},
+			Checklist:   []ChecklistItem{},
+			ColumnID:    column,
 			Order:       maxOrder + 1000,
+			EnteredAt:   time.Now().Unix(),
+			Number:      nextCardNumber(bs),
 		}
+		s.stage(Event{Type: EventCardCreated, CardID: id, Title: title, Column: column})
+		return nil
 	})
-	return id
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// ImportCards creates one card per draft in a single edit so an import is
+// applied (and replicated) atomically. Drafts whose column does not exist are
+// placed in the default column. It returns the IDs of the created cards.
+func (s *Store) ImportCards(drafts []CardDraft) ([]string, error) {
+	ids := make([]string, len(drafts))
+	cols := make([]string, len(drafts))
+	err := s.tryMutate(func(bs *BoardState) error {
+		if err := s.addDrafts(bs, drafts, ids, cols); err != nil {
+			return err
+		}
+		for i, id := range ids {
+			s.stage(Event{Type: EventCardCreated, CardID: id, Title: drafts[i].Title, Column: cols[i]})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
 }
 
-func (s *Store) MoveCard(cardID, toCol string, toIndex int) {
-	s.Edit(func(bs *BoardState) {
+// addDrafts adds a card per draft to bs, for ImportCards, recording the ID
+// and column of each in ids and cols. It changes nothing if it fails.
+func (s *Store) addDrafts(bs *BoardState, drafts []CardDraft, ids, cols []string) error {
+	if err := s.quota.checkCards(len(bs.Board.Cards) + len(drafts)); err != nil {
+		return err
+	}
+	size := 0
+	for _, d := range drafts {
+		size += len(d.Title) + len(d.Description)
+		if err := s.quota.checkDescription(len(d.Description)); err != nil {
+			return fmt.Errorf("card %q: %w", d.Title, err)
+		}
+		if err := checkSealed(bs, d.Title); err != nil {
+			return fmt.Errorf("card %q: %w", d.Title, err)
+		}
+		if err := checkSealed(bs, d.Description); err != nil {
+			return fmt.Errorf("card %q: %w", d.Title, err)
+		}
+	}
+	if err := s.checkGrowth(size); err != nil {
+		return err
+	}
+	if bs.Board.Cards == nil {
+		bs.Board.Cards = make(map[string]Card)
+	}
+	columns := make(map[string]bool, len(bs.Board.Columns))
+	for _, col := range bs.Board.Columns {
+		columns[col.ID] = true
+	}
+	maxOrder := make(map[string]float64)
+	for _, c := range bs.Board.Cards {
+		if c.Order > maxOrder[c.ColumnID] {
+			maxOrder[c.ColumnID] = c.Order
+		}
+	}
+	now := time.Now().Unix()
+	number := nextCardNumber(bs)
+	for i, d := range drafts {
+		colID := d.ColumnID
+		if !columns[colID] {
+			colID = defaultColumn(bs.Board)
+		}
+		maxOrder[colID] += 1000
+		id := uuid.New().String()
+		ids[i] = id
+		cols[i] = colID
+		bs.Board.Cards[id] = Card{
+			ID:          id,
+			Title:       d.Title,
+			Description: textInsert(crdt.Text{}, 0, d.Description, s.crdt.Clock()),
+			ColumnID:    colID,
+			Order:       maxOrder[colID],
+			Assignee:    d.Assignee,
+			Labels:      labelSetOf(d.Labels...),
+			Checklist:   []ChecklistItem{},
+			Priority:    d.Priority,
+			EnteredAt:   now,
+			Number:      number + i,
+		}
+	}
+	return nil
+}
+
+func (s *Store) MoveCard(cardID, toCol string, toIndex int) error {
+	msg := &WSMessage{Type: "refresh"}
+	return s.tryMutateMsg(msg, func(bs *BoardState) error {
 		card, ok := bs.Board.Cards[cardID]
 		if !ok {
-			return
+			return nil
+		}
+		if columnIndex(bs.Board, toCol) < 0 {
+			return ErrColumnNotFound
 		}
+		s.stage(Event{Type: EventCardMoved, CardID: cardID, Title: card.Title, From: card.ColumnID, Column: toCol})
+		moved, index := placeCard(bs, card, toCol, toIndex)
+		msg.Move = &MoveOp{CardID: cardID, FromCol: card.ColumnID, ToCol: toCol, ToIndex: index}
+		bs.Board.Cards[cardID] = moved
+		return nil
+	})
+}
 
-		// Collect and sort the other cards in the target column.
-		var colCards []Card
-		for _, c := range bs.Board.Cards {
-			if c.ColumnID == toCol && c.ID != cardID {
-				colCards = append(colCards, c)
+// placeCard returns card as moved to index toIndex of column toCol of bs,
+// and that index, clamped to the column.
+func placeCard(bs *BoardState, card Card, toCol string, toIndex int) (Card, int) {
+	// Collect and sort the other cards in the target column.
+	var colCards []Card
+	for _, c := range bs.Board.Cards {
+		if c.ColumnID == toCol && c.ID != card.ID {
+			colCards = append(colCards, c)
+		}
+	}
+	sortCards(colCards)
+
+	// Compute new order using fractional indexing.
+	var newOrder float64
+	switch {
+	case len(colCards) == 0:
+		newOrder = 1000
+	case toIndex <= 0:
+		newOrder = colCards[0].Order - 1000
+	case toIndex >= len(colCards):
+		newOrder = colCards[len(colCards)-1].Order + 1000
+	default:
+		newOrder = (colCards[toIndex-1].Order + colCards[toIndex].Order) / 2
+	}
+
+	if card.ColumnID != toCol {
+		card.EnteredAt = time.Now().Unix()
+		card.Overdue = false
+	}
+	card.ColumnID = toCol
+	card.Order = newOrder
+	return card, min(max(toIndex, 0), len(colCards))
+}
+
+func (s *Store) UpdateCardText(cardID, op, val string, pos, length int) error {
+	return s.updateCardText(cardID, op, val, pos, length, false)
+}
+
+// ApplyTextOp applies a client's edit of a card description. Unlike
+// UpdateCardText, which clamps positions, it rejects ops that do not fit the
+// description: they were computed against another text, and would edit the
+// wrong characters.
+func (s *Store) ApplyTextOp(op TextOp) error {
+	return s.updateCardText(op.CardID, op.Op, op.Val, op.Pos, op.Length, true)
+}
+
+func (s *Store) updateCardText(cardID, op, val string, pos, length int, strict bool) error {
+	return s.tryMutate(func(bs *BoardState) error {
+		card, ok := bs.Board.Cards[cardID]
+		if !ok {
+			return nil
+		}
+		if strict {
+			if err := checkTextOp(op, pos, length, textLen(card.Description)); err != nil {
+				return err
+			}
+		}
+		if op == "insert" {
+			if err := s.quota.checkDescription(textLen(card.Description) + len(val)); err != nil {
+				return err
+			}
+		}
+		if op == "replace" {
+			if err := s.quota.checkDescription(len(val)); err != nil {
+				return err
+			}
+		}
+		if op != "delete" {
+			if err := s.checkGrowth(len(val)); err != nil {
+				return err
 			}
 		}
-		sortCards(colCards)
+		// Encrypted descriptions are only ever replaced whole.
+		if bs.Board.Encryption.Enabled() && (op != "replace" || !isSealed(val)) {
+			return ErrPlaintext
+		}
+		s.stage(Event{Type: EventCardUpdated, CardID: cardID})
+		if op == "insert" {
+			card.Description = textInsert(card.Description, pos, val, s.crdt.Clock())
+		} else if op == "delete" {
+			card.Description = textDelete(card.Description, pos, length)
+		} else if op == "replace" {
+			card.Description = textDelete(card.Description, 0, textLen(card.Description))
+			card.Description = textInsert(card.Description, 0, val, s.crdt.Clock())
+		}
+		bs.Board.Cards[cardID] = card
+		return nil
+	})
+}
+
+// UpdateCardTitle applies a text op, sent while a title is typed, to a card
+// title. Unlike a description, a title is a plain string, not a text CRDT:
+// the op is applied here and the result replaces the title whole. When two
+// nodes edit one title at once, the titles converge on the last edit and
+// the other edit is lost, not merged.
+func (s *Store) UpdateCardTitle(cardID, op, val string, pos, length int) error {
+	return s.tryMutate(func(bs *BoardState) error {
+		card, ok := bs.Board.Cards[cardID]
+		if !ok {
+			return nil
+		}
+		// Encrypted titles are only ever replaced whole.
+		if bs.Board.Encryption.Enabled() && (op != "replace" || !isSealed(val)) {
+			return ErrPlaintext
+		}
+		if err := checkTextOp(op, pos, length, len(card.Title)); err != nil {
+			return err
+		}
+		if op != "delete" {
+			if err := s.checkGrowth(len(val)); err != nil {
+				return err
+			}
+		}
+		title := spliceText(card.Title, op, val, pos, length)
+		if title == card.Title {
+			return nil
+		}
+		card.Title = title
+		bs.Board.Cards[cardID] = card
+		s.stage(Event{Type: EventCardUpdated, CardID: cardID, Title: title})
+		return nil
+	})
+}
+
+// AddLabel adds label to a card unless it already has it. Unknown cards are
+// skipped.
+func (s *Store) AddLabel(cardID, label string) error {
+	if err := s.LabelCard(cardID, label, true); err != ErrCardNotFound {
+		return err
+	}
+	return nil
+}
 
-		// Compute new order using fractional indexing.
-		var newOrder float64
-		switch {
-		case len(colCards) == 0:
-			newOrder = 1000
-		case toIndex <= 0:
-			newOrder = colCards[0].Order - 1000
-		case toIndex >= len(colCards):
-			newOrder = colCards[len(colCards)-1].Order + 1000
-		default:
-			newOrder = (colCards[toIndex-1].Order + colCards[toIndex].Order) / 2
+// AssignCard sets the card's assignee, see users.go. An empty assignee
+// unassigns it.
+func (s *Store) AssignCard(cardID, assignee string) error {
+	assignee = strings.TrimSpace(assignee)
+	return s.tryMutate(func(bs *BoardState) error {
+		card, ok := bs.Board.Cards[cardID]
+		if !ok {
+			return ErrCardNotFound
 		}
+		if card.Assignee == assignee {
+			return nil
+		}
+		card.Assignee = assignee
+		bs.Board.Cards[cardID] = card
+		s.stage(Event{Type: EventCardAssigned, CardID: cardID, Title: card.Title, Assignee: assignee})
+		return nil
+	})
+}
+
+// SetLabels replaces the card's labels.
+func (s *Store) SetLabels(cardID string, labels []string) error {
+	return s.tryMutate(func(bs *BoardState) error {
+		card, ok := bs.Board.Cards[cardID]
+		if !ok {
+			return ErrCardNotFound
+		}
+		card.Labels = labelSetOf(labels...)
+		bs.Board.Cards[cardID] = card
+		return nil
+	})
+}
 
-		card.ColumnID = toCol
-		card.Order = newOrder
+// SetPriority sets the card's priority. An empty priority clears it.
+func (s *Store) SetPriority(cardID, priority string) error {
+	return s.tryMutate(func(bs *BoardState) error {
+		card, ok := bs.Board.Cards[cardID]
+		if !ok {
+			return ErrCardNotFound
+		}
+		card.Priority = priority
 		bs.Board.Cards[cardID] = card
+		return nil
 	})
 }
 
-func (s *Store) UpdateCardText(cardID, op, val string, pos, length int) {
-	s.Edit(func(bs *BoardState) {
+// LinkIssue records the external issue a card is tracked by.
+func (s *Store) LinkIssue(cardID string, number int, url string) error {
+	return s.mutate(func(bs *BoardState) {
 		card, ok := bs.Board.Cards[cardID]
 		if !ok {
 			return
 		}
-		if op == "insert" {
-			card.Description = card.Description.Insert(pos, val, s.crdt.Clock())
-		} else if op == "delete" {
-			card.Description = card.Description.Delete(pos, length)
+		card.IssueNumber = number
+		card.IssueURL = url
+		bs.Board.Cards[cardID] = card
+	})
+}
+
+// CardByIssue returns the ID of the card linked to the given issue number.
+func (s *Store) CardByIssue(number int) (string, bool) {
+	for id, c := range s.GetBoard().Board.Cards {
+		if c.IssueNumber == number {
+			return id, true
+		}
+	}
+	return "", false
+}
+
+// AddComment appends a comment to a card.
+func (s *Store) AddComment(cardID, author, body string) error {
+	return s.tryMutate(func(bs *BoardState) error {
+		card, ok := bs.Board.Cards[cardID]
+		if !ok {
+			return nil
+		}
+		if err := s.checkGrowth(len(author) + len(body)); err != nil {
+			return err
 		}
+		card.Comments = append(card.Comments, Comment{
+			ID:     uuid.New().String(),
+			Author: author,
+			Body:   body,
+			Time:   time.Now().Unix(),
+		})
 		bs.Board.Cards[cardID] = card
+		s.stage(Event{Type: EventCardCommented, CardID: cardID, Title: card.Title, Author: author, Comment: body})
+		return nil
 	})
 }
 
-func (s *Store) DeleteCard(cardID string) {
-	s.Edit(func(bs *BoardState) {
+func (s *Store) DeleteCard(cardID string) error {
+	return s.mutate(func(bs *BoardState) {
+		card, ok := bs.Board.Cards[cardID]
+		if !ok {
+			return
+		}
+		s.stage(Event{Type: EventCardDeleted, CardID: cardID, Title: card.Title, Column: card.ColumnID})
 		delete(bs.Board.Cards, cardID)
+		s.trash.put(card, time.Now())
 	})
 }
 
+// Notify delivers msg to a single subscriber, if it is still registered.
+func (s *Store) Notify(ch chan WSMessage, msg WSMessage) {
+	s.hub.Send(ch, msg)
+}
+
+// Broadcast queues msg for every subscriber. The fan-out happens on the
+// hub's dispatcher, so this is cheap to call with the store lock held.
 func (s *Store) Broadcast(msg WSMessage) {
-	subCount := len(s.subs)
+	subCount := s.hub.Len()
 	if subCount > 0 && !msg.Silent {
 		log.Printf("Broadcasting refresh to %d subscribers", subCount)
 	}
-
-	for ch := range s.subs {
-		select {
-		case ch <- msg:
-		default:
-		}
+	if msg.Type == "refresh" {
+		msg.Hash = s.snap.Load().hash
 	}
+	s.hub.Broadcast(msg)
 }
 
 // parseDeltaPaths extracts the operation paths from a marshaled Delta.
@@ -474,15 +1231,45 @@ func deltaSummary(paths []string) string {
 }
 
 // isConnectionOnlyDelta returns true when every operation in the delta targets
-// the nodeConnections slice, so callers can suppress noisy UI refreshes.
+// the NodeConnections slice or the announcements, so callers can suppress
+// noisy UI refreshes.
 func isConnectionOnlyDelta(paths []string) bool {
 	if len(paths) == 0 {
 		return false
 	}
 	for _, p := range paths {
-		if !strings.HasPrefix(p, "/nodeConnections") {
+		if !strings.HasPrefix(p, "/NodeConnections") && !strings.HasPrefix(p, "/Announcements") {
 			return false
 		}
 	}
 	return true
 }
+
+// moveHint describes the change from before to after as a single card move,
+// so clients can animate it. It returns nil unless exactly one card changed
+// position.
+func moveHint(before, after map[string]Card) *MoveOp {
+	var hint *MoveOp
+	for id, a := range after {
+		b, ok := before[id]
+		if !ok || (a.ColumnID == b.ColumnID && a.Order == b.Order) {
+			continue
+		}
+		if hint != nil {
+			return nil
+		}
+		hint = &MoveOp{CardID: id, FromCol: b.ColumnID, ToCol: a.ColumnID}
+	}
+	if hint == nil {
+		return nil
+	}
+	var colCards []Card
+	for _, c := range after {
+		if c.ColumnID == hint.ToCol {
+			colCards = append(colCards, c)
+		}
+	}
+	sortCards(colCards)
+	hint.ToIndex = slices.IndexFunc(colCards, func(c Card) bool { return c.ID == hint.CardID })
+	return hint
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"text/template"
+)
+
+// History entries describe an edit in words: "Moved 'Fix login bug' to Done"
+// rather than the paths it changed. Each changed field of a card, or of the
+// board, has a template; the "history" section of the -config file adds
+// templates for other fields or replaces the built-in ones:
+//
+//	{"history": {"Estimate": "Sized '{{.Card}}' at {{.Value}} points"}}
+//
+// Card fields are named as in Card ("Estimate"), board fields with a
+// "Board." prefix ("Board.Title"). Changes outside the board, like
+// connection counts, keep their raw paths.
+
+// SummaryData is what a summary template is executed with.
+type SummaryData struct {
+	Card  string // title of the card, or its key if the title is sealed
+	Key   string // key of the card, e.g. DB-12
+	Field string // the field changed, e.g. "Estimate"
+	Value string // its new value, column and sprint IDs resolved to names
+	Old   string // its value before
+}
+
+// maxSummaryParts is how many changes an entry names before it counts the
+// rest.
+const maxSummaryParts = 3
+
+// builtinSummaries are the templates of the known fields.
+var builtinSummaries = map[string]string{
+	"Title":       `Renamed '{{.Old}}' to '{{.Card}}'`,
+	"Description": `Edited description of '{{.Card}}'`,
+	"ColumnID":    `Moved '{{.Card}}' to {{.Value}}`,
+	"Order":       `Reordered '{{.Card}}'`,
+	"Assignee":    `{{if .Value}}Assigned '{{.Card}}' to {{.Value}}{{else}}Unassigned '{{.Card}}'{{end}}`,
+	"Labels":      `Changed labels of '{{.Card}}'`,
+	"Priority":    `{{if .Value}}Set priority of '{{.Card}}' to {{.Value}}{{else}}Cleared priority of '{{.Card}}'{{end}}`,
+	"Comments":    `Commented on '{{.Card}}'`,
+	"Votes":       `Voted on '{{.Card}}'`,
+	"Checklist":   `Changed the checklist of '{{.Card}}'`,
+	"Estimate":    `{{if .Value}}Estimated '{{.Card}}' at {{.Value}}{{else}}Cleared estimate of '{{.Card}}'{{end}}`,
+	"Due":         `{{if .Value}}Set '{{.Card}}' due {{.Value}}{{else}}Cleared due date of '{{.Card}}'{{end}}`,
+	"Sprint":      `{{if .Value}}Added '{{.Card}}' to {{.Value}}{{else}}Moved '{{.Card}}' to the backlog{{end}}`,
+	"Overdue":     `{{if eq .Value "true"}}Flagged '{{.Card}}' overdue{{else}}Cleared overdue flag of '{{.Card}}'{{end}}`,
+	"Number":      `Numbered '{{.Card}}' {{.Key}}`,
+	"IssueNumber": `Linked '{{.Card}}' to issue #{{.Value}}`,
+
+	"Board.Title":    `Renamed the board to '{{.Value}}'`,
+	"Board.Columns":  `Changed the columns`,
+	"Board.Frozen":   `{{if eq .Value "true"}}Froze{{else}}Unfroze{{end}} the board`,
+	"Board.Archived": `{{if eq .Value "true"}}Archived{{else}}Unarchived{{end}} the board`,
+	"Board.Boards":   `Changed the list of boards`,
+}
+
+// Templates of the fields no other template covers.
+const (
+	otherCardSummary  = `Changed {{.Field}} of '{{.Card}}'`
+	otherBoardSummary = `Changed board {{.Field}}`
+)
+
+// SummaryTemplates are the parsed summary templates, by field.
+type SummaryTemplates map[string]*template.Template
+
+// parseSummaries parses the built-in templates overridden by custom.
+func parseSummaries(custom map[string]string) (SummaryTemplates, error) {
+	t := SummaryTemplates{}
+	for _, set := range []map[string]string{builtinSummaries, custom} {
+		for field, text := range set {
+			tmpl, err := template.New(field).Parse(text)
+			if err != nil {
+				return nil, fmt.Errorf("history template %s: %w", field, err)
+			}
+			t[field] = tmpl
+		}
+	}
+	return t, nil
+}
+
+var (
+	// defaultSummaries are the built-in templates, used until
+	// SetSummaryTemplates replaces them.
+	defaultSummaries, _ = parseSummaries(nil)
+
+	otherCardTemplate  = template.Must(template.New("card").Parse(otherCardSummary))
+	otherBoardTemplate = template.Must(template.New("board").Parse(otherBoardSummary))
+)
+
+// SetSummaryTemplates describes history entries with t. It must be called
+// before serving requests.
+func (s *Store) SetSummaryTemplates(t SummaryTemplates) {
+	s.summaries = t
+}
+
+// summaryChange is one field changed by an edit; Field is "" when a whole
+// card was added or removed. CardID is "" for board fields.
+type summaryChange struct {
+	CardID string
+	Field  string
+}
+
+// summaryOf returns the summary of an edit that changed paths of prev, to
+// be called with the board the edit produced.
+func (s *Store) summaryOf(paths []string, prev Board) func(after Board) string {
+	return func(after Board) string { return s.summarize(paths, prev, after) }
+}
+
+// summarize describes an edit that changed paths, turning before into after.
+func (s *Store) summarize(paths []string, before, after Board) string {
+	var changes []summaryChange
+	var other []string
+	seen := map[summaryChange]bool{}
+	moved := map[string]bool{}
+	for _, p := range paths {
+		parts := strings.Split(strings.TrimPrefix(p, "/"), "/")
+		var c summaryChange
+		switch {
+		case len(parts) >= 3 && parts[0] == "Board" && parts[1] == "Cards":
+			c.CardID = parts[2]
+			if len(parts) > 3 {
+				c.Field = parts[3]
+			}
+		case len(parts) >= 2 && parts[0] == "Board":
+			c.Field = "Board." + parts[1]
+		default:
+			other = append(other, p)
+			continue
+		}
+		if c.Field == "ColumnID" {
+			moved[c.CardID] = true
+		}
+		if !seen[c] {
+			seen[c] = true
+			changes = append(changes, c)
+		}
+	}
+	if len(changes) == 0 {
+		return deltaSummary(other)
+	}
+
+	templates := s.summaries
+	if templates == nil {
+		templates = defaultSummaries
+	}
+	var texts []string
+	for _, c := range changes {
+		// Column clocks restart on moves, which also reorder the card.
+		if c.Field == "EnteredAt" || moved[c.CardID] && (c.Field == "Order" || c.Field == "Overdue") {
+			continue
+		}
+		texts = append(texts, describeChange(templates, c, before, after))
+	}
+	if len(texts) > maxSummaryParts {
+		texts = append(texts[:maxSummaryParts], fmt.Sprintf("and %d more changes", len(texts)-maxSummaryParts))
+	}
+	return strings.Join(texts, "; ")
+}
+
+// describeChange describes one change with its template.
+func describeChange(templates SummaryTemplates, c summaryChange, before, after Board) string {
+	if c.CardID == "" {
+		tmpl, ok := templates[c.Field]
+		if !ok {
+			tmpl = otherBoardTemplate
+		}
+		name := strings.TrimPrefix(c.Field, "Board.")
+		return execSummary(tmpl, SummaryData{
+			Field: name,
+			Value: fieldValue(reflect.ValueOf(after), name),
+			Old:   fieldValue(reflect.ValueOf(before), name),
+		})
+	}
+
+	old, hadOld := before.Cards[c.CardID]
+	card, ok := after.Cards[c.CardID]
+	switch {
+	case c.Field == "" && !hadOld:
+		return "Added '" + cardName(card) + "'"
+	case c.Field == "" && !ok:
+		return "Deleted '" + cardName(old) + "'"
+	case c.Field == "":
+		return "Changed '" + cardName(card) + "'"
+	case !ok:
+		card = old
+	}
+	tmpl, found := templates[c.Field]
+	if !found {
+		tmpl = otherCardTemplate
+	}
+	data := SummaryData{
+		Card:  cardName(card),
+		Key:   cardKey(card.Number),
+		Field: c.Field,
+		Value: fieldValue(reflect.ValueOf(card), c.Field),
+		Old:   fieldValue(reflect.ValueOf(old), c.Field),
+	}
+	switch c.Field {
+	case "Title":
+		data.Old = cardName(old)
+	case "ColumnID":
+		data.Value = columnName(after, card.ColumnID)
+		data.Old = columnName(before, old.ColumnID)
+	case "Sprint":
+		data.Value = sprintName(after, card.Sprint)
+		data.Old = sprintName(before, old.Sprint)
+	}
+	return execSummary(tmpl, data)
+}
+
+func execSummary(tmpl *template.Template, data SummaryData) string {
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return fmt.Sprintf("Changed %s (%v)", data.Field, err)
+	}
+	return b.String()
+}
+
+// cardName is how the history names c: its title, unless that is sealed.
+func cardName(c Card) string {
+	if isSealed(c.Title) {
+		if key := cardKey(c.Number); key != "" {
+			return key
+		}
+		return "a card"
+	}
+	return c.Title
+}
+
+// fieldValue returns the named field of v if it is a plain value, or "".
+func fieldValue(v reflect.Value, name string) string {
+	f := v.FieldByName(name)
+	switch f.Kind() {
+	case reflect.String, reflect.Bool, reflect.Int, reflect.Int64, reflect.Float64:
+		if f.IsZero() && f.Kind() != reflect.Bool {
+			return ""
+		}
+		return fmt.Sprint(f.Interface())
+	}
+	return ""
+}
+
+// columnName returns the title of column id of b, or id if it has none.
+func columnName(b Board, id string) string {
+	for _, col := range b.Columns {
+		if col.ID == id {
+			return col.Title
+		}
+	}
+	return id
+}
+
+// sprintName returns the name of sprint id of b, or "" for the backlog.
+func sprintName(b Board, id string) string {
+	for _, sp := range b.Sprints {
+		if sp.ID == id {
+			return sp.Name
+		}
+	}
+	return id
+}
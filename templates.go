@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"slices"
+	"time"
+)
+
+// ErrTemplateNotFound is returned for an unknown card template.
+var ErrTemplateNotFound = errors.New("card template not found")
+
+// variableName is what a board variable may be called, as in placeholders.
+var variableName = regexp.MustCompile(`^[\w.-]+$`)
+
+// CardTemplate is a card that can be created on demand. Its title,
+// description and assignee may contain placeholders. Templates and the
+// board's variables live in the CRDT, so every node offers the same ones.
+type CardTemplate struct {
+	Name        string   `deep:"key" json:"name"`
+	Title       string   `json:"title"`
+	Description string   `json:"description"`
+	ColumnID    string   `json:"columnID"`
+	Assignee    string   `json:"assignee"`
+	Labels      []string `json:"labels"`
+	Priority    string   `json:"priority"`
+}
+
+// SaveTemplate adds t, or replaces the template of the same name.
+func (s *Store) SaveTemplate(t CardTemplate) error {
+	if t.Name == "" || t.Title == "" {
+		return fmt.Errorf("template needs a name and a title")
+	}
+	return s.tryMutate(func(bs *BoardState) error {
+		i := slices.IndexFunc(bs.Board.Templates, func(o CardTemplate) bool { return o.Name == t.Name })
+		if i < 0 {
+			bs.Board.Templates = append(bs.Board.Templates, t)
+		} else {
+			bs.Board.Templates[i] = t
+		}
+		return nil
+	})
+}
+
+// DeleteTemplate removes the template called name.
+func (s *Store) DeleteTemplate(name string) error {
+	return s.tryMutate(func(bs *BoardState) error {
+		i := slices.IndexFunc(bs.Board.Templates, func(o CardTemplate) bool { return o.Name == name })
+		if i < 0 {
+			return ErrTemplateNotFound
+		}
+		bs.Board.Templates = slices.Delete(bs.Board.Templates, i, i+1)
+		return nil
+	})
+}
+
+// SetVariable sets a board variable for templates. An empty value removes
+// it.
+func (s *Store) SetVariable(name, value string) error {
+	if !variableName.MatchString(name) {
+		return fmt.Errorf("invalid variable name %q", name)
+	}
+	return s.tryMutate(func(bs *BoardState) error {
+		if value == "" {
+			delete(bs.Board.Variables, name)
+			return nil
+		}
+		if bs.Board.Variables == nil {
+			bs.Board.Variables = make(map[string]string)
+		}
+		bs.Board.Variables[name] = value
+		return nil
+	})
+}
+
+// templateInputs returns the placeholders of t that neither the board nor
+// the built-in variables define, which the user has to fill in.
+func templateInputs(t CardTemplate, board Board) []string {
+	builtin := builtinVariables(time.Time{}, "")
+	var inputs []string
+	for _, name := range placeholders(t.Title, t.Description, t.Assignee) {
+		if _, ok := (VarResolver{board.Variables, builtin}).Lookup(name); !ok {
+			inputs = append(inputs, name)
+		}
+	}
+	return inputs
+}
+
+// CreateFromTemplate creates a card from the template called name, with
+// input, the variables the user filled in, taking precedence over the
+// board's. user is the creator, for {{user}}.
+func (s *Store) CreateFromTemplate(name string, input map[string]string, user string, now time.Time) (string, error) {
+	board := s.GetBoard().Board
+	i := slices.IndexFunc(board.Templates, func(o CardTemplate) bool { return o.Name == name })
+	if i < 0 {
+		return "", ErrTemplateNotFound
+	}
+	t := board.Templates[i]
+	r := VarResolver{input, board.Variables, builtinVariables(now, user)}
+	title, m1 := r.Expand(t.Title)
+	desc, m2 := r.Expand(t.Description)
+	assignee, m3 := r.Expand(t.Assignee)
+	if missing := slices.Compact(slices.Sorted(slices.Values(slices.Concat(m1, m2, m3)))); len(missing) > 0 {
+		return "", MissingVariablesError(missing)
+	}
+	ids, err := s.ImportCards([]CardDraft{{
+		Title:       title,
+		Description: desc,
+		ColumnID:    t.ColumnID,
+		Assignee:    assignee,
+		Labels:      slices.Clone(t.Labels),
+		Priority:    t.Priority,
+	}})
+	if err != nil {
+		return "", err
+	}
+	return ids[0], nil
+}
+
+// TemplateInfo is a template as listed to clients, with the variables the
+// user has to fill in.
+type TemplateInfo struct {
+	CardTemplate
+	Inputs []string `json:"inputs"`
+}
+
+// handleTemplates lists the board's card templates, GET /api/templates.
+func handleTemplates(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		board := s.GetBoard().Board
+		out := make([]TemplateInfo, 0, len(board.Templates))
+		for _, t := range board.Templates {
+			out = append(out, TemplateInfo{CardTemplate: t, Inputs: templateInputs(t, board)})
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(out)
+	}
+}
+
+// handleCreateFromTemplate creates a card from a template, POST
+// /api/cards/from-template with {"template": "bug", "vars": {"sprint": "12"}}.
+// It answers the new card's ID, or 400 naming the variables still missing.
+func handleCreateFromTemplate(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req struct {
+			Template string            `json:"template"`
+			Vars     map[string]string `json:"vars"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		state := s.GetBoard()
+		if i := slices.IndexFunc(state.Board.Templates, func(o CardTemplate) bool { return o.Name == req.Template }); i >= 0 {
+			col := state.Board.Templates[i].ColumnID
+			if col == "" {
+				col = defaultColumn(state.Board)
+			}
+			if err := checkColumnAccess(state, currentUser(r), col); err != nil {
+				writeMutationError(w, err)
+				return
+			}
+		}
+		id, err := s.CreateFromTemplate(req.Template, req.Vars, homeUser(r), time.Now())
+		if err != nil {
+			writeMutationError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			ID string `json:"id"`
+		}{id})
+	}
+}
+
+// handleAdminTemplates manages card templates: GET lists, POST saves one
+// (replacing the template of the same name), DELETE ?name= removes one.
+func handleAdminTemplates(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(s.GetBoard().Board.Templates)
+		case http.MethodPost:
+			var t CardTemplate
+			if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+				writeError(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if t.Name == "" || t.Title == "" {
+				writeError(w, "template needs a name and a title", http.StatusBadRequest)
+				return
+			}
+			if err := s.SaveTemplate(t); err != nil {
+				writeMutationError(w, err)
+				return
+			}
+			log.Printf("ADMIN: Saved card template %q", t.Name)
+			s.Audit(r, AuditTemplatesChange, fmt.Sprintf("saved template %q", t.Name))
+			w.WriteHeader(http.StatusOK)
+		case http.MethodDelete:
+			name := r.URL.Query().Get("name")
+			if err := s.DeleteTemplate(name); err != nil {
+				writeMutationError(w, err)
+				return
+			}
+			log.Printf("ADMIN: Deleted card template %q", name)
+			s.Audit(r, AuditTemplatesChange, fmt.Sprintf("deleted template %q", name))
+			w.WriteHeader(http.StatusOK)
+		default:
+			writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// handleVariables manages the board's template variables: GET lists them,
+// POST {"name": "sprint", "value": "12"} sets one (an empty value removes
+// it).
+func handleVariables(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			vars := s.GetBoard().Board.Variables
+			if vars == nil {
+				vars = map[string]string{}
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(vars)
+		case http.MethodPost:
+			var req struct {
+				Name  string `json:"name"`
+				Value string `json:"value"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeError(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if !variableName.MatchString(req.Name) {
+				writeError(w, "invalid variable name", http.StatusBadRequest)
+				return
+			}
+			if err := s.SetVariable(req.Name, req.Value); err != nil {
+				writeMutationError(w, err)
+				return
+			}
+			log.Printf("ADMIN: Set template variable %s=%q", req.Name, req.Value)
+			s.Audit(r, AuditTemplatesChange, fmt.Sprintf("variable %s=%q", req.Name, req.Value))
+			w.WriteHeader(http.StatusOK)
+		default:
+			writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// tenantPathPrefix is where tenant boards are mounted. Peers always reach a
+// tenant through it, so each tenant only ever syncs with the same tenant on
+// other nodes.
+const tenantPathPrefix = "/t/"
+
+var tenantNameRe = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+// defaultBoardKey names the default board in per-board routes such as
+// /ws/{board}; tenant boards go by their tenant name.
+const defaultBoardKey = "default"
+
+type basePathKey struct{}
+
+// requestBase returns the path the current board is mounted at ("" for the
+// default board or a tenant served from its own subdomain).
+func requestBase(r *http.Request) string {
+	base, _ := r.Context().Value(basePathKey{}).(string)
+	return base
+}
+
+// parseTenants splits and validates the -tenants flag.
+func parseTenants(spec string) ([]string, error) {
+	var names []string
+	seen := map[string]bool{}
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !tenantNameRe.MatchString(name) {
+			return nil, fmt.Errorf("invalid tenant name %q (use lowercase letters, digits and dashes)", name)
+		}
+		if name == defaultBoardKey {
+			return nil, fmt.Errorf("tenant name %q is reserved for the default board", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate tenant %q", name)
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// tenantDBPath derives a tenant's database from the main one:
+// deepboard.db becomes deepboard-<tenant>.db.
+func tenantDBPath(dbPath, tenant string) string {
+	ext := filepath.Ext(dbPath)
+	return strings.TrimSuffix(dbPath, ext) + "-" + tenant + ext
+}
+
+// TenantRouter dispatches requests to the default board or to a tenant's
+// board, selected by a /t/<tenant>/ path prefix or, when domain is set, by a
+// <tenant>.<domain> host name.
+type TenantRouter struct {
+	root    http.Handler
+	domain  string
+	mu      sync.RWMutex
+	tenants map[string]http.Handler
+}
+
+func newTenantRouter(root http.Handler, domain string) *TenantRouter {
+	return &TenantRouter{root: root, tenants: map[string]http.Handler{}, domain: domain}
+}
+
+// Add mounts a tenant's board handler.
+func (t *TenantRouter) Add(name string, h http.Handler) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.tenants[name] = h
+}
+
+// Remove unmounts a tenant's board handler.
+func (t *TenantRouter) Remove(name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.tenants, name)
+}
+
+func (t *TenantRouter) tenant(name string) (http.Handler, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	h, ok := t.tenants[name]
+	return h, ok
+}
+
+func (t *TenantRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if t.domain != "" {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		if name, ok := strings.CutSuffix(host, "."+t.domain); ok {
+			h, ok := t.tenant(name)
+			if !ok {
+				writeMutationError(w, ErrBoardNotFound)
+				return
+			}
+			h.ServeHTTP(w, r)
+			return
+		}
+	}
+
+	rest, ok := strings.CutPrefix(r.URL.Path, tenantPathPrefix)
+	if !ok {
+		t.root.ServeHTTP(w, r)
+		return
+	}
+	name, path, _ := strings.Cut(rest, "/")
+	h, ok := t.tenant(name)
+	if !ok {
+		writeMutationError(w, ErrBoardNotFound)
+		return
+	}
+	base := tenantPathPrefix + name
+	if path == "" && !strings.HasSuffix(r.URL.Path, "/") {
+		http.Redirect(w, r, base+"/", http.StatusMovedPermanently)
+		return
+	}
+
+	r2 := r.WithContext(context.WithValue(r.Context(), basePathKey{}, base))
+	r2.URL.Path = "/" + path
+	r2.URL.RawPath = ""
+	h.ServeHTTP(w, r2)
+}
+
+// BoardKey returns the name of the board in per-board routes.
+func (s *Store) BoardKey() string {
+	if name, ok := strings.CutPrefix(s.basePath, tenantPathPrefix); ok {
+		return name
+	}
+	return defaultBoardKey
+}
+
+// handleBoardWS serves /ws/{board}, the WebSocket of one board. Each board
+// has its own subscribers, so a change only wakes the clients of its board.
+// It is mounted above the tenant router: the board is named in the path
+// whatever host or prefix the page was served from. The per-board /ws route
+// is kept for pages loaded before this endpoint existed.
+func handleBoardWS(boards *Boards) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s := boards.Get(r.PathValue("board"))
+		if s == nil {
+			writeMutationError(w, ErrBoardNotFound)
+			return
+		}
+		handleWS(s)(w, r)
+	}
+}
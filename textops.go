@@ -0,0 +1,335 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/brunoga/deep/v5/crdt"
+	"github.com/brunoga/deep/v5/crdt/hlc"
+)
+
+// Card descriptions are crdt.Text values: runs of characters linked to the
+// character they were typed after. crdt.Text orders its runs by visiting
+// every character of every run, several times per operation, so typing into
+// a 100KB description costs hundreds of thousands of map lookups per
+// keystroke. The functions below are drop-in equivalents that order the text
+// run by run, looking up what hangs off each run with a binary search, so
+// the cost follows the number of runs, not the length of the text.
+// They produce exactly the runs crdt.Text would, which keeps them safe to mix
+// with merges from peers running either implementation.
+
+// Ordering is also memoized: while someone types, each edit orders the text
+// the previous render already ordered, and every connected client renders
+// the same text again. Entries are verified against the full input, so a
+// stale or colliding entry is never returned.
+const (
+	orderCacheMinRuns = 32  // smaller texts are cheaper to order than to look up
+	orderCacheSize    = 256 // entries kept before the cache is reset
+)
+
+type orderCacheKey struct {
+	runs        int
+	first, last hlc.HLC
+}
+
+type orderCacheEntry struct {
+	input, ordered crdt.Text
+}
+
+var orderCache struct {
+	sync.Mutex
+	entries map[orderCacheKey]orderCacheEntry
+}
+
+// orderText returns the runs of t in document order, like crdt.Text does.
+// The result may be modified by the caller.
+func orderText(t crdt.Text) crdt.Text {
+	if len(t) < orderCacheMinRuns {
+		return orderRuns(t)
+	}
+	key := orderCacheKey{len(t), t[0].ID, t[len(t)-1].ID}
+	orderCache.Lock()
+	e, ok := orderCache.entries[key]
+	orderCache.Unlock()
+	if ok && slices.Equal(e.input, t) {
+		return slices.Clone(e.ordered)
+	}
+
+	ordered := orderRuns(t)
+	orderCache.Lock()
+	if orderCache.entries == nil || len(orderCache.entries) >= orderCacheSize {
+		orderCache.entries = make(map[orderCacheKey]orderCacheEntry)
+	}
+	orderCache.entries[key] = orderCacheEntry{slices.Clone(t), slices.Clone(ordered)}
+	orderCache.Unlock()
+	return ordered
+}
+
+// orderRuns orders t from scratch: depth first from the start of the text,
+// visiting the runs typed after a character newest first.
+func orderRuns(t crdt.Text) crdt.Text {
+	if len(t) <= 1 {
+		return t
+	}
+	// Key every run by its predecessor, with node IDs interned, and sort
+	// the runs by key: the runs typed after one character become adjacent,
+	// and those after the characters of one run consecutive, in character
+	// order. Siblings are sorted newest first.
+	nodes := map[string]int32{}
+	key := func(id hlc.HLC) charKey {
+		n, ok := nodes[id.NodeID]
+		if !ok {
+			n = int32(len(nodes))
+			nodes[id.NodeID] = n
+		}
+		return charKey{id.WallTime, n, id.Logical}
+	}
+	prevKeys := make([]charKey, len(t))
+	order := make([]int32, len(t))
+	for i, run := range t {
+		prevKeys[i] = key(run.Prev)
+		order[i] = int32(i)
+	}
+	slices.SortFunc(order, func(a, b int32) int {
+		if c := prevKeys[a].compare(prevKeys[b]); c != 0 {
+			return c
+		}
+		return t[b].ID.Compare(t[a].ID)
+	})
+	keys := make([]charKey, len(t))
+	for i, j := range order {
+		keys[i] = prevKeys[j]
+	}
+	search := func(k charKey) int {
+		i, _ := slices.BinarySearchFunc(keys, k, charKey.compare)
+		return i
+	}
+
+	result := make(crdt.Text, 0, len(t))
+	visited := make([]bool, len(t))
+	// visit appends the runs typed after the character keys[i], each
+	// followed by what was typed after it, and returns the index past them.
+	var visit func(i int) int
+	visit = func(i int) int {
+		prev := keys[i]
+		j := i
+		for ; j < len(keys) && keys[j] == prev; j++ {
+			if visited[j] {
+				continue
+			}
+			visited[j] = true
+			run := t[order[j]]
+			result = append(result, run)
+			first := key(run.ID)
+			end := first.logical + int32(len(run.Value))
+			for k := search(first); k < len(keys) && keys[k].sameRun(first) && keys[k].logical < end; {
+				k = visit(k)
+			}
+		}
+		return j
+	}
+	if i := search(key(hlc.HLC{})); i < len(keys) && keys[i] == key(hlc.HLC{}) {
+		visit(i)
+	}
+	return result
+}
+
+// charKey identifies a character like hlc.HLC, with the node interned.
+type charKey struct {
+	wall    int64
+	node    int32
+	logical int32
+}
+
+// compare orders keys so that the characters of a run are consecutive.
+func (a charKey) compare(b charKey) int {
+	switch {
+	case a.wall != b.wall:
+		return cmp.Compare(a.wall, b.wall)
+	case a.node != b.node:
+		return cmp.Compare(a.node, b.node)
+	default:
+		return cmp.Compare(a.logical, b.logical)
+	}
+}
+
+// sameRun reports whether a and b can be characters of the same run.
+func (a charKey) sameRun(b charKey) bool {
+	return a.wall == b.wall && a.node == b.node
+}
+
+// textString is t.String().
+func textString(t crdt.Text) string {
+	var b strings.Builder
+	b.Grow(textLen(t))
+	for _, run := range orderText(t) {
+		if !run.Deleted {
+			b.WriteString(run.Value)
+		}
+	}
+	return b.String()
+}
+
+// textLen returns the length in bytes of t.String() without ordering t.
+func textLen(t crdt.Text) int {
+	n := 0
+	for _, run := range t {
+		if !run.Deleted {
+			n += len(run.Value)
+		}
+	}
+	return n
+}
+
+// ErrBadTextOp is returned for a text op that does not fit the text it
+// edits, such as one computed against a stale copy of it.
+var ErrBadTextOp = errors.New("text op does not fit the current text")
+
+// checkTextOp reports whether op, at pos and deleting length bytes, fits a
+// text of n bytes.
+func checkTextOp(op string, pos, length, n int) error {
+	switch op {
+	case "insert":
+		if pos < 0 || pos > n {
+			return fmt.Errorf("%w: insert at %d of %d bytes", ErrBadTextOp, pos, n)
+		}
+	case "delete":
+		if pos < 0 || length < 0 || pos+length > n {
+			return fmt.Errorf("%w: delete of %d bytes at %d of %d", ErrBadTextOp, length, pos, n)
+		}
+	case "replace":
+	default:
+		return fmt.Errorf("%w: unknown op %q", ErrBadTextOp, op)
+	}
+	return nil
+}
+
+// spliceText applies a text op to a plain string: "insert" val at pos,
+// "delete" length bytes at pos, or "replace" it whole with val. Positions
+// past the end are clamped to it.
+func spliceText(s, op, val string, pos, length int) string {
+	pos = min(max(pos, 0), len(s))
+	switch op {
+	case "insert":
+		return s[:pos] + val + s[pos:]
+	case "delete":
+		return s[:pos] + s[min(pos+max(length, 0), len(s)):]
+	case "replace":
+		return val
+	}
+	return s
+}
+
+// textInsert is t.Insert(pos, value, clock).
+func textInsert(t crdt.Text, pos int, value string, clock *hlc.Clock) crdt.Text {
+	if value == "" {
+		return t
+	}
+	ordered := orderText(t)
+	prevID := textIDAt(ordered, pos-1)
+	result := textSplitAt(ordered, pos)
+	result = append(result, crdt.TextRun{
+		ID:    clock.Reserve(len(value)),
+		Value: value,
+		Prev:  prevID,
+	})
+	return textNormalize(result)
+}
+
+// textDelete is t.Delete(pos, length).
+func textDelete(t crdt.Text, pos, length int) crdt.Text {
+	if length <= 0 {
+		return t
+	}
+	result := textSplitAt(orderText(t), pos)
+	result = textSplitAt(orderText(result), pos+length)
+	ordered := orderText(result)
+	currentPos := 0
+	for i := range ordered {
+		runLen := len(ordered[i].Value)
+		if !ordered[i].Deleted {
+			if currentPos >= pos && currentPos+runLen <= pos+length {
+				ordered[i].Deleted = true
+			}
+			currentPos += runLen
+		}
+	}
+	return textNormalize(ordered)
+}
+
+// textIDAt returns the ID of the character at pos of the ordered runs.
+func textIDAt(ordered crdt.Text, pos int) hlc.HLC {
+	if pos < 0 {
+		return hlc.HLC{}
+	}
+	currentPos := 0
+	for _, run := range ordered {
+		if run.Deleted {
+			continue
+		}
+		runLen := len(run.Value)
+		if pos >= currentPos && pos < currentPos+runLen {
+			id := run.ID
+			id.Logical += int32(pos - currentPos)
+			return id
+		}
+		currentPos += runLen
+	}
+	return hlc.HLC{}
+}
+
+// textSplitAt splits the run of the ordered runs that spans pos, so that an
+// insertion at pos lands between two runs. It returns ordered unchanged if
+// pos already falls on a run boundary.
+func textSplitAt(ordered crdt.Text, pos int) crdt.Text {
+	if pos <= 0 {
+		return ordered
+	}
+	currentPos := 0
+	for i, run := range ordered {
+		if run.Deleted {
+			continue
+		}
+		runLen := len(run.Value)
+		if pos > currentPos && pos < currentPos+runLen {
+			offset := pos - currentPos
+			left := crdt.TextRun{ID: run.ID, Value: run.Value[:offset], Prev: run.Prev}
+			right := crdt.TextRun{ID: run.ID, Value: run.Value[offset:], Prev: run.ID}
+			right.ID.Logical += int32(offset)
+			right.Prev.Logical += int32(offset - 1)
+			split := make(crdt.Text, 0, len(ordered)+1)
+			split = append(split, ordered[:i]...)
+			split = append(split, left, right)
+			return append(split, ordered[i+1:]...)
+		}
+		currentPos += runLen
+	}
+	return ordered
+}
+
+// textNormalize orders t and coalesces runs that continue one another.
+func textNormalize(t crdt.Text) crdt.Text {
+	ordered := orderText(t)
+	if len(ordered) <= 1 {
+		return ordered
+	}
+	result := make(crdt.Text, 0, len(ordered))
+	result = append(result, ordered[0])
+	for _, curr := range ordered[1:] {
+		last := &result[len(result)-1]
+		expectedID := last.ID
+		expectedID.Logical += int32(len(last.Value))
+		prevID := last.ID
+		prevID.Logical += int32(len(last.Value) - 1)
+		if curr.Deleted == last.Deleted && curr.ID == expectedID && curr.Prev == prevID {
+			last.Value += curr.Value
+		} else {
+			result = append(result, curr)
+		}
+	}
+	return result
+}
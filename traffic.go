@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"maps"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// trafficMinutes is how many minutes of per-peer traffic are kept.
+const trafficMinutes = 60
+
+// PeerTraffic counts sync traffic with a peer.
+type PeerTraffic struct {
+	BytesSent      int64 `json:"bytesSent"`
+	BytesReceived  int64 `json:"bytesReceived"`
+	DeltasSent     int64 `json:"deltasSent"`
+	DeltasReceived int64 `json:"deltasReceived"`
+	Errors         int64 `json:"errors"`
+}
+
+func (t *PeerTraffic) add(o PeerTraffic) {
+	t.BytesSent += o.BytesSent
+	t.BytesReceived += o.BytesReceived
+	t.DeltasSent += o.DeltasSent
+	t.DeltasReceived += o.DeltasReceived
+	t.Errors += o.Errors
+}
+
+// PeerTrafficStats is the sync traffic with one peer since this node
+// started, and per minute over the last trafficMinutes, oldest first.
+type PeerTrafficStats struct {
+	Peer    string        `json:"peer"`
+	Total   PeerTraffic   `json:"total"`
+	Minutes []PeerTraffic `json:"minutes"`
+}
+
+// trafficLog is the traffic with one peer: a running total and a ring of
+// per-minute buckets indexed by unix minute.
+type trafficLog struct {
+	total   PeerTraffic
+	minutes [trafficMinutes]PeerTraffic
+	last    int64 // unix minute of the newest bucket
+}
+
+// record adds t to the log at now, clearing the buckets of the minutes that
+// passed without traffic.
+func (l *trafficLog) record(t PeerTraffic, now time.Time) {
+	minute := now.Unix() / 60
+	for m := max(l.last+1, minute-trafficMinutes+1); m <= minute; m++ {
+		l.minutes[m%trafficMinutes] = PeerTraffic{}
+	}
+	l.last = max(l.last, minute)
+	l.total.add(t)
+	l.minutes[minute%trafficMinutes].add(t)
+}
+
+// stats returns the log as of now.
+func (l *trafficLog) stats(peer string, now time.Time) PeerTrafficStats {
+	minute := now.Unix() / 60
+	st := PeerTrafficStats{Peer: peer, Total: l.total, Minutes: make([]PeerTraffic, trafficMinutes)}
+	for i := range st.Minutes {
+		m := minute - trafficMinutes + 1 + int64(i)
+		if m <= l.last && m > l.last-trafficMinutes {
+			st.Minutes[i] = l.minutes[m%trafficMinutes]
+		}
+	}
+	return st
+}
+
+// recordTraffic accounts t to peer.
+func (s *Store) recordTraffic(peer string, t PeerTraffic) {
+	s.peerMu.Lock()
+	defer s.peerMu.Unlock()
+	s.recordTrafficLocked(peer, t)
+}
+
+// recordTrafficLocked is recordTraffic with s.peerMu held.
+func (s *Store) recordTrafficLocked(peer string, t PeerTraffic) {
+	l, ok := s.peerTraffic[peer]
+	if !ok {
+		l = &trafficLog{}
+		s.peerTraffic[peer] = l
+	}
+	l.record(t, time.Now())
+}
+
+// trafficPeer returns the peer at remoteAddr: the configured peer on the
+// same host, or the bare remote host if none matches. Peers push from
+// ephemeral ports, so only the host can be matched.
+func (s *Store) trafficPeer(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	for _, p := range s.GetPeers() {
+		if strings.Split(p, ":")[0] == host {
+			return p
+		}
+	}
+	return host
+}
+
+// GetPeerTraffic returns the traffic with every current peer, and with any
+// other host that pushed changes to this node.
+func (s *Store) GetPeerTraffic() []PeerTrafficStats {
+	s.peerMu.RLock()
+	defer s.peerMu.RUnlock()
+	now := time.Now()
+	out := make([]PeerTrafficStats, 0, len(s.peerTraffic))
+	seen := make(map[string]bool)
+	for _, p := range s.peers {
+		l, ok := s.peerTraffic[p]
+		if !ok {
+			l = &trafficLog{}
+		}
+		out = append(out, l.stats(p, now))
+		seen[p] = true
+	}
+	others := slices.Sorted(maps.Keys(s.peerTraffic))
+	for _, p := range others {
+		if !seen[p] {
+			out = append(out, s.peerTraffic[p].stats(p, now))
+		}
+	}
+	return out
+}
+
+// handlePeerStats serves the per-peer sync traffic, GET /api/peers/stats.
+func handlePeerStats(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.GetPeerTraffic())
+	}
+}
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brunoga/deep/v5/crdt"
+	"github.com/gorilla/websocket"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Transport carries replication traffic to peers. board is the path the
+// board is mounted at ("" or /t/<tenant>), the same on every node. Every
+// node serves all transports, so nodes may use different ones.
+type Transport interface {
+	// SendDelta pushes an encoded delta along with the digest of the state
+	// it produced on this node.
+	SendDelta(peer, board string, delta []byte, digest string) error
+	// FetchState returns the peer's full CRDT, encoded.
+	FetchState(peer, board string) ([]byte, error)
+	// Digest returns the peer's current digest.
+	Digest(peer, board string) (DigestInfo, error)
+}
+
+// Transport names, as given with -transport.
+const (
+	TransportHTTP      = "http"
+	TransportWebSocket = "websocket"
+	TransportGRPC      = "grpc"
+)
+
+// newTransport returns the transport with the given name.
+func newTransport(name string) (Transport, error) {
+	switch name {
+	case TransportHTTP, "":
+		return httpTransport{}, nil
+	case TransportWebSocket:
+		return &wsTransport{conns: make(map[string]*wsPeerConn)}, nil
+	case TransportGRPC:
+		return &grpcTransport{conns: make(map[string]*grpc.ClientConn)}, nil
+	}
+	return nil, fmt.Errorf("unknown transport %q (want %s, %s or %s)", name, TransportHTTP, TransportWebSocket, TransportGRPC)
+}
+
+// errBadDelta marks a pushed delta that could not be decoded.
+var errBadDelta = errors.New("undecodable delta")
+
+// receiveDelta applies a delta pushed by a peer at remoteAddr and forwards
+// it to the edges relayed by this node. remoteDigest is the sender's digest
+// after the change, or "" if it sent none.
+func (s *Store) receiveDelta(data []byte, remoteDigest, remoteAddr string) error {
+	if err := s.applyPushedDelta(data, remoteDigest, remoteAddr); err != nil {
+		return err
+	}
+	s.forwardToEdges(data, "", nil)
+	return nil
+}
+
+// applyPushedDelta is receiveDelta without the forwarding.
+func (s *Store) applyPushedDelta(data []byte, remoteDigest, remoteAddr string) error {
+	peer := s.trafficPeer(remoteAddr)
+	if err := s.checkPeer(peer); err != nil {
+		return err
+	}
+	s.recordTraffic(peer, PeerTraffic{BytesReceived: int64(len(data)), DeltasReceived: 1})
+	data, err := upgradePayload(payloadDelta, data)
+	if err != nil {
+		// Applying it would corrupt the state; divergence checks could not
+		// repair what the versions disagree on either.
+		s.recordTraffic(peer, PeerTraffic{Errors: 1})
+		log.Printf("Sync: rejected a delta from %s: %v", peer, err)
+		return err
+	}
+	var delta crdt.Delta[BoardState]
+	if err := json.Unmarshal(data, &delta); err != nil {
+		// A peer sent something we cannot apply; our states may drift.
+		s.recordTraffic(peer, PeerTraffic{Errors: 1})
+		s.SuspectDivergence("undecodable delta: " + err.Error())
+		return fmt.Errorf("%w: %v", errBadDelta, err)
+	}
+	if delta.Timestamp.WallTime == 0 {
+		// Empty delta — nothing to apply
+		return nil
+	}
+	if err := s.ApplyDelta(delta); err != nil {
+		s.recordTraffic(peer, PeerTraffic{Errors: 1})
+		s.SuspectDivergence("delta apply failed: " + err.Error())
+		return err
+	}
+	if remoteDigest != "" && remoteDigest != s.Digest().Digest {
+		s.SuspectDivergence("digest mismatch after delta " + delta.Timestamp.String())
+	}
+	return nil
+}
+
+// Replication operations, named after the Transport methods. They are the
+// gRPC method names and the "op" of WebSocket requests.
+const (
+	opSendDelta  = "SendDelta"
+	opFetchState = "FetchState"
+	opDigest     = "Digest"
+)
+
+// replicationRequest and replicationReply are the messages of the WebSocket
+// and gRPC transports.
+type replicationRequest struct {
+	Op     string `json:"op,omitempty"` // WebSocket only; gRPC uses the method
+	Board  string `json:"board"`        // gRPC only; WebSocket connects per board
+	Delta  []byte `json:"delta,omitempty"`
+	Digest string `json:"digest,omitempty"`
+}
+
+type replicationReply struct {
+	Data  []byte `json:"data,omitempty"`
+	Error string `json:"error,omitempty"` // WebSocket only; gRPC uses its status
+}
+
+// serveReplication runs a replication operation for a peer at remoteAddr.
+func (s *Store) serveReplication(op string, req replicationRequest, remoteAddr string) (replicationReply, error) {
+	if err := s.checkRemote(remoteAddr); err != nil {
+		return replicationReply{}, err
+	}
+	switch op {
+	case opSendDelta:
+		return replicationReply{}, s.receiveDelta(req.Delta, req.Digest, remoteAddr)
+	case opFetchState:
+		return replicationReply{Data: s.snap.Load().crdtJSON}, nil
+	case opDigest:
+		data, err := json.Marshal(s.Digest())
+		return replicationReply{Data: data}, err
+	}
+	return replicationReply{}, fmt.Errorf("unknown replication operation %q", op)
+}
+
+// peerBoardURL returns the URL of endpoint for board on peer.
+func peerBoardURL(peer, board, endpoint string) string {
+	return fmt.Sprintf("http://%s%s%s", peer, board, endpoint)
+}
+
+// httpTransport replicates over plain HTTP requests: POST /api/sync,
+// GET /api/state and GET /api/digest.
+type httpTransport struct{}
+
+func (httpTransport) SendDelta(peer, board string, delta []byte, digest string) error {
+	req, err := http.NewRequest(http.MethodPost, peerBoardURL(peer, board, "/api/sync"), bytes.NewReader(delta))
+	if err != nil {
+		return err
+	}
+	req.Header = peerHeader()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(digestHeader, digest)
+	resp, err := peerHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("sync returned %s", resp.Status)
+	}
+	return nil
+}
+
+// peerGet sends a GET request for url to a peer.
+func peerGet(url string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = peerHeader()
+	return peerHTTPClient.Do(req)
+}
+
+func (httpTransport) FetchState(peer, board string) ([]byte, error) {
+	resp, err := peerGet(peerBoardURL(peer, board, "/api/state"))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("state request returned %s", resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+func (httpTransport) Digest(peer, board string) (DigestInfo, error) {
+	var info DigestInfo
+	resp, err := peerGet(peerBoardURL(peer, board, "/api/digest"))
+	if err != nil {
+		return info, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return info, fmt.Errorf("digest request returned %s", resp.Status)
+	}
+	err = json.NewDecoder(resp.Body).Decode(&info)
+	return info, err
+}
+
+// wsTransport replicates over one persistent WebSocket per peer and board,
+// to /api/replicate. Requests on a connection are answered in order.
+type wsTransport struct {
+	mu    sync.Mutex
+	conns map[string]*wsPeerConn
+}
+
+type wsPeerConn struct {
+	mu   sync.Mutex
+	conn *websocket.Conn
+}
+
+func (t *wsTransport) roundTrip(peer, board string, req replicationRequest) ([]byte, error) {
+	url := "ws" + strings.TrimPrefix(peerBoardURL(peer, board, "/api/replicate"), "http")
+	t.mu.Lock()
+	pc, ok := t.conns[url]
+	if !ok {
+		pc = &wsPeerConn{}
+		t.conns[url] = pc
+	}
+	t.mu.Unlock()
+
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	if pc.conn == nil {
+		dialer := websocket.Dialer{HandshakeTimeout: peerHTTPClient.Timeout}
+		conn, _, err := dialer.Dial(url, peerHeader())
+		if err != nil {
+			return nil, err
+		}
+		pc.conn = conn
+	}
+	var reply replicationReply
+	deadline := time.Now().Add(peerHTTPClient.Timeout)
+	pc.conn.SetWriteDeadline(deadline)
+	pc.conn.SetReadDeadline(deadline)
+	err := pc.conn.WriteJSON(req)
+	if err == nil {
+		err = pc.conn.ReadJSON(&reply)
+	}
+	if err != nil {
+		// The connection may be out of step; start over on the next request.
+		pc.conn.Close()
+		pc.conn = nil
+		return nil, err
+	}
+	if reply.Error != "" {
+		return nil, errors.New(reply.Error)
+	}
+	return reply.Data, nil
+}
+
+func (t *wsTransport) SendDelta(peer, board string, delta []byte, digest string) error {
+	_, err := t.roundTrip(peer, board, replicationRequest{Op: opSendDelta, Delta: delta, Digest: digest})
+	return err
+}
+
+func (t *wsTransport) FetchState(peer, board string) ([]byte, error) {
+	return t.roundTrip(peer, board, replicationRequest{Op: opFetchState})
+}
+
+func (t *wsTransport) Digest(peer, board string) (DigestInfo, error) {
+	var info DigestInfo
+	data, err := t.roundTrip(peer, board, replicationRequest{Op: opDigest})
+	if err == nil {
+		err = json.Unmarshal(data, &info)
+	}
+	return info, err
+}
+
+// handleReplicate serves the WebSocket transport, /api/replicate.
+func handleReplicate(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			var req replicationRequest
+			if err := conn.ReadJSON(&req); err != nil {
+				return
+			}
+			reply, err := s.serveReplication(req.Op, req, r.RemoteAddr)
+			if err != nil {
+				reply.Error = err.Error()
+			}
+			if err := conn.WriteJSON(reply); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// grpcTransport replicates over gRPC, sharing one HTTP/2 connection per
+// peer between all boards. Messages are JSON encoded (see jsonCodec), so no
+// generated code is needed.
+type grpcTransport struct {
+	mu    sync.Mutex
+	conns map[string]*grpc.ClientConn
+}
+
+// replicationService is the gRPC service name.
+const replicationService = "deepboard.Replication"
+
+func (t *grpcTransport) call(peer, board, op string, req replicationRequest) ([]byte, error) {
+	t.mu.Lock()
+	conn, ok := t.conns[peer]
+	if !ok {
+		var err error
+		conn, err = grpc.NewClient(peer,
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+			grpc.WithDefaultCallOptions(grpc.CallContentSubtype(jsonCodec{}.Name())))
+		if err != nil {
+			t.mu.Unlock()
+			return nil, err
+		}
+		t.conns[peer] = conn
+	}
+	t.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), peerHTTPClient.Timeout)
+	defer cancel()
+	if peerSecret != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, peerSecretHeader, peerSecret)
+	}
+	req.Board = board
+	var reply replicationReply
+	if err := conn.Invoke(ctx, "/"+replicationService+"/"+op, &req, &reply); err != nil {
+		return nil, err
+	}
+	return reply.Data, nil
+}
+
+func (t *grpcTransport) SendDelta(peer, board string, delta []byte, digest string) error {
+	_, err := t.call(peer, board, opSendDelta, replicationRequest{Delta: delta, Digest: digest})
+	return err
+}
+
+func (t *grpcTransport) FetchState(peer, board string) ([]byte, error) {
+	return t.call(peer, board, opFetchState, replicationRequest{})
+}
+
+func (t *grpcTransport) Digest(peer, board string) (DigestInfo, error) {
+	var info DigestInfo
+	data, err := t.call(peer, board, opDigest, replicationRequest{})
+	if err == nil {
+		err = json.Unmarshal(data, &info)
+	}
+	return info, err
+}
+
+// jsonCodec encodes gRPC messages as JSON.
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+func (jsonCodec) Name() string                       { return "json" }
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+// newReplicationServer returns the gRPC server of the gRPC transport. It
+// serves every board of boards, by base path.
+func newReplicationServer(boards *Boards) *grpc.Server {
+	srv := grpc.NewServer()
+	method := func(op string) grpc.MethodDesc {
+		return grpc.MethodDesc{
+			MethodName: op,
+			Handler: func(_ any, ctx context.Context, dec func(any) error, _ grpc.UnaryServerInterceptor) (any, error) {
+				var req replicationRequest
+				if err := dec(&req); err != nil {
+					return nil, err
+				}
+				md, _ := metadata.FromIncomingContext(ctx)
+				if err := checkPeerSecret(strings.Join(md.Get(peerSecretHeader), "")); err != nil {
+					return nil, status.Error(codes.Unauthenticated, err.Error())
+				}
+				for _, s := range boards.All() {
+					if s.basePath == req.Board {
+						var remoteAddr string
+						if p, ok := peer.FromContext(ctx); ok {
+							remoteAddr = p.Addr.String()
+						}
+						return s.serveReplication(op, req, remoteAddr)
+					}
+				}
+				return nil, fmt.Errorf("board %q not found", req.Board)
+			},
+		}
+	}
+	srv.RegisterService(&grpc.ServiceDesc{
+		ServiceName: replicationService,
+		HandlerType: (*any)(nil),
+		Methods:     []grpc.MethodDesc{method(opSendDelta), method(opFetchState), method(opDigest)},
+	}, struct{}{})
+	return srv
+}
+
+// withGRPC routes gRPC requests (HTTP/2 with a gRPC content type) to srv and
+// everything else to h, so the gRPC transport shares the HTTP port.
+func withGRPC(srv *grpc.Server, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor == 2 && strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+			srv.ServeHTTP(w, r)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
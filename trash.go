@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// undoWindow is how long a deleted card can be restored.
+const undoWindow = 10 * time.Second
+
+// ErrUndoExpired is returned when a card is restored after its undo window,
+// or was not deleted on this node.
+var ErrUndoExpired = errors.New("card can no longer be restored")
+
+// trashBin keeps recently deleted cards so their deletion can be undone. The
+// deletion itself replicates immediately; undoing it adds the card back as a
+// new edit. Deleted cards are not kept in the board as restorable
+// tombstones: like presence, the bin is node-local and in memory, so only the
+// node that made the deletion can undo it, and not after it restarts.
+type trashBin struct {
+	mu    sync.Mutex
+	cards map[string]trashedCard
+}
+
+type trashedCard struct {
+	card    Card
+	expires time.Time
+}
+
+// put keeps card restorable until now plus undoWindow and forgets the cards
+// whose window has passed.
+func (t *trashBin) put(card Card, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for id, tc := range t.cards {
+		if now.After(tc.expires) {
+			delete(t.cards, id)
+		}
+	}
+	if t.cards == nil {
+		t.cards = make(map[string]trashedCard)
+	}
+	t.cards[card.ID] = trashedCard{card: card, expires: now.Add(undoWindow)}
+}
+
+// get returns cardID from the bin if its window is still open.
+func (t *trashBin) get(cardID string, now time.Time) (Card, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tc, ok := t.cards[cardID]
+	if !ok || now.After(tc.expires) {
+		return Card{}, false
+	}
+	return tc.card, true
+}
+
+// remove forgets cardID, once restored.
+func (t *trashBin) remove(cardID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.cards, cardID)
+}
+
+// clear forgets every card, which can no longer be restored.
+func (t *trashBin) clear() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.cards = nil
+}
+
+// UndoDelete restores a card deleted on this node within the last undoWindow,
+// as it was when deleted. A card that fails to be restored, say on a frozen
+// board, stays in the bin until its window passes.
+func (s *Store) UndoDelete(cardID string) error {
+	card, ok := s.trash.get(cardID, time.Now())
+	if !ok {
+		return ErrUndoExpired
+	}
+	err := s.tryMutate(func(bs *BoardState) error {
+		if _, exists := bs.Board.Cards[cardID]; exists {
+			return nil
+		}
+		if err := s.quota.checkCards(len(bs.Board.Cards) + 1); err != nil {
+			return err
+		}
+		if err := s.checkGrowth(len(card.Title) + textLen(card.Description)); err != nil {
+			return err
+		}
+		// A card deleted before the board was encrypted stays deleted.
+		if err := checkSealed(bs, card.Title); err != nil {
+			return err
+		}
+		if bs.Board.Cards == nil {
+			bs.Board.Cards = make(map[string]Card)
+		}
+		bs.Board.Cards[cardID] = card
+		s.stage(Event{Type: EventCardCreated, CardID: cardID, Title: card.Title, Column: card.ColumnID})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.trash.remove(cardID)
+	return nil
+}
@@ -1,30 +1,72 @@
 package main
 
+import (
+	"cmp"
+	"fmt"
+	"hash/fnv"
+	"html/template"
+	"slices"
+	"strings"
+	"time"
+)
+
 const boardHTML = `
+<style id="label-colors">{{range .Labels}}.label-chip[data-label="{{.Name}}"] { background: {{.Color}}; }{{end}}{{range .Users}}.card-assignee[data-assignee="{{.ID}}"] { background: {{.Color}}; }{{end}}</style>
 {{range .Columns}}
 <div class="column">
     <h3>{{.Title}}</h3>
-    <div class="card-list" id="col-{{.ID}}" data-col-id="{{.ID}}">
-        {{range .Cards}}
-        <div class="card" data-id="{{.ID}}">
+    <div class="card-list" id="col-{{.ID}}" data-col-id="{{.ID}}" data-sort="{{.Sort}}">
+        {{range .Cards}}` + cardHTML + `{{end}}
+        ` + moreCardsHTML + `
+    </div>
+</div>
+{{end}}
+`
+
+// cardHTML is one card of a column.
+const cardHTML = `{{$desc := text .Description}}{{$id := .ID}}
+        <div class="card{{if .Overdue}} overdue{{end}}" data-id="{{.ID}}" data-key="{{cardKey .Number}}" style="--votes: {{len .Votes}}">
             <div style="display: flex; justify-content: space-between; align-items: center; margin-bottom: 8px;">
-                <span class="card-title">{{.Title}}</span>
-                <button onclick="deleteCard('{{.ID}}')" class="delete-btn">&times;</button>
+                <span><span class="card-key" onclick="showLinks('{{.ID}}')" title="Links">{{cardKey .Number}}</span> <span class="card-title" id="title-{{.ID}}" contenteditable="plaintext-only" spellcheck="false" data-last-value="{{.Title}}">{{.Title}}</span> <span class="card-due{{if pastDue .Due}} past-due{{end}}" title="Due">{{.Due}}</span> <span class="card-assignee" data-assignee="{{.Assignee}}" onclick="pickAssignee('{{.ID}}')" title="Assignee">{{with .Assignee}}@{{.}}{{else}}+{{end}}</span> <span class="checklist-progress" title="Checklist">{{with progress .Checklist}}&#9745; {{.}}{{end}}</span></span>
+                <span>
+                    <button onclick="vote('{{.ID}}')" class="delete-btn vote-btn" data-voters="{{voters .Votes}}" title="Vote">&#9650; <span class="vote-count">{{len .Votes}}</span></button>
+                    <button onclick="startPoker('{{.ID}}')" class="delete-btn poker-btn" title="Estimate">{{if .Estimate}}{{.Estimate}}{{else}}&#127183;{{end}}</button>
+                    <button onclick="pickMove('{{.ID}}')" class="delete-btn move-btn" title="Move">&#8644;</button>
+                    <button onclick="addChecklistItem('{{.ID}}')" class="delete-btn" title="Add a checklist item">&#9745;</button>
+                    <button onclick="toggleWatch('{{.ID}}')" class="delete-btn watch-btn" data-card="{{.ID}}" title="Watch">&#128065;</button>
+                    <button onclick="showVersions('{{.ID}}')" class="delete-btn" title="Description history">&#128339;</button>
+                    <button onclick="deleteCard('{{.ID}}')" class="delete-btn">&times;</button>
+                </span>
             </div>
+            {{with .Labels}}<div class="card-labels">{{range .Names}}<span class="label-chip" data-label="{{.}}">{{.}}</span>{{end}}</div>{{end}}
+            <div class="checklist">{{range checklist .Checklist}}<div class="checklist-item{{if .Done}} done{{end}}"><input type="checkbox"{{if .Done}} checked{{end}} onchange="checkItem('{{$id}}', '{{.ID}}', this.checked)"> <span>{{.Text}}</span> <button onclick="removeChecklistItem('{{$id}}', '{{.ID}}')" class="delete-btn" title="Remove">&times;</button></div>{{end}}</div>
             <textarea class="card-desc" id="desc-{{.ID}}" placeholder="Add a description..."
-                      data-last-value="{{.Description.String}}">{{.Description.String}}</textarea>
+                      data-last-value="{{$desc}}">{{$desc}}</textarea>
         </div>
-        {{end}}
-    </div>
-</div>
-{{end}}
 `
 
+// historyHTML is an entry of the history, edged in the color of whoever made
+// the change.
+const historyHTML = `
+                <div class="history-entry {{.Kind}}"{{with .Color}} style="border-left-color: {{.}}"{{end}}>{{.Summary}}{{with .Actor}} (by {{.}}){{end}}</div>
+                `
+
+// moreCardsHTML marks a column whose later cards are left out; the page
+// loads them from /board/column/{id} when it scrolls into view.
+const moreCardsHTML = `{{if .Next}}<div class="card-more" data-col-id="{{.ID}}" data-offset="{{.Next}}">{{.More}} more cards</div>{{end}}`
+
+// columnPageHTML is a page of the cards of a column, for
+// /board/column/{id}.
+const columnPageHTML = `{{range .Cards}}` + cardHTML + `{{end}}` + moreCardsHTML
+
 const indexHTML = `
 <!DOCTYPE html>
 <html>
 <head>
     <title>DeepBoard - Collaborative Kanban</title>
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <meta name="theme-color" content="#2c3e50">
+    <link rel="manifest" href="{{.Base}}/manifest.webmanifest">
     <script src="https://unpkg.com/htmx.org@1.9.10"></script>
     <script src="https://cdn.jsdelivr.net/npm/sortablejs@1.15.2/Sortable.min.js"></script>
     <style>
@@ -33,6 +75,10 @@ const indexHTML = `
         header h1 { margin: 0; font-size: 1.5rem; letter-spacing: -0.5px; }
         
         .main-container { display: flex; flex: 1; overflow: hidden; padding: 20px; gap: 20px; }
+        .user-tag { font-weight: 600; border-left: 4px solid transparent; padding-left: 4px; }
+        .user-tag.away { opacity: 0.45; font-weight: normal; }
+        .served-by { padding: 0 20px 6px; font-size: 0.75rem; color: #95a5a6; text-align: right; }
+        .served-by.moved { color: #e67e22; }
         .board { display: flex; gap: 20px; flex: 1; overflow-x: auto; align-items: flex-start; }
         
         .column { background: #ebedf0; border-radius: 10px; width: 320px; min-width: 320px; display: flex; flex-direction: column; max-height: 100%; box-shadow: 0 1px 3px rgba(0,0,0,0.1); }
@@ -47,7 +93,8 @@ const indexHTML = `
         .card { background: white; border-radius: 8px; padding: 12px; margin-bottom: 12px; box-shadow: 0 1px 2px rgba(0,0,0,0.1); cursor: grab; border: 1px solid #e1e4e8; transition: transform 0.1s; }
         .card:hover { border-color: #3498db; }
         .card:active { cursor: grabbing; transform: scale(1.02); }
-        .card-title { font-weight: 600; font-size: 0.95rem; color: #2c3e50; }
+        .card-title { font-weight: 600; font-size: 0.95rem; color: #2c3e50; cursor: text; border-radius: 3px; }
+        .card-title:focus { outline: 1px dashed #95a5a6; outline-offset: 2px; }
         
         .delete-btn { background: none; border: none; color: #bdc3c7; cursor: pointer; font-size: 1.4rem; line-height: 1; padding: 0 4px; transition: color 0.2s; }
         .delete-btn:hover { color: #e74c3c; }
@@ -68,29 +115,143 @@ const indexHTML = `
 
         .sidebar-header { display: flex; justify-content: space-between; align-items: center; padding: 0 12px; background: #95a5a6; border-radius: 10px 10px 0 0; color: white; }
         .sidebar-header h3 { background: none !important; box-shadow: none !important; margin: 0; }
+        .history-all { font-size: 0.75rem; cursor: pointer; margin-left: auto; margin-right: 8px; }
+        .history-entry.presence, .history-entry.admin { opacity: 0.7; font-style: italic; }
         .clear-btn { background: #e74c3c; color: white; border: none; border-radius: 4px; padding: 4px 8px; font-size: 0.7rem; cursor: pointer; transition: background 0.2s; }
         .clear-btn:hover { background: #c0392b; }
 
         .add-card-form button.reset-btn { background: #e74c3c; color: white; border: none; border-radius: 6px; padding: 0 16px; font-size: 0.8rem; font-weight: bold; cursor: pointer; text-transform: uppercase; transition: background 0.2s; height: 38px; box-sizing: border-box; margin-left: 10px; }
         .add-card-form button.reset-btn:hover { background: #c0392b; }
+
+        .search-box { position: relative; margin-right: 20px; }
+        .search-box input { padding: 8px 12px; border: 1px solid #34495e; border-radius: 6px; background: #34495e; color: white; width: 220px; font-size: 0.9rem; }
+        .search-results { display: none; position: absolute; top: 42px; left: 0; width: 360px; max-height: 420px; overflow-y: auto; background: white; color: #1c1e21; border-radius: 8px; box-shadow: 0 4px 12px rgba(0,0,0,0.2); z-index: 10; }
+        .search-result { display: block; padding: 8px 12px; border-bottom: 1px solid #eee; color: inherit; text-decoration: none; font-size: 0.85rem; }
+        .search-result:hover { background: #f0f2f5; }
+        .search-result small { color: #7f8c8d; display: block; }
+        .mention-suggestions { position: fixed; width: 260px; }
+        .assignee-input { font-size: 0.7rem; width: 140px; }
+        .notif-box { display: none; position: relative; margin-right: 20px; cursor: pointer; }
+        .notif-badge { display: none; position: absolute; top: -6px; right: -10px; background: #e74c3c; color: white; border-radius: 10px; padding: 0 5px; font-size: 0.7rem; }
+        .notif-panel { display: none; position: absolute; top: 32px; right: 0; width: 340px; max-height: 420px; overflow-y: auto; background: white; color: #1c1e21; border-radius: 8px; box-shadow: 0 4px 12px rgba(0,0,0,0.2); z-index: 10; cursor: default; }
+        .notification { display: block; padding: 8px 12px; border-bottom: 1px solid #eee; color: inherit; text-decoration: none; font-size: 0.85rem; }
+        .notification.unread { background: #eef6fd; font-weight: 600; }
+        .notification small { color: #7f8c8d; display: block; font-weight: normal; }
+        .watch-btn { opacity: 0.4; }
+        .watch-btn.watching { opacity: 1; }
+        .undo-toast { display: none; position: fixed; bottom: 20px; left: 50%; transform: translateX(-50%); background: #333; color: white; padding: 10px 16px; border-radius: 6px; font-size: 0.85rem; z-index: 30; }
+        .undo-toast button { margin-left: 12px; background: none; border: none; color: #8cc4ff; font-weight: bold; cursor: pointer; }
+        .presence-banner { display: none; margin-top: 8px; padding: 4px 8px; border-radius: 4px; background: #fdf2e0; color: #a0620a; font-size: 0.75rem; }
+        .versions-panel { display: none; position: fixed; top: 60px; right: 20px; bottom: 20px; width: 480px; overflow-y: auto; background: white; border-radius: 10px; box-shadow: 0 4px 16px rgba(0,0,0,0.25); padding: 12px; z-index: 20; }
+        .poker-panel { display: none; position: fixed; bottom: 20px; right: 20px; width: 320px; background: white; border-radius: 10px; box-shadow: 0 4px 16px rgba(0,0,0,0.25); padding: 12px; z-index: 20; font-size: 0.85rem; }
+        .poker-panel .poker-values button { margin: 2px; min-width: 36px; }
+        .poker-panel .poker-values button.picked { background: #3498db; color: white; }
+        .poker-btn { font-size: 0.9rem; }
+        .version { border-bottom: 1px solid #eee; padding: 8px 0; font-size: 0.8rem; }
+        .version pre { margin: 6px 0; white-space: pre-wrap; word-break: break-word; font-size: 0.75rem; }
+        .diff-add { background: #e6ffed; display: block; }
+        .diff-del { background: #ffeef0; display: block; text-decoration: line-through; }
+        .card.overdue { border-left: 4px solid #e74c3c; }
+        .link-previews { display: flex; flex-wrap: wrap; gap: 4px; margin-top: 6px; }
+        .link-preview { display: inline-flex; align-items: center; gap: 4px; max-width: 100%; padding: 2px 8px; border-radius: 10px; background: #ecf0f1; color: #2c3e50; font-size: 0.75rem; text-decoration: none; overflow: hidden; white-space: nowrap; text-overflow: ellipsis; }
+        .link-preview img { width: 14px; height: 14px; }
+        .card-key { color: #95a5a6; font-size: 0.75rem; cursor: pointer; }
+        .card-due { color: #e67e22; font-size: 0.75rem; }
+        .card-due:empty { display: none; }
+        .card-due.past-due { color: #c0392b; font-weight: 600; }
+        .card-labels { margin: -4px 0 8px; }
+        .card-assignee { background: #95a5a6; color: white; border-radius: 8px; padding: 1px 6px; font-size: 0.7rem; cursor: pointer; }
+        .card-assignee[data-assignee=""] { background: none; color: #95a5a6; }
+        .checklist-progress { font-size: 0.75rem; color: #7f8c8d; }
+        .checklist-item { font-size: 0.85rem; margin-bottom: 4px; }
+        .checklist-item.done span { text-decoration: line-through; color: #95a5a6; }
+        .label-chip { background: #95a5a6; color: white; border-radius: 8px; padding: 1px 6px; font-size: 0.7rem; margin-right: 4px; }
+        .confetti { position: fixed; width: 8px; height: 8px; z-index: 1000; pointer-events: none; transition: transform 1.2s ease-out, opacity 1.2s ease-in; }
+        .card-mention { color: #2980b9; font-size: 0.75rem; cursor: pointer; margin-right: 6px; }
+        .card.unseen { border-top: 3px solid #3498db; }
+        .card.presented { box-shadow: 0 0 0 3px rgba(241, 196, 15, 0.7); transition: box-shadow 0.3s; }
+        .vote-btn.voted { color: #3498db; }
+        /* Sorting by votes reorders the cards visually only, so drag and
+           drop and drift detection keep working on the board's own order. */
+        body.sort-votes .card-list { display: flex; flex-direction: column; }
+        body.sort-votes .card { order: calc(-1 * var(--votes)); }
+        .card.highlight { border-color: #f39c12; box-shadow: 0 0 0 3px rgba(243,156,18,0.4); }
+        .card-more { text-align: center; padding: 8px; color: #7f8c8d; font-size: 0.8rem; cursor: pointer; }
+        body.sort-votes .card-more { order: 1; }
+
+        /* Mobile view: one column per screen, swiped horizontally, with a
+           move picker instead of drag and drop. */
+        .move-btn, .column-tabs, .move-sheet { display: none; }
+        body.mobile header { flex-wrap: wrap; gap: 8px; padding: 0.6rem 1rem; }
+        body.mobile header h1 { font-size: 1.1rem; }
+        body.mobile #connection-stats, body.mobile .sidebar, body.mobile .add-card-form .reset-btn { display: none; }
+        body.mobile .search-box { margin-right: 0; flex: 1; }
+        body.mobile .search-box input { width: 100%; box-sizing: border-box; }
+        body.mobile .search-results { width: calc(100vw - 2rem); }
+        body.mobile .add-card-form, body.mobile .add-card-form form { width: 100%; }
+        body.mobile .add-card-form input { flex: 1; }
+        body.mobile .main-container { padding: 8px 0; }
+        body.mobile .board { gap: 0; scroll-snap-type: x mandatory; }
+        body.mobile .column { width: 100vw; min-width: 100vw; border-radius: 0; scroll-snap-align: start; }
+        body.mobile .card { cursor: default; }
+        body.mobile .card:active { transform: none; }
+        body.mobile .delete-btn { font-size: 1.6rem; padding: 6px 8px; }
+        body.mobile .move-btn { display: inline; }
+        body.mobile .card-desc { font-size: 1rem; }
+        body.mobile .column-tabs { display: flex; overflow-x: auto; background: #34495e; }
+        .column-tabs button { flex: 1; padding: 12px; background: none; border: none; color: #bdc3c7; font-size: 0.9rem; white-space: nowrap; }
+        .column-tabs button.active { color: white; border-bottom: 3px solid #3498db; }
+        body.mobile .move-sheet.open { display: block; position: fixed; left: 0; right: 0; bottom: 0; background: white; box-shadow: 0 -4px 16px rgba(0,0,0,0.25); border-radius: 12px 12px 0 0; padding: 12px; z-index: 40; }
+        .move-sheet button { display: block; width: 100%; padding: 14px; margin-bottom: 8px; font-size: 1rem; border: 1px solid #e1e4e8; border-radius: 8px; background: #f8f9fa; }
+        .move-sheet button:disabled { color: #bdc3c7; }
     </style>
 </head>
-<body>
+<body class="{{if eq .View "mobile"}}mobile{{end}}" data-view="{{.View}}">
     <header>
-        <h1>DeepBoard <span style="font-size: 0.8rem; color: #3498db; vertical-align: middle;">(Node: {{.NodeID}})</span></h1>
+        <h1><a href="/home" title="Home" style="color: inherit; text-decoration: none;">DeepBoard</a>
+            <span id="star" onclick="toggleStar()" title="Star this board" style="cursor: pointer; color: #f1c40f;" data-starred="{{.Starred}}">{{if .Starred}}&#9733;{{else}}&#9734;{{end}}</span>
+            <span style="font-size: 0.8rem; color: #3498db; vertical-align: middle;">(Node: {{.NodeID}})</span></h1>
         <div id="connection-stats" style="color: #bdc3c7; font-size: 0.8rem; margin-left: auto; margin-right: 20px;">
-            <span id="conn-counts">Local: {{.LocalCount}} | Total: {{.TotalCount}}</span>
+            <span id="conn-counts" onclick="showOnline()" title="Who's online" style="cursor: pointer;">Local: {{.LocalCount}} | Total: {{.TotalCount}}</span>
+            <span id="offline-status" style="display: none; margin-left: 10px; color: #f1c40f;"></span>
+            <span id="presenter" style="display: none; margin-left: 10px; color: #f1c40f;"></span>
+            <span id="unseen-note" onclick="clearUnseen()" title="Dismiss" style="display: none; margin-left: 10px; color: #3498db; cursor: pointer;"></span>
+            <span id="peer-health" title="No peers" style="display: none; width: 10px; height: 10px; border-radius: 50%; margin-left: 10px; vertical-align: middle;"></span>
             <span onclick="cleanupConnections()" style="cursor: pointer; margin-left: 10px; text-decoration: underline;" title="Force cleanup of stale nodes">🧹</span>
         </div>
+        <div class="notif-box" id="notif-box">
+            <span onclick="toggleNotifications()" title="Notifications">&#128276;<span class="notif-badge" id="notif-badge"></span></span>
+            <div class="notif-panel" id="notif-panel"></div>
+        </div>
+        <div class="search-box">
+            <input type="search" id="search" placeholder="Search all boards..." autocomplete="off">
+            <div class="search-results" id="search-results"></div>
+        </div>
+        <datalist id="directory-users"></datalist>
+        <div class="search-results mention-suggestions" id="mention-suggestions"></div>
         <div class="add-card-form">
-            <form action="/api/add" method="POST" style="display: flex; gap: 8px; align-items: center;">
+            <form id="add-form" action="{{.Base}}/api/add" method="POST" style="display: flex; gap: 8px; align-items: center;">
                 <input type="text" name="title" placeholder="What needs to be done?" required>
                 <button type="submit">Add Task</button>
             </form>
+            {{if not .E2E}}<select id="template-select" onchange="createFromTemplate(this)" style="display: none;"><option value="">From template...</option></select>{{end}}
+            <select id="view-select" onchange="pickView(this)" title="Saved views"><option value="">All cards</option><option value="+">New view...</option></select>
+            <button onclick="toggleSortByVotes()" class="reset-btn" id="sort-votes">Sort by votes</button>
+            <button onclick="toggleEffects()" class="reset-btn" id="effects-btn" title="Confetti when a card is done or a sprint ends">Mute effects</button>
+            <button onclick="togglePresenting()" class="reset-btn" id="present-btn" title="Highlight the card you focus on everyone's board">Present</button>
+            <button onclick="toggleFreeze()" class="reset-btn">Freeze</button>
+            <button onclick="setArchived({{not .Archived}})" class="reset-btn">{{if .Archived}}Unarchive{{else}}Archive{{end}}</button>
+            {{if .Archived}}<a href="{{.Base}}/archive" class="reset-btn" target="_blank">Archived copy</a>{{end}}
+            {{if .E2E}}<span title="End-to-end encrypted">&#128274;</span>{{else}}<button onclick="enableEncryption()" class="reset-btn">Encrypt</button>{{end}}
             <button onclick="resetBoard()" class="reset-btn">Reset Board</button>
+            <a href="{{.Base}}/admin" class="reset-btn">Admin</a>
         </div>
     </header>
     
+    <nav class="column-tabs" id="column-tabs">
+        {{range .Columns}}<button onclick="showColumn('{{.ID}}')" data-col-id="{{.ID}}">{{.Title}}</button>{{end}}
+    </nav>
+
     <div class="main-container">
         <div class="board" id="board">
             ` + "{{with .}}" + boardHTML + "{{end}}" + `
@@ -99,40 +260,438 @@ const indexHTML = `
         <div class="sidebar">
             <div class="sidebar-header">
                 <h3>Activity</h3>
+                <label class="history-all" title="Also list presence and admin changes"><input type="checkbox" id="history-all" onchange="toggleHistoryAll(this.checked)"> All</label>
                 <button onclick="clearHistory()" class="clear-btn">Clear</button>
             </div>
             <div class="history-list" id="history">
-                {{range .History}}
-                <div class="history-entry">{{.}}</div>
-                {{end}}
+                {{range .History}}` + historyHTML + `{{end}}
             </div>
         </div>
     </div>
 
+    <footer class="served-by" id="served-by" data-node="{{.NodeID}}">Served by {{.NodeID}}</footer>
+
+    <div class="move-sheet" id="move-sheet"></div>
+
+    <div class="undo-toast" id="undo-toast">Card deleted.<button id="undo-btn">Undo</button></div>
+
+    <div class="poker-panel" id="poker">
+        <div style="display: flex; justify-content: space-between; align-items: center;">
+            <strong id="poker-title">Estimate</strong>
+            <button onclick="pokerSend('cancel')" class="delete-btn" title="End the round">&times;</button>
+        </div>
+        <div class="poker-values" id="poker-values"></div>
+        <div id="poker-status"></div>
+        <button onclick="pokerSend('reveal')" class="clear-btn" id="poker-reveal">Reveal</button>
+    </div>
+
+    <div class="versions-panel" id="online">
+        <div style="display: flex; justify-content: space-between; align-items: center;">
+            <strong>Who's online</strong>
+            <button onclick="document.getElementById('online').style.display = 'none'" class="delete-btn">&times;</button>
+        </div>
+        <div id="online-list"></div>
+    </div>
+
+    <div class="versions-panel" id="links">
+        <div style="display: flex; justify-content: space-between; align-items: center;">
+            <strong id="links-title">Links</strong>
+            <button onclick="document.getElementById('links').style.display = 'none'" class="delete-btn">&times;</button>
+        </div>
+        <div id="links-list"></div>
+    </div>
+
+    <div class="versions-panel" id="versions">
+        <div style="display: flex; justify-content: space-between; align-items: center;">
+            <strong>Description history</strong>
+            <button onclick="document.getElementById('versions').style.display = 'none'" class="delete-btn">&times;</button>
+        </div>
+        <div id="versions-list"></div>
+    </div>
+
     <script>
+        const base = '{{.Base}}';
+        const boardKey = '{{.BoardKey}}';
+        const columnPageSize = {{.PageSize}};
+        // Features on for this viewer, by name (see flags.go).
+        const features = {{.Features}};
         let socket;
         let heartbeatInterval;
 
+        // End-to-end encryption: on an encrypted board, titles and
+        // descriptions are sealed here with AES-GCM under a key derived from
+        // the board passphrase, and the server only sees "e2e:" followed by
+        // base64(iv + ciphertext). The key is kept for the browser session.
+        const e2eKeyCheck = {{.E2E}};
+        const e2eCheckText = 'deepboard-e2e';
+        const e2eKeyName = 'deepboard-e2e:' + base;
+        let e2eKey = null;
+
+        function toBase64(bytes) {
+            let s = '';
+            for (const b of bytes) s += String.fromCharCode(b);
+            return btoa(s);
+        }
+
+        function fromBase64(s) {
+            return Uint8Array.from(atob(s), c => c.charCodeAt(0));
+        }
+
+        function deriveKey(passphrase, salt) {
+            return crypto.subtle.importKey('raw', new TextEncoder().encode(passphrase), 'PBKDF2', false, ['deriveKey'])
+                .then(k => crypto.subtle.deriveKey({name: 'PBKDF2', salt: fromBase64(salt), iterations: 310000, hash: 'SHA-256'},
+                    k, {name: 'AES-GCM', length: 256}, true, ['encrypt', 'decrypt']));
+        }
+
+        // seal encrypts text with key, the board key by default. Without a
+        // key it returns text as is, which an encrypted board rejects.
+        function seal(text, key = e2eKey) {
+            if (!key || !text) return Promise.resolve(text);
+            const iv = crypto.getRandomValues(new Uint8Array(12));
+            return crypto.subtle.encrypt({name: 'AES-GCM', iv}, key, new TextEncoder().encode(text)).then(ct => {
+                const out = new Uint8Array(iv.length + ct.byteLength);
+                out.set(iv);
+                out.set(new Uint8Array(ct), iv.length);
+                return 'e2e:' + toBase64(out);
+            });
+        }
+
+        // unseal decrypts sealed text, leaving anything else as is. Concurrent
+        // replacements of a description merge into several sealed texts in a
+        // row; they are decrypted one per line.
+        function unseal(text, key = e2eKey) {
+            if (!key || !text.startsWith('e2e:')) return Promise.resolve(text);
+            return Promise.all(text.split('e2e:').slice(1).map(part => {
+                const raw = fromBase64(part);
+                return crypto.subtle.decrypt({name: 'AES-GCM', iv: raw.slice(0, 12)}, key, raw.slice(12))
+                    .then(pt => new TextDecoder().decode(pt));
+            })).then(parts => parts.join('\n'), () => '\u{1F512} (cannot decrypt)');
+        }
+
+        function checkKey(key) {
+            return unseal(e2eKeyCheck.check, key).then(t => t === e2eCheckText);
+        }
+
+        function saveKey(key) {
+            e2eKey = key;
+            return crypto.subtle.exportKey('raw', key).then(raw => sessionStorage.setItem(e2eKeyName, toBase64(new Uint8Array(raw))));
+        }
+
+        // unlockBoard loads the key of an encrypted board, asking for the
+        // passphrase until it opens the key check. Cancelling leaves the
+        // board locked, showing ciphertext.
+        function unlockBoard() {
+            if (!e2eKeyCheck) return Promise.resolve();
+            const saved = sessionStorage.getItem(e2eKeyName);
+            const stored = saved ? crypto.subtle.importKey('raw', fromBase64(saved), 'AES-GCM', true, ['encrypt', 'decrypt']) : Promise.resolve(null);
+            return stored.then(key => key ? checkKey(key).then(ok => ok && key) : null).then(key => {
+                if (key) {
+                    e2eKey = key;
+                    return;
+                }
+                const ask = () => {
+                    const pass = prompt('This board is end-to-end encrypted. Passphrase:');
+                    if (pass === null) return;
+                    return deriveKey(pass, e2eKeyCheck.salt).then(key => checkKey(key).then(ok => {
+                        if (ok) return saveKey(key);
+                        alert('Wrong passphrase.');
+                        return ask();
+                    }));
+                };
+                return ask();
+            });
+        }
+
+        // openSealed decrypts the card titles and descriptions under root in
+        // place.
+        function openSealed(root) {
+            if (!e2eKey) return Promise.resolve();
+            const jobs = [];
+            root.querySelectorAll('.card-title').forEach(el => {
+                jobs.push(unseal(el.textContent).then(t => {
+                    el.textContent = t;
+                    el.dataset.lastValue = t;
+                }));
+            });
+            root.querySelectorAll('.card-desc').forEach(el => {
+                jobs.push(unseal(el.value).then(t => {
+                    el.textContent = t;
+                    el.value = t;
+                    el.dataset.lastValue = t;
+                }));
+            });
+            return Promise.all(jobs);
+        }
+
+        function enableEncryption() {
+            const pass = prompt('Passphrase to encrypt this board with. The server never sees it, and content cannot be recovered without it:');
+            if (!pass) return;
+            if (prompt('Repeat the passphrase:') !== pass) {
+                alert('The passphrases do not match.');
+                return;
+            }
+            const salt = toBase64(crypto.getRandomValues(new Uint8Array(16)));
+            deriveKey(pass, salt).then(key => seal(e2eCheckText, key).then(check =>
+                fetch(base + '/api/admin/e2e', {method: 'POST', body: new URLSearchParams({salt, check})}).then(r => {
+                    if (!r.ok) return alertError(r);
+                    return saveKey(key).then(() => location.reload());
+                })));
+        }
+
         function updateStats() {
-            fetch('/stats').then(r => r.text()).then(text => {
+            fetch(base + '/stats').then(r => r.text()).then(text => {
                 const countsEl = document.getElementById('conn-counts');
                 if (countsEl) countsEl.innerHTML = text;
             });
+            if (document.getElementById('online').style.display === 'block') showOnline();
         }
 
+        // showOnline lists who is connected to each node of the cluster and
+        // the card they are editing, if it is on the page.
+        function showOnline() {
+            fetch(base + '/api/presence').then(r => r.json()).then(nodes => {
+                const list = document.getElementById('online-list');
+                list.innerHTML = '';
+                if (!nodes.length) list.textContent = 'Nobody is connected.';
+                nodes.forEach(n => {
+                    const head = document.createElement('div');
+                    head.className = 'version';
+                    head.textContent = n.node + (n.local ? ' (this node)' : '') + ': ' + n.connections + ' connected';
+                    list.appendChild(head);
+                    n.users.forEach(u => {
+                        const item = document.createElement('div');
+                        item.className = 'card-mention';
+                        item.append(userTag(u.name, u.color, u.away), u.connections > 1 ? ' (' + u.connections + ' tabs)' : '');
+                        const title = u.cardId && document.querySelector('.card[data-id="' + CSS.escape(u.cardId) + '"] .card-title');
+                        if (title) {
+                            item.append(', editing ' + title.textContent);
+                            item.onclick = () => goToCard(u.cardId);
+                        }
+                        list.appendChild(item);
+                    });
+                });
+                document.getElementById('online').style.display = 'block';
+            });
+        }
+
+        // noteServedBy shows in the footer the node that answered r. Behind the
+        // proxy it is the node the session is pinned to, so a node other than
+        // the one the page came from means the pinned node went away.
+        function noteServedBy(r) {
+            const el = document.getElementById('served-by');
+            const node = r.headers.get('X-DeepBoard-Node');
+            if (el && node) {
+                const moved = node !== el.dataset.node;
+                el.textContent = 'Served by ' + node + (moved ? ' (page loaded from ' + el.dataset.node + ')' : '');
+                el.classList.toggle('moved', moved);
+            }
+            return r;
+        }
+
+        const peerColors = { green: '#2ecc71', yellow: '#f1c40f', red: '#e74c3c' };
+
+        function updatePeers() {
+            fetch(base + '/api/peers').then(noteServedBy).then(r => r.json()).then(status => {
+                const el = document.getElementById('peer-health');
+                if (!el) return;
+                if (!status.health) {
+                    el.style.display = 'none';
+                    return;
+                }
+                el.style.display = 'inline-block';
+                el.style.background = peerColors[status.health];
+                el.title = status.peers.map(p => p.peer + ': ' + p.health +
+                    (p.lastError ? ' (' + p.lastError + ')' : ' (' + p.latencyMs + 'ms)')).join('\n');
+            }).catch(() => {});
+        }
+
+        // The Activity sidebar lists content edits; "All" adds presence and
+        // admin changes, and is remembered per board.
+        let historyAll = localStorage.getItem('deepboard-history:' + base) === 'all';
+
         function updateHistory() {
-            fetch('/history').then(r => r.text()).then(html => {
+            fetch(base + '/history' + (historyAll ? '?all=true' : '')).then(r => r.text()).then(html => {
                 const historyEl = document.getElementById('history');
                 if (historyEl) historyEl.innerHTML = html;
             });
         }
 
+        function toggleHistoryAll(on) {
+            historyAll = on;
+            localStorage.setItem('deepboard-history:' + base, on ? 'all' : '');
+            updateHistory();
+        }
+
+        // Edits made while disconnected are queued in local storage and
+        // replayed in order on reconnect; the CRDT merges them with whatever
+        // changed on the server meanwhile.
+        const queueKey = 'deepboard-queue:' + base;
+
+        function loadQueue() {
+            try {
+                return JSON.parse(localStorage.getItem(queueKey)) || [];
+            } catch (e) {
+                return [];
+            }
+        }
+
+        function sendOp(msg) {
+            if (socket && socket.readyState === WebSocket.OPEN && msg.type !== 'add') {
+                socket.send(JSON.stringify(msg));
+                return;
+            }
+            if (msg.type === 'add' && navigator.onLine) {
+                fetch(base + '/api/add', {method: 'POST', body: new URLSearchParams({title: msg.title})})
+                    .then(() => refreshUI()).catch(() => queueOp(msg));
+                return;
+            }
+            queueOp(msg);
+        }
+
+        function queueOp(msg) {
+            const queue = loadQueue();
+            queue.push(msg);
+            localStorage.setItem(queueKey, JSON.stringify(queue));
+            showQueue();
+        }
+
+        function showQueue() {
+            const n = loadQueue().length;
+            const el = document.getElementById('offline-status');
+            el.textContent = 'Offline: ' + n + ' pending edit' + (n === 1 ? '' : 's');
+            el.style.display = n ? 'inline' : 'none';
+        }
+
+        // flushQueue replays the queued edits: card additions through the
+        // REST API, everything else as WebSocket ops.
+        function flushQueue() {
+            const queue = loadQueue();
+            localStorage.removeItem(queueKey);
+            let chain = Promise.resolve();
+            queue.forEach(msg => {
+                chain = chain.then(() => {
+                    if (msg.type === 'add') {
+                        return fetch(base + '/api/add', {method: 'POST', body: new URLSearchParams({title: msg.title})});
+                    }
+                    socket.send(JSON.stringify(msg));
+                }).catch(() => queueOp(msg));
+            });
+            return chain.then(showQueue);
+        }
+
+        function initAddForm() {
+            const form = document.getElementById('add-form');
+            form.onsubmit = e => {
+                e.preventDefault();
+                seal(form.elements.title.value).then(title => sendOp({type: 'add', title}));
+                form.reset();
+            };
+        }
+
+        let templates = [];
+
+        function loadTemplates() {
+            const sel = document.getElementById('template-select');
+            if (!sel) return;
+            fetch(base + '/api/templates').then(r => r.ok ? r.json() : []).then(list => {
+                templates = list;
+                sel.length = 1;
+                list.forEach(t => sel.add(new Option(t.name, t.name)));
+                sel.style.display = list.length ? '' : 'none';
+            });
+        }
+
+        // currentView is the ID of the saved view the board shows, "" for
+        // all cards. /board applies it; a view's order is not the board's, so
+        // drops only change columns and moves refresh instead of animating.
+        let currentView = localStorage.getItem('deepboard-view:' + base) || '';
+
+        function loadViews() {
+            const sel = document.getElementById('view-select');
+            return fetch(base + '/api/views').then(r => r.ok ? r.json() : []).then(list => {
+                sel.length = 1;
+                list.forEach(v => sel.add(new Option(v.name, v.id)));
+                if (!list.some(v => v.id === currentView)) setView('');
+                if (currentView) sel.add(new Option('Delete this view', '-'));
+                sel.add(new Option('New view...', '+'));
+                sel.value = currentView;
+            });
+        }
+
+        function setView(id) {
+            currentView = id;
+            localStorage.setItem('deepboard-view:' + base, id);
+        }
+
+        function pickView(sel) {
+            if (sel.value === '+') {
+                sel.value = currentView;
+                const name = prompt('View name, e.g. "my urgent bugs":');
+                if (!name) return;
+                const text = prompt('Title contains (blank for any):', '');
+                if (text === null) return;
+                const assignee = prompt('Assignee ("me" for you, blank for anyone):', 'me');
+                if (assignee === null) return;
+                const labels = prompt('Any of these labels, comma-separated (blank for any):', '');
+                if (labels === null) return;
+                const priorities = prompt('Any of these priorities, comma-separated (blank for any):', '');
+                if (priorities === null) return;
+                const sort = prompt('Sort by: priority, votes, title, newest (blank for board order):', '');
+                if (sort === null) return;
+                const list = s => s.split(',').map(x => x.trim()).filter(x => x);
+                const filter = {text: text.trim(), assignee: assignee.trim(), labels: list(labels), priorities: list(priorities), sort: sort.trim()};
+                fetch(base + '/api/views', {
+                    method: 'POST',
+                    headers: {'Content-Type': 'application/json'},
+                    body: JSON.stringify({name, filter}),
+                }).then(r => r.ok ? r.json().then(v => { setView(v.id); return loadViews().then(refreshUI); }) : alertError(r));
+                return;
+            }
+            if (sel.value === '-') {
+                sel.value = currentView;
+                fetch(base + '/api/views?id=' + encodeURIComponent(currentView), {method: 'DELETE'}).then(() => {
+                    setView('');
+                    return loadViews().then(refreshUI);
+                });
+                return;
+            }
+            setView(sel.value);
+            loadViews().then(refreshUI);
+        }
+
+        function createFromTemplate(sel) {
+            const t = templates.find(t => t.name === sel.value);
+            sel.value = '';
+            if (!t) return;
+            const vars = {};
+            for (const name of t.inputs || []) {
+                const v = prompt(name + ':');
+                if (v === null) return;
+                vars[name] = v;
+            }
+            fetch(base + '/api/cards/from-template', {
+                method: 'POST',
+                headers: {'Content-Type': 'application/json'},
+                body: JSON.stringify({template: t.name, vars}),
+            }).then(r => r.ok ? refreshUI() : alertError(r));
+        }
+
+        // Input tells the server the user is here, at most once a minute, so
+        // others see them as away only once they leave the page alone.
+        let lastActive = 0;
+        function noteActive() {
+            if (Date.now() - lastActive < 60000 || !socket || socket.readyState !== WebSocket.OPEN) return;
+            lastActive = Date.now();
+            socket.send(JSON.stringify({type: 'active'}));
+        }
+        ['pointerdown', 'pointermove', 'keydown', 'wheel'].forEach(t => document.addEventListener(t, noteActive, {passive: true}));
+
         function connect() {
             const protocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:';
-            socket = new WebSocket(protocol + '//' + window.location.host + '/ws');
+            socket = new WebSocket(protocol + '//' + window.location.host + '/ws/' + boardKey);
             socket.onopen = () => {
                 console.log('WebSocket connected');
-                refreshUI();
+                flushQueue().then(refreshUI);
                 heartbeatInterval = setInterval(() => {
                     if (socket.readyState === WebSocket.OPEN) {
                         socket.send(JSON.stringify({type: 'heartbeat'}));
@@ -144,9 +703,30 @@ const indexHTML = `
                 if (msg.type === 'refresh') {
                     if (msg.silent) {
                         updateStats();
+                        checkMissed(msg.hash);
+                    } else if (msg.move && !currentView) {
+                        animateMove(msg.move);
                     } else {
                         refreshUI();
                     }
+                } else if (msg.type === 'presence') {
+                    const active = document.activeElement;
+                    if (active && active.id === 'desc-' + msg.presence.cardId) {
+                        showPresence(msg.presence.cardId, msg.presence.editors || [], msg.presence.colors || {}, msg.presence.away || []);
+                    }
+                } else if (msg.type === 'presenting') {
+                    showPresenting(msg.presence);
+                } else if (msg.type === 'poker') {
+                    showPoker(msg.poker);
+                } else if (msg.type === 'effect') {
+                    playEffect(msg.effect);
+                } else if (msg.type === 'deleted') {
+                    showUndo(msg.delete);
+                } else if (msg.type === 'error') {
+                    // A text op that missed the current text only needs the
+                    // page to catch up.
+                    if (msg.error.code !== 'bad_text_op') alert(msg.error.message);
+                    refreshUI(); // Revert optimistic local changes
                 }
             };
             socket.onclose = () => {
@@ -161,18 +741,135 @@ const indexHTML = `
 
         let refreshTimeout;
 
+        // alertError shows the message of an error response. Errors come in
+        // an envelope: {"error": {"code", "message", "requestId"}}.
+        function alertError(r) {
+            return r.json().then(body => alert(body.error.message), () => alert(r.statusText));
+        }
+
+        // Effects celebrate notable edits. The server skips them for users
+        // who muted them, and so does the page while the preference loads.
+        let effectsMuted = true;
+
+        function loadEffects() {
+            fetch(base + '/api/effects').then(r => r.ok ? r.json() : {muted: true}).then(showEffects);
+        }
+
+        function showEffects(pref) {
+            effectsMuted = pref.muted;
+            document.getElementById('effects-btn').textContent = effectsMuted ? 'Unmute effects' : 'Mute effects';
+        }
+
+        function toggleEffects() {
+            fetch(base + '/api/effects', {
+                method: 'POST',
+                headers: {'Content-Type': 'application/json'},
+                body: JSON.stringify({muted: !effectsMuted}),
+            }).then(r => r.ok ? r.json().then(showEffects) : alertError(r));
+        }
+
+        function playEffect(fx) {
+            if (effectsMuted || matchMedia('(prefers-reduced-motion: reduce)').matches) return;
+            if (fx.kind === 'card.completed') {
+                const card = document.querySelector('.card[data-id="' + CSS.escape(fx.cardId) + '"]');
+                const rect = card ? card.getBoundingClientRect() : null;
+                confetti(rect ? rect.left + rect.width / 2 : innerWidth / 2, rect ? rect.top : innerHeight / 3, 40);
+            } else if (fx.kind === 'sprint.ended') {
+                [0.25, 0.5, 0.75].forEach(x => confetti(innerWidth * x, innerHeight / 3, 60));
+            }
+        }
+
+        function confetti(x, y, count) {
+            const colors = ['#e74c3c', '#f1c40f', '#2ecc71', '#3498db', '#9b59b6'];
+            for (let i = 0; i < count; i++) {
+                const bit = document.createElement('div');
+                bit.className = 'confetti';
+                bit.style.left = x + 'px';
+                bit.style.top = y + 'px';
+                bit.style.background = colors[i % colors.length];
+                document.body.appendChild(bit);
+                const angle = Math.random() * 2 * Math.PI, dist = 60 + Math.random() * 140;
+                requestAnimationFrame(() => requestAnimationFrame(() => {
+                    bit.style.transform = 'translate(' + Math.cos(angle) * dist + 'px, ' + (Math.sin(angle) * dist + 120) + 'px) rotate(' + Math.random() * 720 + 'deg)';
+                    bit.style.opacity = '0';
+                }));
+                setTimeout(() => bit.remove(), 1300);
+            }
+        }
+
+        // Drift detection: the server hashes the board as clients render it
+        // and sends the hash with every refresh (and with /board). A client
+        // whose page does not match what it last fetched, or which hears of
+        // a state it never fetched, re-renders the whole board.
+        let renderedFrom = null; // hash of the last /board response rendered
+        let refreshing = 0;
+        let driftCount = 0;
+
+        function renderedHash() {
+            let s = '';
+            document.querySelectorAll('.card-list').forEach(list => {
+                list.querySelectorAll('.card').forEach(card => {
+                    s += list.dataset.colId + '\t' + card.dataset.id + '\t' +
+                        card.querySelector('.card-title').textContent + '\t' +
+                        card.querySelector('.card-desc').value + '\n';
+                });
+            });
+            // FNV-1a, as hash/fnv on the server.
+            let h = 0x811c9dc5;
+            for (const b of new TextEncoder().encode(s)) {
+                h = Math.imul(h ^ b, 0x01000193) >>> 0;
+            }
+            return h.toString(16).padStart(8, '0');
+        }
+
+        // hasLocalEdits reports whether the page shows edits the server has
+        // not seen yet, which would make it differ from any server hash.
+        function hasLocalEdits() {
+            if (loadQueue().length) return true;
+            if (document.activeElement && document.activeElement.matches('.card-desc, .card-title')) return true;
+            return Array.from(document.querySelectorAll('.card-desc, .card-title')).some(el => el._pendingOp);
+        }
+
+        function reportDrift(reason) {
+            driftCount++;
+            console.warn('Board drift #' + driftCount + ' (' + reason + '), re-rendering');
+            if (socket && socket.readyState === WebSocket.OPEN) {
+                socket.send(JSON.stringify({type: 'drift'}));
+            }
+        }
+
+        // checkMissed compares the hash of a refresh message that did not
+        // trigger a refresh with the state last rendered.
+        function checkMissed(hash) {
+            // Refresh messages hash the whole board, not the view or the
+            // pages of large columns shown.
+            if (currentView || document.querySelector('.card-more') || !hash || !renderedFrom || refreshing || hash === renderedFrom || hasLocalEdits()) return;
+            reportDrift('missed update');
+            refreshUI();
+        }
+
         function refreshUI() {
             // Update History & Stats
             updateHistory();
             updateStats();
-            
-            fetch('/board').then(r => {
+            updateNotifications();
+
+            refreshing++;
+            let fetchedHash = null;
+            const params = new URLSearchParams({limit: loadedLimit()});
+            if (currentView) params.set('view', currentView);
+            return fetch(base + '/board?' + params).then(r => {
                 if (!r.ok) throw new Error('Network response was not ok');
+                noteServedBy(r);
+                fetchedHash = r.headers.get('X-Board-Hash');
                 return r.text();
             }).then(html => {
-                const activeId = document.activeElement && document.activeElement.classList.contains('card-desc') ? document.activeElement.id : null;
                 const temp = document.createElement('div');
                 temp.innerHTML = html;
+                return openSealed(temp).then(() => temp);
+            }).then(temp => {
+                const html = temp.innerHTML;
+                const activeId = document.activeElement && document.activeElement.classList.contains('card-desc') ? document.activeElement.id : null;
                 
                 const cardLists = temp.querySelectorAll('.card-list');
                 if (cardLists.length === 0) {
@@ -181,6 +878,9 @@ const indexHTML = `
                     if (!activeId) document.getElementById('board').innerHTML = html;
                     return;
                 }
+                const oldColors = document.getElementById('label-colors');
+                const newColors = temp.querySelector('#label-colors');
+                if (oldColors && newColors) oldColors.replaceWith(newColors);
 
                 cardLists.forEach(newList => {
                     const oldList = document.getElementById(newList.id);
@@ -202,11 +902,49 @@ const indexHTML = `
                         if (!oldCard) {
                             oldList.appendChild(newCard.cloneNode(true));
                         } else {
-                            // Update title
+                            oldCard.classList.toggle('overdue', newCard.classList.contains('overdue'));
+                            oldCard.style.setProperty('--votes', newCard.style.getPropertyValue('--votes'));
+                            const oldVotes = oldCard.querySelector('.vote-btn');
+                            const newVotes = newCard.querySelector('.vote-btn');
+                            if (oldVotes && newVotes) oldVotes.replaceWith(newVotes.cloneNode(true));
+                            const oldEstimate = oldCard.querySelector('.poker-btn');
+                            const newEstimate = newCard.querySelector('.poker-btn');
+                            if (oldEstimate && newEstimate) oldEstimate.replaceWith(newEstimate.cloneNode(true));
+
+                            if (oldCard.dataset.key !== newCard.dataset.key) {
+                                oldCard.dataset.key = newCard.dataset.key;
+                                oldCard.querySelector('.card-key').textContent = newCard.dataset.key;
+                            }
+                            const oldLabels = oldCard.querySelector('.card-labels');
+                            const newLabels = newCard.querySelector('.card-labels');
+                            if (oldLabels) oldLabels.remove();
+                            if (newLabels) oldCard.firstElementChild.after(newLabels.cloneNode(true));
+                            for (const part of ['.checklist-progress', '.checklist']) {
+                                const oldPart = oldCard.querySelector(part);
+                                const newPart = newCard.querySelector(part);
+                                if (oldPart && newPart) oldPart.replaceWith(newPart.cloneNode(true));
+                            }
+                            const oldAssignee = oldCard.querySelector('.card-assignee');
+                            const newAssignee = newCard.querySelector('.card-assignee');
+                            if (oldAssignee && newAssignee) oldAssignee.replaceWith(newAssignee.cloneNode(true));
+                            const oldDue = oldCard.querySelector('.card-due');
+                            const newDue = newCard.querySelector('.card-due');
+                            if (oldDue && newDue) {
+                                oldDue.textContent = newDue.textContent;
+                                oldDue.className = newDue.className;
+                            }
+
+                            // Update title, unless a local edit of it is
+                            // still to be sent.
                             const oldTitle = oldCard.querySelector('.card-title');
                             const newTitle = newCard.querySelector('.card-title');
-                            if (oldTitle && newTitle && oldTitle.innerText !== newTitle.innerText) {
-                                oldTitle.innerText = newTitle.innerText;
+                            if (oldTitle && newTitle && oldTitle.textContent !== newTitle.textContent) {
+                                if (!oldTitle._pendingOp) {
+                                    setEditableText(oldTitle, newTitle.textContent);
+                                } else {
+                                    clearTimeout(refreshTimeout);
+                                    refreshTimeout = setTimeout(refreshUI, 1100);
+                                }
                             }
                             
                             const oldTA = oldCard.querySelector('.card-desc');
@@ -240,55 +978,812 @@ const indexHTML = `
                             }
                         }
                     });
+
+                    // 3. Follow the server's order, which sorted columns and
+                    // views change, unless someone is typing in the column.
+                    oldList.dataset.sort = newList.dataset.sort;
+                    const order = newCards.map(c => c.dataset.id);
+                    const current = Array.from(oldList.querySelectorAll('.card')).map(c => c.dataset.id);
+                    if (order.join() !== current.join() && !oldList.contains(document.activeElement)) {
+                        order.forEach(id => oldList.appendChild(oldList.querySelector('[data-id="' + id + '"]')));
+                    }
+
+                    // 4. Replace the "more" marker, which goes last.
+                    const oldMore = oldList.querySelector('.card-more');
+                    const newMore = newList.querySelector('.card-more');
+                    if (oldMore) oldMore.remove();
+                    if (newMore) oldList.appendChild(newMore.cloneNode(true));
                 });
 
-                initSortable(); initTextareas();
+                initSortable(); initTextareas(); markWatched(); markVoted(); markPresented(); markUnseen(); renderPreviews(); renderMentions(); observeMore();
+
+                renderedFrom = fetchedHash;
+                // The server hashes ciphertext, which the page no longer shows.
+                if (fetchedHash && !e2eKey && !hasLocalEdits() && renderedHash() !== fetchedHash) {
+                    reportDrift('render');
+                    document.getElementById('board').innerHTML = html;
+                    initSortable(); initTextareas(); markWatched(); markVoted(); markPresented(); markUnseen(); renderPreviews(); renderMentions(); observeMore();
+                }
             }).catch(err => {
                 console.error('Failed to refresh UI:', err);
+            }).finally(() => {
+                refreshing--;
+            });
+        }
+
+        // loadedLimit is the number of cards per column a refresh asks for:
+        // a page, or as many as the longest column has loaded.
+        function loadedLimit() {
+            let n = columnPageSize;
+            document.querySelectorAll('.card-list').forEach(list => {
+                n = Math.max(n, list.querySelectorAll('.card').length);
+            });
+            return n;
+        }
+
+        // loadMore replaces a "more" marker with the next page of its
+        // column. Cards a refresh has rendered meanwhile are skipped.
+        function loadMore(more) {
+            if (more._loading) return;
+            more._loading = true;
+            const params = new URLSearchParams({offset: more.dataset.offset});
+            if (currentView) params.set('view', currentView);
+            fetch(base + '/board/column/' + encodeURIComponent(more.dataset.colId) + '?' + params).then(r => {
+                if (!r.ok) throw new Error('Network response was not ok');
+                return r.text();
+            }).then(html => {
+                const temp = document.createElement('div');
+                temp.innerHTML = html;
+                return openSealed(temp).then(() => temp);
+            }).then(temp => {
+                if (!more.isConnected) return;
+                const list = more.parentNode;
+                if (moreObserver) moreObserver.unobserve(more);
+                more.remove();
+                Array.from(temp.children).forEach(el => {
+                    if (el.classList.contains('card') && list.querySelector('.card[data-id="' + CSS.escape(el.dataset.id) + '"]')) return;
+                    list.appendChild(el);
+                });
+                initSortable(); initTextareas(); markWatched(); markVoted(); markPresented(); markUnseen(); renderPreviews(); renderMentions(); observeMore();
+            }).catch(err => {
+                more._loading = false;
+                console.error('Failed to load cards:', err);
+            });
+        }
+
+        // observeMore loads the next page of a column when its "more" marker
+        // scrolls into view, or when it is clicked.
+        let moreObserver = null;
+        function observeMore() {
+            if (!moreObserver && 'IntersectionObserver' in window) {
+                moreObserver = new IntersectionObserver(entries => {
+                    entries.forEach(e => { if (e.isIntersecting) loadMore(e.target); });
+                });
+            }
+            document.querySelectorAll('.card-more').forEach(more => {
+                more.onclick = () => loadMore(more);
+                if (moreObserver) moreObserver.observe(more);
+            });
+        }
+
+        // animateMove refreshes the board and slides the moved card from its
+        // old position to the new one instead of letting it snap.
+        function animateMove(move) {
+            const selector = '.card[data-id="' + CSS.escape(move.cardId) + '"]';
+            const before = document.querySelector(selector);
+            const first = before && before.getBoundingClientRect();
+            refreshUI().then(() => {
+                const el = document.querySelector(selector);
+                if (!first || !el) return;
+                const last = el.getBoundingClientRect();
+                const dx = first.left - last.left, dy = first.top - last.top;
+                if (!dx && !dy) return;
+                el.style.transition = 'none';
+                el.style.transform = 'translate(' + dx + 'px, ' + dy + 'px)';
+                requestAnimationFrame(() => {
+                    el.style.transition = 'transform 0.3s ease';
+                    el.style.transform = '';
+                });
+            });
+        }
+
+        function sendPresence(type, cardId) {
+            if (socket && socket.readyState === WebSocket.OPEN) {
+                socket.send(JSON.stringify({type, presence: {cardId}}));
+            }
+        }
+
+        // Link previews: chips with the title and favicon of the URLs in a
+        // description, unfurled by the server. Encrypted boards skip them, as
+        // asking would hand the server the URLs.
+        const unfurlEnabled = {{.Unfurl}};
+        const previews = new Map();
+        const urlPattern = /https?:\/\/[^\s<>"')]+/g;
+
+        function unfurl(url) {
+            if (!previews.has(url)) {
+                previews.set(url, fetch(base + '/api/unfurl?url=' + encodeURIComponent(url)).then(r => r.ok ? r.json() : null).catch(() => null));
+            }
+            return previews.get(url);
+        }
+
+        function renderPreviews() {
+            if (!unfurlEnabled || e2eKey) return;
+            document.querySelectorAll('.card-desc').forEach(el => {
+                const urls = [...new Set(el.value.match(urlPattern) || [])].slice(0, 3);
+                const key = urls.join(' ');
+                if (el._previewKey === key) return;
+                el._previewKey = key;
+                let box = el.parentElement.querySelector('.link-previews');
+                if (!box) {
+                    box = document.createElement('div');
+                    box.className = 'link-previews';
+                    el.after(box);
+                }
+                box.innerHTML = '';
+                urls.forEach(url => unfurl(url).then(p => {
+                    if (!p || el._previewKey !== key) return;
+                    const chip = document.createElement('a');
+                    chip.className = 'link-preview';
+                    chip.href = url;
+                    chip.target = '_blank';
+                    chip.rel = 'noopener noreferrer';
+                    chip.title = p.description || url;
+                    if (p.favicon) {
+                        const icon = document.createElement('img');
+                        icon.src = p.favicon;
+                        icon.alt = '';
+                        icon.onerror = () => icon.remove();
+                        chip.appendChild(icon);
+                    }
+                    chip.appendChild(document.createTextNode(p.title || p.siteName || url));
+                    box.appendChild(chip);
+                }));
+            });
+        }
+
+        // Card keys: #DB-42 in a description links to that card, and the key
+        // on a card lists what it mentions and what mentions it.
+        const mentionPattern = /#DB-\d+\b/g;
+
+        function goToCard(cardId) {
+            document.querySelectorAll('.card.highlight').forEach(c => c.classList.remove('highlight'));
+            history.replaceState(null, '', '#card-' + cardId);
+            highlightLinkedCard();
+        }
+
+        function renderMentions() {
+            document.querySelectorAll('.card-desc').forEach(el => {
+                const keys = [...new Set((el.value.match(mentionPattern) || []).map(m => m.slice(1)))];
+                const key = keys.join(' ');
+                if (el._mentionKey === key) return;
+                el._mentionKey = key;
+                let box = el.parentElement.querySelector('.card-mentions');
+                if (!box) {
+                    box = document.createElement('div');
+                    box.className = 'card-mentions';
+                    el.after(box);
+                }
+                box.innerHTML = '';
+                keys.forEach(k => {
+                    const target = document.querySelector('.card[data-key="' + CSS.escape(k) + '"]');
+                    if (!target) return;
+                    const chip = document.createElement('span');
+                    chip.className = 'card-mention';
+                    chip.textContent = k + ' ' + target.querySelector('.card-title').textContent;
+                    chip.onclick = () => goToCard(target.dataset.id);
+                    box.appendChild(chip);
+                });
+            });
+        }
+
+        function showLinks(cardId) {
+            fetch(base + '/api/cards/links?card=' + encodeURIComponent(cardId)).then(r => r.json()).then(data => {
+                document.getElementById('links-title').textContent = 'Links of ' + data.key;
+                const list = document.getElementById('links-list');
+                list.innerHTML = '';
+                [['Mentions', data.links], ['Mentioned by', data.backlinks]].forEach(([label, refs]) => {
+                    const head = document.createElement('div');
+                    head.className = 'version';
+                    head.textContent = label + (refs.length ? '' : ': none');
+                    list.appendChild(head);
+                    refs.forEach(ref => {
+                        const item = document.createElement('div');
+                        item.className = 'card-mention';
+                        item.textContent = ref.key + ' ';
+                        const title = document.createElement('span');
+                        title.textContent = ref.title;
+                        unseal(ref.title).then(t => { title.textContent = t; });
+                        item.appendChild(title);
+                        item.onclick = () => goToCard(ref.id);
+                        list.appendChild(item);
+                    });
+                });
+                document.getElementById('links').style.display = 'block';
+            });
+        }
+
+        // Cards changed since the viewer last looked are badged until clicked.
+        const unseenCards = new Set({{.Unseen}} || []);
+
+        function markUnseen() {
+            document.querySelectorAll('.card').forEach(c => c.classList.toggle('unseen', unseenCards.has(c.dataset.id)));
+            const note = document.getElementById('unseen-note');
+            const n = unseenCards.size;
+            note.textContent = n + (n === 1 ? ' card' : ' cards') + ' changed since you last looked';
+            note.style.display = n ? 'inline' : 'none';
+        }
+
+        function clearUnseen() {
+            unseenCards.clear();
+            markUnseen();
+        }
+
+        document.addEventListener('click', e => {
+            const card = e.target.closest && e.target.closest('.card.unseen');
+            if (card && unseenCards.delete(card.dataset.id)) markUnseen();
+        });
+        window.addEventListener('pagehide', () => navigator.sendBeacon(base + '/api/seen'));
+
+        // In presentation mode, the card this client focuses is highlighted on
+        // everyone's board.
+        let presenting = false;
+        let presentedCard = '';
+        let myPresentedCard = '';
+
+        function togglePresenting() {
+            presenting = !presenting;
+            document.getElementById('present-btn').textContent = presenting ? 'Stop presenting' : 'Present';
+            myPresentedCard = '';
+            if (!presenting) sendPresence('present', '');
+        }
+
+        function presentFocused(e) {
+            const card = presenting && e.target.closest && e.target.closest('.card');
+            if (card && card.dataset.id !== myPresentedCard) {
+                myPresentedCard = card.dataset.id;
+                sendPresence('present', myPresentedCard);
+            }
+        }
+        document.addEventListener('focusin', presentFocused);
+        document.addEventListener('click', presentFocused);
+
+        function showPresenting(op) {
+            if (presenting && op.cardId !== myPresentedCard) {
+                // Someone else took over.
+                presenting = false;
+                document.getElementById('present-btn').textContent = 'Present';
+            }
+            presentedCard = op.cardId;
+            const label = document.getElementById('presenter');
+            label.textContent = '';
+            if (op.cardId) {
+                const name = op.presenter || 'Someone';
+                label.append(userTag(name, (op.colors || {})[name]), ' is presenting');
+            }
+            label.style.display = op.cardId ? 'inline' : 'none';
+            markPresented();
+            const card = presentedCard && document.querySelector('.card[data-id="' + CSS.escape(presentedCard) + '"]');
+            if (card && !presenting) card.scrollIntoView({behavior: 'smooth', block: 'nearest'});
+        }
+
+        function markPresented() {
+            document.querySelectorAll('.card').forEach(c => c.classList.toggle('presented', c.dataset.id === presentedCard));
+        }
+
+        // userTag is a user's name in their color, as the server assigned it,
+        // dimmed if they are away.
+        function userTag(name, color, away) {
+            const tag = document.createElement('span');
+            tag.className = 'user-tag' + (away ? ' away' : '');
+            tag.textContent = name;
+            if (color) tag.style.borderLeftColor = color;
+            if (away) tag.title = 'Away';
+            return tag;
+        }
+
+        // showPresence warns that others are editing a card's description
+        // before local typing gets merged with theirs.
+        function showPresence(cardId, editors, colors, away) {
+            const ta = document.getElementById('desc-' + cardId);
+            if (!ta) return;
+            let banner = ta.previousElementSibling;
+            if (!banner || !banner.classList.contains('presence-banner')) {
+                if (!editors.length) return;
+                banner = document.createElement('div');
+                banner.className = 'presence-banner';
+                ta.before(banner);
+            }
+            banner.textContent = '';
+            editors.forEach((name, i) => banner.append(i ? ', ' : '', userTag(name, colors[name], away.includes(name))));
+            banner.append((editors.length > 1 ? ' are' : ' is') + ' editing this card');
+            banner.style.display = editors.length ? 'block' : 'none';
+        }
+
+        function showVersions(cardId) {
+            fetch(base + '/api/cards/versions?card=' + encodeURIComponent(cardId)).then(r => r.json()).then(versions => {
+                const list = document.getElementById('versions-list');
+                list.innerHTML = '';
+                if (!versions.length) list.textContent = 'No recorded versions.';
+                versions.forEach((v, i) => {
+                    const el = document.createElement('div');
+                    el.className = 'version';
+                    const head = document.createElement('div');
+                    head.textContent = new Date(v.time).toLocaleString() + (i === 0 ? ' (current)' : '');
+                    if (i > 0) {
+                        const btn = document.createElement('button');
+                        btn.textContent = 'Restore';
+                        btn.className = 'clear-btn';
+                        btn.style.marginLeft = '8px';
+                        btn.onclick = () => restoreVersion(cardId, v.patch);
+                        head.appendChild(btn);
+                    }
+                    const pre = document.createElement('pre');
+                    v.diff.forEach(d => {
+                        const line = document.createElement('span');
+                        line.className = d.op === '+' ? 'diff-add' : d.op === '-' ? 'diff-del' : '';
+                        unseal(d.text).then(t => { line.textContent = d.op + ' ' + t + '\n'; });
+                        pre.appendChild(line);
+                    });
+                    el.append(head, pre);
+                    list.appendChild(el);
+                });
+                document.getElementById('versions').style.display = 'block';
+            });
+        }
+
+        function restoreVersion(cardId, patch) {
+            if (!confirm('Replace the description with this version?')) return;
+            const body = new URLSearchParams({card: cardId, patch});
+            fetch(base + '/api/cards/restore', {method: 'POST', body}).then(r => {
+                if (!r.ok) return alertError(r);
+                showVersions(cardId);
             });
         }
 
         function deleteCard(cardId) {
             if (confirm('Delete this card?')) {
-                if (socket && socket.readyState === WebSocket.OPEN) {
-                    socket.send(JSON.stringify({type: 'delete', delete: {cardId}}));
-                } else {
-                    console.error('WebSocket not open, cannot delete card');
-                }
+                sendOp({type: 'delete', delete: {cardId}});
             }
         }
 
+        let undoTimer = null;
+
+        // showUndo offers to undo a deletion made by this client for as long
+        // as the server keeps the card restorable.
+        function showUndo(del) {
+            const toast = document.getElementById('undo-toast');
+            document.getElementById('undo-btn').onclick = () => {
+                socket.send(JSON.stringify({type: 'undoDelete', delete: {cardId: del.cardId}}));
+                toast.style.display = 'none';
+            };
+            toast.style.display = 'block';
+            clearTimeout(undoTimer);
+            undoTimer = setTimeout(() => { toast.style.display = 'none'; }, del.undoSeconds * 1000);
+        }
+
         function clearHistory() {
             if (confirm('Clear activity history?')) {
-                fetch('/api/history/clear').then(() => refreshUI());
+                fetch(base + '/api/history/clear').then(() => refreshUI());
             }
         }
 
         function cleanupConnections() {
-            fetch('/api/connections/cleanup').then(() => refreshUI());
+            fetch(base + '/api/connections/cleanup').then(() => refreshUI());
         }
 
         function resetBoard() {
             if (confirm('DANGER: This will wipe EVERYTHING and reset the board for all users. Are you absolutely sure?')) {
-                fetch('/api/admin/reset').then(() => refreshUI());
+                fetch(base + '/api/admin/reset').then(() => refreshUI());
             }
         }
 
+        function toggleStar() {
+            const el = document.getElementById('star');
+            const star = el.dataset.starred !== 'true';
+            fetch('/api/favorites', {method: 'POST', body: new URLSearchParams({board: boardKey, star})}).then(r => {
+                if (!r.ok) return;
+                el.dataset.starred = star;
+                el.innerHTML = star ? '&#9733;' : '&#9734;';
+            });
+        }
+
+        function toggleFreeze() {
+            fetch(base + '/api/admin/freeze').then(() => refreshUI());
+        }
+
+        function setArchived(archived) {
+            if (archived && !confirm('Archive this board? It becomes read-only and stops syncing.')) return;
+            fetch(base + '/api/admin/archive', {method: 'POST', body: new URLSearchParams({archived})}).then(r => {
+                if (r.ok) location.reload();
+                else alertError(r);
+            });
+        }
+
+        // Signed-in viewers vote as themselves; anonymous ones as an ID kept
+        // in this browser.
+        const voterKey = 'deepboard-voter';
+        if (!localStorage.getItem(voterKey)) localStorage.setItem(voterKey, crypto.randomUUID());
+        const browserVoter = localStorage.getItem(voterKey);
+        const myVoter = '{{.Voter}}' || 'anon:' + browserVoter;
+
+        function votedFor(btn) {
+            return JSON.parse(btn.dataset.voters || '[]').includes(myVoter);
+        }
+
+        function vote(cardId) {
+            const btn = document.querySelector('.card[data-id="' + CSS.escape(cardId) + '"] .vote-btn');
+            sendOp({type: 'vote', vote: {cardId, up: !votedFor(btn), voter: browserVoter}});
+        }
+
+        function markVoted() {
+            document.querySelectorAll('.vote-btn').forEach(btn => {
+                const on = votedFor(btn);
+                btn.classList.toggle('voted', on);
+                btn.title = on ? 'Withdraw vote' : 'Vote';
+            });
+        }
+
+        const pokerValues = ['1', '2', '3', '5', '8', '13', '21', '?'];
+        let pokerCard = null;
+        let pokerPick = null;
+
+        function pokerSend(action, value) {
+            if (!socket || socket.readyState !== WebSocket.OPEN) {
+                alert('Estimation needs a live connection.');
+                return;
+            }
+            socket.send(JSON.stringify({type: 'poker', poker: {cardId: pokerCard, action, value, voter: browserVoter}}));
+        }
+
+        function startPoker(cardId) {
+            if (pokerCard !== cardId) pokerPick = null;
+            pokerCard = cardId;
+            pokerSend('start');
+        }
+
+        function showPoker(round) {
+            const panel = document.getElementById('poker');
+            if (round.closed) {
+                if (round.cardId === pokerCard) {
+                    panel.style.display = 'none';
+                    pokerCard = null;
+                }
+                return;
+            }
+            if (pokerCard && pokerCard !== round.cardId && panel.style.display === 'block') return;
+            if (pokerCard !== round.cardId) pokerPick = null;
+            pokerCard = round.cardId;
+            const card = document.querySelector('.card[data-id="' + CSS.escape(round.cardId) + '"] .card-title');
+            document.getElementById('poker-title').textContent = 'Estimate: ' + (card ? card.textContent : '');
+            const values = document.getElementById('poker-values');
+            values.innerHTML = '';
+            const estimates = round.estimates || {};
+            const choices = round.revealed ? [...new Set(Object.values(estimates))] : pokerValues;
+            choices.forEach(v => {
+                const btn = document.createElement('button');
+                btn.textContent = v;
+                btn.className = 'clear-btn' + (v === pokerPick && !round.revealed ? ' picked' : '');
+                btn.title = round.revealed ? 'Accept ' + v : '';
+                btn.onclick = round.revealed ? () => pokerSend('accept', v) : () => { pokerPick = v; pokerSend('estimate', v); };
+                values.appendChild(btn);
+            });
+            const status = document.getElementById('poker-status');
+            status.textContent = round.revealed
+                ? Object.entries(estimates).map(([name, v]) => name + ': ' + v).join(', ') + '. Pick the agreed value.'
+                : 'Estimated: ' + ((round.voted || []).join(', ') || 'nobody yet');
+            document.getElementById('poker-reveal').style.display = round.revealed ? 'none' : '';
+            panel.style.display = 'block';
+        }
+
+        function toggleSortByVotes() {
+            const on = document.body.classList.toggle('sort-votes');
+            localStorage.setItem('deepboard-sort:' + base, on ? 'votes' : '');
+            document.getElementById('sort-votes').textContent = on ? 'Sort by order' : 'Sort by votes';
+            initSortable();
+        }
+
+        let watching = [];
+
+        // updateNotifications refreshes the inbox badge and panel. The
+        // endpoint answers 204 when nobody is signed in; the bell then stays
+        // hidden.
+        function updateNotifications() {
+            fetch(base + '/api/notifications').then(r => r.status === 200 ? r.json() : null).then(data => {
+                if (!data) return;
+                document.getElementById('notif-box').style.display = 'block';
+                const badge = document.getElementById('notif-badge');
+                badge.textContent = data.unread;
+                badge.style.display = data.unread ? 'inline' : 'none';
+                const panel = document.getElementById('notif-panel');
+                panel.innerHTML = '';
+                data.notifications.forEach(n => {
+                    const a = document.createElement('a');
+                    a.className = 'notification' + (n.read ? '' : ' unread');
+                    a.href = '#card-' + n.cardId;
+                    const label = document.createTextNode(n.title || n.cardId);
+                    unseal(label.data).then(t => { label.data = t; });
+                    a.appendChild(label);
+                    a.onclick = () => markRead(n.id);
+                    const meta = document.createElement('small');
+                    meta.textContent = n.detail + ' · ' + new Date(n.time).toLocaleString();
+                    a.appendChild(meta);
+                    panel.appendChild(a);
+                });
+                if (data.unread) {
+                    const all = document.createElement('a');
+                    all.className = 'notification';
+                    all.href = '#';
+                    all.textContent = 'Mark all as read';
+                    all.onclick = e => { e.preventDefault(); markRead(0); };
+                    panel.prepend(all);
+                }
+                if (!data.notifications.length) panel.textContent = 'No notifications';
+                watching = data.watching;
+                markWatched();
+            }).catch(() => {});
+        }
+
+        function toggleNotifications() {
+            const panel = document.getElementById('notif-panel');
+            panel.style.display = panel.style.display === 'block' ? 'none' : 'block';
+        }
+
+        function markRead(id) {
+            const body = new URLSearchParams(id ? {id} : {});
+            fetch(base + '/api/notifications/read', {method: 'POST', body}).then(() => updateNotifications());
+        }
+
+        function markWatched() {
+            document.querySelectorAll('.watch-btn').forEach(btn => {
+                const on = watching.includes(btn.dataset.card);
+                btn.classList.toggle('watching', on);
+                btn.title = on ? 'Stop watching' : 'Watch';
+            });
+        }
+
+        function toggleWatch(cardId) {
+            const body = new URLSearchParams({card: cardId, watch: !watching.includes(cardId)});
+            fetch(base + '/api/cards/watch', {method: 'POST', body}).then(r => {
+                if (!r.ok) return alertError(r);
+                updateNotifications();
+            });
+        }
+
+        // searchUsers calls done with the directory users matching q
+        // (/api/users/search, which finds nobody unless LDAP is configured),
+        // once typing pauses.
+        let userSearchTimeout;
+
+        function searchUsers(q, done) {
+            clearTimeout(userSearchTimeout);
+            if (!q) return done([]);
+            userSearchTimeout = setTimeout(() => {
+                fetch(base + '/api/users/search?q=' + encodeURIComponent(q)).then(r => r.ok ? r.json() : []).then(done).catch(() => done([]));
+            }, 200);
+        }
+
+        // pickAssignee replaces the card's assignee chip with an input that
+        // suggests the people of the board and, as a name is typed, those
+        // of the directory; any other name works too, and none unassigns
+        // the card. Enter assigns, Escape cancels.
+        function pickAssignee(cardId) {
+            const chip = document.querySelector('.card[data-id="' + CSS.escape(cardId) + '"] .card-assignee');
+            const options = document.getElementById('directory-users');
+            const input = document.createElement('input');
+            input.className = 'assignee-input';
+            input.value = chip.dataset.assignee;
+            input.placeholder = 'Assign to (blank for nobody)';
+            input.setAttribute('list', 'directory-users');
+            let board = [];
+            const suggest = directory => {
+                options.innerHTML = '';
+                const seen = new Set();
+                board.map(u => ({username: u.id, name: u.name})).concat(directory).forEach(u => {
+                    if (seen.has(u.username)) return;
+                    seen.add(u.username);
+                    const option = document.createElement('option');
+                    option.value = u.username;
+                    if (u.name && u.name !== u.username) option.label = u.name;
+                    options.appendChild(option);
+                });
+            };
+            fetch(base + '/api/users').then(r => r.ok ? r.json() : []).then(users => {
+                board = users;
+                suggest([]);
+            });
+            const done = () => {
+                input.remove();
+                chip.style.display = '';
+            };
+            input.oninput = () => searchUsers(input.value.trim(), suggest);
+            input.onkeydown = e => {
+                if (e.key === 'Enter') {
+                    e.preventDefault();
+                    sendOp({type: 'assign', assign: {cardId, assignee: input.value.trim()}});
+                    done();
+                } else if (e.key === 'Escape') {
+                    done();
+                }
+            };
+            input.onblur = done;
+            chip.style.display = 'none';
+            chip.after(input);
+            input.focus();
+            input.select();
+        }
+
+        // suggestMentions lists the directory users matching the @mention
+        // being typed in el under it; picking one completes the mention.
+        function suggestMentions(el) {
+            const box = document.getElementById('mention-suggestions');
+            el.addEventListener('input', () => {
+                const m = el.value.slice(0, el.selectionStart).match(/(?:^|\s)@([\w.-]+)$/);
+                if (!m) {
+                    box.style.display = 'none';
+                    return;
+                }
+                searchUsers(m[1], users => {
+                    if (document.activeElement !== el) return;
+                    box.innerHTML = '';
+                    users.forEach(u => {
+                        const item = document.createElement('div');
+                        item.className = 'search-result';
+                        item.textContent = '@' + u.username;
+                        if (u.name) {
+                            const meta = document.createElement('small');
+                            meta.textContent = u.name + (u.email ? ' · ' + u.email : '');
+                            item.appendChild(meta);
+                        }
+                        // Picking on mousedown keeps the focus in el.
+                        item.onmousedown = e => {
+                            e.preventDefault();
+                            el.setRangeText(u.username + ' ', el.selectionStart - m[1].length, el.selectionStart, 'end');
+                            box.style.display = 'none';
+                            el.oninput();
+                        };
+                        box.appendChild(item);
+                    });
+                    const rect = el.getBoundingClientRect();
+                    box.style.top = rect.bottom + 'px';
+                    box.style.left = rect.left + 'px';
+                    box.style.display = users.length ? 'block' : 'none';
+                });
+            });
+            el.addEventListener('blur', () => box.style.display = 'none');
+        }
+
+        function addChecklistItem(cardId) {
+            const text = prompt('Checklist item:');
+            if (!text || !text.trim()) return;
+            sendOp({type: 'checklist', checklist: {cardId, op: 'add', text: text.trim()}});
+        }
+
+        function checkItem(cardId, itemId, done) {
+            sendOp({type: 'checklist', checklist: {cardId, op: 'toggle', itemId, done}});
+        }
+
+        function removeChecklistItem(cardId, itemId) {
+            sendOp({type: 'checklist', checklist: {cardId, op: 'remove', itemId}});
+        }
+
+        let searchTimeout;
+
+        function initSearch() {
+            const input = document.getElementById('search');
+            const list = document.getElementById('search-results');
+            input.oninput = () => {
+                clearTimeout(searchTimeout);
+                const q = input.value.trim();
+                if (!q) {
+                    list.style.display = 'none';
+                    return;
+                }
+                // Search is global: it lives above the tenant prefix.
+                searchTimeout = setTimeout(() => {
+                    fetch('/api/search?q=' + encodeURIComponent(q)).then(r => r.json()).then(page => {
+                        const results = page.items;
+                        list.innerHTML = '';
+                        results.forEach(res => {
+                            const a = document.createElement('a');
+                            a.className = 'search-result';
+                            a.href = res.board + '/#card-' + res.cardId;
+                            a.textContent = res.title;
+                            const meta = document.createElement('small');
+                            meta.textContent = res.boardTitle + ' · ' + res.column + ' · ' + res.field + ': ' + res.snippet;
+                            a.appendChild(meta);
+                            list.appendChild(a);
+                        });
+                        if (!results.length) list.textContent = 'No matches';
+                        list.style.display = 'block';
+                    }).catch(() => {});
+                }, 200);
+            };
+            input.onblur = () => setTimeout(() => list.style.display = 'none', 200);
+        }
+
+        function highlightLinkedCard() {
+            const m = location.hash.match(/^#card-(.+)$/);
+            if (!m) return;
+            const el = document.querySelector('.card[data-id="' + CSS.escape(m[1]) + '"]');
+            if (!el) return;
+            el.classList.add('highlight');
+            el.scrollIntoView({block: 'center'});
+        }
+
+        function isMobile() {
+            return document.body.classList.contains('mobile');
+        }
+
+        // initView picks the mobile view for small screens unless a view was
+        // chosen explicitly with ?view=.
+        function initView() {
+            if (!document.body.dataset.view && window.matchMedia('(max-width: 700px)').matches) {
+                document.body.classList.add('mobile');
+            }
+            if (!isMobile()) return;
+            const board = document.getElementById('board');
+            board.addEventListener('scroll', () => {
+                const i = Math.round(board.scrollLeft / board.clientWidth);
+                document.querySelectorAll('.column-tabs button').forEach((b, j) => b.classList.toggle('active', i === j));
+            });
+            board.dispatchEvent(new Event('scroll'));
+        }
+
+        function showColumn(colId) {
+            const list = document.getElementById('col-' + colId);
+            if (list) list.closest('.column').scrollIntoView({behavior: 'smooth', inline: 'start'});
+        }
+
+        // pickMove replaces drag and drop on phones: it offers the other
+        // columns and moves the card to the end of the chosen one.
+        function pickMove(cardId) {
+            const card = document.querySelector('.card[data-id="' + CSS.escape(cardId) + '"]');
+            if (!card) return;
+            const fromColId = card.closest('.card-list').dataset.colId;
+            const sheet = document.getElementById('move-sheet');
+            sheet.innerHTML = '';
+            document.querySelectorAll('.card-list').forEach(list => {
+                const btn = document.createElement('button');
+                btn.textContent = list.closest('.column').querySelector('h3').textContent;
+                btn.disabled = list.dataset.colId === fromColId;
+                btn.onclick = () => {
+                    const toIndex = list.querySelectorAll('.card').length;
+                    sendOp({type: 'move', move: {cardId, from: fromColId, to: list.dataset.colId, toIndex}});
+                    sheet.classList.remove('open');
+                };
+                sheet.appendChild(btn);
+            });
+            const cancel = document.createElement('button');
+            cancel.textContent = 'Cancel';
+            cancel.onclick = () => sheet.classList.remove('open');
+            sheet.appendChild(cancel);
+            sheet.classList.add('open');
+        }
+
         function initSortable() {
+            // Dragging is unusable on phones; the mobile view moves cards
+            // with pickMove instead.
+            if (isMobile()) return;
             document.querySelectorAll('.card-list').forEach(col => {
                 if (col._sortable) col._sortable.destroy();
-                col._sortable = new Sortable(col, { group: 'shared', animation: 150, onEnd: e => {
+                // Sorted by votes or by the column, or shown through a view,
+                // the position in a column is not the card's; a drop to
+                // another column appends.
+                const sort = !document.body.classList.contains('sort-votes') && !currentView && !col.dataset.sort;
+                col._sortable = new Sortable(col, { group: 'shared', animation: 150, sort, draggable: '.card', onEnd: e => {
                     const cardId = e.item.dataset.id;
                     const fromColId = e.from.dataset.colId;
                     const toColId = e.to.dataset.colId;
-                    const toIndex = e.newIndex;
-                    if (fromColId !== toColId || e.oldIndex !== toIndex) {
-                        if (socket && socket.readyState === WebSocket.OPEN) {
-                            socket.send(JSON.stringify({type:'move', move:{cardId, from:fromColId, to:toColId, toIndex}}));
-                        } else {
-                            console.error('WebSocket not open, cannot move card');
-                            refreshUI(); // Revert UI if possible
-                        }
+                    // A column's first pages are its first cards, so the
+                    // index among the loaded ones is the card's; the "more"
+                    // marker stays last.
+                    const more = e.to.querySelector('.card-more');
+                    if (more) e.to.appendChild(more);
+                    const newIndex = Array.from(e.to.querySelectorAll('.card')).indexOf(e.item);
+                    const toIndex = currentView || e.to.dataset.sort ? Number.MAX_SAFE_INTEGER : newIndex;
+                    if (fromColId !== toColId || e.oldIndex !== newIndex) {
+                        sendOp({type:'move', move:{cardId, from:fromColId, to:toColId, toIndex}});
                     }
                 }});
             });
@@ -304,8 +1799,23 @@ const indexHTML = `
                 if (el._inputHandlerInit) return;
                 el._inputHandlerInit = true;
 
+                el.onfocus = () => {
+                    const cardId = el.id.slice(5);
+                    sendPresence('editing', cardId);
+                    sendPresence('presenceQuery', cardId);
+                    clearInterval(el._presenceInterval);
+                    el._presenceInterval = setInterval(() => sendPresence('presenceQuery', cardId), 5000);
+                };
+                el.onblur = () => {
+                    clearInterval(el._presenceInterval);
+                    sendPresence('editing', '');
+                    showPresence(el.id.slice(5), [], {}, []);
+                };
+
+                composeText(el);
+                suggestMentions(el);
                 el.oninput = () => {
-                    if (el.dataset.syncing) return;
+                    if (el.dataset.syncing || el._composing) return;
                     const old = el.dataset.lastValue || "";
                     const val = el.value;
                     el._pendingOp = true;
@@ -319,89 +1829,432 @@ const indexHTML = `
                             return;
                         }
 
-                        let commonPrefix = 0;
-                        while (commonPrefix < old.length && commonPrefix < val.length && old[commonPrefix] === val[commonPrefix]) {
-                            commonPrefix++;
-                        }
-
-                        let commonSuffix = 0;
-                        while (commonSuffix < old.length - commonPrefix && commonSuffix < val.length - commonPrefix &&
-                               old[old.length - 1 - commonSuffix] === val[val.length - 1 - commonSuffix]) {
-                            commonSuffix++;
-                        }
-
-                        const delLen = old.length - commonPrefix - commonSuffix;
-                        const insStr = val.slice(commonPrefix, val.length - commonSuffix);
-
-                        if (delLen > 0) {
-                            if (socket && socket.readyState === WebSocket.OPEN) {
-                                socket.send(JSON.stringify({
-                                    type: 'textOp',
-                                    textOp: { cardId: el.id.slice(5), op: 'delete', pos: commonPrefix, length: delLen }
-                                }));
-                            }
-                        }
+                        sendTextEdit('textOp', el.id.slice(5), old, val);
+                        el.dataset.lastValue = val;
+                    }, 250);
+                };
+            });
 
-                        if (insStr.length > 0) {
-                            if (socket && socket.readyState === WebSocket.OPEN) {
-                                socket.send(JSON.stringify({
-                                    type: 'textOp',
-                                    textOp: { cardId: el.id.slice(5), op: 'insert', pos: commonPrefix, val: insStr }
-                                }));
-                            }
-                        }
+            // Titles are sent as ops while they are typed too, but the
+            // server replaces the title whole with each one, so concurrent
+            // edits do not merge; Enter ends the edit.
+            document.querySelectorAll('.card-title[contenteditable]').forEach(el => {
+                if (el._inputHandlerInit) return;
+                el._inputHandlerInit = true;
+                const cardId = el.id.slice(6);
 
+                el.onfocus = () => sendPresence('editing', cardId);
+                el.onblur = () => sendPresence('editing', '');
+                el.onkeydown = e => {
+                    if (e.key === 'Enter') {
+                        e.preventDefault();
+                        el.blur();
+                    }
+                };
+                composeText(el);
+                el.oninput = () => {
+                    if (el._composing) return;
+                    el._pendingOp = true;
+                    clearTimeout(el._inputTimeout);
+                    el._inputTimeout = setTimeout(() => {
+                        el._pendingOp = false;
+                        const old = el.dataset.lastValue || '';
+                        const val = el.textContent;
+                        if (val === old) return;
+                        sendTextEdit('titleOp', cardId, old, val);
                         el.dataset.lastValue = val;
                     }, 250);
                 };
             });
         }
 
+        // textDiff returns the ops that turn old into val: a delete of what
+        // changed between their common prefix and suffix, then an insert of
+        // what replaced it.
+        // The server counts positions in UTF-8 bytes, the page in UTF-16
+        // units, and neither may split a character.
+        function textDiff(old, val) {
+            let commonPrefix = 0;
+            while (commonPrefix < old.length && commonPrefix < val.length && old[commonPrefix] === val[commonPrefix]) {
+                commonPrefix++;
+            }
+            if (isLowSurrogate(old, commonPrefix) || isLowSurrogate(val, commonPrefix)) commonPrefix--;
+
+            let commonSuffix = 0;
+            while (commonSuffix < old.length - commonPrefix && commonSuffix < val.length - commonPrefix &&
+                   old[old.length - 1 - commonSuffix] === val[val.length - 1 - commonSuffix]) {
+                commonSuffix++;
+            }
+            if (commonSuffix > 0 && isLowSurrogate(old, old.length - commonSuffix)) commonSuffix--;
+
+            const pos = utf8Length(old.slice(0, commonPrefix));
+            const delLen = utf8Length(old.slice(commonPrefix, old.length - commonSuffix));
+            const insStr = val.slice(commonPrefix, val.length - commonSuffix);
+            const ops = [];
+            if (delLen > 0) ops.push({op: 'delete', pos, length: delLen});
+            if (insStr.length > 0) ops.push({op: 'insert', pos, val: insStr});
+            return ops;
+        }
+
+        function isLowSurrogate(s, i) {
+            const c = s.charCodeAt(i);
+            return c >= 0xdc00 && c <= 0xdfff;
+        }
+
+        function utf8Length(s) {
+            return new TextEncoder().encode(s).length;
+        }
+
+        // composeText holds back the edits of el while an input method
+        // composes text: the intermediate strings it shows (the romaji of a
+        // word being converted, an autocorrect suggestion) are not the
+        // user's text, and sending them produces deletes and inserts that
+        // race the final one. The composed text is sent once it is committed.
+        // Remote changes are not applied meanwhile, as replacing the value
+        // would cancel the composition.
+        function composeText(el) {
+            el.addEventListener('compositionstart', () => {
+                el._composing = true;
+                el._pendingOp = true;
+                clearTimeout(el._inputTimeout);
+            });
+            el.addEventListener('compositionend', () => {
+                el._composing = false;
+                el.oninput();
+            });
+        }
+
+        // sendTextEdit sends the edit of a card's text from old to val as
+        // messages of type ('textOp' for descriptions, 'titleOp' for titles).
+        function sendTextEdit(type, cardId, old, val) {
+            if (e2eKeyCheck) {
+                // Ciphertext cannot be spliced: send it all.
+                seal(val).then(sealed => sendOp({type, [type]: {cardId, op: 'replace', val: sealed}}));
+                return;
+            }
+            textDiff(old, val).forEach(op => sendOp({type, [type]: {cardId, ...op}}));
+        }
+
+        // setEditableText replaces the text of a contenteditable element,
+        // keeping the caret where it was if the element has focus.
+        function setEditableText(el, text) {
+            const sel = window.getSelection();
+            const focused = el === document.activeElement && sel.rangeCount > 0;
+            const offset = focused ? sel.getRangeAt(0).startOffset : 0;
+            el.textContent = text;
+            el.dataset.lastValue = text;
+            if (focused && el.firstChild) {
+                sel.collapse(el.firstChild, Math.min(offset, text.length));
+            }
+        }
+
         document.addEventListener('DOMContentLoaded', () => {
-            connect();
+            initView();
+            if (localStorage.getItem('deepboard-sort:' + base) === 'votes') toggleSortByVotes();
+            if (historyAll) {
+                document.getElementById('history-all').checked = true;
+                updateHistory();
+            }
+            initAddForm();
+            loadTemplates();
+            loadViews();
+            loadEffects();
+            showQueue();
+            if ('serviceWorker' in navigator) {
+                navigator.serviceWorker.register(base + '/sw.js', {scope: base + '/'}).catch(() => {});
+            }
+            updatePeers();
+            setInterval(updatePeers, 10000);
+            setInterval(updateNotifications, 30000);
+            unlockBoard().then(() => openSealed(document.getElementById('board'))).then(connect);
             initSortable();
             initTextareas();
+            markVoted();
+            markUnseen();
+            renderPreviews();
+            renderMentions();
+            observeMore();
+            initSearch();
+            highlightLinkedCard();
         });
+        window.addEventListener('hashchange', highlightLinkedCard);
+    </script>
+</body>
+</html>
+`
+
+// wallboardHTML is the read-only wallboard: large, high-contrast text and
+// no scripts, so it suits e-ink displays and kiosk browsers alike.
+const wallboardHTML = `
+<!DOCTYPE html>
+<html>
+<head>
+    <title>{{.Title}} - Wallboard</title>
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <meta http-equiv="refresh" content="{{.Refresh}}">
+    <style>
+        body { font-family: Verdana, Geneva, sans-serif; background: #fff; color: #000; margin: 0; padding: 1vw; }
+        header { display: flex; justify-content: space-between; align-items: baseline; border-bottom: 4px solid #000; margin-bottom: 1vw; }
+        h1 { font-size: 3vw; margin: 0; }
+        header span { font-size: 1.5vw; }
+        .columns { display: flex; gap: 1vw; }
+        section { flex: 1; min-width: 0; border: 3px solid #000; padding: 0.5vw 1vw; }
+        h2 { font-size: 2vw; margin: 0 0 0.5vw; border-bottom: 2px solid #000; }
+        ul { list-style: none; margin: 0; padding: 0; }
+        li { font-size: 1.6vw; padding: 0.3vw 0; border-bottom: 1px solid #000; overflow: hidden; text-overflow: ellipsis; white-space: nowrap; }
+        li:last-child { border-bottom: none; }
+        .more { font-style: italic; }
+    </style>
+</head>
+<body>
+    <header><h1>{{.Title}}</h1><span>Updated {{.Updated}}</span></header>
+    <div class="columns">
+        {{range .Columns}}<section>
+            <h2>{{.Title}} ({{.Count}})</h2>
+            <ul>{{range .Cards}}<li>{{.}}</li>{{end}}{{if .More}}<li class="more">+{{.More}} more</li>{{end}}</ul>
+        </section>{{end}}
+    </div>
+</body>
+</html>
+`
+
+// embedHTML is an embedded, read-only board or column. It has no scripts
+// and its own styles, so it looks the same in any page that frames it.
+const embedHTML = `
+<!DOCTYPE html>
+<html>
+<head>
+    <title>{{.Title}}</title>
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <meta http-equiv="refresh" content="60">
+    <style>
+        * { box-sizing: border-box; }
+        body { font: 14px/1.4 -apple-system, 'Segoe UI', Roboto, sans-serif; background: transparent; color: #1c1e21; margin: 0; padding: 8px; }
+        .columns { display: flex; gap: 8px; }
+        section { flex: 1; min-width: 0; background: #f0f2f5; border-radius: 6px; padding: 8px; }
+        h2 { font-size: 13px; text-transform: uppercase; letter-spacing: 0.5px; color: #606770; margin: 0 0 6px; }
+        ul { list-style: none; margin: 0; padding: 0; }
+        li { background: #fff; border-radius: 4px; padding: 6px 8px; margin-bottom: 4px; box-shadow: 0 1px 1px rgba(0,0,0,0.1); overflow-wrap: anywhere; }
+        .more { background: none; box-shadow: none; color: #606770; }
+    </style>
+</head>
+<body>
+    <div class="columns">
+        {{range .Columns}}<section>
+            <h2>{{.Title}} ({{.Count}})</h2>
+            <ul>{{range .Cards}}<li>{{.}}</li>{{end}}{{if .More}}<li class="more">+{{.More}} more</li>{{end}}</ul>
+        </section>{{end}}
+    </div>
+</body>
+</html>
+`
+
+// demoHTML is the launcher page of "deepboard demo": every node of the
+// cluster side by side, each with a switch to cut it off from the others.
+const demoHTML = `
+<!DOCTYPE html>
+<html>
+<head>
+    <title>DeepBoard - Demo Cluster</title>
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <style>
+        body { font-family: 'Segoe UI', Tahoma, Geneva, Verdana, sans-serif; background: #f0f2f5; margin: 0; color: #1c1e21; display: flex; flex-direction: column; height: 100vh; }
+        header { background: #2c3e50; color: white; padding: 0.8rem 2rem; }
+        header h1 { margin: 0; font-size: 1.5rem; }
+        header p { margin: 0.3rem 0 0; font-size: 0.9rem; color: #bdc3c7; }
+        .nodes { display: flex; gap: 8px; padding: 8px; flex: 1; min-height: 0; }
+        .node { flex: 1; display: flex; flex-direction: column; background: white; border-radius: 8px; box-shadow: 0 1px 2px rgba(0,0,0,0.1); overflow: hidden; border: 3px solid transparent; }
+        .node.isolated { border-color: #e74c3c; }
+        .node-bar { display: flex; justify-content: space-between; align-items: center; padding: 6px 10px; font-weight: 600; }
+        .node-bar a { color: inherit; }
+        .node iframe { flex: 1; border: 0; width: 100%; }
+        button { padding: 4px 10px; border-radius: 4px; border: 1px solid #ccc; background: #fff; cursor: pointer; }
+        .isolated button { background: #e74c3c; color: white; border-color: #e74c3c; }
+    </style>
+</head>
+<body>
+    <header>
+        <h1>DeepBoard demo cluster</h1>
+        <p>Each pane is a separate node with its own database. Edit on one and watch the others follow. Disconnect a node, edit on both sides, then reconnect it and see the changes merge.</p>
+    </header>
+    <div class="nodes">
+        {{range .}}<div class="node{{if .Isolated}} isolated{{end}}" data-node="{{.ID}}">
+            <div class="node-bar"><a href="{{.Path}}" target="_blank">{{.ID}}</a>
+                <button onclick="togglePartition(this)">{{if .Isolated}}Reconnect{{else}}Disconnect{{end}}</button></div>
+            <iframe src="{{.Path}}" title="{{.ID}}"></iframe>
+        </div>{{end}}
+    </div>
+    <script>
+        async function togglePartition(button) {
+            const node = button.closest('.node');
+            const isolated = !node.classList.contains('isolated');
+            const body = new URLSearchParams({node: node.dataset.node, isolated: String(isolated)});
+            const r = await fetch('/demo/partition', {method: 'POST', body});
+            if (!r.ok) return;
+            node.classList.toggle('isolated', isolated);
+            button.textContent = isolated ? 'Reconnect' : 'Disconnect';
+        }
     </script>
 </body>
 </html>
 `
 
+// homeHTML is the personal home page: starred and recently visited boards.
+const homeHTML = `
+<!DOCTYPE html>
+<html>
+<head>
+    <title>DeepBoard - Home</title>
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <style>
+        body { font-family: 'Segoe UI', Tahoma, Geneva, Verdana, sans-serif; background: #f0f2f5; margin: 0; color: #1c1e21; }
+        header { background: #2c3e50; color: white; padding: 0.8rem 2rem; box-shadow: 0 2px 4px rgba(0,0,0,0.1); }
+        header h1 { margin: 0; font-size: 1.5rem; letter-spacing: -0.5px; }
+        main { max-width: 720px; margin: 0 auto; padding: 20px; }
+        h2 { font-size: 1rem; text-transform: uppercase; letter-spacing: 1px; color: #7f8c8d; }
+        .boards { display: flex; flex-direction: column; gap: 8px; }
+        .home-board { display: flex; justify-content: space-between; background: white; border-radius: 8px; padding: 12px 16px; box-shadow: 0 1px 2px rgba(0,0,0,0.1); color: inherit; text-decoration: none; }
+        .home-board:hover { box-shadow: 0 0 0 2px #3498db; }
+        .home-board small { color: #7f8c8d; }
+        .empty { color: #7f8c8d; font-size: 0.9rem; }
+    </style>
+</head>
+<body>
+    <header><h1>DeepBoard</h1></header>
+    <main>
+        <h2>Starred</h2>
+        <div class="boards" id="starred">
+            {{range .Starred}}<a class="home-board" href="{{.Path}}"><span>&#9733; {{.Title}}</span><small data-cards="{{.Key}}">{{.Cards}} cards</small></a>
+            {{else}}<p class="empty">Star a board from its header to pin it here.</p>{{end}}
+        </div>
+        <h2>Recent</h2>
+        <div class="boards" id="recent">
+            {{range .Recent}}<a class="home-board" href="{{.Path}}"><span>{{if .Starred}}&#9733; {{end}}{{.Title}}</span><small data-cards="{{.Key}}">{{.Cards}} cards</small></a>
+            {{else}}<p class="empty">Boards you open show up here.</p>{{end}}
+        </div>
+    </main>
+    <script>
+        // Keep card counts live.
+        setInterval(() => {
+            fetch('/api/home').then(r => r.json()).then(data => {
+                data.starred.concat(data.recent).forEach(b => {
+                    document.querySelectorAll('[data-cards="' + CSS.escape(b.key) + '"]').forEach(el => {
+                        el.textContent = b.cards + ' cards';
+                    });
+                });
+            }).catch(() => {});
+        }, 10000);
+    </script>
+</body>
+</html>
+`
+
+// uiFuncs are the functions available to the board templates.
+var uiFuncs = template.FuncMap{
+	"text":      textString,
+	"voters":    votersJSON,
+	"cardKey":   cardKey,
+	"pastDue":   func(due string) bool { return pastDue(due, time.Now()) },
+	"checklist": sortChecklist,
+	"progress":  checklistProgress,
+}
+
+// UIColumn is a column with its cards in order, built from Board.Cards by
+// buildUIColumns to render.
 type UIColumn struct {
 	ID    string
 	Title string
+	Sort  string // the column's sort policy, "" for manual
 	Cards []Card
+	Next  int // offset of the first card left out, 0 if all are shown
+	More  int // how many cards are left out
 }
 
 type UIData struct {
+	Base       string // mount path of the board, for tenant boards
+	BoardKey   string // name of the board in /ws/{board}
 	NodeID     string
 	Columns    []UIColumn
-	History    []string
+	History    []HistoryEntry
 	LocalCount int
 	TotalCount int
+	View       string // "mobile", "desktop" or "" to pick by screen size
+	Starred    bool   // whether the viewer starred this board
+	Archived   bool
+	E2E        *Encryption     // key check of an encrypted board, nil if not encrypted
+	Voter      string          // the signed-in viewer's voter ID, "" when sign-in is off
+	Unseen     []string        // cards changed since the viewer last looked
+	Unfurl     bool            // whether link previews are enabled
+	PageSize   int             // cards shown per column before loading more
+	Features   map[string]bool // features on for the viewer, see flags.go
+	Labels     []Label         // labels the board defines, for the colors of chips
+	Users      []BoardUser     // people cards can be assigned to, for the colors of assignees
+}
+
+// boardHash is a short hash of the board as clients render it: the cards of
+// each column in order, with their titles and descriptions as the browser
+// reads them back from the page. Clients hash what they display the same way
+// (renderedHash in indexHTML) and compare it with the hash of refresh
+// messages to notice updates they missed.
+func boardHash(state BoardState) string {
+	return columnsHash(buildUIColumns(state))
+}
+
+// columnsHash is boardHash of the given columns, such as those of a view.
+func columnsHash(cols []UIColumn) string {
+	h := fnv.New32a()
+	for _, col := range cols {
+		for _, c := range col.Cards {
+			fmt.Fprintf(h, "%s\t%s\t%s\t%s\n", col.ID, c.ID, renderedText(c.Title),
+				strings.TrimPrefix(renderedText(textString(c.Description)), "\n"))
+		}
+	}
+	return fmt.Sprintf("%08x", h.Sum32())
+}
+
+// renderedText normalizes line breaks like HTML parsing does. (A textarea
+// also drops a single leading line break, which boardHash strips.)
+func renderedText(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "\r\n", "\n"), "\r", "\n")
 }
 
 func buildUIColumns(state BoardState) []UIColumn {
-	uiColumns := make([]UIColumn, len(state.Board.Columns))
+	columns := orderedColumns(state.Board)
+	uiColumns := make([]UIColumn, len(columns))
 	colMap := make(map[string]int)
 
-	for i, col := range state.Board.Columns {
+	for i, col := range columns {
 		uiColumns[i] = UIColumn{
 			ID:    col.ID,
 			Title: col.Title,
-			Cards: []Card{},
+			Sort:  col.Sort,
 		}
 		colMap[col.ID] = i
 	}
 
+	// Cards of a deleted column are shown in the first one.
+	columnOf := func(card Card) (int, bool) {
+		idx, ok := colMap[card.ColumnID]
+		return idx, ok || len(uiColumns) > 0
+	}
+	counts := make([]int, len(uiColumns))
+	for _, card := range state.Board.Cards {
+		if idx, ok := columnOf(card); ok {
+			counts[idx]++
+		}
+	}
+	for i, n := range counts {
+		uiColumns[i].Cards = make([]Card, 0, n)
+	}
 	for _, card := range state.Board.Cards {
-		if idx, ok := colMap[card.ColumnID]; ok {
+		if idx, ok := columnOf(card); ok {
 			uiColumns[idx].Cards = append(uiColumns[idx].Cards, card)
 		}
 	}
 
 	for i := range uiColumns {
 		sortCards(uiColumns[i].Cards)
+		sortColumn(uiColumns[i].Cards, uiColumns[i].Sort)
 	}
 
 	return uiColumns
@@ -411,20 +2264,124 @@ func prepareUIData(s *Store) UIData {
 	state := s.GetBoard()
 	localCount, totalCount := getConnectionCounts(state, s.nodeID)
 	return UIData{
+		BoardKey:   s.BoardKey(),
+		Archived:   state.Board.Archived,
+		E2E:        e2eKeyCheck(state),
 		NodeID:     s.nodeID,
-		Columns:    buildUIColumns(state),
-		History:    s.GetHistory(15),
+		Columns:    pageColumns(buildUIColumns(state), columnPageSize),
+		PageSize:   columnPageSize,
+		Labels:     state.Board.Labels,
+		Users:      state.Board.Users,
+		History:    s.recentHistory(false),
 		LocalCount: localCount,
 		TotalCount: totalCount,
 	}
 }
 
+// sortCards puts cards in board order. Cards of equal order, as left by
+// concurrent inserts, are ordered by ID so every render agrees.
 func sortCards(cards []Card) {
-	for i := 0; i < len(cards); i++ {
-		for j := i + 1; j < len(cards); j++ {
-			if cards[i].Order > cards[j].Order {
-				cards[i], cards[j] = cards[j], cards[i]
-			}
-		}
-	}
+	slices.SortFunc(cards, func(a, b Card) int {
+		return cmp.Or(cmp.Compare(a.Order, b.Order), strings.Compare(a.ID, b.ID))
+	})
 }
+
+// adminHTML is the admin dashboard of a board.
+const adminHTML = `
+<!DOCTYPE html>
+<html>
+<head>
+    <title>DeepBoard - Admin</title>
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <style>
+        body { font-family: 'Segoe UI', Tahoma, Geneva, Verdana, sans-serif; background: #f0f2f5; margin: 0; color: #1c1e21; }
+        header { background: #2c3e50; color: white; padding: 0.8rem 2rem; box-shadow: 0 2px 4px rgba(0,0,0,0.1); }
+        header h1 { margin: 0; font-size: 1.5rem; letter-spacing: -0.5px; }
+        header a { color: inherit; text-decoration: none; }
+        main { max-width: 960px; margin: 0 auto; padding: 20px; }
+        h2 { font-size: 1rem; text-transform: uppercase; letter-spacing: 1px; color: #7f8c8d; }
+        .widget { background: white; border-radius: 8px; padding: 16px; box-shadow: 0 1px 2px rgba(0,0,0,0.1); overflow-x: auto; }
+        .calendar { display: flex; gap: 3px; }
+        .week { display: flex; flex-direction: column; gap: 3px; }
+        .hours { display: flex; flex-direction: column; gap: 3px; margin-top: 16px; }
+        .hours .row { display: flex; gap: 3px; align-items: center; }
+        .hours small { width: 32px; color: #7f8c8d; }
+        .cell { width: 11px; height: 11px; border-radius: 2px; }
+        .empty { color: #7f8c8d; font-size: 0.9rem; }
+    </style>
+</head>
+<body>
+    <header><h1><a href="{{.Base}}/">DeepBoard</a> &middot; {{.Title}}</h1></header>
+    <main>
+        <h2>Activity</h2>
+        <div class="widget">
+            <p class="empty" id="total"></p>
+            <div class="calendar" id="calendar"></div>
+            <div class="hours" id="hours"></div>
+        </div>
+    </main>
+    <script>
+        const base = '{{.Base}}';
+        const levels = ['#ebedf0', '#c6e48b', '#7bc96f', '#239a3b', '#196127'];
+        const weekdays = ['Sun', 'Mon', 'Tue', 'Wed', 'Thu', 'Fri', 'Sat'];
+
+        function cell(n, max, title) {
+            const el = document.createElement('span');
+            el.className = 'cell';
+            el.style.background = levels[n ? Math.min(4, Math.ceil(4 * n / max)) : 0];
+            el.title = title + ': ' + n + ' edit' + (n === 1 ? '' : 's');
+            return el;
+        }
+
+        function isoDate(d) {
+            return d.getFullYear() + '-' + String(d.getMonth() + 1).padStart(2, '0') + '-' + String(d.getDate()).padStart(2, '0');
+        }
+
+        // The calendar covers the last 53 weeks, one column per week, in the
+        // browser's time zone.
+        function renderCalendar(days) {
+            const counts = Object.fromEntries(days.map(d => [d.date, d.edits]));
+            const max = Math.max(1, ...days.map(d => d.edits));
+            const cal = document.getElementById('calendar');
+            const end = new Date();
+            const day = new Date();
+            day.setHours(12, 0, 0, 0); // noon keeps clear of DST shifts
+            day.setDate(day.getDate() - 52 * 7 - day.getDay());
+            while (day <= end) {
+                const week = document.createElement('div');
+                week.className = 'week';
+                for (let i = 0; i < 7 && day <= end; i++) {
+                    const date = isoDate(day);
+                    week.appendChild(cell(counts[date] || 0, max, date));
+                    day.setDate(day.getDate() + 1);
+                }
+                cal.appendChild(week);
+            }
+        }
+
+        function renderHours(hours) {
+            const max = Math.max(1, ...hours.flat());
+            const grid = document.getElementById('hours');
+            hours.forEach((counts, wd) => {
+                const row = document.createElement('div');
+                row.className = 'row';
+                const label = document.createElement('small');
+                label.textContent = weekdays[wd];
+                row.appendChild(label);
+                counts.forEach((n, h) => row.appendChild(cell(n, max, weekdays[wd] + ' ' + h + ':00')));
+                grid.appendChild(row);
+            });
+        }
+
+        const tz = Intl.DateTimeFormat().resolvedOptions().timeZone;
+        fetch(base + '/api/metrics/heatmap?tz=' + encodeURIComponent(tz)).then(r => r.json()).then(hm => {
+            document.getElementById('total').textContent = hm.total + ' edits in the retained history';
+            renderCalendar(hm.days);
+            renderHours(hm.hours);
+        }).catch(() => {
+            document.getElementById('total').textContent = 'Activity is unavailable.';
+        });
+    </script>
+</body>
+</html>
+`
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Link previews show the title and favicon of the URLs in card descriptions.
+// The server fetches the pages, so browsers do not need to reach them and
+// every viewer shares one cache, but only from the hosts allowed with
+// -unfurl-allow: fetching arbitrary URLs on a user's behalf would let anyone
+// probe the network the server sits in.
+
+const (
+	// unfurlTTL is how long a preview, or a failure, is cached.
+	unfurlTTL = time.Hour
+	// unfurlMaxEntries bounds the cache; it is emptied when full.
+	unfurlMaxEntries = 1000
+	// unfurlMaxBody is how much of a page is read looking for its metadata.
+	unfurlMaxBody = 512 * 1024
+)
+
+// ErrUnfurlDenied is returned for a URL whose host is not allowed.
+var ErrUnfurlDenied = errors.New("host not allowed for link previews")
+
+// Preview is what a link preview shows, from the page's Open Graph
+// metadata, or its title.
+type Preview struct {
+	URL         string `json:"url"`
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	SiteName    string `json:"siteName,omitempty"`
+	Favicon     string `json:"favicon,omitempty"`
+}
+
+// Unfurler fetches and caches link previews.
+type Unfurler struct {
+	allow  []string // host names; "*.example.com" allows subdomains
+	client *http.Client
+
+	mu    sync.Mutex
+	cache map[string]unfurlEntry
+}
+
+type unfurlEntry struct {
+	preview Preview
+	err     error
+	expires time.Time
+}
+
+// unfurler is set when link previews are enabled.
+var unfurler *Unfurler
+
+// newUnfurler returns an unfurler for the comma-separated host patterns in
+// allow.
+func newUnfurler(allow string) *Unfurler {
+	u := &Unfurler{cache: make(map[string]unfurlEntry)}
+	for _, h := range strings.Split(allow, ",") {
+		if h = strings.ToLower(strings.TrimSpace(h)); h != "" {
+			u.allow = append(u.allow, h)
+		}
+	}
+	u.client = &http.Client{
+		Timeout: 5 * time.Second,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return errors.New("too many redirects")
+			}
+			if !u.allowed(req.URL) {
+				return ErrUnfurlDenied
+			}
+			return nil
+		},
+	}
+	return u
+}
+
+// allowed reports whether previews may be fetched from target.
+func (u *Unfurler) allowed(target *url.URL) bool {
+	if target.Scheme != "http" && target.Scheme != "https" {
+		return false
+	}
+	host := strings.ToLower(target.Hostname())
+	for _, pattern := range u.allow {
+		if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+			if strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+		} else if host == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// Unfurl returns the preview of rawURL, from the cache if it is fresh.
+func (u *Unfurler) Unfurl(rawURL string, now time.Time) (Preview, error) {
+	target, err := url.Parse(rawURL)
+	if err != nil || !u.allowed(target) {
+		return Preview{}, ErrUnfurlDenied
+	}
+	key := target.String()
+
+	u.mu.Lock()
+	e, ok := u.cache[key]
+	u.mu.Unlock()
+	if ok && now.Before(e.expires) {
+		return e.preview, e.err
+	}
+
+	p, err := u.fetch(target)
+	u.mu.Lock()
+	if len(u.cache) >= unfurlMaxEntries {
+		clear(u.cache)
+	}
+	u.cache[key] = unfurlEntry{preview: p, err: err, expires: now.Add(unfurlTTL)}
+	u.mu.Unlock()
+	return p, err
+}
+
+func (u *Unfurler) fetch(target *url.URL) (Preview, error) {
+	req, err := http.NewRequest(http.MethodGet, target.String(), nil)
+	if err != nil {
+		return Preview{}, err
+	}
+	req.Header.Set("Accept", "text/html")
+	req.Header.Set("User-Agent", "DeepBoard link preview")
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return Preview{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Preview{}, fmt.Errorf("fetching %s: %s", target, resp.Status)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "" && !strings.Contains(ct, "html") {
+		return Preview{}, fmt.Errorf("fetching %s: not a page (%s)", target, ct)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, unfurlMaxBody))
+	if err != nil {
+		return Preview{}, err
+	}
+	return parsePreview(resp.Request.URL, string(body)), nil
+}
+
+var (
+	metaTagRe  = regexp.MustCompile(`(?is)<meta\s[^>]*>`)
+	linkTagRe  = regexp.MustCompile(`(?is)<link\s[^>]*>`)
+	titleTagRe = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+	attrRe     = regexp.MustCompile(`(?is)([a-z:-]+)\s*=\s*("[^"]*"|'[^']*'|[^\s>]+)`)
+)
+
+// tagAttrs returns the attributes of an HTML tag, names lowercased and
+// values unescaped.
+func tagAttrs(tag string) map[string]string {
+	attrs := make(map[string]string)
+	for _, m := range attrRe.FindAllStringSubmatch(tag, -1) {
+		attrs[strings.ToLower(m[1])] = html.UnescapeString(strings.Trim(m[2], `"'`))
+	}
+	return attrs
+}
+
+// parsePreview reads the metadata of the page at pageURL. The favicon
+// defaults to /favicon.ico.
+func parsePreview(pageURL *url.URL, page string) Preview {
+	p := Preview{URL: pageURL.String()}
+	meta := make(map[string]string)
+	for _, tag := range metaTagRe.FindAllString(page, -1) {
+		attrs := tagAttrs(tag)
+		name := strings.ToLower(attrs["property"])
+		if name == "" {
+			name = strings.ToLower(attrs["name"])
+		}
+		if _, seen := meta[name]; name != "" && !seen {
+			meta[name] = strings.TrimSpace(attrs["content"])
+		}
+	}
+	p.Title = meta["og:title"]
+	if p.Title == "" {
+		if m := titleTagRe.FindStringSubmatch(page); m != nil {
+			p.Title = strings.Join(strings.Fields(html.UnescapeString(m[1])), " ")
+		}
+	}
+	p.Description = meta["og:description"]
+	if p.Description == "" {
+		p.Description = meta["description"]
+	}
+	p.SiteName = meta["og:site_name"]
+
+	icon := "/favicon.ico"
+	for _, tag := range linkTagRe.FindAllString(page, -1) {
+		attrs := tagAttrs(tag)
+		rels := strings.Fields(strings.ToLower(attrs["rel"]))
+		if attrs["href"] != "" && slices.Contains(rels, "icon") {
+			icon = attrs["href"]
+			break
+		}
+	}
+	if ref, err := url.Parse(icon); err == nil {
+		if abs := pageURL.ResolveReference(ref); abs.Scheme == "http" || abs.Scheme == "https" {
+			p.Favicon = abs.String()
+		}
+	}
+	return p
+}
+
+// handleUnfurl serves the preview of a URL, GET /api/unfurl?url=. It
+// answers 404 when previews are disabled and 403 for hosts not allowed.
+func handleUnfurl(w http.ResponseWriter, r *http.Request) {
+	if unfurler == nil {
+		writeError(w, "link previews are disabled", http.StatusNotFound)
+		return
+	}
+	p, err := unfurler.Unfurl(r.URL.Query().Get("url"), time.Now())
+	if errors.Is(err, ErrUnfurlDenied) {
+		writeMutationError(w, err)
+		return
+	}
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"cmp"
+	"database/sql"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Each user has a read marker per board: the HLC timestamp of the newest
+// change they have seen. When they open the board, the cards changed in the
+// patch log after their marker get a badge, and the marker moves to the
+// newest change. Leaving the page moves it again, so the user's own edits
+// are not reported back to them. Like visits, markers are per node, and,
+// like the history, only cover the retained patches.
+
+// hlcStamp is a patch log timestamp, an HLC as "wall:logical:node".
+type hlcStamp struct {
+	wall, logical int64
+	node          string
+}
+
+// parseHLC parses a patch log timestamp.
+func parseHLC(ts string) (hlcStamp, bool) {
+	parts := strings.SplitN(ts, ":", 3)
+	if len(parts) != 3 {
+		return hlcStamp{}, false
+	}
+	wall, err1 := strconv.ParseInt(parts[0], 10, 64)
+	logical, err2 := strconv.ParseInt(parts[1], 10, 64)
+	if err1 != nil || err2 != nil {
+		return hlcStamp{}, false
+	}
+	return hlcStamp{wall, logical, parts[2]}, true
+}
+
+func (a hlcStamp) compare(b hlcStamp) int {
+	return cmp.Or(cmp.Compare(a.wall, b.wall), cmp.Compare(a.logical, b.logical), strings.Compare(a.node, b.node))
+}
+
+// cardOfPath returns the card a patch path changes, if any.
+func cardOfPath(path string) (string, bool) {
+	rest, ok := strings.CutPrefix(path, "/Board/Cards/")
+	if !ok {
+		return "", false
+	}
+	id, _, _ := strings.Cut(rest, "/")
+	return id, id != ""
+}
+
+// ChangedSince returns the cards still on the board that changed after the
+// marker since, sorted, and the timestamp of the newest change. An empty
+// marker, a user's first visit, reports no cards.
+func (s *Store) ChangedSince(since string) (cards []string, latest string, err error) {
+	s.histMu.RLock()
+	defer s.histMu.RUnlock()
+
+	rows, err := s.db.Query("SELECT timestamp, patch FROM patches")
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	marker, hasMarker := parseHLC(since)
+	var newest hlcStamp
+	board := s.snap.Load().state.Board
+	changed := make(map[string]bool)
+	for rows.Next() {
+		var timestamp string
+		var patch []byte
+		if err := rows.Scan(&timestamp, &patch); err != nil {
+			return nil, "", err
+		}
+		ts, ok := parseHLC(timestamp)
+		if !ok {
+			continue
+		}
+		if ts.compare(newest) > 0 {
+			newest, latest = ts, timestamp
+		}
+		if !hasMarker || ts.compare(marker) <= 0 {
+			continue
+		}
+		for _, p := range parseDeltaPaths(patch) {
+			if id, ok := cardOfPath(p); ok {
+				if _, exists := board.Cards[id]; exists {
+					changed[id] = true
+				}
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+	return slices.Sorted(maps.Keys(changed)), cmp.Or(latest, since), nil
+}
+
+// LastSeen returns user's read marker, "" if they never opened the board.
+func (s *Store) LastSeen(user string) (string, error) {
+	var seen string
+	err := s.db.QueryRow("SELECT last_seen FROM board_users WHERE user = ?", user).Scan(&seen)
+	if err == sql.ErrNoRows {
+		err = nil
+	}
+	return seen, err
+}
+
+// MarkSeen moves user's read marker to timestamp.
+func (s *Store) MarkSeen(user, timestamp string) error {
+	_, err := s.db.Exec(`INSERT INTO board_users (user, last_seen) VALUES (?, ?)
+		ON CONFLICT (user) DO UPDATE SET last_seen = excluded.last_seen`, user, timestamp)
+	return err
+}
+
+// Unseen returns the cards that changed since user last looked, and moves
+// their marker to the newest change.
+func (s *Store) Unseen(user string) ([]string, error) {
+	since, err := s.LastSeen(user)
+	if err != nil {
+		return nil, err
+	}
+	cards, latest, err := s.ChangedSince(since)
+	if err != nil {
+		return nil, err
+	}
+	if latest == "" {
+		latest = "0:0:" // seen, before any change
+	}
+	if latest != since {
+		if err := s.MarkSeen(user, latest); err != nil {
+			return nil, err
+		}
+	}
+	return cards, nil
+}
+
+// handleSeen moves the viewer's read marker to the newest change, POST
+// /api/seen. Pages send it when they are left.
+func handleSeen(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		user := homeUser(r)
+		_, latest, err := s.ChangedSince("")
+		if err == nil && latest != "" {
+			err = s.MarkSeen(user, latest)
+		}
+		if err != nil {
+			log.Printf("Failed to mark board seen by %s: %v", user, err)
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Cards are assigned to people. The board keeps a registry of them, each
+// with an ID, the name shown for them and a color, replicated like the rest
+// of the board so every node offers the same people to assign. Signed-in
+// users are registered the first time they open the board, with their
+// presence color; others, such as the people of a demo, are registered with
+// /api/users. A card's assignee is whoever it names, usually the ID of a
+// registered user, and is kept when the user is removed. Assignments are
+// made with an "assign" WebSocket message or POST /api/cards/assign, and
+// /api/cards?assignee=ada lists the cards of one person.
+
+var (
+	// ErrBadUser is returned for a user without an ID, with "/" or "~" in
+	// it, or with a color that is not #rgb or #rrggbb.
+	ErrBadUser = errors.New("a user needs an ID without / or ~, and a color as #rgb or #rrggbb if any")
+	// ErrUserNotFound is returned for a user the board does not know.
+	ErrUserNotFound = errors.New("user not found")
+)
+
+// BoardUser is a person cards can be assigned to.
+type BoardUser struct {
+	ID    string `deep:"key" json:"id"`
+	Name  string `json:"name"`  // shown for them, their ID if not given
+	Color string `json:"color"` // their presence color by default, see colors.go
+}
+
+// AssignOp assigns a card in an "assign" request, or unassigns it if
+// Assignee is "".
+type AssignOp struct {
+	CardID   string `json:"cardId"`
+	Assignee string `json:"assignee"`
+}
+
+// findUser returns the index of the user with ID id in b, or -1.
+func findUser(b Board, id string) int {
+	return slices.IndexFunc(b.Users, func(u BoardUser) bool { return u.ID == id })
+}
+
+// AddUser registers user id, shown as name, or changes the name and color
+// of a registered one. A new user without a color gets the one they have, or
+// would have, in presence.
+func (s *Store) AddUser(id, name, color string) error {
+	id, name = strings.TrimSpace(id), strings.TrimSpace(name)
+	if id == "" || strings.ContainsAny(id, "/~") || color != "" && !labelColorPattern.MatchString(color) {
+		return ErrBadUser
+	}
+	if name == "" {
+		name = id
+	}
+	return s.mutate(func(bs *BoardState) {
+		if i := findUser(bs.Board, id); i >= 0 {
+			bs.Board.Users[i].Name = name
+			if color != "" {
+				bs.Board.Users[i].Color = color
+			}
+			return
+		}
+		if color == "" {
+			color = bs.Board.assignColor(name)
+		}
+		bs.Board.Users = append(bs.Board.Users, BoardUser{ID: id, Name: name, Color: color})
+	})
+}
+
+// RemoveUser removes user id from the registry. Their cards stay assigned
+// to them.
+func (s *Store) RemoveUser(id string) error {
+	return s.tryMutate(func(bs *BoardState) error {
+		i := findUser(bs.Board, id)
+		if i < 0 {
+			return ErrUserNotFound
+		}
+		bs.Board.Users = slices.Delete(bs.Board.Users, i, i+1)
+		return nil
+	})
+}
+
+// registerViewer registers the signed-in user u the first time they open
+// the board. Bots are not registered.
+func (s *Store) registerViewer(u *User) {
+	if u == nil || u.Bot || findUser(s.snap.Load().state.Board, u.ID) >= 0 {
+		return
+	}
+	err := s.AddUser(u.ID, presenceName(u), "")
+	if err != nil && err != ErrReadOnly && err != ErrBoardFrozen {
+		log.Printf("Failed to register user %s: %v", u.ID, err)
+	}
+}
+
+// handleUsers serves /api/users: GET lists the people cards can be assigned
+// to, POST {"id": "ada", "name": "Ada Lovelace", "color": "#3498db"}
+// registers one or changes their name and color, and DELETE ?id=ada removes
+// one.
+func handleUsers(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			users := s.GetBoard().Board.Users
+			if users == nil {
+				users = []BoardUser{}
+			}
+			w.Header().Set("Cache-Control", "no-store")
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(users)
+			return
+		case http.MethodPost, http.MethodDelete:
+		default:
+			writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !canEditCards(w, r) {
+			return
+		}
+		var err error
+		if r.Method == http.MethodDelete {
+			err = s.RemoveUser(r.URL.Query().Get("id"))
+		} else {
+			var user BoardUser
+			if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
+				writeError(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			err = s.AddUser(user.ID, user.Name, user.Color)
+		}
+		if err != nil {
+			writeMutationError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// handleCardAssign assigns a card, POST /api/cards/assign with {"cardId":
+// "...", "assignee": "ada"}, or unassigns it with "assignee": "".
+func handleCardAssign(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var op AssignOp
+		if err := json.NewDecoder(r.Body).Decode(&op); err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := s.AssignCard(op.CardID, op.Assignee); err != nil {
+			writeMutationError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// assignedTo reports whether card c is assigned to assignee, "me" for the
+// viewer known by handles, or is unassigned if assignee is "none".
+func assignedTo(c CardInfo, assignee string, handles []string) bool {
+	switch want := strings.ToLower(assignee); want {
+	case "none":
+		return c.Assignee == ""
+	case "me":
+		return slices.Contains(handles, strings.ToLower(c.Assignee))
+	default:
+		return strings.ToLower(c.Assignee) == want
+	}
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brunoga/deep/v5"
+	"github.com/brunoga/deep/v5/crdt"
+	"github.com/brunoga/deep/v5/crdt/hlc"
+)
+
+// maxDescriptionVersions caps how many versions of a description are
+// returned, newest first.
+const maxDescriptionVersions = 50
+
+// ErrCardNotFound is returned when an operation names an unknown card.
+var ErrCardNotFound = errors.New("card not found")
+
+// DescriptionVersion is a card description as it was after one change in
+// the patch log. Diff is against the previous version listed.
+type DescriptionVersion struct {
+	Patch int64      `json:"patch"` // id of the change in the patch log
+	Time  int64      `json:"time"`  // unix milliseconds of the change
+	Text  string     `json:"text"`
+	Diff  []DiffLine `json:"diff"`
+}
+
+// DescriptionVersions rebuilds the versions of a card's description by
+// replaying, from the patch log, the operations that touched it. If the card
+// predates the oldest retained patch (history was cleared or pruned), the
+// earliest versions are missing what came before.
+func (s *Store) DescriptionVersions(cardID string) ([]DescriptionVersion, error) {
+	s.histMu.RLock()
+	defer s.histMu.RUnlock()
+
+	rows, err := s.db.Query("SELECT id, patch FROM patches ORDER BY id ASC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cardPath := "/Board/Cards/" + cardID
+	var desc crdt.Text
+	var versions []DescriptionVersion
+	for rows.Next() {
+		var id int64
+		var data []byte
+		if err := rows.Scan(&id, &data); err != nil {
+			return nil, err
+		}
+		if !touchesPath(parseDeltaPaths(data), cardPath) {
+			continue
+		}
+		var delta struct {
+			P struct {
+				Ops []descriptionOp `json:"ops"`
+			} `json:"p"`
+			T hlc.HLC `json:"t"`
+		}
+		if err := json.Unmarshal(data, &delta); err != nil {
+			continue
+		}
+		for _, op := range delta.P.Ops {
+			if op.Path == cardPath || strings.HasPrefix(op.Path, cardPath+"/") {
+				desc = op.apply(desc, strings.TrimPrefix(op.Path, cardPath))
+			}
+		}
+		text := textString(desc)
+		var prev string
+		if n := len(versions); n > 0 {
+			prev = versions[n-1].Text
+		}
+		if text == prev {
+			continue
+		}
+		versions = append(versions, DescriptionVersion{
+			Patch: id,
+			Time:  delta.T.WallTime / int64(time.Millisecond),
+			Text:  text,
+			Diff:  diffLines(prev, text),
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Newest first, capped.
+	out := make([]DescriptionVersion, 0, min(len(versions), maxDescriptionVersions))
+	for i := len(versions) - 1; i >= 0 && len(out) < maxDescriptionVersions; i-- {
+		out = append(out, versions[i])
+	}
+	return out, nil
+}
+
+// descriptionOp is a patch operation as stored in the patch log. Operations
+// on single text runs address them by ID, which deep.Apply cannot resolve
+// once the patch went through JSON, so DescriptionVersions applies them
+// itself.
+type descriptionOp struct {
+	Kind  deep.OpKind     `json:"k"`
+	Path  string          `json:"p"`
+	Value json.RawMessage `json:"n"`
+}
+
+// apply applies op to desc, the description of the card op belongs to. rel
+// is the path of op below the card.
+func (op descriptionOp) apply(desc crdt.Text, rel string) crdt.Text {
+	switch {
+	case rel == "":
+		var card Card
+		if op.Kind == deep.OpRemove || json.Unmarshal(op.Value, &card) != nil {
+			return nil
+		}
+		return card.Description
+	case rel == "/Description":
+		var text crdt.Text
+		if op.Kind == deep.OpRemove || json.Unmarshal(op.Value, &text) != nil {
+			return nil
+		}
+		return crdt.MergeTextRuns(desc, text)
+	case !strings.HasPrefix(rel, "/Description/"):
+		return desc
+	}
+
+	key, field, _ := strings.Cut(strings.TrimPrefix(rel, "/Description/"), "/")
+	i := slices.IndexFunc(desc, func(run crdt.TextRun) bool { return run.ID.String() == key })
+	desc = slices.Clone(desc)
+	switch {
+	case op.Kind == deep.OpRemove && field == "":
+		if i >= 0 {
+			desc = slices.Delete(desc, i, i+1)
+		}
+	case field == "":
+		var run crdt.TextRun
+		if json.Unmarshal(op.Value, &run) != nil {
+			break
+		}
+		if i >= 0 {
+			desc[i] = run
+		} else {
+			desc = append(desc, run)
+		}
+	case i >= 0:
+		switch field {
+		case "Value":
+			json.Unmarshal(op.Value, &desc[i].Value)
+		case "Prev":
+			json.Unmarshal(op.Value, &desc[i].Prev)
+		case "Deleted":
+			json.Unmarshal(op.Value, &desc[i].Deleted)
+		}
+	}
+	return desc
+}
+
+// touchesPath reports whether any of paths is prefix or lies below it.
+func touchesPath(paths []string, prefix string) bool {
+	for _, p := range paths {
+		if p == prefix || strings.HasPrefix(p, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// RestoreDescription replaces a card's description with text. The restore
+// is an ordinary edit: it replicates and shows up as a new version.
+func (s *Store) RestoreDescription(cardID, text string) error {
+	found := false
+	err := s.tryMutate(func(bs *BoardState) error {
+		card, ok := bs.Board.Cards[cardID]
+		if !ok {
+			return nil
+		}
+		if err := s.quota.checkDescription(len(text)); err != nil {
+			return err
+		}
+		if err := s.checkGrowth(len(text)); err != nil {
+			return err
+		}
+		found = true
+		desc := textDelete(card.Description, 0, textLen(card.Description))
+		card.Description = textInsert(desc, 0, text, s.crdt.Clock())
+		bs.Board.Cards[cardID] = card
+		s.stage(Event{Type: EventCardUpdated, CardID: cardID})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if !found {
+		return ErrCardNotFound
+	}
+	return nil
+}
+
+// DiffLine is one line of a line diff: Op is " " (unchanged), "-" (removed)
+// or "+" (added).
+type DiffLine struct {
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+// maxDiffCells bounds the work of diffLines. Larger diffs are reported as
+// everything removed and re-added.
+const maxDiffCells = 4_000_000
+
+// diffLines returns a line diff from a to b, based on their longest common
+// subsequence of lines.
+func diffLines(a, b string) []DiffLine {
+	var al, bl []string
+	if a != "" {
+		al = strings.Split(a, "\n")
+	}
+	if b != "" {
+		bl = strings.Split(b, "\n")
+	}
+	diff := []DiffLine{}
+	if len(al)*len(bl) > maxDiffCells {
+		for _, l := range al {
+			diff = append(diff, DiffLine{"-", l})
+		}
+		for _, l := range bl {
+			diff = append(diff, DiffLine{"+", l})
+		}
+		return diff
+	}
+
+	// lcs[i][j] is the LCS length of al[i:] and bl[j:].
+	lcs := make([][]int, len(al)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(bl)+1)
+	}
+	for i := len(al) - 1; i >= 0; i-- {
+		for j := len(bl) - 1; j >= 0; j-- {
+			if al[i] == bl[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(al) && j < len(bl) {
+		switch {
+		case al[i] == bl[j]:
+			diff = append(diff, DiffLine{" ", al[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			diff = append(diff, DiffLine{"-", al[i]})
+			i++
+		default:
+			diff = append(diff, DiffLine{"+", bl[j]})
+			j++
+		}
+	}
+	for ; i < len(al); i++ {
+		diff = append(diff, DiffLine{"-", al[i]})
+	}
+	for ; j < len(bl); j++ {
+		diff = append(diff, DiffLine{"+", bl[j]})
+	}
+	return diff
+}
+
+// handleDescriptionVersions serves GET /api/cards/versions?card=<id>.
+func handleDescriptionVersions(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cardID := r.URL.Query().Get("card")
+		if _, ok := s.GetBoard().Board.Cards[cardID]; !ok {
+			writeError(w, ErrCardNotFound.Error(), http.StatusNotFound)
+			return
+		}
+		versions, err := s.DescriptionVersions(cardID)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(versions)
+	}
+}
+
+// handleRestoreDescription serves POST /api/cards/restore with the card and
+// the patch id of the version to restore.
+func handleRestoreDescription(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		cardID := r.FormValue("card")
+		patch, err := strconv.ParseInt(r.FormValue("patch"), 10, 64)
+		if err != nil {
+			writeError(w, "invalid patch", http.StatusBadRequest)
+			return
+		}
+		versions, err := s.DescriptionVersions(cardID)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for _, v := range versions {
+			if v.Patch != patch {
+				continue
+			}
+			if err := s.RestoreDescription(cardID, v.Text); err != nil {
+				writeMutationError(w, err)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		writeError(w, "version not found", http.StatusNotFound)
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// A view is a named filter a user saved, such as "my urgent bugs". Views
+// live in the CRDT, so they follow their owner to every node, and each user
+// only sees their own. The board renderer applies one with /board?view=<id>.
+
+// ErrViewNotFound is returned for an unknown view, or another user's.
+var ErrViewNotFound = errors.New("view not found")
+
+// View sort orders. The default keeps the board's order.
+const (
+	ViewSortPriority = "priority"
+	ViewSortVotes    = "votes"
+	ViewSortTitle    = "title"
+	ViewSortNewest   = "newest"
+)
+
+// ViewFilter selects and orders the cards a view shows. Empty fields match
+// every card.
+type ViewFilter struct {
+	Text       string   `json:"text,omitempty"`       // in the title, case-insensitive
+	Assignee   string   `json:"assignee,omitempty"`   // "me" for the viewer
+	Labels     []string `json:"labels,omitempty"`     // any of them
+	Priorities []string `json:"priorities,omitempty"` // any of them
+	Sort       string   `json:"sort,omitempty"`
+}
+
+// BoardView is a filter saved by Owner under Name.
+type BoardView struct {
+	ID     string     `deep:"key" json:"id"`
+	Name   string     `json:"name"`
+	Owner  string     `json:"owner"`
+	Filter ViewFilter `json:"filter"`
+}
+
+// priorityRank orders priorities, most urgent first. Names are matched
+// case-insensitively; unknown and missing priorities come last.
+func priorityRank(p string) int {
+	switch strings.ToLower(p) {
+	case "highest", "critical", "urgent", "blocker":
+		return 0
+	case "high":
+		return 1
+	case "medium", "normal":
+		return 2
+	case "low":
+		return 3
+	case "lowest", "trivial":
+		return 4
+	}
+	return 5
+}
+
+// matches reports whether c passes f for a viewer known by handles.
+func (f ViewFilter) matches(c Card, handles []string) bool {
+	if f.Text != "" && !strings.Contains(strings.ToLower(c.Title), strings.ToLower(f.Text)) {
+		return false
+	}
+	if f.Assignee != "" {
+		want := []string{strings.ToLower(f.Assignee)}
+		if want[0] == "me" {
+			want = handles
+		}
+		if !slices.Contains(want, strings.ToLower(c.Assignee)) {
+			return false
+		}
+	}
+	if len(f.Labels) > 0 && !slices.ContainsFunc(c.Labels.Names(), func(l string) bool {
+		return slices.ContainsFunc(f.Labels, func(w string) bool { return strings.EqualFold(l, w) })
+	}) {
+		return false
+	}
+	if len(f.Priorities) > 0 && !slices.ContainsFunc(f.Priorities, func(p string) bool { return strings.EqualFold(p, c.Priority) }) {
+		return false
+	}
+	return true
+}
+
+// viewOrder returns how f orders cards, nil for the board's order.
+func viewOrder(sort string) func(a, b Card) int {
+	switch sort {
+	case ViewSortPriority:
+		return func(a, b Card) int { return cmp.Compare(priorityRank(a.Priority), priorityRank(b.Priority)) }
+	case ViewSortVotes:
+		return func(a, b Card) int { return cmp.Compare(len(b.Votes), len(a.Votes)) }
+	case ViewSortTitle:
+		return func(a, b Card) int { return strings.Compare(strings.ToLower(a.Title), strings.ToLower(b.Title)) }
+	case ViewSortNewest:
+		return func(a, b Card) int { return cmp.Compare(b.EnteredAt, a.EnteredAt) }
+	}
+	return nil
+}
+
+// applyView filters and sorts the cards of cols, in board order, in place.
+func applyView(cols []UIColumn, f ViewFilter, handles []string) []UIColumn {
+	order := viewOrder(f.Sort)
+	for i := range cols {
+		cols[i].Cards = slices.DeleteFunc(cols[i].Cards, func(c Card) bool { return !f.matches(c, handles) })
+		if order != nil {
+			slices.SortStableFunc(cols[i].Cards, order)
+		}
+	}
+	return cols
+}
+
+// validViewSort reports whether sort is a known order.
+func validViewSort(sort string) bool {
+	return sort == "" || viewOrder(sort) != nil
+}
+
+// SaveView saves f as owner's view called name, replacing their view of the
+// same name, and returns its ID.
+func (s *Store) SaveView(owner, name string, f ViewFilter) (string, error) {
+	var id string
+	err := s.mutate(func(bs *BoardState) {
+		i := slices.IndexFunc(bs.Board.Views, func(v BoardView) bool { return v.Owner == owner && v.Name == name })
+		if i >= 0 {
+			id = bs.Board.Views[i].ID
+			bs.Board.Views[i].Filter = f
+			return
+		}
+		id = uuid.New().String()
+		bs.Board.Views = append(bs.Board.Views, BoardView{ID: id, Name: name, Owner: owner, Filter: f})
+	})
+	return id, err
+}
+
+// DeleteView deletes owner's view id.
+func (s *Store) DeleteView(owner, id string) error {
+	return s.tryMutate(func(bs *BoardState) error {
+		i := slices.IndexFunc(bs.Board.Views, func(v BoardView) bool { return v.ID == id && v.Owner == owner })
+		if i < 0 {
+			return ErrViewNotFound
+		}
+		bs.Board.Views = slices.Delete(bs.Board.Views, i, i+1)
+		return nil
+	})
+}
+
+// userView returns owner's view id.
+func userView(board Board, owner, id string) (BoardView, bool) {
+	i := slices.IndexFunc(board.Views, func(v BoardView) bool { return v.ID == id && v.Owner == owner })
+	if i < 0 {
+		return BoardView{}, false
+	}
+	return board.Views[i], true
+}
+
+// viewerHandles returns the names a request's user is assigned cards by.
+func viewerHandles(r *http.Request) []string {
+	if u := currentUser(r); u != nil {
+		return userHandles(u)
+	}
+	return nil
+}
+
+// handleViews manages the viewer's views: GET lists them, POST {"name":
+// "my urgent bugs", "filter": {...}} saves one and DELETE ?id= deletes one.
+func handleViews(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		owner := homeUser(r)
+		switch r.Method {
+		case http.MethodGet:
+			views := []BoardView{}
+			for _, v := range s.GetBoard().Board.Views {
+				if v.Owner == owner {
+					views = append(views, v)
+				}
+			}
+			slices.SortFunc(views, func(a, b BoardView) int { return strings.Compare(a.Name, b.Name) })
+			w.Header().Set("Cache-Control", "no-store")
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(views)
+		case http.MethodPost:
+			var req struct {
+				Name   string     `json:"name"`
+				Filter ViewFilter `json:"filter"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeError(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if req.Name == "" || !validViewSort(req.Filter.Sort) {
+				writeError(w, "view needs a name and a known sort", http.StatusBadRequest)
+				return
+			}
+			id, err := s.SaveView(owner, req.Name, req.Filter)
+			if err != nil {
+				writeMutationError(w, err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(struct {
+				ID string `json:"id"`
+			}{id})
+		case http.MethodDelete:
+			if err := s.DeleteView(owner, r.URL.Query().Get("id")); err != nil {
+				writeMutationError(w, err)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		default:
+			writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"slices"
+	"time"
+)
+
+// ErrNoVoter is returned for a vote that names no voter.
+var ErrNoVoter = errors.New("vote needs a voter")
+
+// Vote is one user's vote for a card. Votes are keyed by voter, so votes
+// cast concurrently on different nodes all survive the merge, and a user
+// voting twice, even from two nodes at once, still counts once.
+type Vote struct {
+	Voter string `deep:"key" json:"voter"`
+	Time  int64  `json:"time"`
+}
+
+// VoteOp casts (Up) or withdraws a client's vote for a card. Signed-in users
+// vote as themselves; with sign-in off, Voter is an ID the browser picked.
+type VoteOp struct {
+	CardID string `json:"cardId"`
+	Up     bool   `json:"up"`
+	Voter  string `json:"voter,omitempty"`
+}
+
+// voterID returns who votes: the signed-in user, or else the anonymous
+// browser named client.
+func voterID(u *User, client string) string {
+	if u != nil {
+		return u.ID
+	}
+	if client == "" {
+		return ""
+	}
+	return "anon:" + client
+}
+
+// Vote casts voter's vote for a card, or withdraws it. Voting again, or
+// withdrawing a vote that was not cast, changes nothing.
+func (s *Store) Vote(cardID, voter string, up bool) error {
+	if voter == "" {
+		return ErrNoVoter
+	}
+	return s.tryMutate(func(bs *BoardState) error {
+		card, ok := bs.Board.Cards[cardID]
+		if !ok {
+			return ErrCardNotFound
+		}
+		i := slices.IndexFunc(card.Votes, func(v Vote) bool { return v.Voter == voter })
+		switch {
+		case up && i < 0:
+			card.Votes = append(card.Votes, Vote{Voter: voter, Time: time.Now().Unix()})
+		case !up && i >= 0:
+			card.Votes = slices.Delete(card.Votes, i, i+1)
+		default:
+			return nil
+		}
+		bs.Board.Cards[cardID] = card
+		return nil
+	})
+}
+
+// votersJSON lists the voters of a card as JSON, for the page to tell which
+// cards the viewer voted for.
+func votersJSON(votes []Vote) string {
+	if len(votes) == 0 {
+		return "[]"
+	}
+	voters := make([]string, len(votes))
+	for i, v := range votes {
+		voters[i] = v.Voter
+	}
+	data, _ := json.Marshal(voters)
+	return string(data)
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// The wallboard is a read-only rendering of the board for hallway screens
+// and e-ink displays: black on white, no scripts, refreshed by the page
+// itself. /wallboard.png draws the same content server-side for devices
+// that can only show an image.
+
+// Wallboard defaults and bounds.
+const (
+	wallboardRefresh    = 60 // seconds
+	wallboardMinRefresh = 10
+	wallboardCards      = 12 // cards listed per column
+	wallboardMaxSize    = 4096
+	wallboardMaxScale   = 4
+)
+
+// WallboardColumn is a column as the wallboard shows it: its first cards
+// and how many more it holds.
+type WallboardColumn struct {
+	Title string
+	Count int
+	Cards []string
+	More  int
+}
+
+// wallboardColumns returns the columns of state with up to limit cards
+// each, as "DB-42 Title". Sealed titles of encrypted boards are not shown.
+func wallboardColumns(state BoardState, limit int) []WallboardColumn {
+	var cols []WallboardColumn
+	for _, col := range buildUIColumns(state) {
+		wc := WallboardColumn{Title: col.Title, Count: len(col.Cards)}
+		for i, c := range col.Cards {
+			if i == limit {
+				wc.More = len(col.Cards) - limit
+				break
+			}
+			title := c.Title
+			if strings.HasPrefix(title, e2ePrefix) {
+				title = "(encrypted)"
+			}
+			if key := cardKey(c.Number); key != "" {
+				title = key + " " + title
+			}
+			wc.Cards = append(wc.Cards, title)
+		}
+		cols = append(cols, wc)
+	}
+	return cols
+}
+
+// queryInt returns the integer query parameter name of r clamped to
+// [lo, hi], or def if it is missing or malformed.
+func queryInt(r *http.Request, name string, def, lo, hi int) int {
+	n, err := strconv.Atoi(r.URL.Query().Get(name))
+	if err != nil {
+		return def
+	}
+	return min(max(n, lo), hi)
+}
+
+// handleWallboard serves GET /wallboard, reloading every ?refresh= seconds.
+func handleWallboard(s *Store) http.HandlerFunc {
+	tmpl := template.Must(template.New("wallboard").Parse(wallboardHTML))
+	return func(w http.ResponseWriter, r *http.Request) {
+		state := s.GetBoard()
+		w.Header().Set("Cache-Control", "no-store")
+		tmpl.Execute(w, struct {
+			Title   string
+			Refresh int
+			Updated string
+			Columns []WallboardColumn
+		}{
+			Title:   state.Board.Title,
+			Refresh: queryInt(r, "refresh", wallboardRefresh, wallboardMinRefresh, 24*3600),
+			Updated: time.Now().Format("15:04"),
+			Columns: wallboardColumns(state, queryInt(r, "cards", wallboardCards, 1, 100)),
+		})
+	}
+}
+
+// handleWallboardPNG serves GET /wallboard.png, a grayscale image of the
+// wallboard sized for the display with ?width= and ?height= (800x480 by
+// default) and magnified ?scale= times for legibility.
+func handleWallboardPNG(s *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		width := queryInt(r, "width", 800, 100, wallboardMaxSize)
+		height := queryInt(r, "height", 480, 100, wallboardMaxSize)
+		scale := queryInt(r, "scale", 2, 1, wallboardMaxScale)
+		state := s.GetBoard()
+		img := renderWallboard(state.Board.Title, wallboardColumns(state, wallboardCards), width/scale, height/scale)
+		if scale > 1 {
+			img = magnify(img, scale)
+		}
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(buf.Bytes())
+	}
+}
+
+// renderWallboard draws the board title over one box per column, listing as
+// many cards as fit, in black on white with a 7x13 bitmap font.
+func renderWallboard(title string, cols []WallboardColumn, width, height int) *image.Gray {
+	const lineHeight, pad = 15, 4
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+	text := func(s string, x, y, maxWidth int) {
+		d := font.Drawer{Dst: img, Src: image.Black, Face: basicfont.Face7x13, Dot: fixed.P(x, y)}
+		if d.MeasureString(s).Ceil() > maxWidth {
+			runes := []rune(s)
+			s = string(runes[:min(max(maxWidth/basicfont.Face7x13.Advance-1, 0), len(runes))]) + "~"
+		}
+		d.DrawString(s)
+	}
+	text(title, pad, lineHeight-2, width-2*pad)
+	top := lineHeight + pad
+	fill(img, image.Rect(0, top-2, width, top), color.Black)
+	if len(cols) == 0 {
+		return img
+	}
+	colWidth := width / len(cols)
+	for i, col := range cols {
+		x := i * colWidth
+		if i > 0 {
+			fill(img, image.Rect(x, top, x+1, height), color.Black)
+		}
+		inner := colWidth - 2*pad
+		text(col.Title+" ("+strconv.Itoa(col.Count)+")", x+pad, top+lineHeight, inner)
+		fill(img, image.Rect(x+pad, top+lineHeight+3, x+colWidth-pad, top+lineHeight+4), color.Black)
+		y := top + 2*lineHeight + pad
+		shown := 0
+		for _, card := range col.Cards {
+			if y+lineHeight > height { // keep the last line for the count
+				break
+			}
+			text(card, x+pad, y, inner)
+			y += lineHeight
+			shown++
+		}
+		if rest := col.Count - shown; rest > 0 && y <= height {
+			text("+"+strconv.Itoa(rest)+" more", x+pad, y, inner)
+		}
+	}
+	return img
+}
+
+func fill(img *image.Gray, r image.Rectangle, c color.Color) {
+	draw.Draw(img, r, image.NewUniform(c), image.Point{}, draw.Src)
+}
+
+// magnify scales img up by an integer factor, keeping pixels sharp.
+func magnify(img *image.Gray, factor int) *image.Gray {
+	b := img.Bounds()
+	out := image.NewGray(image.Rect(0, 0, b.Dx()*factor, b.Dy()*factor))
+	for y := range out.Rect.Dy() {
+		src := img.Pix[(y/factor)*img.Stride:]
+		dst := out.Pix[y*out.Stride:]
+		for x := range out.Rect.Dx() {
+			dst[x] = src[x/factor]
+		}
+	}
+	return out
+}
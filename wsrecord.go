@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// With -ws-record, every message browsers send over the board WebSockets is
+// appended to a file with the time it arrived. "deepboard replay" sends a
+// recording back to a node, one connection per recorded connection, at the
+// original pace or faster. Replayed against a copy of the database taken
+// when recording started, it reproduces a session's edits; against a fresh
+// node, it is realistic load.
+
+// RecordedOp is one message of a recording, a line of JSON in the file.
+type RecordedOp struct {
+	Time  int64           `json:"t"`     // Unix milliseconds
+	Board string          `json:"board"` // board key, "default" for the root board
+	Conn  string          `json:"conn"`  // connection the message arrived on
+	Op    json.RawMessage `json:"op,omitempty"`
+	Raw   string          `json:"raw,omitempty"` // the message, if it was not JSON
+}
+
+// wsRecording appends the messages of every board to one file.
+type wsRecording struct {
+	mu  sync.Mutex
+	f   *os.File
+	enc *json.Encoder
+}
+
+// openWSRecording opens path for recording, appending to it if it exists.
+func openWSRecording(path string) (*wsRecording, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &wsRecording{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+// record appends a message that arrived on conn of board.
+func (rec *wsRecording) record(board, conn string, data []byte) {
+	op := RecordedOp{Time: time.Now().UnixMilli(), Board: board, Conn: conn}
+	if json.Valid(data) {
+		op.Op = data
+	} else {
+		op.Raw = string(data)
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if err := rec.enc.Encode(op); err != nil {
+		log.Printf("Failed to record WebSocket message: %v", err)
+	}
+}
+
+func (rec *wsRecording) Close() error {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return rec.f.Close()
+}
+
+// SetWSRecording records the messages clients send to this board to rec. It
+// must be called before serving requests.
+func (s *Store) SetWSRecording(rec *wsRecording) {
+	s.wsRecording = rec
+}
+
+// readWSRecording reads the recording at path.
+func readWSRecording(path string) ([]RecordedOp, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var ops []RecordedOp
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1024*1024)
+	for line := 1; sc.Scan(); line++ {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var op RecordedOp
+		if err := json.Unmarshal(sc.Bytes(), &op); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		ops = append(ops, op)
+	}
+	return ops, sc.Err()
+}
+
+// replayWSURL returns the WebSocket URL of board on the node at base.
+func replayWSURL(base, board string) string {
+	base = "ws" + strings.TrimPrefix(strings.TrimSuffix(base, "/"), "http")
+	if board == defaultBoardKey {
+		return base + "/ws"
+	}
+	return base + tenantPathPrefix + board + "/ws"
+}
+
+// replayBarrier is the card a replayed connection asks about once it has
+// sent everything. The answer comes after the replies to all earlier
+// messages, so the connection can then be closed without losing any.
+const replayBarrier = "replay-end"
+
+// replayConn is one recorded connection being replayed.
+type replayConn struct {
+	conn     *websocket.Conn
+	rejected int // error replies, counted until done is closed
+	done     chan struct{}
+}
+
+// ReplayResult counts what a replay sent and what the node rejected.
+type ReplayResult struct {
+	Sent     int
+	Rejected int
+}
+
+// replayWS sends ops to the node at base, speed times faster than they were
+// recorded, or as fast as possible if speed is 0. Requests carry header.
+func replayWS(ops []RecordedOp, base string, speed float64, header http.Header) (result ReplayResult, err error) {
+	conns := map[string]*replayConn{}
+	defer func() {
+		for _, rc := range conns {
+			rc.conn.WriteJSON(WSMessage{Type: "presenceQuery", Presence: &PresenceOp{CardID: replayBarrier}})
+			select {
+			case <-rc.done:
+			case <-time.After(5 * time.Second):
+				rc.conn.Close()
+				<-rc.done
+			}
+			result.Rejected += rc.rejected
+		}
+	}()
+
+	start := time.Now()
+	for _, op := range ops {
+		if speed > 0 {
+			at := start.Add(time.Duration(float64(time.Duration(op.Time-ops[0].Time)*time.Millisecond) / speed))
+			time.Sleep(time.Until(at))
+		}
+		key := op.Board + "/" + op.Conn
+		rc, ok := conns[key]
+		if !ok {
+			conn, _, err := websocket.DefaultDialer.Dial(replayWSURL(base, op.Board), header)
+			if err != nil {
+				return result, fmt.Errorf("connecting to board %s: %w", op.Board, err)
+			}
+			rc = &replayConn{conn: conn, done: make(chan struct{})}
+			go rc.read()
+			conns[key] = rc
+		}
+		data := []byte(op.Op)
+		if op.Op == nil {
+			data = []byte(op.Raw)
+		}
+		if err := rc.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+			return result, err
+		}
+		result.Sent++
+	}
+	return result, nil
+}
+
+// read counts the error replies to a replayed connection until the answer
+// to the barrier, then closes it.
+func (rc *replayConn) read() {
+	defer close(rc.done)
+	defer rc.conn.Close()
+	for {
+		var msg WSMessage
+		if err := rc.conn.ReadJSON(&msg); err != nil {
+			return
+		}
+		switch {
+		case msg.Type == "error" && msg.Error != nil:
+			rc.rejected++
+			log.Printf("Replay: rejected: %s", msg.Error.Message)
+		case msg.Type == "presence" && msg.Presence != nil && msg.Presence.CardID == replayBarrier:
+			rc.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+			return
+		}
+	}
+}
+
+// runReplay is "deepboard replay": it replays a recording against a node.
+func runReplay(args []string) {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	url := fs.String("url", "http://localhost:8080", "base URL of the node to replay to")
+	speed := fs.Float64("speed", 1, "replay speed relative to the recording; 0 sends as fast as possible")
+	token := fs.String("token", "", "bot token to authenticate with, for nodes with login enabled")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: deepboard replay [flags] recording.jsonl")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 || *speed < 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	ops, err := readWSRecording(fs.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(ops) == 0 {
+		log.Fatal("replay: the recording is empty")
+	}
+	header := http.Header{}
+	if *token != "" {
+		header.Set("Authorization", "Bearer "+*token)
+	}
+	span := time.Duration(ops[len(ops)-1].Time-ops[0].Time) * time.Millisecond
+	fmt.Printf("Replaying %d messages recorded over %v to %s\n", len(ops), span.Round(time.Second), *url)
+	result, err := replayWS(ops, *url, *speed, header)
+	fmt.Printf("Sent %d messages, %d rejected\n", result.Sent, result.Rejected)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"net/http"
+	"slices"
+	"unicode/utf8"
+)
+
+// Every WebSocket message type has a JSON Schema. The server validates the
+// messages clients send against theirs before acting on them, and serves
+// all of them, including those of the messages it sends, at /api/ws-schema
+// for other clients to be built against. The schemas use a small subset of
+// JSON Schema, which validate implements.
+
+// ErrInvalidMessage is returned for a WebSocket message that does not match
+// the schema of its type.
+var ErrInvalidMessage = errors.New("invalid message")
+
+// SchemaError locates where a message breaks its schema.
+type SchemaError struct {
+	Path   string `json:"path"` // JSON pointer to the offending value
+	Reason string `json:"reason"`
+}
+
+func (e *SchemaError) Error() string {
+	return fmt.Sprintf("%v at %q: %s", ErrInvalidMessage, e.Path, e.Reason)
+}
+
+func (e *SchemaError) Unwrap() error { return ErrInvalidMessage }
+
+// JSONSchema is a JSON Schema, in the subset the messages need.
+type JSONSchema struct {
+	Description string                 `json:"description,omitempty"`
+	Type        string                 `json:"type,omitempty"`
+	Const       string                 `json:"const,omitempty"`
+	Enum        []string               `json:"enum,omitempty"`
+	MaxLength   int                    `json:"maxLength,omitempty"`
+	Minimum     *float64               `json:"minimum,omitempty"`
+	Properties  map[string]*JSONSchema `json:"properties,omitempty"`
+	Required    []string               `json:"required,omitempty"`
+	// Values is the schema of the values of maps. Objects without it take
+	// only their Properties.
+	Values *JSONSchema `json:"-"`
+	Items  *JSONSchema `json:"items,omitempty"`
+}
+
+// MarshalJSON writes additionalProperties, which is false unless the object
+// is a map.
+func (s *JSONSchema) MarshalJSON() ([]byte, error) {
+	type plain JSONSchema
+	out := struct {
+		*plain
+		AdditionalProperties any `json:"additionalProperties,omitempty"`
+	}{plain: (*plain)(s)}
+	if s.Type == "object" {
+		out.AdditionalProperties = false
+		if s.Values != nil {
+			out.AdditionalProperties = s.Values
+		}
+	}
+	return json.Marshal(out)
+}
+
+// validate reports where v, decoded from JSON, breaks s.
+func (s *JSONSchema) validate(v any, path string) error {
+	fail := func(format string, args ...any) error {
+		return &SchemaError{Path: path, Reason: fmt.Sprintf(format, args...)}
+	}
+	switch s.Type {
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			return fail("want a string")
+		}
+		if s.Const != "" && str != s.Const {
+			return fail("want %q", s.Const)
+		}
+		if s.Enum != nil && !slices.Contains(s.Enum, str) {
+			return fail("want one of %q", s.Enum)
+		}
+		if s.MaxLength > 0 && utf8.RuneCountInString(str) > s.MaxLength {
+			return fail("longer than %d characters", s.MaxLength)
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return fail("want a boolean")
+		}
+	case "integer":
+		n, ok := v.(float64)
+		if !ok || n != math.Trunc(n) {
+			return fail("want an integer")
+		}
+		if s.Minimum != nil && n < *s.Minimum {
+			return fail("less than %v", *s.Minimum)
+		}
+	case "array":
+		items, ok := v.([]any)
+		if !ok {
+			return fail("want an array")
+		}
+		for i, item := range items {
+			if err := s.Items.validate(item, fmt.Sprintf("%s/%d", path, i)); err != nil {
+				return err
+			}
+		}
+	case "object":
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return fail("want an object")
+		}
+		for _, name := range s.Required {
+			if _, ok := obj[name]; !ok {
+				return fail("missing %q", name)
+			}
+		}
+		for _, name := range slices.Sorted(maps.Keys(obj)) {
+			prop := s.Properties[name]
+			if prop == nil {
+				prop = s.Values
+			}
+			if prop == nil {
+				return fail("unknown property %q", name)
+			}
+			if err := prop.validate(obj[name], path+"/"+name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Schema building blocks.
+var (
+	nonNegative   = 0.0
+	cardIDSchema  = &JSONSchema{Type: "string", Description: "ID of the card"}
+	stringSchema  = &JSONSchema{Type: "string"}
+	boolSchema    = &JSONSchema{Type: "boolean"}
+	indexSchema   = &JSONSchema{Type: "integer", Minimum: &nonNegative}
+	stringsSchema = &JSONSchema{Type: "array", Items: stringSchema}
+	colorsSchema  = &JSONSchema{Type: "object", Values: stringSchema, Description: "color of each user named, as #rrggbb"}
+)
+
+// messageSchema returns the schema of messages of type typ with the given
+// payload properties, of which those named in required are required.
+func messageSchema(typ, description string, payload map[string]*JSONSchema, required ...string) *JSONSchema {
+	props := map[string]*JSONSchema{"type": {Type: "string", Const: typ}}
+	for name, s := range payload {
+		props[name] = s
+	}
+	return &JSONSchema{Type: "object", Description: description, Properties: props, Required: append([]string{"type"}, required...)}
+}
+
+// objectSchema returns the schema of an object with props.
+func objectSchema(props map[string]*JSONSchema, required ...string) *JSONSchema {
+	return &JSONSchema{Type: "object", Properties: props, Required: required}
+}
+
+var (
+	presenceQuery = objectSchema(map[string]*JSONSchema{"cardId": cardIDSchema}, "cardId")
+	deleteSchema  = objectSchema(map[string]*JSONSchema{"cardId": cardIDSchema}, "cardId")
+	moveSchema    = objectSchema(map[string]*JSONSchema{
+		"cardId":  cardIDSchema,
+		"from":    {Type: "string", Description: "column the card is moved from"},
+		"to":      {Type: "string", Description: "column the card is moved to"},
+		"toIndex": {Type: "integer", Minimum: &nonNegative, Description: "position in the target column; past the end appends"},
+	}, "cardId", "to")
+	textOpSchema = objectSchema(map[string]*JSONSchema{
+		"cardId": cardIDSchema,
+		"op":     {Type: "string", Enum: []string{"insert", "delete", "replace"}},
+		"pos":    indexSchema,
+		"val":    stringSchema,
+		"length": indexSchema,
+	}, "cardId", "op")
+)
+
+// wsClientSchemas are the schemas of the messages clients send, by type.
+var wsClientSchemas = map[string]*JSONSchema{
+	"move":       messageSchema("move", "Moves a card.", map[string]*JSONSchema{"move": moveSchema}, "move"),
+	"textOp":     messageSchema("textOp", "Edits a card description.", map[string]*JSONSchema{"textOp": textOpSchema}, "textOp"),
+	"titleOp":    messageSchema("titleOp", "Edits a card title as it is typed; concurrent edits of a title are not merged, the last one wins.", map[string]*JSONSchema{"titleOp": textOpSchema}, "titleOp"),
+	"delete":     messageSchema("delete", "Deletes a card; the sender is answered with a deleted message.", map[string]*JSONSchema{"delete": deleteSchema}, "delete"),
+	"undoDelete": messageSchema("undoDelete", "Restores a card deleted moments ago.", map[string]*JSONSchema{"delete": deleteSchema}, "delete"),
+	"due": messageSchema("due", "Sets a card's due date, \"\" to clear it.", map[string]*JSONSchema{"due": objectSchema(map[string]*JSONSchema{
+		"cardId": cardIDSchema,
+		"due":    {Type: "string", Description: "due date as 2006-01-02, \"\" for none"},
+	}, "cardId", "due")}, "due"),
+	"assign": messageSchema("assign", "Assigns a card, \"\" to unassign it.", map[string]*JSONSchema{"assign": objectSchema(map[string]*JSONSchema{
+		"cardId":   cardIDSchema,
+		"assignee": {Type: "string", Description: "usually the ID of a user of /api/users, \"\" for none"},
+	}, "cardId", "assignee")}, "assign"),
+	"checklist": messageSchema("checklist", "Adds, checks or unchecks, removes or moves an item of a card's checklist.", map[string]*JSONSchema{"checklist": objectSchema(map[string]*JSONSchema{
+		"cardId":  cardIDSchema,
+		"op":      {Type: "string", Enum: []string{"add", "toggle", "remove", "move"}},
+		"itemId":  {Type: "string", Description: "the item, for all but add"},
+		"text":    {Type: "string", Description: "of the item to add"},
+		"done":    boolSchema,
+		"toIndex": {Type: "integer", Minimum: &nonNegative, Description: "position to move the item to; past the end moves it last"},
+	}, "cardId", "op")}, "checklist"),
+	"vote": messageSchema("vote", "Votes for a card, or withdraws the vote.", map[string]*JSONSchema{"vote": objectSchema(map[string]*JSONSchema{
+		"cardId": cardIDSchema,
+		"up":     boolSchema,
+		"voter":  {Type: "string", Description: "ID of an anonymous browser; ignored when signed in"},
+	}, "cardId", "up")}, "vote"),
+	"poker": messageSchema("poker", "Acts on a card's estimation round.", map[string]*JSONSchema{"poker": objectSchema(map[string]*JSONSchema{
+		"cardId": cardIDSchema,
+		"action": {Type: "string", Enum: []string{"start", "estimate", "reveal", "cancel", "accept"}},
+		"value":  {Type: "string", MaxLength: maxEstimateLen},
+		"voter":  {Type: "string", Description: "ID of an anonymous browser; ignored when signed in"},
+	}, "cardId", "action")}, "poker"),
+	"column": messageSchema("column", "Changes the columns; admins only.", map[string]*JSONSchema{"column": objectSchema(map[string]*JSONSchema{
+		"op":       {Type: "string", Enum: []string{"add", "rename", "move", "delete"}},
+		"columnId": {Type: "string", Description: "column renamed, moved or deleted"},
+		"title":    {Type: "string", Description: "title of the column added or renamed"},
+		"index":    {Type: "integer", Minimum: &nonNegative, Description: "position a column is moved to; past the end puts it last"},
+		"into":     {Type: "string", Description: "column the cards of a deleted column move to"},
+	}, "op")}, "column"),
+	"heartbeat":     messageSchema("heartbeat", "Keeps the connection's presence alive.", nil),
+	"drift":         messageSchema("drift", "Reports that the page drifted from the board.", nil),
+	"active":        messageSchema("active", "Tells that the user is at the page, so they are not shown as away.", nil),
+	"editing":       messageSchema("editing", "Tells others which card's description the sender edits, \"\" for none.", map[string]*JSONSchema{"presence": presenceQuery}, "presence"),
+	"present":       messageSchema("present", "Presents a card to everyone, \"\" to stop.", map[string]*JSONSchema{"presence": presenceQuery}, "presence"),
+	"presenceQuery": messageSchema("presenceQuery", "Asks who else edits a card; answered with a presence message.", map[string]*JSONSchema{"presence": presenceQuery}, "presence"),
+}
+
+// wsServerSchemas are the schemas of the messages the server sends, by type.
+var wsServerSchemas = map[string]*JSONSchema{
+	"refresh": messageSchema("refresh", "The board changed; fetch it again.", map[string]*JSONSchema{
+		"silent": {Type: "boolean", Description: "only stats changed"},
+		"move":   moveSchema,
+		"hash":   {Type: "string", Description: "hash of the board as rendered, to detect missed updates"},
+	}),
+	"presence": messageSchema("presence", "Who else edits a card.", map[string]*JSONSchema{"presence": objectSchema(map[string]*JSONSchema{
+		"cardId":  cardIDSchema,
+		"editors": stringsSchema,
+		"colors":  colorsSchema,
+		"away":    {Type: "array", Items: stringSchema, Description: "editors who are idle"},
+	}, "cardId")}, "presence"),
+	"presenting": messageSchema("presenting", "The card being presented, \"\" when nobody presents.", map[string]*JSONSchema{"presence": objectSchema(map[string]*JSONSchema{
+		"cardId":    cardIDSchema,
+		"presenter": stringSchema,
+		"colors":    colorsSchema,
+	}, "cardId")}, "presence"),
+	"poker": messageSchema("poker", "The state of a card's estimation round.", map[string]*JSONSchema{"poker": objectSchema(map[string]*JSONSchema{
+		"cardId":    cardIDSchema,
+		"voted":     stringsSchema,
+		"estimates": {Type: "object", Values: stringSchema, Description: "estimates by name, once revealed"},
+		"revealed":  boolSchema,
+		"closed":    boolSchema,
+	}, "cardId")}, "poker"),
+	"deleted": messageSchema("deleted", "The sender's deletion, with how long it can be undone.", map[string]*JSONSchema{"delete": objectSchema(map[string]*JSONSchema{
+		"cardId":      cardIDSchema,
+		"undoSeconds": indexSchema,
+	}, "cardId")}, "delete"),
+	"effect": messageSchema("effect", "A notable edit to celebrate.", map[string]*JSONSchema{"effect": objectSchema(map[string]*JSONSchema{
+		"kind":   {Type: "string", Enum: []string{EffectCardCompleted, EffectSprintEnded}},
+		"cardId": cardIDSchema,
+		"title":  stringSchema,
+	}, "kind")}, "effect"),
+	"error": messageSchema("error", "The sender's last message was rejected.", map[string]*JSONSchema{"error": objectSchema(map[string]*JSONSchema{
+		"code":      stringSchema,
+		"message":   stringSchema,
+		"details":   {Description: "more about the error, depending on its code"},
+		"requestId": stringSchema,
+	}, "code", "message")}, "error"),
+}
+
+// decodeWSMessage validates a client message against the schema of its type
+// and decodes it.
+func decodeWSMessage(data []byte) (WSMessage, error) {
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return WSMessage{}, &SchemaError{Path: "", Reason: "not JSON"}
+	}
+	obj, _ := v.(map[string]any)
+	typ, _ := obj["type"].(string)
+	schema, ok := wsClientSchemas[typ]
+	if !ok {
+		return WSMessage{}, &SchemaError{Path: "/type", Reason: fmt.Sprintf("unknown message type %q", typ)}
+	}
+	if err := schema.validate(v, ""); err != nil {
+		return WSMessage{}, err
+	}
+	var msg WSMessage
+	err := json.Unmarshal(data, &msg)
+	return msg, err
+}
+
+// handleWSSchema serves the schemas of the WebSocket messages: GET
+// /api/ws-schema.
+func handleWSSchema(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/schema+json")
+	json.NewEncoder(w).Encode(struct {
+		Schema      string                 `json:"$schema"`
+		Title       string                 `json:"title"`
+		Description string                 `json:"description"`
+		Client      map[string]*JSONSchema `json:"client"`
+		Server      map[string]*JSONSchema `json:"server"`
+	}{
+		Schema:      "https://json-schema.org/draft/2020-12/schema",
+		Title:       "DeepBoard WebSocket messages",
+		Description: "Schemas of the messages sent over /ws, by type: client for those clients send, which the server rejects unless they match, and server for those it sends.",
+		Client:      wsClientSchemas,
+		Server:      wsServerSchemas,
+	})
+}